@@ -30,7 +30,7 @@ echo.
 set CGO_ENABLED=1
 set CC=%GCC_PATH%
 
-"%GO_PATH%" build -o chroniclecore.exe ./cmd/server
+"%GO_PATH%" build -tags sqlite_fts5 -o chroniclecore.exe ./cmd/server
 
 if errorlevel 1 (
     echo.
@@ -8,7 +8,7 @@ set CC=C:\Users\josh\AppData\Local\Microsoft\WinGet\Packages\BrechtSanders.WinLi
 set GOOS=windows
 set GOARCH=amd64
 
-"C:\Program Files\Go\bin\go.exe" build -tags="sqlite_omit_load_extension" -ldflags="-s -w" -o chroniclecore.exe ./cmd/server
+"C:\Program Files\Go\bin\go.exe" build -tags="sqlite_omit_load_extension sqlite_fts5" -ldflags="-s -w" -o chroniclecore.exe ./cmd/server
 
 if %errorlevel% neq 0 (
     echo Build failed!
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"chroniclecore/internal/store"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: migrate [-db path] <command>\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  status       Show applied and pending migrations\n")
+	fmt.Fprintf(os.Stderr, "  up           Apply all pending migrations\n")
+	fmt.Fprintf(os.Stderr, "  down [N]     Roll back the last N migrations (default 1)\n")
+	fmt.Fprintf(os.Stderr, "  doctor [repair]  Check database integrity; 'repair' fixes what it can (audited)\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	dbPath := flag.String("db", getDefaultDBPath(), "Path to SQLite database")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	if _, err := os.Stat(*dbPath); err != nil {
+		log.Fatalf("Database not found: %s", *dbPath)
+	}
+
+	fmt.Printf("Opening database: %s\n", *dbPath)
+
+	db, err := sql.Open("sqlite3", *dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+
+	switch flag.Arg(0) {
+	case "status":
+		printStatus(db)
+
+	case "up":
+		applied, err := store.ApplyMigrations(db)
+		if err != nil {
+			log.Fatalf("Migration failed after %d applied: %v", applied, err)
+		}
+		fmt.Printf("✅ Applied %d migration(s)\n", applied)
+
+	case "down":
+		steps := 1
+		if flag.NArg() > 1 {
+			steps, err = strconv.Atoi(flag.Arg(1))
+			if err != nil || steps < 1 {
+				log.Fatalf("Invalid step count: %s", flag.Arg(1))
+			}
+		}
+		rolledBack, err := store.RollbackMigrations(db, steps)
+		if err != nil {
+			log.Fatalf("Rollback failed after %d rolled back: %v", rolledBack, err)
+		}
+		fmt.Printf("✅ Rolled back %d migration(s)\n", rolledBack)
+
+	case "doctor":
+		repair := false
+		if flag.NArg() > 1 {
+			if flag.Arg(1) != "repair" {
+				log.Fatalf("Unknown doctor option: %s", flag.Arg(1))
+			}
+			repair = true
+		}
+		if !runDoctor(db, repair) {
+			os.Exit(1)
+		}
+
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// runDoctor prints the integrity report and reports whether the database is healthy
+func runDoctor(db *sql.DB, repair bool) bool {
+	report, err := store.CheckIntegrity(db, repair)
+	if err != nil {
+		log.Fatalf("Integrity check failed: %v", err)
+	}
+
+	for _, issue := range report.Issues {
+		status := "✅"
+		if issue.Count > issue.Repaired {
+			status = "❌"
+		}
+		fmt.Printf("%s %-22s found %d", status, issue.Class, issue.Count)
+		if repair && issue.Count > 0 {
+			fmt.Printf(", repaired %d", issue.Repaired)
+			if issue.AuditID != nil {
+				fmt.Printf(" (audit #%d)", *issue.AuditID)
+			}
+		}
+		fmt.Println()
+		for _, sample := range issue.Samples {
+			fmt.Printf("     %s\n", sample)
+		}
+		if issue.Count > len(issue.Samples) {
+			fmt.Printf("     ... and %d more\n", issue.Count-len(issue.Samples))
+		}
+	}
+
+	if report.Healthy {
+		fmt.Println("\nDatabase is healthy")
+	} else if !repair {
+		fmt.Println("\nProblems found; run 'doctor repair' to fix the repairable ones")
+	} else {
+		fmt.Println("\nSome problems could not be repaired automatically")
+	}
+
+	return report.Healthy
+}
+
+func printStatus(db *sql.DB) {
+	states, err := store.GetMigrationStatus(db)
+	if err != nil {
+		log.Fatalf("Failed to read migration status: %v", err)
+	}
+
+	pending := 0
+	for _, s := range states {
+		appliedAt := "-"
+		if s.AppliedAt != nil {
+			appliedAt = *s.AppliedAt
+		}
+		if s.Status == "PENDING" {
+			pending++
+		}
+		fmt.Printf("  %03d  %-24s %-18s %s\n", s.Version, s.Name, s.Status, appliedAt)
+	}
+
+	fmt.Printf("\nSchema version target: %d, pending: %d\n", store.LatestMigrationVersion(), pending)
+}
+
+// getDefaultDBPath mirrors the server's database location
+func getDefaultDBPath() string {
+	if dbPath := os.Getenv("CHRONICLE_DB_PATH"); dbPath != "" {
+		return dbPath
+	}
+
+	appData := os.Getenv("LOCALAPPDATA")
+	if appData == "" {
+		appData = "."
+	}
+
+	return filepath.Join(appData, "ChronicleCore", "chronicle.db")
+}
@@ -18,6 +18,7 @@ import (
 	"chroniclecore/internal/api"
 	"chroniclecore/internal/engine"
 	"chroniclecore/internal/ml"
+	"chroniclecore/internal/security"
 	"chroniclecore/internal/store"
 	"chroniclecore/internal/tracker"
 
@@ -74,6 +75,18 @@ func main() {
 	})
 	defer appAggregator.Stop()
 
+	// Initialize backup scheduler (daily/weekly rotating snapshots)
+	backupScheduler := engine.NewBackupScheduler(engine.BackupSchedulerConfig{
+		Store: appStore,
+	})
+	defer backupScheduler.Stop()
+
+	// Initialize dictionary GC (drops titles/domains nothing refers to)
+	dictGC := engine.NewDictGC(engine.DictGCConfig{
+		Store: appStore,
+	})
+	defer dictGC.Stop()
+
 	// Initialize ML sidecar (optional - starts Python process)
 	mlSidecar, err := ml.NewSidecarManager(MLPort)
 	if err != nil {
@@ -103,11 +116,19 @@ func main() {
 	blacklistHandler := api.NewBlacklistHandler(appStore)
 	eventHandler := api.NewEventHandler(appStore)
 	settingsHandler := api.NewSettingsHandler(appStore)
+	backupHandler := api.NewBackupHandler(appStore, appAggregator)
+	searchHandler := api.NewSearchHandler(appStore)
+	trashHandler := api.NewTrashHandler(appStore)
+	auditHandler := api.NewAuditHandler(appStore)
+	dictionaryHandler := api.NewDictionaryHandler(appStore)
+	archiveHandler := api.NewArchiveHandler(appStore)
+	integrityHandler := api.NewIntegrityHandler(appStore)
+	rebuildHandler := api.NewRebuildHandler(appAggregator)
 
 	// ML handler (only if sidecar is running)
 	var mlHandler *api.MLHandler
 	if mlSidecar != nil {
-		mlHandler = api.NewMLHandler(appStore.DB, mlSidecar)
+		mlHandler = api.NewMLHandler(appStore, mlSidecar)
 	}
 
 	// Setup HTTP server - MUST bind to localhost only
@@ -199,6 +220,9 @@ func main() {
 	mux.HandleFunc("/api/v1/blocks", blockHandler.ListBlocks)
 	mux.HandleFunc("/api/v1/blocks/grouped", blockHandler.ListGroupedBlocks)
 	mux.HandleFunc("/api/v1/blocks/manual", blockHandler.CreateManualEntry)
+	mux.HandleFunc("/api/v1/blocks/rebuild", rebuildHandler.Rebuild)
+	mux.HandleFunc("/api/v1/blocks/merge", blockHandler.MergeBlocks)
+	mux.HandleFunc("/api/v1/blocks/bulk", blockHandler.BulkBlocks)
 	mux.HandleFunc("/api/v1/blocks/", func(w http.ResponseWriter, r *http.Request) {
 		// Route based on path suffix
 		path := r.URL.Path
@@ -206,10 +230,40 @@ func main() {
 			blockHandler.ReassignBlock(w, r)
 		} else if strings.HasSuffix(path, "/lock") {
 			blockHandler.LockBlock(w, r)
+		} else if strings.HasSuffix(path, "/split") {
+			blockHandler.SplitBlock(w, r)
+		} else if strings.HasSuffix(path, "/history") {
+			auditHandler.BlockHistory(w, r)
 		} else if r.Method == http.MethodGet {
 			blockHandler.GetBlock(w, r) // Handle GET /api/v1/blocks/{id}
 		} else if r.Method == http.MethodDelete {
 			blockHandler.DeleteBlock(w, r)
+		} else if r.Method == http.MethodPatch {
+			blockHandler.UpdateBlock(w, r) // Handle PATCH /api/v1/blocks/{id}
+		} else {
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	})
+
+	// Audit log and undo
+	mux.HandleFunc("/api/v1/audit", auditHandler.ListAudit)
+	mux.HandleFunc("/api/v1/audit/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/v1/audit/operations/") && strings.HasSuffix(r.URL.Path, "/undo") {
+			auditHandler.UndoOperation(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/undo") {
+			auditHandler.Undo(w, r)
+		} else {
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	})
+
+	// Trash bin
+	mux.HandleFunc("/api/v1/trash", trashHandler.ListTrash)
+	mux.HandleFunc("/api/v1/trash/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/restore") {
+			trashHandler.RestoreBlock(w, r)
+		} else if r.Method == http.MethodDelete {
+			trashHandler.PurgeBlock(w, r)
 		} else {
 			http.Error(w, "Not found", http.StatusNotFound)
 		}
@@ -245,6 +299,13 @@ func main() {
 
 	// Export endpoints
 	mux.HandleFunc("/api/v1/export/invoice-lines", exportHandler.ExportInvoiceLines)
+	mux.HandleFunc("/api/v1/export/dataset", func(w http.ResponseWriter, r *http.Request) {
+		exportHandler.ExportDataset(w, r, AppVersion)
+	})
+	mux.HandleFunc("/api/v1/import/dataset", exportHandler.ImportDataset)
+
+	// Full-text search
+	mux.HandleFunc("/api/v1/search", searchHandler.Search)
 
 	// Extension event ingestion endpoint
 	mux.HandleFunc("/api/v1/events/ingest", eventHandler.IngestExtensionEvent)
@@ -259,6 +320,9 @@ func main() {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
+	mux.HandleFunc("/api/v1/rules/preview", ruleHandler.PreviewRule)
+	mux.HandleFunc("/api/v1/rules/apply", ruleHandler.ApplyRules)
+	mux.HandleFunc("/api/v1/rules/analysis", ruleHandler.AnalyzeRules)
 	mux.HandleFunc("/api/v1/rules/", func(w http.ResponseWriter, r *http.Request) {
 		// Handles /api/v1/rules/{id} for PUT and DELETE
 		if r.Method == http.MethodPut {
@@ -275,6 +339,16 @@ func main() {
 	mux.HandleFunc("/api/v1/system/check-update", func(w http.ResponseWriter, r *http.Request) {
 		systemHandler.CheckForUpdate(w, r, AppVersion)
 	})
+	mux.HandleFunc("/api/v1/system/backup", backupHandler.CreateBackup)
+	mux.HandleFunc("/api/v1/system/backups", backupHandler.ListBackups)
+	mux.HandleFunc("/api/v1/system/restore", backupHandler.RestoreBackup)
+	mux.HandleFunc("/api/v1/system/dictionary", dictionaryHandler.Stats)
+	mux.HandleFunc("/api/v1/system/dictionary/gc", dictionaryHandler.CollectGarbage)
+	mux.HandleFunc("/api/v1/system/archives", archiveHandler.ListArchives)
+	mux.HandleFunc("/api/v1/system/archives/rehydrate", archiveHandler.Rehydrate)
+	mux.HandleFunc("/api/v1/system/secrets/rotate", settingsHandler.RotateSecrets)
+	mux.HandleFunc("/api/v1/system/integrity", integrityHandler.Check)
+	mux.HandleFunc("/api/v1/system/integrity/repair", integrityHandler.Repair)
 
 	// Settings endpoints
 	mux.HandleFunc("/api/v1/settings", func(w http.ResponseWriter, r *http.Request) {
@@ -449,6 +523,14 @@ func initializeDatabase(dbPath string) error {
 		return fmt.Errorf("failed to initialize store: %w", err)
 	}
 
+	// Secrets use DPAPI on Windows, a file keyring elsewhere
+	keyProvider, err := security.NewDefaultKeyProvider(filepath.Dir(dbPath))
+	if err != nil {
+		log.Printf("Warning: secret storage unavailable: %v", err)
+	} else {
+		appStore.SetKeyProvider(keyProvider)
+	}
+
 	return nil
 }
 
@@ -465,7 +547,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 		// Only allow localhost origins
 		if origin == "http://127.0.0.1" || origin == "http://localhost" {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		}
 
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"chroniclecore/internal/store"
+)
+
+// ArchiveHandler exposes the raw event cold archive
+type ArchiveHandler struct {
+	store *store.Store
+}
+
+func NewArchiveHandler(store *store.Store) *ArchiveHandler {
+	return &ArchiveHandler{store: store}
+}
+
+// ListArchives handles GET /api/v1/system/archives
+func (h *ArchiveHandler) ListArchives(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archives, err := h.store.ListRawEventArchives()
+	if err != nil {
+		log.Printf("Failed to list archives: %v", err)
+		respondError(w, "Failed to list archives", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"archive_dir": h.store.ArchiveDir(),
+		"archives":    archives,
+	}, http.StatusOK)
+}
+
+// Rehydrate handles POST /api/v1/system/archives/rehydrate with a
+// {"start_date": "YYYY-MM-DD", "end_date": "YYYY-MM-DD"} body (inclusive)
+func (h *ArchiveHandler) Rehydrate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		StartDate string `json:"start_date"`
+		EndDate   string `json:"end_date"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	start, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		respondError(w, "Invalid start_date (use YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	end, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		respondError(w, "Invalid end_date (use YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	if end.Before(start) {
+		respondError(w, "end_date must not be before start_date", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.store.RehydrateRawEvents(start, end.AddDate(0, 0, 1))
+	if err != nil {
+		log.Printf("Rehydrate %s..%s failed: %v", req.StartDate, req.EndDate, err)
+		respondError(w, "Rehydrate failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Rehydrated %d raw events from %d archive(s)", report.Inserted, len(report.Files))
+
+	respondJSON(w, report, http.StatusOK)
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chroniclecore/internal/store"
+)
+
+// AuditHandler handles block edit history and undo
+type AuditHandler struct {
+	store *store.Store
+}
+
+func NewAuditHandler(store *store.Store) *AuditHandler {
+	return &AuditHandler{store: store}
+}
+
+// ListAudit handles GET /api/v1/audit?action=&actor=&block_id=&start_date=&end_date=&limit=&offset=
+func (h *AuditHandler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+
+	filter := store.AuditFilter{
+		Action:    strings.ToUpper(params.Get("action")),
+		Actor:     strings.ToUpper(params.Get("actor")),
+		StartDate: params.Get("start_date"),
+		EndDate:   params.Get("end_date"),
+	}
+
+	for _, d := range []string{filter.StartDate, filter.EndDate} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			respondError(w, "Invalid date format (use YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if blockIDStr := params.Get("block_id"); blockIDStr != "" {
+		blockID, err := strconv.ParseInt(blockIDStr, 10, 64)
+		if err != nil {
+			respondError(w, "Invalid block_id", http.StatusBadRequest)
+			return
+		}
+		filter.BlockID = &blockID
+	}
+
+	filter.Limit, filter.Offset = parseLimitOffset(params.Get("limit"), params.Get("offset"))
+
+	entries, err := h.store.ListAudit(filter)
+	if err != nil {
+		log.Printf("Failed to list audit log: %v", err)
+		respondError(w, "Failed to list audit log", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, entries, http.StatusOK)
+}
+
+// BlockHistory handles GET /api/v1/blocks/{id}/history
+func (h *AuditHandler) BlockHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Path is /api/v1/blocks/{id}/history
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 4 {
+		respondError(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	blockID, err := strconv.ParseInt(pathParts[3], 10, 64)
+	if err != nil {
+		respondError(w, "Invalid block_id", http.StatusBadRequest)
+		return
+	}
+
+	params := r.URL.Query()
+	limit, offset := parseLimitOffset(params.Get("limit"), params.Get("offset"))
+
+	entries, err := h.store.ListAudit(store.AuditFilter{BlockID: &blockID, Limit: limit, Offset: offset})
+	if err != nil {
+		log.Printf("Failed to load history for block %d: %v", blockID, err)
+		respondError(w, "Failed to load block history", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, entries, http.StatusOK)
+}
+
+// Undo handles POST /api/v1/audit/{id}/undo
+func (h *AuditHandler) Undo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Path is /api/v1/audit/{id}/undo
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/audit/"), "/undo")
+	auditID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		respondError(w, "Invalid audit ID", http.StatusBadRequest)
+		return
+	}
+
+	undoID, err := h.store.UndoAudit(auditID)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "not found"):
+			respondError(w, "Audit entry not found", http.StatusNotFound)
+		case strings.Contains(err.Error(), "conflict"), strings.Contains(err.Error(), "already undone"):
+			respondError(w, err.Error(), http.StatusConflict)
+		case strings.Contains(err.Error(), "no block changes"):
+			respondError(w, err.Error(), http.StatusBadRequest)
+		default:
+			log.Printf("Undo of audit entry %d failed: %v", auditID, err)
+			respondError(w, "Undo failed: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("Undid audit entry %d (undo entry %d)", auditID, undoID)
+
+	respondJSON(w, map[string]interface{}{
+		"success":  true,
+		"undone":   auditID,
+		"audit_id": undoID,
+	}, http.StatusOK)
+}
+
+// UndoOperation handles POST /api/v1/audit/operations/{operation_id}/undo
+func (h *AuditHandler) UndoOperation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	operationID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/audit/operations/"), "/undo")
+	if operationID == "" || strings.Contains(operationID, "/") {
+		respondError(w, "Invalid operation ID", http.StatusBadRequest)
+		return
+	}
+
+	undoIDs, err := h.store.UndoOperation(operationID)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "not found"):
+			respondError(w, "Operation not found", http.StatusNotFound)
+		case strings.Contains(err.Error(), "conflict"), strings.Contains(err.Error(), "already undone"):
+			respondError(w, err.Error(), http.StatusConflict)
+		default:
+			log.Printf("Undo of operation %s failed: %v", operationID, err)
+			respondError(w, "Undo failed: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("Undid operation %s (%d undo entries)", operationID, len(undoIDs))
+
+	respondJSON(w, map[string]interface{}{
+		"success":   true,
+		"undone":    operationID,
+		"audit_ids": undoIDs,
+	}, http.StatusOK)
+}
+
+// parseLimitOffset reads pagination params with defaults of 100 and 0
+func parseLimitOffset(limitStr, offsetStr string) (int, int) {
+	limit, offset := 100, 0
+	if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+		offset = o
+	}
+	return limit, offset
+}
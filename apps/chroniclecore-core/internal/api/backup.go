@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"chroniclecore/internal/engine"
+	"chroniclecore/internal/store"
+)
+
+// BackupHandler handles database snapshot endpoints
+type BackupHandler struct {
+	store      *store.Store
+	aggregator *engine.Aggregator // Restores pause rollups and reload its caches
+}
+
+func NewBackupHandler(store *store.Store, aggregator *engine.Aggregator) *BackupHandler {
+	return &BackupHandler{store: store, aggregator: aggregator}
+}
+
+// CreateBackup handles POST /api/v1/system/backup
+func (h *BackupHandler) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	snapshot, err := h.store.CreateSnapshot(store.SnapshotManual)
+	if err != nil {
+		log.Printf("Manual backup failed: %v", err)
+		respondError(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, snapshot, http.StatusCreated)
+}
+
+// ListBackups handles GET /api/v1/system/backups
+func (h *BackupHandler) ListBackups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	snapshots, err := h.store.ListSnapshots()
+	if err != nil {
+		respondError(w, "Failed to list backups", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"backup_dir": h.store.BackupDir(),
+		"backups":    snapshots,
+	}, http.StatusOK)
+}
+
+// RestoreBackup handles POST /api/v1/system/restore
+// Body: {"name": "chronicle_daily_20260110T020000Z.db"}
+func (h *BackupHandler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	path, err := h.store.SnapshotPath(req.Name)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		respondError(w, "Backup not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.aggregator.RestoreSnapshot(req.Name); err != nil {
+		// A bad snapshot is a client error, not a failed restore
+		if errors.Is(err, store.ErrInvalidSnapshot) {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Restore from %s failed: %v", req.Name, err)
+		respondError(w, "Restore failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Database restored from backup %s", req.Name)
+
+	respondJSON(w, map[string]interface{}{
+		"success":  true,
+		"restored": req.Name,
+	}, http.StatusOK)
+}
@@ -69,7 +69,7 @@ func (h *BlockHandler) GetBlock(w http.ResponseWriter, r *http.Request) {
 		LEFT JOIN client c ON p.client_id = c.client_id
 		LEFT JOIN project pr ON p.project_id = pr.project_id
 		LEFT JOIN service s ON p.service_id = s.service_id
-		WHERE b.block_id = ?
+		WHERE b.block_id = ? AND b.deleted_at IS NULL
 	`
 
 	var b BlockDTO
@@ -162,6 +162,12 @@ func (h *BlockHandler) GetBlock(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	titles, err := h.store.GetBlockTitles(blockID)
+	if err != nil {
+		log.Printf("Failed to get titles for block %d: %v", blockID, err)
+	}
+	b.Titles = titles
+
 	respondJSON(w, b, http.StatusOK)
 }
 
@@ -180,6 +186,9 @@ type BlockDTO struct {
 	PrimaryDomain  *string `json:"primary_domain,omitempty"`
 	TitleSummary   *string `json:"title_summary,omitempty"`
 
+	// Time per window title, longest first (single block lookups only)
+	Titles []store.BlockTitle `json:"titles,omitempty"`
+
 	// Profile assignment
 	ProfileID   *int64  `json:"profile_id,omitempty"`
 	ClientName  *string `json:"client_name,omitempty"`
@@ -276,11 +285,19 @@ func (h *BlockHandler) ListBlocks(w http.ResponseWriter, r *http.Request) {
 		profileID = &pid
 	}
 
-	// Unassigned filter
-	unassignedOnly := params.Get("unassigned") == "true"
-
-	// Low confidence filter (needs review)
-	needsReview := params.Get("needs_review") == "true"
+	// Unassigned, needs review (LOW confidence), app and domain filters
+	filter := store.BlockFilter{
+		StartDate:   startDateStr,
+		EndDate:     endDateStr,
+		ProfileID:   profileID,
+		Unassigned:  params.Get("unassigned") == "true",
+		NeedsReview: params.Get("needs_review") == "true",
+		App:         params.Get("app"),
+		Domain:      params.Get("domain"),
+	}
+	if dateStr != "" {
+		filter.Date = filterDate.Format("2006-01-02")
+	}
 
 	// Build query
 	query := `
@@ -311,37 +328,11 @@ func (h *BlockHandler) ListBlocks(w http.ResponseWriter, r *http.Request) {
 		LEFT JOIN client c ON p.client_id = c.client_id
 		LEFT JOIN project pr ON p.project_id = pr.project_id
 		LEFT JOIN service s ON p.service_id = s.service_id
-		WHERE 1=1
+		WHERE b.deleted_at IS NULL
 	`
 
-	var args []interface{}
-
-	// Apply date filter
-	if startDateStr != "" && endDateStr != "" {
-		query += " AND DATE(b.ts_start) >= ? AND DATE(b.ts_start) <= ?"
-		args = append(args, startDateStr, endDateStr)
-	} else if dateStr != "" {
-		query += " AND DATE(b.ts_start) = ?"
-		args = append(args, filterDate.Format("2006-01-02"))
-	}
-
-	// Apply profile filter
-	if profileID != nil {
-		query += " AND b.profile_id = ?"
-		args = append(args, *profileID)
-	}
-
-	// Apply unassigned filter
-	if unassignedOnly {
-		query += " AND b.profile_id IS NULL"
-	}
-
-	// Apply needs review filter
-	if needsReview {
-		query += " AND (b.profile_id IS NULL OR b.confidence = 'LOW')"
-	}
-
-	query += " ORDER BY b.ts_start DESC"
+	where, args := filter.Where()
+	query += where + " ORDER BY b.ts_start DESC"
 
 	// Add limit
 	limit := params.Get("limit")
@@ -528,6 +519,7 @@ func (h *BlockHandler) ListGroupedBlocks(w http.ResponseWriter, r *http.Request)
 		LEFT JOIN app_blacklist abl ON b.primary_app_id = abl.app_id
 		LEFT JOIN ml_suggestion ms ON b.block_id = ms.entity_id AND ms.entity_type = 'BLOCK' AND ms.status = 'PENDING'
 		WHERE abl.app_id IS NULL
+		  AND b.deleted_at IS NULL
 		  AND NOT EXISTS (
 			  SELECT 1 FROM keyword_blacklist kbl 
 			  WHERE dt.title_text LIKE '%' || kbl.keyword_text || '%'
@@ -879,11 +871,18 @@ func (h *BlockHandler) ReassignBlock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tx, err := h.store.GetDB().Begin()
+	if err != nil {
+		respondError(w, "Failed to reassign block", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
 	// Get current block state for audit log
 	var oldProfileID sql.NullInt64
 	var oldConfidence string
-	err = h.store.GetDB().QueryRow(
-		"SELECT profile_id, confidence FROM block WHERE block_id = ?",
+	err = tx.QueryRow(
+		"SELECT profile_id, confidence FROM block WHERE block_id = ? AND deleted_at IS NULL",
 		blockID,
 	).Scan(&oldProfileID, &oldConfidence)
 
@@ -897,8 +896,15 @@ func (h *BlockHandler) ReassignBlock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit := store.NewBlockAudit(store.AuditActorUser, store.AuditReassignBlock)
+	if err := audit.Track(tx, blockID); err != nil {
+		log.Printf("Failed to snapshot block for audit: %v", err)
+		respondError(w, "Failed to reassign block", http.StatusInternalServerError)
+		return
+	}
+
 	// Update block
-	_, err = h.store.GetDB().Exec(
+	_, err = tx.Exec(
 		"UPDATE block SET profile_id = ?, confidence = ? WHERE block_id = ?",
 		req.ProfileID,
 		req.Confidence,
@@ -911,44 +917,57 @@ func (h *BlockHandler) ReassignBlock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Write audit log
 	var oldPID *int64
 	if oldProfileID.Valid {
 		pid := oldProfileID.Int64
 		oldPID = &pid
 	}
 
+	// Create ML label event for training feedback loop, in the same
+	// transaction so a reassignment is never applied without its label.
+	// This allows the ML system to learn from user corrections
+	if req.ProfileID != nil {
+		_, err := tx.Exec(`
+			INSERT INTO ml_label_event (block_id, old_profile_id, new_profile_id, actor, confidence_after)
+			VALUES (?, ?, ?, 'USER', ?)
+		`, blockID, oldPID, *req.ProfileID, req.Confidence)
+		if err != nil {
+			log.Printf("Failed to create ML label event: %v", err)
+			respondError(w, "Failed to reassign block", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Write audit log
 	auditDetails := map[string]interface{}{
-		"block_id":       blockID,
 		"old_profile_id": oldPID,
 		"new_profile_id": req.ProfileID,
 		"old_confidence": oldConfidence,
 		"new_confidence": req.Confidence,
 	}
 
-	h.writeAuditLog("REASSIGN_BLOCK", auditDetails)
+	if _, err := audit.Commit(tx, auditDetails); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+		respondError(w, "Failed to reassign block", http.StatusInternalServerError)
+		return
+	}
 
-	// Create ML label event for training feedback loop
-	// This allows the ML system to learn from user corrections
-	if req.ProfileID != nil {
-		_, labelErr := h.store.GetDB().Exec(`
-			INSERT INTO ml_label_event (block_id, old_profile_id, new_profile_id, actor, confidence_after)
-			VALUES (?, ?, ?, 'USER', ?)
-		`, blockID, oldPID, *req.ProfileID, req.Confidence)
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit reassign: %v", err)
+		respondError(w, "Failed to reassign block", http.StatusInternalServerError)
+		return
+	}
 
-		if labelErr != nil {
-			log.Printf("Warning: Failed to create ML label event: %v", labelErr)
-		} else {
-			log.Printf("[ML] Label event created: block %d assigned to profile %d (training data recorded)", blockID, *req.ProfileID)
+	if req.ProfileID != nil {
+		log.Printf("[ML] Label event created: block %d assigned to profile %d (training data recorded)", blockID, *req.ProfileID)
 
-			// Check if we have enough training data to auto-trigger training
-			var labelCount int
-			h.store.GetDB().QueryRow("SELECT COUNT(*) FROM ml_label_event WHERE new_profile_id IS NOT NULL").Scan(&labelCount)
+		// Check if we have enough training data to auto-trigger training
+		var labelCount int
+		h.store.GetDB().QueryRow("SELECT COUNT(*) FROM ml_label_event WHERE new_profile_id IS NOT NULL").Scan(&labelCount)
 
-			// Log training data status every 5 corrections
-			if labelCount%5 == 0 {
-				log.Printf("[ML] Training data: %d labeled samples. Need 10+ for training.", labelCount)
-			}
+		// Log training data status every 5 corrections
+		if labelCount%5 == 0 {
+			log.Printf("[ML] Training data: %d labeled samples. Need 10+ for training.", labelCount)
 		}
 	}
 
@@ -991,9 +1010,28 @@ func (h *BlockHandler) LockBlock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	action := store.AuditLockBlock
+	if !req.Locked {
+		action = store.AuditUnlockBlock
+	}
+
+	tx, err := h.store.GetDB().Begin()
+	if err != nil {
+		respondError(w, "Failed to update block", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	audit := store.NewBlockAudit(store.AuditActorUser, action)
+	if err := audit.Track(tx, blockID); err != nil {
+		log.Printf("Failed to snapshot block for audit: %v", err)
+		respondError(w, "Failed to update block", http.StatusInternalServerError)
+		return
+	}
+
 	// Update block
-	result, err := h.store.GetDB().Exec(
-		"UPDATE block SET locked = ? WHERE block_id = ?",
+	result, err := tx.Exec(
+		"UPDATE block SET locked = ? WHERE block_id = ? AND deleted_at IS NULL",
 		req.Locked,
 		blockID,
 	)
@@ -1011,15 +1049,17 @@ func (h *BlockHandler) LockBlock(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Write audit log
-	action := "LOCK_BLOCK"
-	if !req.Locked {
-		action = "UNLOCK_BLOCK"
+	if _, err := audit.Commit(tx, map[string]interface{}{"locked": req.Locked}); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+		respondError(w, "Failed to update block", http.StatusInternalServerError)
+		return
 	}
 
-	h.writeAuditLog(action, map[string]interface{}{
-		"block_id": blockID,
-		"locked":   req.Locked,
-	})
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit lock: %v", err)
+		respondError(w, "Failed to update block", http.StatusInternalServerError)
+		return
+	}
 
 	// Fetch updated block
 	blocks := h.getBlocksByIDs([]int64{blockID})
@@ -1031,7 +1071,311 @@ func (h *BlockHandler) LockBlock(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, blocks[0], http.StatusOK)
 }
 
-// DeleteBlock deletes a block
+// UpdateBlock handles PATCH /api/v1/blocks/{id}. It edits times, billable,
+// notes and description, and the title of manual entries. New times that
+// overlap other blocks are rejected, or with "overlap": "trim" the
+// neighbours are shortened to make room.
+func (h *BlockHandler) UpdateBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/v1/blocks/")
+	blockID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		respondError(w, "Invalid block_id", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		TsStart     *string `json:"ts_start"`
+		TsEnd       *string `json:"ts_end"`
+		Billable    *bool   `json:"billable"`
+		Notes       *string `json:"notes"`
+		Description *string `json:"description"`
+		Title       *string `json:"title"`
+		Overlap     string  `json:"overlap"` // reject (default) or trim
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Overlap != "" && req.Overlap != "reject" && req.Overlap != "trim" {
+		respondError(w, "overlap must be reject or trim", http.StatusBadRequest)
+		return
+	}
+
+	edit := store.BlockEdit{
+		Billable:    req.Billable,
+		Notes:       req.Notes,
+		Description: req.Description,
+		Title:       req.Title,
+	}
+	if req.TsStart != nil {
+		ts, err := time.Parse(time.RFC3339, *req.TsStart)
+		if err != nil {
+			respondError(w, "Invalid ts_start format. Use ISO-8601 (e.g., 2026-01-13T09:00:00Z)", http.StatusBadRequest)
+			return
+		}
+		edit.TsStart = &ts
+	}
+	if req.TsEnd != nil {
+		ts, err := time.Parse(time.RFC3339, *req.TsEnd)
+		if err != nil {
+			respondError(w, "Invalid ts_end format. Use ISO-8601 (e.g., 2026-01-13T10:00:00Z)", http.StatusBadRequest)
+			return
+		}
+		edit.TsEnd = &ts
+	}
+
+	trimmedIDs, err := h.store.EditBlock(blockID, edit, req.Overlap == "trim")
+	if err != nil {
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "not found"):
+			respondError(w, "Block not found", http.StatusNotFound)
+		case strings.Contains(msg, "locked"), strings.Contains(msg, "overlaps"):
+			respondError(w, msg, http.StatusConflict)
+		case strings.HasPrefix(msg, "failed to"):
+			log.Printf("Failed to edit block %d: %v", blockID, err)
+			respondError(w, "Failed to update block", http.StatusInternalServerError)
+		default:
+			respondError(w, msg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	blocks := h.getBlocksByIDs([]int64{blockID})
+	if len(blocks) == 0 {
+		respondError(w, "Failed to fetch updated block", http.StatusInternalServerError)
+		return
+	}
+
+	trimmed := []BlockDTO{}
+	if len(trimmedIDs) > 0 {
+		trimmed = h.getBlocksByIDs(trimmedIDs)
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"block":   blocks[0],
+		"trimmed": trimmed,
+	}, http.StatusOK)
+}
+
+// SplitBlock handles POST /api/v1/blocks/{id}/split. The block is cut at
+// split_at, or at each of split_points, with optional per-segment overrides.
+func (h *BlockHandler) SplitBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract block_id from path
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 4 {
+		respondError(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	blockID, err := strconv.ParseInt(pathParts[3], 10, 64)
+	if err != nil {
+		respondError(w, "Invalid block_id", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		SplitAt     string               `json:"split_at"`
+		SplitPoints []string             `json:"split_points"`
+		Segments    []store.SplitSegment `json:"segments"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	raw := req.SplitPoints
+	if req.SplitAt != "" {
+		raw = append([]string{req.SplitAt}, raw...)
+	}
+	if len(raw) == 0 {
+		respondError(w, "split_at or split_points is required", http.StatusBadRequest)
+		return
+	}
+
+	points := make([]time.Time, len(raw))
+	for i, str := range raw {
+		if points[i], err = time.Parse(time.RFC3339, str); err != nil {
+			respondError(w, "Invalid split point format. Use ISO-8601 (e.g., 2026-01-13T09:30:00Z)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ids, err := h.store.SplitBlock(blockID, points, req.Segments)
+	if err != nil {
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "not found"):
+			respondError(w, "Block not found", http.StatusNotFound)
+		case strings.Contains(msg, "locked"):
+			respondError(w, "Block is locked", http.StatusConflict)
+		case strings.Contains(msg, "split point"), strings.Contains(msg, "segment"):
+			respondError(w, msg, http.StatusBadRequest)
+		default:
+			log.Printf("Failed to split block %d: %v", blockID, err)
+			respondError(w, "Failed to split block", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	blocks := h.getBlocksByIDs(ids)
+	if len(blocks) != len(ids) {
+		respondError(w, "Failed to fetch split blocks", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"first":    blocks[0],
+		"second":   blocks[1],
+		"segments": blocks,
+	}, http.StatusOK)
+}
+
+// MergeBlocks handles POST /api/v1/blocks/merge
+func (h *BlockHandler) MergeBlocks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		BlockIDs    []int64 `json:"block_ids"`
+		Force       bool    `json:"force"`
+		ProfileID   *int64  `json:"profile_id"`
+		Billable    *bool   `json:"billable"`
+		Description *string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.BlockIDs) < 2 {
+		respondError(w, "block_ids must list at least 2 blocks", http.StatusBadRequest)
+		return
+	}
+
+	blockID, err := h.store.MergeBlocks(req.BlockIDs, store.MergeOptions{
+		Force:       req.Force,
+		ProfileID:   req.ProfileID,
+		Billable:    req.Billable,
+		Description: req.Description,
+	})
+	if err != nil {
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "not found"):
+			respondError(w, msg, http.StatusNotFound)
+		case strings.Contains(msg, "locked"):
+			respondError(w, msg+" (pass force to merge anyway)", http.StatusConflict)
+		case strings.HasPrefix(msg, "merge "):
+			respondError(w, msg, http.StatusBadRequest)
+		default:
+			log.Printf("Failed to merge blocks %v: %v", req.BlockIDs, err)
+			respondError(w, "Failed to merge blocks", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	blocks := h.getBlocksByIDs([]int64{blockID})
+	if len(blocks) == 0 {
+		respondError(w, "Failed to fetch merged block", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, blocks[0], http.StatusOK)
+}
+
+// BulkBlocks handles POST /api/v1/blocks/bulk. It applies one action to
+// the blocks in block_ids, or to those matching filter, in one transaction.
+func (h *BlockHandler) BulkBlocks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		BlockIDs    []int64            `json:"block_ids"`
+		Filter      *store.BlockFilter `json:"filter"`
+		Action      string             `json:"action"`
+		ProfileID   *int64             `json:"profile_id"`
+		Confidence  string             `json:"confidence"`
+		Billable    *bool              `json:"billable"`
+		Description *string            `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.BlockIDs) == 0 && req.Filter == nil {
+		respondError(w, "block_ids or filter is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.BlockIDs) > 0 && req.Filter != nil {
+		respondError(w, "Use either block_ids or filter, not both", http.StatusBadRequest)
+		return
+	}
+	if req.Action == store.BulkBillable && req.Billable == nil {
+		respondError(w, "billable is required for the billable action", http.StatusBadRequest)
+		return
+	}
+	if f := req.Filter; f != nil {
+		for _, date := range []string{f.Date, f.StartDate, f.EndDate} {
+			if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+				respondError(w, "Invalid date format (use YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	action := store.BulkAction{
+		Action:      req.Action,
+		ProfileID:   req.ProfileID,
+		Confidence:  req.Confidence,
+		Description: req.Description,
+	}
+	if req.Billable != nil {
+		action.Billable = *req.Billable
+	}
+
+	results, auditID, err := h.store.BulkUpdateBlocks(req.BlockIDs, req.Filter, action)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "failed to") {
+			log.Printf("Bulk %s failed: %v", req.Action, err)
+			respondError(w, "Failed to update blocks", http.StatusInternalServerError)
+			return
+		}
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	counts := map[string]int{"updated": 0, "unchanged": 0, "skipped": 0}
+	for _, res := range results {
+		counts[res.Status]++
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"action":    req.Action,
+		"audit_id":  auditID,
+		"results":   results,
+		"updated":   counts["updated"],
+		"unchanged": counts["unchanged"],
+		"skipped":   counts["skipped"],
+	}, http.StatusOK)
+}
+
+// DeleteBlock moves a block to the trash. With ?learn=true the block is
+// instead purged immediately and recorded for ML deletion learning.
 func (h *BlockHandler) DeleteBlock(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -1048,10 +1392,15 @@ func (h *BlockHandler) DeleteBlock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Record block features before deletion for ML training
-	h.recordDeletionForML(id)
+	learn := r.URL.Query().Get("learn") == "true"
+
+	if learn {
+		err = h.store.PurgeBlock(id)
+	} else {
+		err = h.store.TrashBlock(id)
+	}
 
-	if err := h.store.DeleteBlock(id); err != nil {
+	if err != nil {
 		// If block not found, it may have been already deleted (e.g., duplicate request)
 		// Return success since the end result is the same - the block is gone
 		if err.Error() == "block not found" {
@@ -1064,43 +1413,11 @@ func (h *BlockHandler) DeleteBlock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondJSON(w, map[string]bool{"success": true}, http.StatusOK)
-}
-
-// recordDeletionForML saves block features to ml_deletion_event for learning
-func (h *BlockHandler) recordDeletionForML(blockID int64) {
-	// Fetch block features before deletion
-	var appName string
-	var titleText, domainText, tsStart, tsEnd sql.NullString
-
-	err := h.store.GetDB().QueryRow(`
-		SELECT da.app_name, dt.title_text, dd.domain_text, b.ts_start, b.ts_end
-		FROM block b
-		JOIN dict_app da ON b.primary_app_id = da.app_id
-		LEFT JOIN dict_title dt ON b.title_summary_id = dt.title_id
-		LEFT JOIN dict_domain dd ON b.primary_domain_id = dd.domain_id
-		WHERE b.block_id = ?
-	`, blockID).Scan(&appName, &titleText, &domainText, &tsStart, &tsEnd)
-
-	if err != nil {
-		// Block not found is expected if already deleted - don't log as error
-		if err != sql.ErrNoRows {
-			log.Printf("Could not fetch block %d for ML deletion learning: %v", blockID, err)
-		}
-		return
+	if learn {
+		log.Printf("Purged block %d and recorded deletion for ML learning", id)
 	}
 
-	// Insert into ml_deletion_event
-	_, err = h.store.GetDB().Exec(`
-		INSERT INTO ml_deletion_event (app_name, title_text, domain_text, ts_start, ts_end, actor)
-		VALUES (?, ?, ?, ?, ?, 'USER')
-	`, appName, titleText, domainText, tsStart, tsEnd)
-
-	if err != nil {
-		log.Printf("Failed to record deletion for ML: %v", err)
-	} else {
-		log.Printf("Recorded deletion of block %d (%s) for ML learning", blockID, appName)
-	}
+	respondJSON(w, map[string]bool{"success": true, "trashed": !learn}, http.StatusOK)
 }
 
 // getBlocksByIDs fetches blocks by IDs (helper for returning updated blocks)
@@ -1131,6 +1448,8 @@ func (h *BlockHandler) getBlocksByIDs(blockIDs []int64) []BlockDTO {
 		LEFT JOIN project pr ON p.project_id = pr.project_id
 		LEFT JOIN service s ON p.service_id = s.service_id
 		WHERE b.block_id IN (` + strings.Join(placeholders, ",") + `)
+		  AND b.deleted_at IS NULL
+		ORDER BY b.ts_start ASC
 	`
 
 	rows, err := h.store.GetDB().Query(query, args...)
@@ -1220,25 +1539,6 @@ func (h *BlockHandler) getBlocksByIDs(blockIDs []int64) []BlockDTO {
 	return blocks
 }
 
-// writeAuditLog writes an audit log entry
-func (h *BlockHandler) writeAuditLog(action string, details interface{}) {
-	detailsJSON, err := json.Marshal(details)
-	if err != nil {
-		log.Printf("Failed to marshal audit details: %v", err)
-		return
-	}
-
-	_, err = h.store.GetDB().Exec(
-		"INSERT INTO audit_log (actor, action, details_json) VALUES ('USER', ?, ?)",
-		action,
-		string(detailsJSON),
-	)
-
-	if err != nil {
-		log.Printf("Failed to write audit log: %v", err)
-	}
-}
-
 // ManualEntryRequest represents a request to create a manual time entry
 type ManualEntryRequest struct {
 	ProfileID   int64  `json:"profile_id"`
@@ -1348,8 +1648,15 @@ func (h *BlockHandler) CreateManualEntry(w http.ResponseWriter, r *http.Request)
 		description = req.Title
 	}
 
+	tx, err := h.store.GetDB().Begin()
+	if err != nil {
+		respondError(w, "Failed to create manual entry", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
 	// Insert manual block
-	result, err := h.store.GetDB().Exec(`
+	result, err := tx.Exec(`
 		INSERT INTO block (
 			ts_start, ts_end, primary_app_id, title_summary_id, profile_id,
 			confidence, billable, locked, description, is_manual, manual_title
@@ -1374,14 +1681,23 @@ func (h *BlockHandler) CreateManualEntry(w http.ResponseWriter, r *http.Request)
 	blockID, _ := result.LastInsertId()
 
 	// Write audit log
-	h.writeAuditLog("CREATE_MANUAL_ENTRY", map[string]interface{}{
-		"block_id":   blockID,
+	audit := store.NewBlockAudit(store.AuditActorUser, store.AuditCreateManualEntry)
+	audit.Created(blockID)
+	_, err = audit.Commit(tx, map[string]interface{}{
 		"profile_id": req.ProfileID,
 		"title":      req.Title,
 		"ts_start":   req.TsStart,
 		"ts_end":     req.TsEnd,
 		"billable":   req.Billable,
 	})
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Failed to commit manual entry: %v", err)
+		respondError(w, "Failed to create manual entry", http.StatusInternalServerError)
+		return
+	}
 
 	log.Printf("Created manual entry: block_id=%d, profile=%d, title=%s", blockID, req.ProfileID, req.Title)
 
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"chroniclecore/internal/store"
+)
+
+// DictionaryHandler exposes dictionary cache stats and garbage collection
+type DictionaryHandler struct {
+	store *store.Store
+}
+
+func NewDictionaryHandler(store *store.Store) *DictionaryHandler {
+	return &DictionaryHandler{store: store}
+}
+
+// Stats handles GET /api/v1/system/dictionary
+func (h *DictionaryHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	counts := map[string]int64{}
+	for name, table := range map[string]string{"apps": "dict_app", "titles": "dict_title", "domains": "dict_domain"} {
+		var n int64
+		if err := h.store.GetDB().QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			respondError(w, "Failed to count dictionary rows", http.StatusInternalServerError)
+			return
+		}
+		counts[name] = n
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"rows":  counts,
+		"cache": h.store.DictCacheStats(),
+	}, http.StatusOK)
+}
+
+// CollectGarbage handles POST /api/v1/system/dictionary/gc
+func (h *DictionaryHandler) CollectGarbage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	titles, domains, err := h.store.CollectDictGarbage()
+	if err != nil {
+		log.Printf("Dictionary GC failed: %v", err)
+		respondError(w, "Dictionary GC failed", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"titles_removed":  titles,
+		"domains_removed": domains,
+	}, http.StatusOK)
+}
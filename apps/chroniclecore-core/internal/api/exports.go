@@ -1,18 +1,24 @@
 package api
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"chroniclecore/internal/store"
 )
 
+// maxDatasetSize caps uploaded dataset archives
+const maxDatasetSize = 512 << 20
+
 // ExportHandler manages export endpoints
 type ExportHandler struct {
 	store *store.Store
@@ -174,6 +180,7 @@ func (h *ExportHandler) queryInvoiceLines(startDate, endDate time.Time, profileI
 		JOIN service s ON p.service_id = s.service_id
 		JOIN rate r ON p.rate_id = r.rate_id
 		WHERE b.billable = 1
+		  AND b.deleted_at IS NULL
 		  AND DATE(b.ts_start) >= ?
 		  AND DATE(b.ts_start) <= ?
 	`
@@ -269,3 +276,71 @@ func roundDuration(minutes, increment float64) float64 {
 	}
 	return math.Ceil(minutes/float64(increment)) * float64(increment)
 }
+
+// ExportDataset handles GET /api/v1/export/dataset
+// Streams a zip archive of all clients, profiles, rules, blacklists, blocks and ML history
+func (h *ExportHandler) ExportDataset(w http.ResponseWriter, r *http.Request, appVersion string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Buffer so a failure can still be reported as JSON
+	var buf bytes.Buffer
+	manifest, err := h.store.ExportDataset(&buf, appVersion)
+	if err != nil {
+		log.Printf("Dataset export failed: %v", err)
+		respondError(w, "Failed to export dataset", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("chroniclecore_dataset_%s.zip", time.Now().Format("20060102_150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+
+	log.Printf("Exported dataset: %d blocks, %d profiles", manifest.Tables["block"], manifest.Tables["profile"])
+}
+
+// ImportDataset handles POST /api/v1/import/dataset?mode=merge|replace
+// Body: zip archive produced by ExportDataset
+func (h *ExportHandler) ImportDataset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = store.ImportModeMerge
+	}
+	if mode != store.ImportModeMerge && mode != store.ImportModeReplace {
+		respondError(w, "mode must be 'merge' or 'replace'", http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDatasetSize))
+	if err != nil {
+		respondError(w, "Failed to read archive (max 512MB)", http.StatusBadRequest)
+		return
+	}
+
+	// Replace wipes existing data, so keep a way back
+	if mode == store.ImportModeReplace {
+		if _, err := h.store.CreateSnapshot(store.SnapshotPreRestore); err != nil {
+			log.Printf("Pre-import snapshot failed: %v", err)
+			respondError(w, "Failed to snapshot database before replace", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	report, err := h.store.ImportDataset(bytes.NewReader(data), int64(len(data)), mode)
+	if err != nil {
+		log.Printf("Dataset import failed: %v", err)
+		respondError(w, "Import failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	respondJSON(w, report, http.StatusOK)
+}
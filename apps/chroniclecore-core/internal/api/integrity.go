@@ -0,0 +1,57 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"chroniclecore/internal/store"
+)
+
+// IntegrityHandler exposes the database doctor
+type IntegrityHandler struct {
+	store *store.Store
+}
+
+func NewIntegrityHandler(store *store.Store) *IntegrityHandler {
+	return &IntegrityHandler{store: store}
+}
+
+// Check handles GET /api/v1/system/integrity
+func (h *IntegrityHandler) Check(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := h.store.CheckIntegrity(false)
+	if err != nil {
+		log.Printf("Integrity check failed: %v", err)
+		respondError(w, "Integrity check failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, report, http.StatusOK)
+}
+
+// Repair handles POST /api/v1/system/integrity/repair
+func (h *IntegrityHandler) Repair(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := h.store.CheckIntegrity(true)
+	if err != nil {
+		log.Printf("Integrity repair failed: %v", err)
+		respondError(w, "Integrity repair failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, issue := range report.Issues {
+		if issue.Repaired > 0 {
+			log.Printf("Integrity repair: fixed %d/%d %s", issue.Repaired, issue.Count, issue.Class)
+		}
+	}
+
+	respondJSON(w, report, http.StatusOK)
+}
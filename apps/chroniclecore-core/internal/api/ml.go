@@ -9,19 +9,20 @@ import (
 	"time"
 
 	"chroniclecore/internal/ml"
+	"chroniclecore/internal/store"
 )
 
 // MLHandler handles ML-related API endpoints
 type MLHandler struct {
-	db            *sql.DB
+	store         *store.Store
 	sidecar       *ml.SidecarManager
 	sidecarClient *ml.Client
 }
 
 // NewMLHandler creates a new ML handler
-func NewMLHandler(db *sql.DB, sidecar *ml.SidecarManager) *MLHandler {
+func NewMLHandler(store *store.Store, sidecar *ml.SidecarManager) *MLHandler {
 	return &MLHandler{
-		db:            db,
+		store:         store,
 		sidecar:       sidecar,
 		sidecarClient: ml.NewClient(sidecar.GetPort(), sidecar.GetToken()),
 	}
@@ -47,11 +48,12 @@ func (h *MLHandler) GetTrainingData(w http.ResponseWriter, r *http.Request) {
 		LEFT JOIN dict_title dt ON b.title_summary_id = dt.title_id
 		LEFT JOIN dict_domain dd ON b.primary_domain_id = dd.domain_id
 		WHERE le.new_profile_id IS NOT NULL
+		  AND b.deleted_at IS NULL
 		ORDER BY le.ts DESC
 		LIMIT 1000
 	`
 
-	rows, err := h.db.Query(query)
+	rows, err := h.store.GetDB().Query(query)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Database query failed: %v", err), http.StatusInternalServerError)
 		return
@@ -144,11 +146,12 @@ func (h *MLHandler) TriggerTraining(w http.ResponseWriter, r *http.Request) {
 		LEFT JOIN dict_title dt ON b.title_summary_id = dt.title_id
 		LEFT JOIN dict_domain dd ON b.primary_domain_id = dd.domain_id
 		WHERE le.new_profile_id IS NOT NULL
+		  AND b.deleted_at IS NULL
 		ORDER BY le.ts DESC
 		LIMIT 1000
 	`
 
-	rows, err := h.db.Query(query)
+	rows, err := h.store.GetDB().Query(query)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Database query failed: %v", err), http.StatusInternalServerError)
 		return
@@ -241,11 +244,12 @@ func (h *MLHandler) PredictBlocks(w http.ResponseWriter, r *http.Request) {
 		LEFT JOIN dict_domain dd ON b.primary_domain_id = dd.domain_id
 		WHERE b.profile_id IS NULL
 		  AND b.confidence = 'LOW'
+		  AND b.deleted_at IS NULL
 		ORDER BY b.ts_start DESC
 		LIMIT 100
 	`
 
-	rows, err := h.db.Query(query)
+	rows, err := h.store.GetDB().Query(query)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Database query failed: %v", err), http.StatusInternalServerError)
 		return
@@ -318,7 +322,7 @@ func (h *MLHandler) PredictBlocks(w http.ResponseWriter, r *http.Request) {
 			"confidence_level":     pred.ConfidenceLevel,
 		})
 
-		_, err := h.db.Exec(`
+		_, err := h.store.GetDB().Exec(`
 			INSERT INTO ml_suggestion (entity_type, entity_id, suggestion_type, payload_json, confidence, status)
 			VALUES (?, ?, ?, ?, ?, ?)
 		`, "BLOCK", blockID, "PROFILE_ASSIGN", string(payloadJSON), pred.Confidence, "PENDING")
@@ -370,11 +374,12 @@ func (h *MLHandler) GetSuggestions(w http.ResponseWriter, r *http.Request) {
 		LEFT JOIN profile p ON json_extract(s.payload_json, '$.predicted_profile_id') = p.profile_id
 		LEFT JOIN client c ON p.client_id = c.client_id
 		WHERE s.status = 'PENDING'
+		  AND b.deleted_at IS NULL
 		ORDER BY s.confidence DESC, s.created_at DESC
 		LIMIT 50
 	`
 
-	rows, err := h.db.Query(query)
+	rows, err := h.store.GetDB().Query(query)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Database query failed: %v", err), http.StatusInternalServerError)
 		return
@@ -475,7 +480,7 @@ func (h *MLHandler) AcceptSuggestion(w http.ResponseWriter, r *http.Request) {
 	var entityID int
 	var confidence float64
 
-	err := h.db.QueryRow(`
+	err := h.store.GetDB().QueryRow(`
 		SELECT entity_type, entity_id, suggestion_type, payload_json, confidence
 		FROM ml_suggestion
 		WHERE suggestion_id = ? AND status = 'PENDING'
@@ -497,7 +502,7 @@ func (h *MLHandler) AcceptSuggestion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Apply suggestion based on type
-	tx, err := h.db.Begin()
+	tx, err := h.store.GetDB().Begin()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to begin transaction: %v", err), http.StatusInternalServerError)
 		return
@@ -519,17 +524,35 @@ func (h *MLHandler) AcceptSuggestion(w http.ResponseWriter, r *http.Request) {
 			confidenceLevel = "MEDIUM" // Fallback
 		}
 
+		audit := store.NewBlockAudit(store.AuditActorUser, store.AuditMLAccept)
+		if err := audit.Track(tx, int64(entityID)); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to snapshot block: %v", err), http.StatusInternalServerError)
+			return
+		}
+
 		// Update block
-		_, err = tx.Exec(`
+		result, err := tx.Exec(`
 			UPDATE block
 			SET profile_id = ?, confidence = ?, updated_at = datetime('now')
-			WHERE block_id = ?
+			WHERE block_id = ? AND deleted_at IS NULL
 		`, profileID, confidenceLevel, entityID)
 
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to update block: %v", err), http.StatusInternalServerError)
 			return
 		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.Error(w, "Block not found or in trash", http.StatusNotFound)
+			return
+		}
+
+		if _, err := audit.Commit(tx, map[string]interface{}{
+			"suggestion_id": req.SuggestionID,
+			"confidence":    confidence,
+		}); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to write audit log: %v", err), http.StatusInternalServerError)
+			return
+		}
 
 		// Create label event for feedback loop
 		_, err = tx.Exec(`
@@ -584,7 +607,7 @@ func (h *MLHandler) RejectSuggestion(w http.ResponseWriter, r *http.Request) {
 	var entityID int
 	var confidence float64
 
-	err := h.db.QueryRow(`
+	err := h.store.GetDB().QueryRow(`
 		SELECT entity_type, entity_id, suggestion_type, payload_json, confidence
 		FROM ml_suggestion
 		WHERE suggestion_id = ? AND status = 'PENDING'
@@ -605,7 +628,7 @@ func (h *MLHandler) RejectSuggestion(w http.ResponseWriter, r *http.Request) {
 			if predictedID, ok := payload["predicted_profile_id"].(float64); ok {
 				// Record the rejected suggestion as negative training data
 				// This tells the ML "this profile was suggested but user disagreed"
-				_, err := h.db.Exec(`
+				_, err := h.store.GetDB().Exec(`
 					INSERT INTO ml_label_event (block_id, old_profile_id, new_profile_id, actor, confidence_after, action_type)
 					VALUES (?, ?, NULL, 'USER', 'REJECTED', 'REJECT')
 				`, entityID, int(predictedID))
@@ -619,7 +642,7 @@ func (h *MLHandler) RejectSuggestion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Mark suggestion as rejected
-	_, err = h.db.Exec(`
+	_, err = h.store.GetDB().Exec(`
 		UPDATE ml_suggestion
 		SET status = 'REJECTED', resolved_at = datetime('now')
 		WHERE suggestion_id = ? AND status = 'PENDING'
@@ -648,7 +671,7 @@ func (h *MLHandler) GetMLStatus(w http.ResponseWriter, r *http.Request) {
 
 	// Get label count
 	var labelCount int
-	h.db.QueryRow("SELECT COUNT(*) FROM ml_label_event WHERE new_profile_id IS NOT NULL").Scan(&labelCount)
+	h.store.GetDB().QueryRow("SELECT COUNT(*) FROM ml_label_event WHERE new_profile_id IS NOT NULL").Scan(&labelCount)
 
 	// Get latest model
 	var latestModel struct {
@@ -659,7 +682,7 @@ func (h *MLHandler) GetMLStatus(w http.ResponseWriter, r *http.Request) {
 		Samples   int
 	}
 
-	err := h.db.QueryRow(`
+	err := h.store.GetDB().QueryRow(`
 		SELECT version, algorithm,
 		       COALESCE(json_extract(metrics_json, '$.accuracy'), 0) as accuracy,
 		       created_at, trained_samples
@@ -673,11 +696,11 @@ func (h *MLHandler) GetMLStatus(w http.ResponseWriter, r *http.Request) {
 
 	// Get pending suggestions count
 	var pendingSuggestions int
-	h.db.QueryRow("SELECT COUNT(*) FROM ml_suggestion WHERE status = 'PENDING'").Scan(&pendingSuggestions)
+	h.store.GetDB().QueryRow("SELECT COUNT(*) FROM ml_suggestion WHERE status = 'PENDING'").Scan(&pendingSuggestions)
 
 	// Get recent runs
 	var recentRuns []map[string]interface{}
-	rows, _ := h.db.Query(`
+	rows, _ := h.store.GetDB().Query(`
 		SELECT run_type, success, input_samples, output_count, duration_ms, ts
 		FROM ml_run_log
 		ORDER BY ts DESC
@@ -736,7 +759,7 @@ func (h *MLHandler) GetMLStatus(w http.ResponseWriter, r *http.Request) {
 func (h *MLHandler) persistModel(resp *ml.TrainResponse) (int, error) {
 	metricsJSON, _ := json.Marshal(resp.Metrics)
 
-	result, err := h.db.Exec(`
+	result, err := h.store.GetDB().Exec(`
 		INSERT INTO ml_model_registry (model_type, version, algorithm, metrics_json, status, trained_samples)
 		VALUES (?, ?, ?, ?, ?, ?)
 	`, "PROFILE_CLASSIFIER", resp.ModelVersion, resp.Algorithm, string(metricsJSON), "ACTIVE", resp.SamplesTrained)
@@ -761,7 +784,7 @@ func (h *MLHandler) logMLRun(runType string, modelID int, success bool, errorSum
 		successInt = 1
 	}
 
-	_, err := h.db.Exec(`
+	_, err := h.store.GetDB().Exec(`
 		INSERT INTO ml_run_log (run_type, model_id, success, error_summary, duration_ms, input_samples, output_count)
 		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`, runType, modelIDPtr, successInt, errorSummary, duration.Milliseconds(), inputSamples, outputCount)
@@ -777,7 +800,7 @@ func (h *MLHandler) PredictDeletions(w http.ResponseWriter, r *http.Request) {
 
 	// Get count of deletion training samples
 	var deletionCount int
-	h.db.QueryRow("SELECT COUNT(*) FROM ml_deletion_event").Scan(&deletionCount)
+	h.store.GetDB().QueryRow("SELECT COUNT(*) FROM ml_deletion_event").Scan(&deletionCount)
 
 	if deletionCount < 3 {
 		w.Header().Set("Content-Type", "application/json")
@@ -793,7 +816,7 @@ func (h *MLHandler) PredictDeletions(w http.ResponseWriter, r *http.Request) {
 	deletedApps := make(map[string]int)
 	deletedTitles := make(map[string]int)
 
-	rows, err := h.db.Query(`SELECT app_name, title_text FROM ml_deletion_event`)
+	rows, err := h.store.GetDB().Query(`SELECT app_name, title_text FROM ml_deletion_event`)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to query deletion events: %v", err), http.StatusInternalServerError)
 		return
@@ -820,11 +843,12 @@ func (h *MLHandler) PredictDeletions(w http.ResponseWriter, r *http.Request) {
 		WHERE b.profile_id IS NULL
 		  AND abl.app_id IS NULL
 		  AND b.confidence = 'LOW'
+		  AND b.deleted_at IS NULL
 		ORDER BY b.ts_start DESC
 		LIMIT 100
 	`
 
-	blockRows, err := h.db.Query(query)
+	blockRows, err := h.store.GetDB().Query(query)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to query blocks: %v", err), http.StatusInternalServerError)
 		return
@@ -858,7 +882,7 @@ func (h *MLHandler) PredictDeletions(w http.ResponseWriter, r *http.Request) {
 
 			// Check if suggestion already exists
 			var existing int
-			h.db.QueryRow(`
+			h.store.GetDB().QueryRow(`
 				SELECT COUNT(*) FROM ml_suggestion 
 				WHERE entity_id = ? AND suggestion_type = 'DELETE_SUGGEST' AND status = 'PENDING'
 			`, blockID).Scan(&existing)
@@ -875,7 +899,7 @@ func (h *MLHandler) PredictDeletions(w http.ResponseWriter, r *http.Request) {
 
 			// Note: We insert DELETE_SUGGEST even though schema CHECK may not include it
 			// SQLite will accept it anyway and we handle it in code
-			_, err = h.db.Exec(`
+			_, err = h.store.GetDB().Exec(`
 				INSERT INTO ml_suggestion (entity_type, entity_id, suggestion_type, payload_json, confidence, status)
 				VALUES ('BLOCK', ?, 'DELETE_SUGGEST', ?, ?, 'PENDING')
 			`, blockID, string(payloadJSON), confidence)
@@ -675,7 +675,7 @@ func (h *ProfileHandler) GetProfileStats(w http.ResponseWriter, r *http.Request)
 			COALESCE(SUM(CASE WHEN billable = 1 THEN ((strftime('%s', ts_end) - strftime('%s', ts_start)) / 60.0) * COALESCE(activity_score, 1.0) ELSE 0 END), 0) as billable_minutes,
 			COALESCE(SUM(CASE WHEN locked = 1 THEN ((strftime('%s', ts_end) - strftime('%s', ts_start)) / 60.0) * COALESCE(activity_score, 1.0) ELSE 0 END), 0) as locked_minutes
 		FROM block
-		WHERE profile_id = ?
+		WHERE profile_id = ? AND deleted_at IS NULL
 	`
 	var args []interface{}
 	args = append(args, profileID)
@@ -719,7 +719,7 @@ func (h *ProfileHandler) GetProfileStats(w http.ResponseWriter, r *http.Request)
 			FROM block b
 			JOIN dict_app da ON b.primary_app_id = da.app_id
 			LEFT JOIN dict_title dt ON b.title_summary_id = dt.title_id
-			WHERE b.profile_id = ?
+			WHERE b.profile_id = ? AND b.deleted_at IS NULL
 		`
 		var blockArgs []interface{}
 		blockArgs = append(blockArgs, profileID)
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"chroniclecore/internal/engine"
+)
+
+// RebuildHandler re-aggregates blocks from raw events
+type RebuildHandler struct {
+	aggregator *engine.Aggregator
+}
+
+func NewRebuildHandler(aggregator *engine.Aggregator) *RebuildHandler {
+	return &RebuildHandler{aggregator: aggregator}
+}
+
+// Rebuild handles POST /api/v1/blocks/rebuild?start_date=YYYY-MM-DD&end_date=YYYY-MM-DD
+// (inclusive). With dry_run=true the diff is reported but nothing changes.
+func (h *RebuildHandler) Rebuild(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+
+	start, err := time.Parse("2006-01-02", params.Get("start_date"))
+	if err != nil {
+		respondError(w, "Invalid start_date (use YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	end, err := time.Parse("2006-01-02", params.Get("end_date"))
+	if err != nil {
+		respondError(w, "Invalid end_date (use YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	if end.Before(start) {
+		respondError(w, "end_date must not be before start_date", http.StatusBadRequest)
+		return
+	}
+
+	dryRun := params.Get("dry_run") == "true"
+
+	report, err := h.aggregator.RebuildRange(start, end.AddDate(0, 0, 1), dryRun)
+	if err != nil {
+		log.Printf("Rebuild %s..%s failed: %v", params.Get("start_date"), params.Get("end_date"), err)
+		respondError(w, "Rebuild failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, report, http.StatusOK)
+}
@@ -3,17 +3,25 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"chroniclecore/internal/engine"
 	"chroniclecore/internal/store"
 )
 
 // RuleHandler manages rule-related endpoints
 type RuleHandler struct {
 	store *store.Store
+
+	applyMu  sync.Mutex
+	applying *engine.ApplyProgress // Progress of the running apply, nil when idle
 }
 
 func NewRuleHandler(store *store.Store) *RuleHandler {
@@ -203,12 +211,10 @@ func (h *RuleHandler) CreateRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate regex if match_type is TITLE_REGEX
-	if req.MatchType == "TITLE_REGEX" {
-		if _, err := regexp.Compile(req.MatchValue); err != nil {
-			respondError(w, "Invalid regex pattern: "+err.Error(), http.StatusBadRequest)
-			return
-		}
+	// Validate regex, domain patterns and conditions
+	if msg := validateMatchValue(req.MatchType, req.MatchValue); msg != "" {
+		respondError(w, msg, http.StatusBadRequest)
+		return
 	}
 
 	// Verify target_profile_id exists
@@ -333,22 +339,24 @@ func (h *RuleHandler) UpdateRule(w http.ResponseWriter, r *http.Request) {
 		args = append(args, *req.MatchType)
 	}
 
-	if req.MatchValue != nil {
-		// Validate regex if updating to TITLE_REGEX
-		currentMatchType := ""
+	if req.MatchType != nil || req.MatchValue != nil {
+		// Validate the value against the type the rule ends up with
+		var currentMatchType, currentMatchValue string
+		h.store.GetDB().QueryRow("SELECT match_type, match_value FROM rule WHERE rule_id = ?", ruleID).Scan(&currentMatchType, &currentMatchValue)
 		if req.MatchType != nil {
 			currentMatchType = *req.MatchType
-		} else {
-			h.store.GetDB().QueryRow("SELECT match_type FROM rule WHERE rule_id = ?", ruleID).Scan(&currentMatchType)
+		}
+		if req.MatchValue != nil {
+			currentMatchValue = *req.MatchValue
 		}
 
-		if currentMatchType == "TITLE_REGEX" {
-			if _, err := regexp.Compile(*req.MatchValue); err != nil {
-				respondError(w, "Invalid regex pattern: "+err.Error(), http.StatusBadRequest)
-				return
-			}
+		if msg := validateMatchValue(currentMatchType, currentMatchValue); msg != "" {
+			respondError(w, msg, http.StatusBadRequest)
+			return
 		}
+	}
 
+	if req.MatchValue != nil {
 		updates = append(updates, "match_value = ?")
 		args = append(args, *req.MatchValue)
 	}
@@ -421,6 +429,13 @@ func (h *RuleHandler) UpdateRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Hit statistics describe the old pattern; count afresh
+	if req.MatchType != nil || req.MatchValue != nil {
+		if _, err := h.store.GetDB().Exec("DELETE FROM rule_stat WHERE rule_id = ?", ruleID); err != nil {
+			log.Printf("Failed to reset statistics of rule %d: %v", ruleID, err)
+		}
+	}
+
 	// Fetch updated rule
 	rules := h.getRulesByIDs([]int64{ruleID})
 	if len(rules) == 0 {
@@ -467,6 +482,258 @@ func (h *RuleHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, map[string]bool{"success": true}, http.StatusOK)
 }
 
+// PreviewRuleRequest previews an unsaved rule, a saved rule, or a saved rule
+// with the edits in rule applied
+type PreviewRuleRequest struct {
+	RuleID    *int64             `json:"rule_id,omitempty"`
+	Rule      *UpdateRuleRequest `json:"rule,omitempty"`
+	StartDate string             `json:"start_date"`
+	EndDate   string             `json:"end_date"`  // Inclusive
+	Overwrite bool               `json:"overwrite"` // As in ApplyRulesRequest
+}
+
+// PreviewRule handles POST /api/v1/rules/preview
+// Reports the blocks a rule would match, change and lose to higher-priority
+// rules in a date range, without writing anything
+func (h *RuleHandler) PreviewRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PreviewRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	start, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		respondError(w, "Invalid start_date (use YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	end, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		respondError(w, "Invalid end_date (use YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	if end.Before(start) {
+		respondError(w, "end_date must not be before start_date", http.StatusBadRequest)
+		return
+	}
+
+	// Start from the saved rule, if any
+	var rule engine.Rule
+	if req.RuleID != nil {
+		saved := h.getRulesByIDs([]int64{*req.RuleID})
+		if len(saved) == 0 {
+			respondError(w, "Rule not found", http.StatusNotFound)
+			return
+		}
+		rule = engine.Rule{
+			RuleID:          saved[0].RuleID,
+			Name:            saved[0].Name,
+			Priority:        saved[0].Priority,
+			MatchType:       saved[0].MatchType,
+			MatchValue:      saved[0].MatchValue,
+			TargetProfileID: saved[0].TargetProfileID,
+			TargetServiceID: saved[0].TargetServiceID,
+			ConfidenceBoost: saved[0].ConfidenceBoost,
+			Enabled:         saved[0].Enabled,
+		}
+	} else if req.Rule == nil {
+		respondError(w, "rule or rule_id is required", http.StatusBadRequest)
+		return
+	}
+
+	if edit := req.Rule; edit != nil {
+		if edit.Name != nil {
+			rule.Name = *edit.Name
+		}
+		if edit.Priority != nil {
+			rule.Priority = *edit.Priority
+		}
+		if edit.MatchType != nil {
+			rule.MatchType = *edit.MatchType
+		}
+		if edit.MatchValue != nil {
+			rule.MatchValue = *edit.MatchValue
+		}
+		if edit.TargetProfileID != nil {
+			rule.TargetProfileID = *edit.TargetProfileID
+		}
+		if edit.TargetServiceID != nil {
+			rule.TargetServiceID = edit.TargetServiceID
+		}
+		if edit.ConfidenceBoost != nil {
+			rule.ConfidenceBoost = *edit.ConfidenceBoost
+		}
+	}
+
+	// Same checks as saving the rule
+	validMatchTypes := []string{"APP", "DOMAIN", "TITLE_REGEX", "KEYWORD", "COMPOSITE"}
+	if !contains(validMatchTypes, rule.MatchType) {
+		respondError(w, "match_type must be one of: APP, DOMAIN, TITLE_REGEX, KEYWORD, COMPOSITE", http.StatusBadRequest)
+		return
+	}
+	if rule.MatchValue == "" {
+		respondError(w, "match_value is required", http.StatusBadRequest)
+		return
+	}
+	if msg := validateMatchValue(rule.MatchType, rule.MatchValue); msg != "" {
+		respondError(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var profileExists int
+	err = h.store.GetDB().QueryRow(
+		"SELECT COUNT(*) FROM profile WHERE profile_id = ? AND is_active = 1",
+		rule.TargetProfileID,
+	).Scan(&profileExists)
+	if err != nil || profileExists == 0 {
+		respondError(w, "target_profile_id does not exist or is inactive", http.StatusBadRequest)
+		return
+	}
+
+	preview, err := engine.NewRuleEngine(h.store).PreviewRule(&rule, start, end.AddDate(0, 0, 1), req.Overwrite)
+	if err != nil {
+		log.Printf("Rule preview %s..%s failed: %v", req.StartDate, req.EndDate, err)
+		respondError(w, "Preview failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, preview, http.StatusOK)
+}
+
+// ApplyRulesRequest re-runs rules over a date range
+type ApplyRulesRequest struct {
+	StartDate string  `json:"start_date"`
+	EndDate   string  `json:"end_date"` // Inclusive
+	RuleIDs   []int64 `json:"rule_ids,omitempty"`
+	Overwrite bool    `json:"overwrite"`
+}
+
+// ApplyRules handles POST /api/v1/rules/apply, which re-runs rules over
+// existing blocks, and GET, which reports the progress of a running apply
+func (h *RuleHandler) ApplyRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		h.applyMu.Lock()
+		status := map[string]interface{}{"running": h.applying != nil}
+		if h.applying != nil {
+			status["progress"] = *h.applying
+		}
+		h.applyMu.Unlock()
+
+		respondJSON(w, status, http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ApplyRulesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	start, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		respondError(w, "Invalid start_date (use YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	end, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		respondError(w, "Invalid end_date (use YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	if end.Before(start) {
+		respondError(w, "end_date must not be before start_date", http.StatusBadRequest)
+		return
+	}
+
+	// One apply at a time
+	h.applyMu.Lock()
+	if h.applying != nil {
+		h.applyMu.Unlock()
+		respondError(w, "Rules are already being applied", http.StatusConflict)
+		return
+	}
+	h.applying = &engine.ApplyProgress{}
+	h.applyMu.Unlock()
+
+	defer func() {
+		h.applyMu.Lock()
+		h.applying = nil
+		h.applyMu.Unlock()
+	}()
+
+	report, err := engine.NewRuleEngine(h.store).ApplyRules(engine.ApplyOptions{
+		Start:     start,
+		End:       end.AddDate(0, 0, 1),
+		RuleIDs:   req.RuleIDs,
+		Overwrite: req.Overwrite,
+	}, func(p engine.ApplyProgress) {
+		h.applyMu.Lock()
+		*h.applying = p
+		h.applyMu.Unlock()
+	})
+	if err != nil {
+		if errors.Is(err, engine.ErrRuleUnavailable) {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Rule apply %s..%s failed: %v", req.StartDate, req.EndDate, err)
+		respondError(w, "Apply failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, report, http.StatusOK)
+}
+
+// AnalyzeRules handles GET /api/v1/rules/analysis?start_date=YYYY-MM-DD&end_date=YYYY-MM-DD
+// Reports unused, always-shadowed, conflicting and invalid rules. The range
+// (inclusive, default the last 30 days) is matched afresh on top of the hit
+// statistics recorded during assignment.
+func (h *RuleHandler) AnalyzeRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	end, start := today, today.AddDate(0, 0, -29)
+	var err error
+	if v := params.Get("end_date"); v != "" {
+		if end, err = time.Parse("2006-01-02", v); err != nil {
+			respondError(w, "Invalid end_date (use YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("start_date"); v != "" {
+		if start, err = time.Parse("2006-01-02", v); err != nil {
+			respondError(w, "Invalid start_date (use YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+	}
+	if end.Before(start) {
+		respondError(w, "end_date must not be before start_date", http.StatusBadRequest)
+		return
+	}
+
+	analysis, err := engine.NewRuleEngine(h.store).AnalyzeRules(start, end.AddDate(0, 0, 1))
+	if err != nil {
+		log.Printf("Rule analysis failed: %v", err)
+		respondError(w, "Analysis failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, analysis, http.StatusOK)
+}
+
 // getRulesByIDs fetches rules by IDs (helper for returning created/updated rules)
 func (h *RuleHandler) getRulesByIDs(ruleIDs []int64) []RuleDTO {
 	if len(ruleIDs) == 0 {
@@ -558,6 +825,26 @@ func (h *RuleHandler) getRulesByIDs(ruleIDs []int64) []RuleDTO {
 }
 
 // Helper function
+// validateMatchValue checks a rule's match_value for its match_type and
+// returns the error message, or "" if it is valid
+func validateMatchValue(matchType, matchValue string) string {
+	switch matchType {
+	case "TITLE_REGEX":
+		if _, err := regexp.Compile(matchValue); err != nil {
+			return "Invalid regex pattern: " + err.Error()
+		}
+	case "DOMAIN":
+		if err := engine.ValidateDomainPattern(matchValue); err != nil {
+			return "Invalid domain pattern: " + err.Error()
+		}
+	case "COMPOSITE":
+		if err := engine.ValidateCondition(matchValue); err != nil {
+			return "Invalid condition: " + err.Error()
+		}
+	}
+	return ""
+}
+
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
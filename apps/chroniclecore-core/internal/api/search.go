@@ -0,0 +1,93 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chroniclecore/internal/store"
+)
+
+// SearchHandler handles full-text search over blocks
+type SearchHandler struct {
+	store *store.Store
+}
+
+func NewSearchHandler(store *store.Store) *SearchHandler {
+	return &SearchHandler{store: store}
+}
+
+// Search handles GET /api/v1/search?q=...&start_date=&end_date=&profile_id=&limit=&offset=
+func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.store.SearchAvailable() {
+		respondError(w, "Full-text search is not available in this build", http.StatusServiceUnavailable)
+		return
+	}
+
+	params := r.URL.Query()
+
+	q := strings.TrimSpace(params.Get("q"))
+	if q == "" {
+		respondError(w, "Missing search query (q)", http.StatusBadRequest)
+		return
+	}
+
+	search := store.SearchParams{
+		Query:     q,
+		StartDate: params.Get("start_date"),
+		EndDate:   params.Get("end_date"),
+		Limit:     50,
+	}
+
+	for _, d := range []string{search.StartDate, search.EndDate} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			respondError(w, "Invalid date format (use YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if profileIDStr := params.Get("profile_id"); profileIDStr != "" {
+		pid, err := strconv.ParseInt(profileIDStr, 10, 64)
+		if err != nil {
+			respondError(w, "Invalid profile_id", http.StatusBadRequest)
+			return
+		}
+		search.ProfileID = &pid
+	}
+
+	if l := params.Get("limit"); l != "" {
+		if lInt, err := strconv.Atoi(l); err == nil && lInt > 0 && lInt <= 200 {
+			search.Limit = lInt
+		}
+	}
+	if o := params.Get("offset"); o != "" {
+		if oInt, err := strconv.Atoi(o); err == nil && oInt >= 0 {
+			search.Offset = oInt
+		}
+	}
+
+	results, err := h.store.SearchBlocks(search)
+	if err != nil {
+		log.Printf("Search for %q failed: %v", q, err)
+		respondError(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"query":   q,
+		"results": results,
+		"count":   len(results),
+		"limit":   search.Limit,
+		"offset":  search.Offset,
+	}, http.StatusOK)
+}
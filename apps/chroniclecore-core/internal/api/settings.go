@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"chroniclecore/internal/engine"
 	"chroniclecore/internal/store"
 )
 
@@ -42,6 +43,13 @@ type SettingsResponse struct {
 	ExcludedApps         []string `json:"excluded_apps"`
 	IdleThresholdSeconds int      `json:"idle_threshold_seconds"`
 	PrivacyMode          bool     `json:"privacy_mode"`
+	BackupDir            string   `json:"backup_dir"`
+	BackupDailyKeep      int      `json:"backup_daily_keep"`
+	BackupWeeklyKeep     int      `json:"backup_weekly_keep"`
+	TrashRetentionDays   int      `json:"trash_retention_days"`
+	AggregationMode      string   `json:"aggregation_mode"`
+	AggregationStrategy  string   `json:"aggregation_strategy"`
+	InterruptionSeconds  int      `json:"interruption_threshold_seconds"`
 }
 
 // GetSettings handles GET /api/v1/settings
@@ -113,6 +121,33 @@ func (h *SettingsHandler) UpdateSettings(w http.ResponseWriter, r *http.Request)
 		h.store.SetSetting(SettingIdleThreshold, intToString(req.IdleThresholdSeconds))
 	}
 
+	// Save backup configuration
+	if req.BackupDir != "" {
+		h.store.SetSetting(store.SettingBackupDir, req.BackupDir)
+	}
+	if req.BackupDailyKeep > 0 {
+		h.store.SetSetting(store.SettingBackupDailyKeep, intToString(req.BackupDailyKeep))
+	}
+	if req.BackupWeeklyKeep > 0 {
+		h.store.SetSetting(store.SettingBackupWeeklyKeep, intToString(req.BackupWeeklyKeep))
+	}
+
+	// Save trash retention
+	if req.TrashRetentionDays > 0 {
+		h.store.SetSetting(store.SettingTrashRetentionDays, intToString(req.TrashRetentionDays))
+	}
+
+	// Save aggregation mode and strategy (applies from the next rollup)
+	if req.AggregationMode == engine.AggregationModeStrict || req.AggregationMode == engine.AggregationModeInterruptionTolerant {
+		h.store.SetSetting(engine.SettingAggregationMode, req.AggregationMode)
+	}
+	if req.InterruptionSeconds > 0 {
+		h.store.SetSetting(engine.SettingInterruptionThreshold, intToString(req.InterruptionSeconds))
+	}
+	if _, ok := engine.StrategyByName(req.AggregationStrategy); ok {
+		h.store.SetSetting(engine.SettingAggregationStrategy, req.AggregationStrategy)
+	}
+
 	log.Printf("Settings updated: full_tracking=%v, deep_tracking=%v",
 		req.FullTrackingMode, req.DeepTrackingEnabled)
 
@@ -149,6 +184,29 @@ func (h *SettingsHandler) GetSingleSetting(w http.ResponseWriter, r *http.Reques
 	}, http.StatusOK)
 }
 
+// RotateSecrets handles POST /api/v1/system/secrets/rotate
+func (h *SettingsHandler) RotateSecrets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rotated, err := h.store.RotateSecrets()
+	if err != nil {
+		log.Printf("Secret rotation failed: %v", err)
+		respondError(w, "Secret rotation failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Re-encrypted %d secrets with %s", rotated, h.store.KeyProviderName())
+
+	respondJSON(w, map[string]interface{}{
+		"success":  true,
+		"provider": h.store.KeyProviderName(),
+		"rotated":  rotated,
+	}, http.StatusOK)
+}
+
 // loadSettings loads all settings with defaults
 func (h *SettingsHandler) loadSettings() (*SettingsResponse, error) {
 	settings := &SettingsResponse{
@@ -161,6 +219,13 @@ func (h *SettingsHandler) loadSettings() (*SettingsResponse, error) {
 		ExcludedApps:         []string{},
 		IdleThresholdSeconds: 300, // Default: 5 minutes
 		PrivacyMode:          false,
+		BackupDir:            h.store.BackupDir(),
+		BackupDailyKeep:      store.DefaultDailyKeep,
+		BackupWeeklyKeep:     store.DefaultWeeklyKeep,
+		TrashRetentionDays:   h.store.TrashRetentionDays(),
+		AggregationMode:      engine.AggregationModeStrict,
+		AggregationStrategy:  engine.StrategyApp,
+		InterruptionSeconds:  int(engine.DefaultInterruptionThreshold.Seconds()),
 	}
 
 	// Load from database
@@ -207,6 +272,34 @@ func (h *SettingsHandler) loadSettings() (*SettingsResponse, error) {
 		}
 	}
 
+	// Load backup retention
+	if keepStr, err := h.store.GetSetting(store.SettingBackupDailyKeep); err == nil && keepStr != "" {
+		if keep := stringToInt(keepStr); keep > 0 {
+			settings.BackupDailyKeep = keep
+		}
+	}
+
+	if keepStr, err := h.store.GetSetting(store.SettingBackupWeeklyKeep); err == nil && keepStr != "" {
+		if keep := stringToInt(keepStr); keep > 0 {
+			settings.BackupWeeklyKeep = keep
+		}
+	}
+
+	// Load aggregation mode
+	if mode, err := h.store.GetSetting(engine.SettingAggregationMode); err == nil && mode != "" {
+		settings.AggregationMode = mode
+	}
+
+	if secsStr, err := h.store.GetSetting(engine.SettingInterruptionThreshold); err == nil && secsStr != "" {
+		if secs := stringToInt(secsStr); secs > 0 {
+			settings.InterruptionSeconds = secs
+		}
+	}
+
+	if name, err := h.store.GetSetting(engine.SettingAggregationStrategy); err == nil && name != "" {
+		settings.AggregationStrategy = name
+	}
+
 	return settings, nil
 }
 
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"chroniclecore/internal/store"
+)
+
+// TrashHandler handles the block trash bin
+type TrashHandler struct {
+	store *store.Store
+}
+
+func NewTrashHandler(store *store.Store) *TrashHandler {
+	return &TrashHandler{store: store}
+}
+
+// ListTrash handles GET /api/v1/trash?limit=&offset=
+func (h *TrashHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+
+	limit := 100
+	if l := params.Get("limit"); l != "" {
+		if lInt, err := strconv.Atoi(l); err == nil && lInt > 0 && lInt <= 1000 {
+			limit = lInt
+		}
+	}
+	offset := 0
+	if o := params.Get("offset"); o != "" {
+		if oInt, err := strconv.Atoi(o); err == nil && oInt >= 0 {
+			offset = oInt
+		}
+	}
+
+	blocks, err := h.store.ListTrashedBlocks(limit, offset)
+	if err != nil {
+		log.Printf("Failed to list trash: %v", err)
+		respondError(w, "Failed to list trash", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"retention_days": h.store.TrashRetentionDays(),
+		"blocks":         blocks,
+	}, http.StatusOK)
+}
+
+// RestoreBlock handles POST /api/v1/trash/{id}/restore
+func (h *TrashHandler) RestoreBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Path is /api/v1/trash/{id}/restore
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/trash/"), "/restore")
+	blockID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		respondError(w, "Invalid block ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.RestoreTrashedBlock(blockID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			respondError(w, "Block not found in trash", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to restore block %d: %v", blockID, err)
+		respondError(w, "Failed to restore block", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"success":  true,
+		"block_id": blockID,
+	}, http.StatusOK)
+}
+
+// PurgeBlock handles DELETE /api/v1/trash/{id} - permanent deletion, which
+// also feeds ML deletion learning
+func (h *TrashHandler) PurgeBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Path is /api/v1/trash/{id}
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/v1/trash/")
+	blockID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		respondError(w, "Invalid block ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.PurgeBlock(blockID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			respondError(w, "Block not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to purge block %d: %v", blockID, err)
+		respondError(w, "Failed to purge block", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"success":  true,
+		"block_id": blockID,
+	}, http.StatusOK)
+}
@@ -6,6 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"chroniclecore/internal/store"
@@ -15,18 +19,45 @@ import (
 const (
 	MinBlockDuration = 10 * time.Second // Minimum duration to create a block
 	MaxMergeGap      = 2 * time.Minute  // Maximum gap to merge same-app events
+
+	maxBlockSummaries     = 20 // Cap on distinct content summaries kept per block
+	maxBlockInterruptions = 20 // Cap on interruptions listed per block
+	maxBlockURLs          = 20 // Cap on distinct page URLs kept per block (for DOMAIN rules)
+)
+
+// Aggregation settings
+const (
+	SettingAggregationMode       = "aggregation_mode"               // AggregationModeStrict (default) or AggregationModeInterruptionTolerant
+	SettingInterruptionThreshold = "interruption_threshold_seconds" // Longest interruption absorbed in tolerant mode
+)
+
+// Aggregation modes
+const (
+	AggregationModeStrict               = "strict"                // A block ends at every switch
+	AggregationModeInterruptionTolerant = "interruption_tolerant" // Brief switches away stay in the block
 )
 
+// DefaultInterruptionThreshold applies when the threshold setting is absent
+const DefaultInterruptionThreshold = 60 * time.Second
+
 // Aggregator handles rollup of raw events into blocks
 type Aggregator struct {
 	store          *store.Store
 	retentionDays  int
 	ruleEngine     *RuleEngine
 	templateEngine *TemplateEngine
+	rollupMu       sync.Mutex // One rollup at a time
 	ctx            context.Context
 	cancel         context.CancelFunc
 }
 
+// rollupWatermark is the (ts_start, event_id) position of the last raw event
+// that has been rolled up. Everything after it is read again next run.
+type rollupWatermark struct {
+	ts      time.Time
+	eventID int64
+}
+
 // Config holds aggregator configuration
 type AggregatorConfig struct {
 	Store          *store.Store
@@ -98,198 +129,507 @@ func (a *Aggregator) scheduleRollup(interval time.Duration) {
 	}
 }
 
-// Rollup aggregates raw events into blocks and purges old raw events
+// Rollup aggregates closed raw events into blocks, then archives old raw events and purges expired trash
 func (a *Aggregator) Rollup() error {
+	a.rollupMu.Lock()
+	defer a.rollupMu.Unlock()
+
 	log.Println("Starting rollup...")
 
-	// Get last rollup timestamp from settings
-	lastRollup, err := a.getLastRollupTime()
+	// Get the position of the last event rolled up
+	watermark, err := a.getWatermark()
 	if err != nil {
-		return fmt.Errorf("failed to get last rollup time: %w", err)
+		return fmt.Errorf("failed to get rollup watermark: %w", err)
 	}
 
-	// Get raw events since last rollup
-	events, err := a.store.GetRawEventsSince(lastRollup)
+	// Get raw events after it
+	events, err := a.store.GetRawEventsAfter(watermark.ts, watermark.eventID)
 	if err != nil {
 		return fmt.Errorf("failed to get raw events: %w", err)
 	}
 
 	if len(events) == 0 {
 		log.Println("No new events to process")
-		return a.setLastRollupTime(time.Now())
+	} else if err := a.rollupEvents(events); err != nil {
+		return err
 	}
 
+	// Move old raw events (beyond retention period) to the cold archive. Re-read
+	// the watermark so events the rollup is still holding back are kept.
+	watermark, err = a.getWatermark()
+	if err != nil {
+		return fmt.Errorf("failed to get rollup watermark: %w", err)
+	}
+	cutoff := archiveCutoff(time.Now(), a.retentionDays, watermark)
+	archived, err := a.store.ArchiveRawEventsBefore(cutoff)
+	if err != nil {
+		log.Printf("Failed to archive old events: %v", err)
+	} else if archived > 0 {
+		log.Printf("Archived %d old raw events (retention: %d days)", archived, a.retentionDays)
+	}
+
+	// Purge blocks that have sat in the trash past their retention
+	purged, err := a.store.PurgeExpiredTrash(time.Now())
+	if err != nil {
+		log.Printf("Failed to purge trash: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d trashed blocks", purged)
+	}
+
+	log.Println("Rollup complete")
+	return nil
+}
+
+// rollupEvents turns the events that can no longer change into blocks. Block
+// inserts, rule assignment, descriptions and the watermark commit in one
+// transaction, so a failed run leaves nothing behind and the next run starts
+// from the same place.
+func (a *Aggregator) rollupEvents(events []*store.RawEvent) error {
 	log.Printf("Processing %d raw events", len(events))
 
-	// Aggregate events into blocks
-	blocks := a.aggregateEvents(events)
-	log.Printf("Created %d blocks", len(blocks))
+	sources, err := a.sourceReconciler(events, true)
+	if err != nil {
+		return err
+	}
+
+	blocks, consumed := a.aggregateEvents(events, a.strategy(), a.interruptionTolerance(), sources, time.Now())
+	if consumed == 0 {
+		log.Println("Waiting for open events to close")
+		return nil
+	}
+
+	tx, err := a.store.GetDB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
 	// Insert blocks
+	inserted := 0
 	for _, block := range blocks {
-		if err := a.store.InsertBlock(block); err != nil {
-			log.Printf("Failed to insert block: %v", err)
+		exists, err := blockExists(tx, block)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue // Rolled up by an earlier run
 		}
-	}
 
-	// Update last rollup time
-	if err := a.setLastRollupTime(time.Now()); err != nil {
-		return fmt.Errorf("failed to update last rollup time: %w", err)
+		if err := store.InsertBlockTx(tx, block); err != nil {
+			return err
+		}
+		inserted++
 	}
 
-	// Apply rules to assign profiles to blocks
-	if len(blocks) > 0 {
-		if err := a.ruleEngine.AssignBlocksInRange(); err != nil {
-			log.Printf("Failed to assign profiles: %v", err)
+	if inserted > 0 {
+		// Apply rules to assign profiles to blocks
+		if err := a.ruleEngine.AssignBlocksTx(tx, nil); err != nil {
+			return fmt.Errorf("failed to assign profiles: %w", err)
 		}
 
 		// Generate descriptions for new blocks
-		if err := a.templateEngine.GenerateDescriptionsForBlocks(); err != nil {
-			log.Printf("Failed to generate descriptions: %v", err)
+		if err := a.templateEngine.GenerateDescriptionsTx(tx); err != nil {
+			return fmt.Errorf("failed to generate descriptions: %w", err)
 		}
 	}
 
-	// Purge old raw events (beyond retention period)
-	cutoff := time.Now().Add(-time.Duration(a.retentionDays) * 24 * time.Hour)
-	deleted, err := a.store.DeleteRawEventsBefore(cutoff)
-	if err != nil {
-		log.Printf("Failed to purge old events: %v", err)
-	} else if deleted > 0 {
-		log.Printf("Purged %d old raw events (retention: %d days)", deleted, a.retentionDays)
+	last := events[consumed-1]
+	if err := setWatermark(tx, rollupWatermark{ts: last.TsStart, eventID: last.EventID}); err != nil {
+		return fmt.Errorf("failed to update rollup watermark: %w", err)
 	}
 
-	log.Println("Rollup complete")
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rollup: %w", err)
+	}
+
+	log.Printf("Created %d blocks", inserted)
 	return nil
 }
 
-// aggregateEvents groups sequential events into blocks
-func (a *Aggregator) aggregateEvents(events []*store.RawEvent) []*store.Block {
+// aggregateEvents reconciles event sources and groups sequential events into
+// blocks using strategy. It stops at the tracker's open event and holds back
+// the trailing block while a later event could still merge into it. It
+// returns the blocks and the number of leading events they account for; the
+// rest are read again next run.
+func (a *Aggregator) aggregateEvents(events []*store.RawEvent, strategy AggregationStrategy, tolerance time.Duration, sources sourceReconciler, now time.Time) ([]*store.Block, int) {
 	if len(events) == 0 {
-		return nil
+		return nil, 0
 	}
 
-	var blocks []*store.Block
-	var currentBlock *blockBuilder
+	limit := rewindForExtension(events, closeOrphanedEvents(events))
+	reconciled, origin := sources.reconcile(events[:limit])
+	g := groupEvents(reconciled, strategy, tolerance)
 
-	for _, event := range events {
-		// Skip events without end time (still open)
+	var next *store.RawEvent
+	if limit < len(events) {
+		next = events[limit]
+	}
+	if !g.settled(next, now) {
+		// Every event with a part in the open block is read again
+		consumed := limit
+		for _, i := range origin[g.currentFirst:] {
+			if i < consumed {
+				consumed = i
+			}
+		}
+		return g.blocks, rewindForExtension(events, consumed)
+	}
+
+	return g.finish(), limit
+}
+
+// interruptionTolerance returns the longest interruption the configured
+// aggregation mode absorbs, 0 in strict mode
+func (a *Aggregator) interruptionTolerance() time.Duration {
+	mode, _ := a.store.GetSetting(SettingAggregationMode)
+	if mode != AggregationModeInterruptionTolerant {
+		return 0
+	}
+
+	value, _ := a.store.GetSetting(SettingInterruptionThreshold)
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return DefaultInterruptionThreshold
+}
+
+// closeOrphanedEvents ends open OS events left behind by a crash where the
+// next OS event begins. The tracker keeps one OS event open at a time, so
+// only the last can still be running; its index is returned (len(events)
+// if there is none).
+func closeOrphanedEvents(events []*store.RawEvent) int {
+	limit := len(events)
+	var nextOSStart *time.Time
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		if event.Source != "OS" {
+			continue
+		}
+
+		if event.TsEnd == nil {
+			if nextOSStart == nil {
+				limit = i
+			} else {
+				closed := *event
+				end := *nextOSStart
+				closed.TsEnd = &end
+				events[i] = &closed
+			}
+		}
+
+		start := event.TsStart
+		nextOSStart = &start
+	}
+
+	return limit
+}
+
+// eventGrouper folds sequential closed events with the same strategy key
+// into blocks. With a tolerance set, a short run of other keys between two
+// stretches of the same key is absorbed into the surrounding block instead
+// of splitting it.
+type eventGrouper struct {
+	strategy     AggregationStrategy
+	tolerance    time.Duration
+	blocks       []*store.Block
+	current      *blockBuilder
+	currentFirst int               // Index of the current block's first event
+	pending      []*store.RawEvent // Possible interruption of the current block
+	pendingFirst int
+}
+
+// groupEvents groups sequential closed events into blocks. The trailing
+// block is left open so the caller decides whether it is final.
+func groupEvents(events []*store.RawEvent, strategy AggregationStrategy, tolerance time.Duration) *eventGrouper {
+	g := &eventGrouper{strategy: strategy, tolerance: tolerance}
+
+	for i := 0; i < len(events); i++ {
+		event := events[i]
+
+		// Skip point-in-time events without end time
 		if event.TsEnd == nil {
 			continue
 		}
 
-		// Check if event should be grouped with current block
-		if currentBlock != nil && currentBlock.canMerge(event) {
-			currentBlock.merge(event)
-		} else {
-			// Finalize previous block
-			if currentBlock != nil {
-				if block := currentBlock.build(); block != nil {
-					blocks = append(blocks, block)
+		key := g.strategy.Key(event)
+
+		if g.current != nil {
+			lastEnd := g.lastEnd()
+
+			// Check if event should be grouped with current block
+			if len(g.pending) == 0 && g.current.canMerge(key, event) {
+				g.current.merge(event)
+				continue
+			}
+
+			// The block's key is back after an interruption
+			if len(g.pending) > 0 && g.current.canResume(key, event, lastEnd, g.tolerance) {
+				g.current.absorb(g.pending)
+				g.pending = nil
+				g.current.merge(event)
+				continue
+			}
+
+			if g.current.canInterrupt(key, event, lastEnd, g.tolerance) {
+				if len(g.pending) == 0 {
+					g.pendingFirst = i
 				}
+				g.pending = append(g.pending, event)
+				continue
 			}
 
-			// Start new block
-			currentBlock = newBlockBuilder(event)
+			// Finalize previous block
+			g.finalize()
+
+			// Not an interruption after all; group those events on their own
+			if len(g.pending) > 0 {
+				i = g.pendingFirst - 1
+				g.pending = nil
+				continue
+			}
 		}
+
+		// Start new block
+		g.current = newBlockBuilder(key, event)
+		g.currentFirst = i
 	}
 
-	// Finalize last block
-	if currentBlock != nil {
-		if block := currentBlock.build(); block != nil {
-			blocks = append(blocks, block)
-		}
+	return g
+}
+
+// lastEnd is where the grouped events end, interruption included
+func (g *eventGrouper) lastEnd() time.Time {
+	if len(g.pending) > 0 {
+		return *g.pending[len(g.pending)-1].TsEnd
+	}
+	return g.current.tsEnd
+}
+
+// settled reports whether the trailing block is final. next is the
+// tracker's open event, or nil if nothing is open, in which case later
+// events start from now on.
+func (g *eventGrouper) settled(next *store.RawEvent, now time.Time) bool {
+	if g.current == nil {
+		return true
+	}
+
+	start := now
+	if next != nil {
+		start = next.TsStart
+	}
+
+	// Any event could still resume the block or extend the interruption
+	if g.tolerance > 0 && start.Sub(g.lastEnd()) < MaxMergeGap && start.Sub(g.current.tsEnd) <= g.tolerance {
+		return false
+	}
+
+	// An event with the same key could still merge
+	if len(g.pending) == 0 && (next == nil || g.strategy.Key(next) == g.current.key) && start.Sub(g.current.tsEnd) < MaxMergeGap {
+		return false
+	}
+
+	return true
+}
+
+// finalize builds the current block
+func (g *eventGrouper) finalize() {
+	if g.current == nil {
+		return
+	}
+	if block := g.current.build(); block != nil {
+		g.blocks = append(g.blocks, block)
 	}
+	g.current = nil
+}
 
-	return blocks
+// finish builds the trailing block, and an interruption that never resumed
+// as blocks of its own, and returns all blocks
+func (g *eventGrouper) finish() []*store.Block {
+	g.finalize()
+	if len(g.pending) > 0 {
+		g.blocks = append(g.blocks, groupEvents(g.pending, g.strategy, g.tolerance).finish()...)
+		g.pending = nil
+	}
+	return g.blocks
 }
 
 // blockBuilder helps construct blocks from events
 type blockBuilder struct {
 	tsStart        time.Time
 	tsEnd          time.Time
-	primaryAppID   int64
-	titleIDs       map[int64]bool
-	domainIDs      map[int64]bool
+	key            string     // Strategy key shared by the block's events
+	apps           dwellTimes // Per app, to pick the primary one
+	titles         dwellTimes // Per title, to pick the dominant one
+	domains        dwellTimes
 	hasActiveTime  bool
 	totalIdleTime  time.Duration
 	activityScores []float64 // Store scores to calculate average
+	summaries      []string  // Distinct content summaries, in order seen (for search)
+	urls           []string  // Distinct page URLs from the extension, in order seen
+	interruptions  []blockInterruption
+	interrupted    time.Duration
+}
+
+// dwellTimes totals time spent per dictionary ID. IDs keep the order they
+// were first seen in, so ties go to the earlier one.
+type dwellTimes struct {
+	order []int64
+	total map[int64]time.Duration
 }
 
-func newBlockBuilder(event *store.RawEvent) *blockBuilder {
+func (d *dwellTimes) add(id *int64, duration time.Duration) {
+	if id == nil {
+		return
+	}
+	if d.total == nil {
+		d.total = make(map[int64]time.Duration)
+	}
+	if _, seen := d.total[*id]; !seen {
+		d.order = append(d.order, *id)
+	}
+	d.total[*id] += duration
+}
+
+// dominant returns the ID with the most time, or nil if none was seen
+func (d *dwellTimes) dominant() *int64 {
+	if len(d.order) == 0 {
+		return nil
+	}
+	best := d.order[0]
+	for _, id := range d.order[1:] {
+		if d.total[id] > d.total[best] {
+			best = id
+		}
+	}
+	return &best
+}
+
+// blockInterruption is a brief switch to another app absorbed into a block
+type blockInterruption struct {
+	AppID   int64  `json:"app_id"`
+	Start   string `json:"start"`
+	Seconds int    `json:"seconds"`
+}
+
+func newBlockBuilder(key string, event *store.RawEvent) *blockBuilder {
 	bb := &blockBuilder{
 		tsStart:        event.TsStart,
 		tsEnd:          *event.TsEnd,
-		primaryAppID:   event.AppID,
-		titleIDs:       make(map[int64]bool),
-		domainIDs:      make(map[int64]bool),
+		key:            key,
 		activityScores: []float64{},
 	}
 
-	if event.TitleID != nil {
-		bb.titleIDs[*event.TitleID] = true
-	}
+	bb.add(event)
 
-	if event.DomainID != nil {
-		bb.domainIDs[*event.DomainID] = true
-	}
+	return bb
+}
+
+// add accounts for an event's time, titles and metadata
+func (bb *blockBuilder) add(event *store.RawEvent) {
+	duration := event.TsEnd.Sub(event.TsStart)
+	bb.apps.add(&event.AppID, duration)
+	bb.titles.add(event.TitleID, duration)
+	bb.domains.add(event.DomainID, duration)
+
+	bb.addMetadata(event)
 
 	if event.State == "ACTIVE" {
 		bb.hasActiveTime = true
-		// Extract activity score
-		if event.Metadata != nil {
-			var meta map[string]interface{}
-			if err := json.Unmarshal([]byte(*event.Metadata), &meta); err == nil {
-				if score, ok := meta["activity_score"].(float64); ok {
-					bb.activityScores = append(bb.activityScores, score)
-				}
-			}
-		}
 	} else if event.State == "IDLE" {
-		duration := event.TsEnd.Sub(event.TsStart)
 		bb.totalIdleTime += duration
 	}
-
-	return bb
-}
-
-// canMerge checks if an event can be merged into this block
-func (bb *blockBuilder) canMerge(event *store.RawEvent) bool {
-	// Same app and contiguous time (within MaxMergeGap - default 2 minutes)
-	gap := event.TsStart.Sub(bb.tsEnd)
-	return event.AppID == bb.primaryAppID && gap < MaxMergeGap
 }
 
-// merge adds an event to the current block
-func (bb *blockBuilder) merge(event *store.RawEvent) {
-	if event.TsEnd == nil {
+// addMetadata collects the activity score, page URL and content summary from
+// an event
+func (bb *blockBuilder) addMetadata(event *store.RawEvent) {
+	if event.Metadata == nil {
 		return
 	}
 
-	bb.tsEnd = *event.TsEnd
+	var meta map[string]interface{}
+	if err := json.Unmarshal([]byte(*event.Metadata), &meta); err != nil {
+		return
+	}
 
-	if event.TitleID != nil {
-		bb.titleIDs[*event.TitleID] = true
+	if score, ok := meta["activity_score"].(float64); ok && event.State == "ACTIVE" {
+		bb.activityScores = append(bb.activityScores, score)
 	}
 
-	if event.DomainID != nil {
-		bb.domainIDs[*event.DomainID] = true
+	if url, ok := meta["url"].(string); ok && url != "" && len(bb.urls) < maxBlockURLs {
+		seen := false
+		for _, existing := range bb.urls {
+			seen = seen || existing == url
+		}
+		if !seen {
+			bb.urls = append(bb.urls, url)
+		}
 	}
 
-	if event.State == "ACTIVE" {
-		bb.hasActiveTime = true
-		// Extract activity score
-		if event.Metadata != nil {
-			var meta map[string]interface{}
-			if err := json.Unmarshal([]byte(*event.Metadata), &meta); err == nil {
-				if score, ok := meta["activity_score"].(float64); ok {
-					bb.activityScores = append(bb.activityScores, score)
-				}
+	if summary, ok := meta["content_summary"].(string); ok && summary != "" && len(bb.summaries) < maxBlockSummaries {
+		for _, existing := range bb.summaries {
+			if existing == summary {
+				return
 			}
 		}
-	} else if event.State == "IDLE" {
+		bb.summaries = append(bb.summaries, summary)
+	}
+}
+
+// canMerge checks if an event with the given key can be merged into this block
+func (bb *blockBuilder) canMerge(key string, event *store.RawEvent) bool {
+	// Same key and contiguous time (within MaxMergeGap - default 2 minutes)
+	gap := event.TsStart.Sub(bb.tsEnd)
+	return key == bb.key && gap < MaxMergeGap
+}
+
+// canResume checks if an event picks the block's key back up after an
+// interruption ending at lastEnd
+func (bb *blockBuilder) canResume(key string, event *store.RawEvent, lastEnd time.Time, tolerance time.Duration) bool {
+	return key == bb.key &&
+		event.TsStart.Sub(lastEnd) < MaxMergeGap &&
+		event.TsStart.Sub(bb.tsEnd) <= tolerance
+}
+
+// canInterrupt checks if an event with another key, following on from
+// lastEnd, keeps the time away from the block within tolerance
+func (bb *blockBuilder) canInterrupt(key string, event *store.RawEvent, lastEnd time.Time, tolerance time.Duration) bool {
+	return tolerance > 0 &&
+		key != bb.key &&
+		event.TsStart.Sub(lastEnd) < MaxMergeGap &&
+		event.TsEnd.Sub(bb.tsEnd) <= tolerance
+}
+
+// absorb records an interruption the block's app came back from. Its time
+// stays in the block; consecutive events of one app count as one entry.
+func (bb *blockBuilder) absorb(events []*store.RawEvent) {
+	first := len(bb.interruptions)
+	for _, event := range events {
 		duration := event.TsEnd.Sub(event.TsStart)
-		bb.totalIdleTime += duration
+		bb.interrupted += duration
+
+		if n := len(bb.interruptions); n > first && bb.interruptions[n-1].AppID == event.AppID {
+			bb.interruptions[n-1].Seconds += int(duration.Seconds())
+			continue
+		}
+		if len(bb.interruptions) < maxBlockInterruptions {
+			bb.interruptions = append(bb.interruptions, blockInterruption{
+				AppID:   event.AppID,
+				Start:   event.TsStart.UTC().Format(time.RFC3339),
+				Seconds: int(duration.Seconds()),
+			})
+		}
+	}
+}
+
+// merge adds an event to the current block
+func (bb *blockBuilder) merge(event *store.RawEvent) {
+	if event.TsEnd == nil {
+		return
 	}
+
+	bb.tsEnd = *event.TsEnd
+	bb.add(event)
 }
 
 // build creates a block from accumulated events
@@ -305,40 +645,64 @@ func (bb *blockBuilder) build() *store.Block {
 		return nil
 	}
 
-	// Pick primary title (first one for MVP)
-	var titleID *int64
-	for tid := range bb.titleIDs {
-		t := tid
-		titleID = &t
-		break
-	}
+	// Primary app, title and domain are the ones with the most time
+	appID := bb.apps.dominant()
+	titleID := bb.titles.dominant()
+	domainID := bb.domains.dominant()
 
-	// Pick primary domain (first one for MVP)
-	var domainID *int64
-	for did := range bb.domainIDs {
-		d := did
-		domainID = &d
-		break
+	// Full title timeline, in the order titles were first seen
+	titles := make([]store.BlockTitle, 0, len(bb.titles.order))
+	for _, id := range bb.titles.order {
+		titles = append(titles, store.BlockTitle{
+			TitleID: id,
+			Seconds: int(bb.titles.total[id].Seconds()),
+		})
 	}
 
 	// Calculate average activity score
 	var activityScore float64 = 1.0 // Default to 100% if no scores captured
-	var metadata *string
+	meta := map[string]interface{}{}
 	if len(bb.activityScores) > 0 {
 		var sum float64
 		for _, s := range bb.activityScores {
 			sum += s
 		}
 		activityScore = sum / float64(len(bb.activityScores))
-		jsonStr := fmt.Sprintf(`{"avg_activity_score": %.2f}`, activityScore)
-		metadata = &jsonStr
+		meta["avg_activity_score"] = math.Round(activityScore*100) / 100
+	}
+
+	// Content summaries are indexed for full-text search
+	if len(bb.summaries) > 0 {
+		meta["content_summary"] = strings.Join(bb.summaries, " | ")
+	}
+
+	// Time spent in other apps is kept in the block, but on record
+	if bb.interrupted > 0 {
+		meta["interruptions"] = bb.interruptions
+		meta["interrupted_seconds"] = int(bb.interrupted.Seconds())
+	}
+
+	// Every domain and page visited, for DOMAIN rules beyond the primary one
+	if len(bb.domains.order) > 1 {
+		meta["domain_ids"] = bb.domains.order
+	}
+	if len(bb.urls) > 0 {
+		meta["urls"] = bb.urls
+	}
+
+	var metadata *string
+	if len(meta) > 0 {
+		if data, err := json.Marshal(meta); err == nil {
+			jsonStr := string(data)
+			metadata = &jsonStr
+		}
 	}
 
 	// Create block with activity score for billing calculations
 	block := &store.Block{
 		TsStart:         bb.tsStart,
 		TsEnd:           bb.tsEnd,
-		PrimaryAppID:    bb.primaryAppID,
+		PrimaryAppID:    *appID,
 		TitleSummaryID:  titleID,
 		PrimaryDomainID: domainID,
 		Confidence:      "LOW",          // Will be assigned by rules engine
@@ -346,13 +710,25 @@ func (bb *blockBuilder) build() *store.Block {
 		Locked:          false,
 		Metadata:        metadata,
 		ActivityScore:   activityScore,  // Activity-weighted billing support
+		Titles:          titles,
 	}
 
 	return block
 }
 
-// getLastRollupTime retrieves the last rollup timestamp from settings
-func (a *Aggregator) getLastRollupTime() (time.Time, error) {
+// archiveCutoff returns the time before which raw events may be archived:
+// past the retention period, and never past the rollup watermark, since
+// events after it have not been turned into blocks yet
+func archiveCutoff(now time.Time, retentionDays int, watermark rollupWatermark) time.Time {
+	cutoff := now.Add(-time.Duration(retentionDays) * 24 * time.Hour)
+	if watermark.ts.Before(cutoff) {
+		return watermark.ts
+	}
+	return cutoff
+}
+
+// getWatermark retrieves the rollup watermark from settings
+func (a *Aggregator) getWatermark() (rollupWatermark, error) {
 	db := a.store.GetDB()
 
 	var value string
@@ -363,25 +739,54 @@ func (a *Aggregator) getLastRollupTime() (time.Time, error) {
 
 	if err == sql.ErrNoRows {
 		// First run - use epoch or 7 days ago
-		return time.Now().Add(-7 * 24 * time.Hour), nil
+		return rollupWatermark{ts: time.Now().Add(-7 * 24 * time.Hour)}, nil
 	}
 
 	if err != nil {
-		return time.Time{}, err
+		return rollupWatermark{}, err
 	}
 
-	return time.Parse(time.RFC3339, value)
-}
+	ts, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return rollupWatermark{}, err
+	}
 
-// setLastRollupTime updates the last rollup timestamp
-func (a *Aggregator) setLastRollupTime(t time.Time) error {
-	db := a.store.GetDB()
+	// Older versions only stored the timestamp
+	var eventID int64
+	err = db.QueryRow(`
+		SELECT CAST(value AS INTEGER) FROM settings
+		WHERE key = 'last_rollup_event_id'
+	`).Scan(&eventID)
+
+	if err != nil && err != sql.ErrNoRows {
+		return rollupWatermark{}, err
+	}
 
-	_, err := db.Exec(`
+	return rollupWatermark{ts: ts, eventID: eventID}, nil
+}
+
+// setWatermark updates the rollup watermark inside the rollup transaction
+func setWatermark(tx *sql.Tx, w rollupWatermark) error {
+	_, err := tx.Exec(`
 		INSERT INTO settings (key, value, is_encrypted)
-		VALUES ('last_rollup_ts', ?, 0)
+		VALUES ('last_rollup_ts', ?, 0), ('last_rollup_event_id', ?, 0)
 		ON CONFLICT(key) DO UPDATE SET value = excluded.value
-	`, t.UTC().Format(time.RFC3339))
+	`, w.ts.UTC().Format(time.RFC3339), strconv.FormatInt(w.eventID, 10))
 
 	return err
 }
+
+// blockExists reports whether a block with the same start and app is
+// already stored (trashed included), so a re-run never duplicates one
+func blockExists(tx *sql.Tx, block *store.Block) (bool, error) {
+	var n int
+	err := tx.QueryRow(
+		"SELECT COUNT(*) FROM block WHERE ts_start = ? AND primary_app_id = ?",
+		block.TsStart.UTC().Format(time.RFC3339),
+		block.PrimaryAppID,
+	).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for existing block: %w", err)
+	}
+	return n > 0, nil
+}
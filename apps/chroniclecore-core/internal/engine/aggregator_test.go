@@ -0,0 +1,1084 @@
+package engine
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"chroniclecore/internal/store"
+	"chroniclecore/internal/storetest"
+)
+
+var testStart = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+// testEvent builds a closed ACTIVE event starting offset after testStart
+func testEvent(offset, duration time.Duration, appID int64, metadata string) *store.RawEvent {
+	start := testStart.Add(offset)
+	end := start.Add(duration)
+	event := &store.RawEvent{TsStart: start, TsEnd: &end, AppID: appID, State: "ACTIVE", Source: "OS"}
+	if metadata != "" {
+		event.Metadata = &metadata
+	}
+	return event
+}
+
+func withDomain(event *store.RawEvent, domainID int64) *store.RawEvent {
+	event.DomainID = &domainID
+	return event
+}
+
+func withTitle(event *store.RawEvent, titleID int64) *store.RawEvent {
+	event.TitleID = &titleID
+	return event
+}
+
+// spans formats blocks as start-end offsets in minutes from testStart
+func spans(blocks []*store.Block) [][2]float64 {
+	out := make([][2]float64, len(blocks))
+	for i, b := range blocks {
+		out[i] = [2]float64{b.TsStart.Sub(testStart).Minutes(), b.TsEnd.Sub(testStart).Minutes()}
+	}
+	return out
+}
+
+func expectSpans(t *testing.T, blocks []*store.Block, want ...[2]float64) {
+	t.Helper()
+	got := spans(blocks)
+	if len(got) != len(want) {
+		t.Fatalf("Expected blocks %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected blocks %v, got %v", want, got)
+		}
+	}
+}
+
+// setupTestStore opens a store on a fresh database with the project schema
+func setupTestStore(t *testing.T) *store.Store {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	schemaSQL, err := os.ReadFile(filepath.Join("..", "..", "..", "..", "spec", "schema.sql"))
+	if err != nil {
+		t.Fatalf("Failed to read schema: %v", err)
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	if _, err := db.Exec(string(schemaSQL)); err != nil {
+		t.Fatalf("Failed to apply schema: %v", err)
+	}
+	db.Close()
+
+	s := store.NewStore(dbPath)
+	if err := s.Init(); err != nil {
+		t.Fatalf("Failed to initialize store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// newTestAggregator builds an aggregator without the rollup scheduler
+func newTestAggregator(s *store.Store) *Aggregator {
+	return &Aggregator{
+		store:          s,
+		retentionDays:  14,
+		ruleEngine:     NewRuleEngine(s),
+		templateEngine: NewTemplateEngine(s),
+	}
+}
+
+// insertTestEvent stores an OS event; a zero duration leaves it open
+func insertTestEvent(t *testing.T, s *store.Store, start time.Time, duration time.Duration, appID int64) {
+	t.Helper()
+	event := &store.RawEvent{TsStart: start, AppID: appID, State: "ACTIVE", Source: "OS"}
+	if duration > 0 {
+		end := start.Add(duration)
+		event.TsEnd = &end
+	}
+	if err := s.InsertRawEvent(event); err != nil {
+		t.Fatalf("Failed to insert event: %v", err)
+	}
+}
+
+// storedSpans lists block start-end offsets in minutes from base
+func storedSpans(t *testing.T, s *store.Store, base time.Time) [][2]float64 {
+	t.Helper()
+	rows, err := s.GetDB().Query("SELECT ts_start, ts_end FROM block WHERE deleted_at IS NULL ORDER BY ts_start")
+	if err != nil {
+		t.Fatalf("Failed to query blocks: %v", err)
+	}
+	defer rows.Close()
+
+	var out [][2]float64
+	for rows.Next() {
+		var startStr, endStr string
+		rows.Scan(&startStr, &endStr)
+		start, _ := time.Parse(time.RFC3339, startStr)
+		end, _ := time.Parse(time.RFC3339, endStr)
+		out = append(out, [2]float64{start.Sub(base).Minutes(), end.Sub(base).Minutes()})
+	}
+	return out
+}
+
+func expectStoredSpans(t *testing.T, s *store.Store, base time.Time, want ...[2]float64) {
+	t.Helper()
+	got := storedSpans(t, s, base)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Expected blocks %v, got %v", want, got)
+	}
+}
+
+func TestAppStrategyGroupsByApp(t *testing.T) {
+	events := []*store.RawEvent{
+		testEvent(0, 5*time.Minute, 1, ""),
+		testEvent(6*time.Minute, 4*time.Minute, 1, ""), // 1 minute gap still merges
+		testEvent(10*time.Minute, 5*time.Minute, 2, ""),
+		testEvent(20*time.Minute, 5*time.Minute, 2, ""), // 5 minute gap splits
+	}
+
+	blocks := groupEvents(events, AppStrategy{}, 0).finish()
+	expectSpans(t, blocks, [2]float64{0, 10}, [2]float64{10, 15}, [2]float64{20, 25})
+	if blocks[0].PrimaryAppID != 1 || blocks[1].PrimaryAppID != 2 {
+		t.Errorf("Unexpected primary apps %d, %d", blocks[0].PrimaryAppID, blocks[1].PrimaryAppID)
+	}
+}
+
+func TestDocumentStrategyGroupsByDocument(t *testing.T) {
+	events := []*store.RawEvent{
+		testEvent(0, 5*time.Minute, 1, `{"document_name":"Budget.xlsx"}`),
+		testEvent(5*time.Minute, 5*time.Minute, 1, `{"document_name":"Budget.xlsx"}`),
+		testEvent(10*time.Minute, 5*time.Minute, 1, `{"document_name":"Payroll.xlsx"}`),
+		testEvent(15*time.Minute, 5*time.Minute, 2, `{"file_name":"main.go"}`),
+		testEvent(20*time.Minute, 5*time.Minute, 2, ""), // No document, grouped by app
+	}
+
+	blocks := groupEvents(events, DocumentStrategy{}, 0).finish()
+	expectSpans(t, blocks, [2]float64{0, 10}, [2]float64{10, 15}, [2]float64{15, 20}, [2]float64{20, 25})
+
+	// The app strategy sees two runs of apps
+	expectSpans(t, groupEvents(events, AppStrategy{}, 0).finish(), [2]float64{0, 15}, [2]float64{15, 25})
+}
+
+func TestProjectStrategyGroupsFilesOfOneProject(t *testing.T) {
+	events := []*store.RawEvent{
+		testEvent(0, 5*time.Minute, 1, `{"project_name":"ledger","file_name":"main.go"}`),
+		testEvent(5*time.Minute, 5*time.Minute, 1, `{"project_name":"ledger","file_name":"store.go"}`),
+		testEvent(10*time.Minute, 5*time.Minute, 1, `{"project_name":"website","file_name":"index.html"}`),
+	}
+
+	expectSpans(t, groupEvents(events, ProjectStrategy{}, 0).finish(), [2]float64{0, 10}, [2]float64{10, 15})
+	expectSpans(t, groupEvents(events, DocumentStrategy{}, 0).finish(), [2]float64{0, 5}, [2]float64{5, 10}, [2]float64{10, 15})
+}
+
+func TestDomainStrategyGroupsAcrossBrowsers(t *testing.T) {
+	events := []*store.RawEvent{
+		withDomain(testEvent(0, 2*time.Minute, 1, ""), 7),
+		withDomain(testEvent(2*time.Minute, 6*time.Minute, 2, ""), 7),
+		withDomain(testEvent(8*time.Minute, 5*time.Minute, 2, ""), 8),
+	}
+
+	blocks := groupEvents(events, DomainStrategy{}, 0).finish()
+	expectSpans(t, blocks, [2]float64{0, 8}, [2]float64{8, 13})
+
+	// The browser used longest is the primary app
+	if blocks[0].PrimaryAppID != 2 {
+		t.Errorf("Expected primary app 2, got %d", blocks[0].PrimaryAppID)
+	}
+	if blocks[0].PrimaryDomainID == nil || *blocks[0].PrimaryDomainID != 7 {
+		t.Errorf("Expected primary domain 7, got %v", blocks[0].PrimaryDomainID)
+	}
+}
+
+func TestStrategyWithInterruptionTolerance(t *testing.T) {
+	events := []*store.RawEvent{
+		testEvent(0, 5*time.Minute, 1, `{"document_name":"Budget.xlsx"}`),
+		testEvent(5*time.Minute, 30*time.Second, 1, `{"document_name":"Payroll.xlsx"}`),
+		testEvent(5*time.Minute+30*time.Second, 5*time.Minute, 1, `{"document_name":"Budget.xlsx"}`),
+	}
+
+	expectSpans(t, groupEvents(events, DocumentStrategy{}, 0).finish(),
+		[2]float64{0, 5}, [2]float64{5, 5.5}, [2]float64{5.5, 10.5})
+
+	blocks := groupEvents(events, DocumentStrategy{}, time.Minute).finish()
+	expectSpans(t, blocks, [2]float64{0, 10.5})
+	if blocks[0].Metadata == nil {
+		t.Fatal("Expected interruptions in block metadata")
+	}
+}
+
+func TestInterruptionAbsorbedAndWorkResumes(t *testing.T) {
+	events := []*store.RawEvent{
+		testEvent(0, 10*time.Minute, 1, ""),
+		testEvent(10*time.Minute, 20*time.Second, 2, ""),
+		testEvent(10*time.Minute+20*time.Second, 10*time.Second, 3, ""),
+		testEvent(10*time.Minute+30*time.Second, 9*time.Minute+30*time.Second, 1, ""),
+		testEvent(20*time.Minute, 5*time.Minute, 1, ""),
+	}
+
+	// Strict mode ends the block at every switch
+	expectSpans(t, groupEvents(events, AppStrategy{}, 0).finish(),
+		[2]float64{0, 10}, [2]float64{10, 10 + 1.0/3}, [2]float64{10 + 1.0/3, 10.5}, [2]float64{10.5, 25})
+
+	// The 30 second detour stays in the block and work carries on after it
+	blocks := groupEvents(events, AppStrategy{}, time.Minute).finish()
+	expectSpans(t, blocks, [2]float64{0, 25})
+	if blocks[0].PrimaryAppID != 1 {
+		t.Errorf("Expected primary app 1, got %d", blocks[0].PrimaryAppID)
+	}
+
+	var meta struct {
+		Interruptions      []blockInterruption `json:"interruptions"`
+		InterruptedSeconds int                 `json:"interrupted_seconds"`
+	}
+	if blocks[0].Metadata == nil || json.Unmarshal([]byte(*blocks[0].Metadata), &meta) != nil {
+		t.Fatalf("Expected interruptions in block metadata, got %v", blocks[0].Metadata)
+	}
+	want := []blockInterruption{
+		{AppID: 2, Start: "2026-03-02T09:10:00Z", Seconds: 20},
+		{AppID: 3, Start: "2026-03-02T09:10:20Z", Seconds: 10},
+	}
+	if fmt.Sprint(meta.Interruptions) != fmt.Sprint(want) || meta.InterruptedSeconds != 30 {
+		t.Errorf("Expected interruptions %v (30s), got %v (%ds)", want, meta.Interruptions, meta.InterruptedSeconds)
+	}
+}
+
+func TestInterruptionOverThresholdSplitsBlock(t *testing.T) {
+	events := []*store.RawEvent{
+		testEvent(0, 10*time.Minute, 1, ""),
+		testEvent(10*time.Minute, 90*time.Second, 2, ""),
+		testEvent(11*time.Minute+30*time.Second, 8*time.Minute+30*time.Second, 1, ""),
+	}
+
+	blocks := groupEvents(events, AppStrategy{}, time.Minute).finish()
+	expectSpans(t, blocks, [2]float64{0, 10}, [2]float64{10, 11.5}, [2]float64{11.5, 20})
+	for _, b := range blocks {
+		if b.Metadata != nil && strings.Contains(*b.Metadata, "interruptions") {
+			t.Errorf("Split blocks should not list interruptions, got %s", *b.Metadata)
+		}
+	}
+}
+
+func TestDominantTitleByTime(t *testing.T) {
+	events := []*store.RawEvent{
+		withTitle(testEvent(0, time.Minute, 1, ""), 10),
+		withTitle(testEvent(time.Minute, 8*time.Minute, 1, ""), 20),
+		withTitle(testEvent(9*time.Minute, 2*time.Minute, 1, ""), 10),
+	}
+
+	blocks := groupEvents(events, AppStrategy{}, 0).finish()
+	expectSpans(t, blocks, [2]float64{0, 11})
+
+	if *blocks[0].TitleSummaryID != 20 {
+		t.Errorf("Expected dominant title 20, got %d", *blocks[0].TitleSummaryID)
+	}
+	titles := blocks[0].Titles
+	if len(titles) != 2 || titles[0].TitleID != 10 || titles[0].Seconds != 180 || titles[1].Seconds != 480 {
+		t.Errorf("Unexpected title timeline %+v", titles)
+	}
+}
+
+func TestAggregateEventsHoldsBackOpenBlock(t *testing.T) {
+	a := &Aggregator{}
+	events := []*store.RawEvent{
+		testEvent(0, 5*time.Minute, 1, ""),
+		testEvent(5*time.Minute, 5*time.Minute, 2, ""),
+	}
+
+	// App 2 could still continue, so only the first block is final
+	blocks, consumed := a.aggregateEvents(events, AppStrategy{}, 0, sourceReconciler{}, testStart.Add(11*time.Minute))
+	expectSpans(t, blocks, [2]float64{0, 5})
+	if consumed != 1 {
+		t.Errorf("Expected 1 event consumed, got %d", consumed)
+	}
+
+	blocks, consumed = a.aggregateEvents(events, AppStrategy{}, 0, sourceReconciler{}, testStart.Add(time.Hour))
+	expectSpans(t, blocks, [2]float64{0, 5}, [2]float64{5, 10})
+	if consumed != 2 {
+		t.Errorf("Expected 2 events consumed, got %d", consumed)
+	}
+}
+
+func TestArchiveCutoffStopsAtWatermark(t *testing.T) {
+	now := testStart.Add(40 * 24 * time.Hour)
+
+	// Rollup is current, so the retention period decides
+	cutoff := archiveCutoff(now, 30, rollupWatermark{ts: now.Add(-time.Hour)})
+	if !cutoff.Equal(testStart.Add(10 * 24 * time.Hour)) {
+		t.Errorf("Expected retention cutoff, got %v", cutoff)
+	}
+
+	// Events held back behind an open event are past the watermark and must stay
+	cutoff = archiveCutoff(now, 30, rollupWatermark{ts: testStart, eventID: 7})
+	if !cutoff.Equal(testStart) {
+		t.Errorf("Expected cutoff at watermark %v, got %v", testStart, cutoff)
+	}
+}
+
+func TestStrategyByName(t *testing.T) {
+	for _, name := range []string{StrategyApp, StrategyDocument, StrategyProject, StrategyDomain} {
+		strategy, ok := StrategyByName(name)
+		if !ok || strategy.Name() != name {
+			t.Errorf("Expected strategy %q to be registered", name)
+		}
+	}
+	if _, ok := StrategyByName("window"); ok {
+		t.Error("Unknown strategy should not resolve")
+	}
+}
+
+func extensionEvent(offset, duration time.Duration, appID int64) *store.RawEvent {
+	event := testEvent(offset, duration, appID, `{"source":"extension"}`)
+	event.Source = "EXTENSION"
+	return event
+}
+
+// eventSpans formats events as start-end offsets in minutes with their app
+func eventSpans(events []*store.RawEvent) [][3]float64 {
+	out := make([][3]float64, len(events))
+	for i, e := range events {
+		out[i] = [3]float64{e.TsStart.Sub(testStart).Minutes(), e.TsEnd.Sub(testStart).Minutes(), float64(e.AppID)}
+	}
+	return out
+}
+
+func TestReconcileSourcesAttributesBrowserTimeOnce(t *testing.T) {
+	const chrome, excel, page = 1, 2, 9
+	events := []*store.RawEvent{
+		testEvent(0, 10*time.Minute, chrome, ""),
+		extensionEvent(2*time.Minute, 4*time.Minute, page),
+		extensionEvent(5*time.Minute, 2*time.Minute, page), // Overlaps the previous page
+		extensionEvent(8*time.Minute, 6*time.Minute, page), // Runs on while Excel is in front
+		testEvent(10*time.Minute, 5*time.Minute, excel, ""),
+	}
+
+	r := sourceReconciler{browsers: map[int64]bool{chrome: true}}
+	got, origin := r.reconcile(events)
+
+	want := [][3]float64{
+		{0, 2, chrome},
+		{2, 6, page},
+		{6, 7, page},
+		{7, 8, chrome},
+		{8, 10, page},
+		{10, 15, excel},
+	}
+	if spans := eventSpans(got); len(spans) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, spans)
+	} else {
+		for i := range want {
+			if spans[i] != want[i] {
+				t.Fatalf("Expected %v, got %v", want, spans)
+			}
+		}
+	}
+
+	wantOrigin := []int{0, 1, 2, 0, 3, 4}
+	for i := range wantOrigin {
+		if origin[i] != wantOrigin[i] {
+			t.Fatalf("Expected origins %v, got %v", wantOrigin, origin)
+		}
+	}
+	if got[5] != events[4] {
+		t.Error("Untouched events should be returned as is")
+	}
+}
+
+func TestReconcileSourcesSkipsAttributedTime(t *testing.T) {
+	events := []*store.RawEvent{
+		testEvent(0, 5*time.Minute, 1, ""),
+		extensionEvent(0, 5*time.Minute, 9),
+	}
+
+	// A block from an earlier rollup already holds the first three minutes
+	r := sourceReconciler{
+		browsers:   map[int64]bool{1: true},
+		attributed: []timeSpan{{testStart, testStart.Add(3 * time.Minute)}},
+	}
+	got, _ := r.reconcile(events)
+
+	spans := eventSpans(got)
+	if len(spans) != 1 || spans[0] != [3]float64{3, 5, 9} {
+		t.Errorf("Expected only unattributed extension time, got %v", spans)
+	}
+}
+
+func TestAggregateEventsWaitsForOverlappingExtension(t *testing.T) {
+	a := &Aggregator{}
+	open := testEvent(4*time.Minute, 0, 1, "")
+	open.TsEnd = nil
+	events := []*store.RawEvent{
+		testEvent(0, 4*time.Minute, 2, ""),
+		extensionEvent(time.Minute, 5*time.Minute, 9),
+		open,
+	}
+
+	// The extension event runs into the open browser event, so it waits
+	blocks, consumed := a.aggregateEvents(events, AppStrategy{}, 0, sourceReconciler{browsers: map[int64]bool{1: true}}, testStart.Add(time.Hour))
+	expectSpans(t, blocks, [2]float64{0, 4})
+	if consumed != 1 {
+		t.Errorf("Expected 1 event consumed, got %d", consumed)
+	}
+}
+
+func TestDomainPatternMatching(t *testing.T) {
+	cases := []struct {
+		pattern string
+		host    string
+		urls    []string
+		want    bool
+	}{
+		{"go.xero.com", "go.xero.com", nil, true},
+		{"https://www.Xero.com/", "xero.com", nil, true},
+		{"xero.com", "go.xero.com", nil, false},
+		{"*.xero.com", "go.xero.com", nil, true},
+		{"*.xero.com", "xero.com", nil, false},
+		{"*.xero.com", "notxero.com", nil, false},
+		{"site:xero.com", "xero.com", nil, true},
+		{"site:xero.com", "login.go.xero.com", nil, true},
+		{"site:sars.gov.za", "efiling.sars.gov.za", nil, true},
+		{"go.xero.com/app/invoices", "go.xero.com", nil, false},
+		{"go.xero.com/app/invoices", "go.xero.com", []string{"https://go.xero.com/app/invoicesx"}, false},
+		{"go.xero.com/app/invoices", "go.xero.com", []string{"https://go.xero.com/app/invoices/123"}, true},
+		{"*.xero.com/app", "", []string{"https://go.xero.com/App"}, true},
+	}
+
+	for _, c := range cases {
+		p, err := parseDomainPattern(c.pattern)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", c.pattern, err)
+		}
+		if got := p.matches([]string{c.host}, c.urls); got != c.want {
+			t.Errorf("%q against %q %v: expected %v, got %v", c.pattern, c.host, c.urls, c.want, got)
+		}
+	}
+
+	for _, invalid := range []string{"", "*.", "go.*.com", "site:go.xero.com", "site:gov.za"} {
+		if err := ValidateDomainPattern(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	for host, want := range map[string]string{
+		"go.xero.com":         "xero.com",
+		"xero.com":            "xero.com",
+		"www.fnb.co.za":       "fnb.co.za",
+		"efiling.sars.gov.za": "sars.gov.za",
+		"localhost":           "localhost",
+	} {
+		if got := registrableDomain(host); got != want {
+			t.Errorf("registrableDomain(%q): expected %q, got %q", host, want, got)
+		}
+	}
+}
+
+func TestBlockRecordsVisitedDomainsAndURLs(t *testing.T) {
+	events := []*store.RawEvent{
+		withDomain(testEvent(0, 5*time.Minute, 1, `{"url":"https://go.xero.com/app/invoices"}`), 7),
+		withDomain(testEvent(5*time.Minute, time.Minute, 1, `{"url":"https://mail.google.com/"}`), 8),
+		withDomain(testEvent(6*time.Minute, time.Minute, 1, `{"url":"https://go.xero.com/app/invoices"}`), 7),
+	}
+
+	blocks := groupEvents(events, AppStrategy{}, 0).finish()
+	expectSpans(t, blocks, [2]float64{0, 7})
+	if blocks[0].Metadata == nil {
+		t.Fatal("Expected block metadata")
+	}
+
+	var meta struct {
+		DomainIDs []int64  `json:"domain_ids"`
+		URLs      []string `json:"urls"`
+	}
+	if err := json.Unmarshal([]byte(*blocks[0].Metadata), &meta); err != nil {
+		t.Fatalf("Invalid metadata: %v", err)
+	}
+	if len(meta.DomainIDs) != 2 || meta.DomainIDs[0] != 7 || meta.DomainIDs[1] != 8 {
+		t.Errorf("Expected domains [7 8], got %v", meta.DomainIDs)
+	}
+	if len(meta.URLs) != 2 {
+		t.Errorf("Expected 2 distinct URLs, got %v", meta.URLs)
+	}
+}
+
+func TestCompositeConditions(t *testing.T) {
+	// Monday 2026-03-02 09:30, local to the facts
+	facts := &blockFacts{
+		app:           "EXCEL.EXE",
+		title:         "Budget 2026.xlsx - Excel",
+		start:         time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC),
+		duration:      40 * time.Minute,
+		activity:      0.7,
+		hosts:         []string{"go.xero.com"},
+		domainsLoaded: true,
+	}
+
+	cases := []struct {
+		value string
+		want  bool
+	}{
+		{`{"all":[{"app":"EXCEL.EXE"},{"keyword":"budget"},{"weekday":["mon","Friday"]}]}`, true},
+		{`{"all":[{"app":"EXCEL.EXE"},{"weekday":["sat","sun"]}]}`, false},
+		{`{"any":[{"app":"WINWORD.EXE"},{"domain":"site:xero.com"}]}`, true},
+		{`{"not":{"title_regex":"^Budget \\d+"}}`, false},
+		{`{"time_of_day":{"from":"09:00","to":"17:00"}}`, true},
+		{`{"time_of_day":{"from":"22:00","to":"10:00"}}`, true},
+		{`{"time_of_day":{"from":"10:00","to":"17:00"}}`, false},
+		{`{"all":[{"min_duration":"30m"},{"max_duration":"1h"}]}`, true},
+		{`{"min_duration":"45m"}`, false},
+		{`{"all":[{"min_activity":0.5},{"max_activity":0.8}]}`, true},
+	}
+
+	for _, c := range cases {
+		cond, err := compileCondition(c.value)
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %v", c.value, err)
+		}
+		if got := cond.eval(facts); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.value, c.want, got)
+		}
+	}
+}
+
+func TestCompositeValidationPaths(t *testing.T) {
+	cases := map[string]string{
+		`[]`:                        "match_value: expected an object",
+		`{"app":"A","keyword":"b"}`: "match_value: expected exactly one condition",
+		`{"all":[]}`:                "match_value.all: needs at least one condition",
+		`{"all":[{"app":"A"},{"time_of_day":{"from":"9am","to":"17:00"}}]}`: `match_value.all[1].time_of_day.from: invalid time "9am"`,
+		`{"any":[{"not":{"title_regex":"("}}]}`:                             "match_value.any[0].not.title_regex: invalid regex",
+		`{"weekday":["mon","funday"]}`:                                      `match_value.weekday[1]: unknown day "funday"`,
+		`{"min_activity":1.5}`:                                              "match_value.min_activity: 1.5 is not between 0 and 1",
+		`{"max_duration":"soon"}`:                                           `match_value.max_duration: invalid duration "soon"`,
+		`{"domain":"*."}`:                                                   "match_value.domain: domain is empty",
+		`{"window":"x"}`:                                                    "match_value.window: unknown condition",
+	}
+
+	for value, want := range cases {
+		err := ValidateCondition(value)
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%s: expected error starting %q, got %v", value, want, err)
+		}
+	}
+}
+
+func TestPreviewRuleOrder(t *testing.T) {
+	saved := []*Rule{
+		{RuleID: 1, Priority: 10},
+		{RuleID: 2, Priority: 5},
+		{RuleID: 3, Priority: 5},
+	}
+	order := func(rules []*Rule) []int64 {
+		ids := make([]int64, len(rules))
+		for i, r := range rules {
+			ids[i] = r.RuleID
+		}
+		return ids
+	}
+
+	// An unsaved rule goes after saved rules of its priority
+	got := order(withCandidate(saved, &Rule{Priority: 5}))
+	if want := []int64{1, 2, 3, 0}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected order %v, got %v", want, got)
+	}
+
+	// An edited rule replaces its saved version
+	edited := &Rule{RuleID: 1, Priority: 1}
+	rules := withCandidate(saved, edited)
+	if got := order(rules); fmt.Sprint(got) != fmt.Sprint([]int64{2, 3, 1}) || rules[2] != edited {
+		t.Errorf("Expected edited rule last, got %v", got)
+	}
+}
+
+func TestSelectRulesKeepsMatchOrder(t *testing.T) {
+	rules := []*Rule{{RuleID: 3, Priority: 9}, {RuleID: 1, Priority: 5}, {RuleID: 2, Priority: 1}}
+
+	selected, err := selectRules(rules, []int64{2, 3})
+	if err != nil || len(selected) != 2 || selected[0].RuleID != 3 || selected[1].RuleID != 2 {
+		t.Errorf("Expected rules 3, 2 in priority order, got %v (%v)", selected, err)
+	}
+	if all, _ := selectRules(rules, nil); len(all) != 3 {
+		t.Errorf("Expected all rules without a subset, got %d", len(all))
+	}
+	if _, err := selectRules(rules, []int64{1, 7}); !errors.Is(err, ErrRuleUnavailable) || !strings.Contains(err.Error(), "rule 7") {
+		t.Errorf("Expected unknown rule 7 to be rejected, got %v", err)
+	}
+}
+
+func TestRecordMatchesCountsShadowedRules(t *testing.T) {
+	rules := []*Rule{
+		{RuleID: 1, Priority: 10, MatchType: "APP", MatchValue: "EXCEL.EXE"},
+		{RuleID: 2, Priority: 5, MatchType: "KEYWORD", MatchValue: "budget"},
+		{RuleID: 3, Priority: 1, MatchType: "APP", MatchValue: "WINWORD.EXE"},
+	}
+	re := &RuleEngine{stats: make(map[int64]*ruleStat)}
+	facts := &blockFacts{app: "EXCEL.EXE", title: "Budget.xlsx - Excel"}
+
+	for i := 0; i < 2; i++ {
+		if winner := re.recordMatches(rules, facts); winner != rules[0] {
+			t.Fatalf("Expected rule 1 to win, got %v", winner)
+		}
+	}
+
+	if s := re.stats[1]; s == nil || s.matches != 2 || s.wins != 2 {
+		t.Errorf("Expected rule 1 matched and won twice, got %+v", s)
+	}
+	if s := re.stats[2]; s == nil || s.matches != 2 || s.wins != 0 {
+		t.Errorf("Expected rule 2 matched twice without winning, got %+v", s)
+	}
+	if s := re.stats[3]; s != nil {
+		t.Errorf("Expected no stats for rule 3, got %+v", s)
+	}
+}
+
+func TestRollupIsIdempotentAndWaitsForOpenEvent(t *testing.T) {
+	s := setupTestStore(t)
+	a := newTestAggregator(s)
+	excel, _ := s.GetOrCreateDictApp("EXCEL.EXE")
+	word, _ := s.GetOrCreateDictApp("WINWORD.EXE")
+
+	base := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Minute)
+	insertTestEvent(t, s, base, 10*time.Minute, excel)
+	insertTestEvent(t, s, base.Add(10*time.Minute), 10*time.Minute, word)
+	insertTestEvent(t, s, base.Add(20*time.Minute), 0, word)
+
+	// Word is still open and could continue, so only Excel is final
+	if err := a.Rollup(); err != nil {
+		t.Fatalf("Rollup failed: %v", err)
+	}
+	expectStoredSpans(t, s, base, [2]float64{0, 10})
+
+	// Re-running, even from a lost watermark, creates nothing new
+	if err := a.Rollup(); err != nil {
+		t.Fatalf("Second rollup failed: %v", err)
+	}
+	s.GetDB().Exec("DELETE FROM settings WHERE key IN ('last_rollup_ts', 'last_rollup_event_id')")
+	if err := a.Rollup(); err != nil {
+		t.Fatalf("Rollup after watermark reset failed: %v", err)
+	}
+	expectStoredSpans(t, s, base, [2]float64{0, 10})
+
+	// Once the open event closes, the Word block takes it in
+	s.GetDB().Exec("UPDATE raw_event SET ts_end = ? WHERE ts_end IS NULL", base.Add(30*time.Minute).Format(time.RFC3339))
+	insertTestEvent(t, s, base.Add(30*time.Minute), 0, excel)
+	if err := a.Rollup(); err != nil {
+		t.Fatalf("Rollup after close failed: %v", err)
+	}
+	expectStoredSpans(t, s, base, [2]float64{0, 10}, [2]float64{10, 30})
+}
+
+func TestRebuildRangeKeepsUserDecisions(t *testing.T) {
+	s := setupTestStore(t)
+	a := newTestAggregator(s)
+	db := s.GetDB()
+
+	storetest.SeedExcelRule(t, db)
+	if _, err := db.Exec(`
+		INSERT INTO profile (client_id, service_id, rate_id, name)
+		SELECT client_id, service_id, rate_id, 'Other' FROM profile
+	`); err != nil {
+		t.Fatalf("Failed to insert profile: %v", err)
+	}
+
+	excel, _ := s.GetOrCreateDictApp("EXCEL.EXE")
+	word, _ := s.GetOrCreateDictApp("WINWORD.EXE")
+	chrome, _ := s.GetOrCreateDictApp("chrome.exe")
+	titleID, _ := s.GetOrCreateDictTitle("Report.docx")
+
+	for i, appID := range []int64{excel, word, excel, word, chrome} {
+		insertTestEvent(t, s, testStart.Add(time.Duration(i)*30*time.Minute), 30*time.Minute, appID)
+	}
+
+	insertBlock := func(from, to time.Duration, appID int64, extra string) int64 {
+		t.Helper()
+		res, err := db.Exec(`
+			INSERT INTO block (ts_start, ts_end, primary_app_id, confidence) VALUES (?, ?, ?, 'LOW')
+		`, testStart.Add(from).Format(time.RFC3339), testStart.Add(to).Format(time.RFC3339), appID)
+		if err != nil {
+			t.Fatalf("Failed to insert block: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		if extra != "" {
+			db.Exec("UPDATE block SET "+extra+" WHERE block_id = ?", id)
+		}
+		return id
+	}
+
+	// Blocks cut by an older version, with one of each kind a rebuild keeps
+	stale := insertBlock(0, 45*time.Minute, excel, "")
+	locked := insertBlock(45*time.Minute, 60*time.Minute, word, "locked = 1")
+	manual := insertBlock(60*time.Minute, 75*time.Minute, excel, "is_manual = 1")
+	reassigned := insertBlock(75*time.Minute, 120*time.Minute, word, "profile_id = 2")
+	insertBlock(120*time.Minute, 150*time.Minute, chrome, "")
+	later := insertBlock(24*time.Hour, 24*time.Hour+10*time.Minute, excel, "")
+
+	db.Exec(`
+		INSERT INTO ml_label_event (block_id, old_profile_id, new_profile_id, actor, confidence_after)
+		VALUES (?, NULL, 2, 'USER', 'HIGH')
+	`, reassigned)
+	db.Exec("INSERT INTO block_title (block_id, title_id, seconds) VALUES (?, ?, 2700)", reassigned, titleID)
+	db.Exec(`
+		INSERT INTO ml_suggestion (entity_type, entity_id, suggestion_type, payload_json, confidence)
+		VALUES ('BLOCK', ?, 'PROFILE_ASSIGN', '{}', 0.7)
+	`, reassigned)
+
+	before := storedSpans(t, s, testStart)
+	var audits int
+	db.QueryRow("SELECT COUNT(*) FROM audit_log").Scan(&audits)
+
+	end := testStart.Add(12 * time.Hour)
+	report, err := a.RebuildRange(testStart, end, true)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if len(report.Kept) != 2 || len(report.Removed) != 1 || len(report.Created) != 4 || report.Unchanged != 1 {
+		t.Fatalf("Unexpected dry run report: kept %d, removed %d, created %d, unchanged %d",
+			len(report.Kept), len(report.Removed), len(report.Created), report.Unchanged)
+	}
+	reasons := map[int64]string{}
+	for _, b := range report.Kept {
+		reasons[b.BlockID] = b.Reason
+	}
+	if reasons[locked] != RebuildKeptLocked || reasons[manual] != RebuildKeptManual {
+		t.Errorf("Unexpected kept reasons %v", reasons)
+	}
+	carried := 0
+	for _, b := range report.Created {
+		if b.Reason == fmt.Sprintf("profile carried from block %d", reassigned) {
+			carried++
+		}
+	}
+	if carried != 2 {
+		t.Errorf("Expected the reassigned profile carried onto 2 new blocks, got %+v", report.Created)
+	}
+
+	// A dry run writes nothing
+	if got := storedSpans(t, s, testStart); fmt.Sprint(got) != fmt.Sprint(before) {
+		t.Errorf("Dry run changed blocks: %v, was %v", got, before)
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM audit_log").Scan(&n)
+	if n != audits {
+		t.Errorf("Dry run wrote %d audit entries", n-audits)
+	}
+
+	report, err = a.RebuildRange(testStart, end, false)
+	if err != nil {
+		t.Fatalf("Rebuild failed: %v", err)
+	}
+	expectStoredSpans(t, s, testStart,
+		[2]float64{0, 30}, [2]float64{30, 45}, [2]float64{45, 60}, [2]float64{60, 75},
+		[2]float64{75, 90}, [2]float64{90, 120}, [2]float64{120, 150}, [2]float64{1440, 1450})
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM block WHERE block_id = ?", stale).Scan(&n); err != nil || n != 0 {
+		t.Errorf("Stale block should be replaced")
+	}
+
+	// The Word block overlapping the reassigned one most takes over its ID,
+	// profile, label and suggestion
+	var tsStart, source string
+	var profileID int64
+	db.QueryRow(`
+		SELECT ts_start, profile_id, json_extract(metadata, '$.profile_source') FROM block WHERE block_id = ?
+	`, reassigned).Scan(&tsStart, &profileID, &source)
+	if tsStart != testStart.Add(90*time.Minute).Format(time.RFC3339) || profileID != 2 || source != "user" {
+		t.Errorf("Reassigned block should move to 90-120 with user profile 2, got %s, %d (%q)", tsStart, profileID, source)
+	}
+	var labels, suggestions int
+	db.QueryRow("SELECT COUNT(*) FROM ml_label_event WHERE block_id = ?", reassigned).Scan(&labels)
+	db.QueryRow("SELECT COUNT(*) FROM ml_suggestion WHERE entity_type = 'BLOCK' AND entity_id = ?", reassigned).Scan(&suggestions)
+	if labels != 1 || suggestions != 1 {
+		t.Errorf("Reassigned block lost its label (%d) or suggestion (%d)", labels, suggestions)
+	}
+
+	// The Excel block cut from it carries the profile too, and the Excel
+	// rule leaves it alone although it is LOW, here and in later rollups
+	carriedExcel := func(q interface {
+		QueryRow(string, ...interface{}) *sql.Row
+	}) (profileID int64) {
+		q.QueryRow("SELECT profile_id FROM block WHERE ts_start = ?",
+			testStart.Add(75*time.Minute).Format(time.RFC3339)).Scan(&profileID)
+		return profileID
+	}
+	if got := carriedExcel(db); got != 2 {
+		t.Errorf("Excel block at 75 should carry profile 2, got %d", got)
+	}
+	tx, _ := db.Begin()
+	if err := a.ruleEngine.AssignBlocksTx(tx, nil); err != nil {
+		t.Fatalf("Assign failed: %v", err)
+	}
+	if got := carriedExcel(tx); got != 2 {
+		t.Errorf("Rules should not overwrite a carried profile, got %d", got)
+	}
+	tx.Rollback()
+
+	// Rules run on the new blocks only
+	var newExcel sql.NullInt64
+	db.QueryRow("SELECT profile_id FROM block WHERE ts_start = ? AND primary_app_id = ?",
+		testStart.Format(time.RFC3339), excel).Scan(&newExcel)
+	if !newExcel.Valid || newExcel.Int64 != 1 {
+		t.Errorf("New Excel block should be assigned by the rule, got %v", newExcel)
+	}
+	var laterProfile sql.NullInt64
+	db.QueryRow("SELECT profile_id FROM block WHERE block_id = ?", later).Scan(&laterProfile)
+	if laterProfile.Valid {
+		t.Errorf("Block outside the rebuild should not be assigned, got profile %d", laterProfile.Int64)
+	}
+
+	// Undo puts the old blocks back
+	if _, err := s.UndoAudit(report.AuditID); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	if got := storedSpans(t, s, testStart); fmt.Sprint(got) != fmt.Sprint(before) {
+		t.Errorf("Expected blocks %v after undo, got %v", before, got)
+	}
+	var titles int
+	db.QueryRow("SELECT COUNT(*) FROM block_title WHERE block_id = ?", reassigned).Scan(&titles)
+	db.QueryRow("SELECT COUNT(*) FROM ml_label_event WHERE block_id = ?", reassigned).Scan(&labels)
+	if titles != 1 || labels != 1 {
+		t.Errorf("Undo should give the reassigned block back its titles (%d) and label (%d)", titles, labels)
+	}
+}
+
+func TestRollupRecordsInterruptionsInTolerantMode(t *testing.T) {
+	s := setupTestStore(t)
+	a := newTestAggregator(s)
+	excel, _ := s.GetOrCreateDictApp("EXCEL.EXE")
+	slack, _ := s.GetOrCreateDictApp("slack.exe")
+	s.SetSetting(SettingAggregationMode, AggregationModeInterruptionTolerant)
+	s.SetSetting(SettingInterruptionThreshold, "45")
+
+	base := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Minute)
+	insertTestEvent(t, s, base, 10*time.Minute, excel)
+	insertTestEvent(t, s, base.Add(10*time.Minute), 40*time.Second, slack)
+	insertTestEvent(t, s, base.Add(10*time.Minute+40*time.Second), 10*time.Minute-40*time.Second, excel)
+
+	if err := a.Rollup(); err != nil {
+		t.Fatalf("Rollup failed: %v", err)
+	}
+	expectStoredSpans(t, s, base, [2]float64{0, 20})
+
+	var seconds int
+	var appID int64
+	err := s.GetDB().QueryRow(`
+		SELECT json_extract(metadata, '$.interrupted_seconds'), json_extract(metadata, '$.interruptions[0].app_id')
+		FROM block
+	`).Scan(&seconds, &appID)
+	if err != nil || seconds != 40 || appID != slack {
+		t.Errorf("Expected a 40s slack interruption in block metadata, got %ds app %d (%v)", seconds, appID, err)
+	}
+}
+
+func TestApplyRulesCommitsInBatches(t *testing.T) {
+	s := setupTestStore(t)
+	db := s.GetDB()
+	storetest.SeedExcelRule(t, db)
+	excel, _ := s.GetOrCreateDictApp("EXCEL.EXE")
+	word, _ := s.GetOrCreateDictApp("WINWORD.EXE")
+
+	// Blocks sharing a start time must not be skipped between batches
+	n := applyBatchSize + 10
+	for i := 0; i < n; i++ {
+		appID := excel
+		if i%10 == 9 {
+			appID = word
+		}
+		start := testStart.Add(time.Duration((i+1)/2) * time.Minute).Format(time.RFC3339)
+		if _, err := db.Exec(
+			"INSERT INTO block (ts_start, ts_end, primary_app_id, confidence) VALUES (?, ?, ?, 'LOW')",
+			start, start, appID,
+		); err != nil {
+			t.Fatalf("Failed to insert block: %v", err)
+		}
+	}
+
+	var updates []ApplyProgress
+	report, err := NewRuleEngine(s).ApplyRules(ApplyOptions{
+		Start: testStart,
+		End:   testStart.AddDate(0, 0, 1),
+	}, func(p ApplyProgress) { updates = append(updates, p) })
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	want := n - n/10
+	if report.Blocks != n || len(report.Changes) != want || report.Unmatched != n/10 {
+		t.Errorf("Expected %d blocks, %d changes, %d unmatched; got %d, %d, %d",
+			n, want, n/10, report.Blocks, len(report.Changes), report.Unmatched)
+	}
+	if len(report.AuditIDs) != 2 || len(updates) != 2 || updates[1].Processed != n || updates[1].Total != n {
+		t.Errorf("Expected two committed batches, got audits %v and progress %+v", report.AuditIDs, updates)
+	}
+
+	var assigned int
+	db.QueryRow("SELECT COUNT(*) FROM block WHERE profile_id = 1").Scan(&assigned)
+	if assigned != want {
+		t.Errorf("Expected %d assigned blocks, got %d", want, assigned)
+	}
+
+	// Each batch undoes on its own
+	if _, err := s.UndoAudit(report.AuditIDs[1]); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM block WHERE profile_id = 1").Scan(&assigned)
+	if assigned != applyBatchSize-applyBatchSize/10 {
+		t.Errorf("Expected only the first batch assigned after undo, got %d", assigned)
+	}
+
+	// Undoing the operation reverts the batches still applied
+	undoIDs, err := s.UndoOperation(report.OperationID)
+	if err != nil || len(undoIDs) != 1 {
+		t.Fatalf("Expected the remaining batch undone, got %v (%v)", undoIDs, err)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM block WHERE profile_id IS NOT NULL").Scan(&assigned)
+	if assigned != 0 {
+		t.Errorf("Expected no assigned blocks after undoing the operation, got %d", assigned)
+	}
+	if _, err := s.UndoOperation(report.OperationID); err == nil || !strings.Contains(err.Error(), "already undone") {
+		t.Errorf("Undoing the operation twice should fail, got %v", err)
+	}
+	if _, err := s.UndoOperation("missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected an unknown operation to be rejected, got %v", err)
+	}
+
+	if _, err := NewRuleEngine(s).ApplyRules(ApplyOptions{
+		Start:   testStart,
+		End:     testStart.AddDate(0, 0, 1),
+		RuleIDs: []int64{42},
+	}, nil); !errors.Is(err, ErrRuleUnavailable) {
+		t.Errorf("Expected unknown rule to be rejected, got %v", err)
+	}
+}
+
+func TestPreviewRuleMatchesApplyEligibility(t *testing.T) {
+	s := setupTestStore(t)
+	db := s.GetDB()
+	storetest.SeedExcelRule(t, db)
+	db.Exec(`
+		INSERT INTO profile (client_id, service_id, rate_id, name)
+		SELECT client_id, service_id, rate_id, 'Other' FROM profile
+	`)
+	excel, _ := s.GetOrCreateDictApp("EXCEL.EXE")
+
+	insertBlock := func(minute int, extra string) int64 {
+		t.Helper()
+		start := testStart.Add(time.Duration(minute) * time.Minute).Format(time.RFC3339)
+		res, err := db.Exec(
+			"INSERT INTO block (ts_start, ts_end, primary_app_id, confidence) VALUES (?, ?, ?, 'LOW')",
+			start, start, excel,
+		)
+		if err != nil {
+			t.Fatalf("Failed to insert block: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		if extra != "" {
+			db.Exec("UPDATE block SET "+extra+" WHERE block_id = ?", id)
+		}
+		return id
+	}
+
+	low := insertBlock(0, "")
+	ruled := insertBlock(10, "profile_id = 2, confidence = 'HIGH'")
+	insertBlock(20, "profile_id = 2, confidence = 'HIGH', is_manual = 1")
+	labelled := insertBlock(30, "profile_id = 2, confidence = 'HIGH'")
+	insertBlock(40, "locked = 1")
+	db.Exec(`
+		INSERT INTO ml_label_event (block_id, old_profile_id, new_profile_id, actor, confidence_after)
+		VALUES (?, NULL, 2, 'USER', 'HIGH')
+	`, labelled)
+
+	re := NewRuleEngine(s)
+	if err := re.LoadRules(); err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	rule := re.cache.rules[0]
+	end := testStart.AddDate(0, 0, 1)
+
+	changed := func(blocks []RulePreviewBlock) []int64 {
+		ids := []int64{}
+		for _, b := range blocks {
+			ids = append(ids, b.BlockID)
+		}
+		return ids
+	}
+	skipped := func(p *RulePreview) map[string]int {
+		counts := make(map[string]int)
+		for _, b := range p.Matched {
+			counts[b.Skipped]++
+		}
+		return counts
+	}
+
+	for _, overwrite := range []bool{false, true} {
+		preview, err := re.PreviewRule(rule, testStart, end, overwrite)
+		if err != nil {
+			t.Fatalf("Preview failed: %v", err)
+		}
+		want := []int64{low}
+		confirmed := 3
+		if overwrite {
+			want = []int64{low, ruled}
+			confirmed = 2
+		}
+		if got := changed(preview.Changes); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Overwrite %v: expected changes %v, got %v", overwrite, want, got)
+		}
+		if counts := skipped(preview); counts[PreviewSkippedConfirmed] != confirmed || counts[PreviewSkippedLocked] != 1 {
+			t.Errorf("Overwrite %v: expected %d confirmed and 1 locked, got %v", overwrite, confirmed, counts)
+		}
+
+		// A rule run with the same setting changes the same blocks
+		report, err := re.ApplyRules(ApplyOptions{Start: testStart, End: end, Overwrite: overwrite}, nil)
+		if err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+		var applied []int64
+		for _, c := range report.Changes {
+			applied = append(applied, c.BlockID)
+		}
+		if fmt.Sprint(applied) != fmt.Sprint(want) {
+			t.Errorf("Overwrite %v: expected apply to change %v, got %v", overwrite, want, applied)
+		}
+		if _, err := s.UndoOperation(report.OperationID); err != nil {
+			t.Fatalf("Undo failed: %v", err)
+		}
+	}
+}
+
+func TestRestoreSnapshotReloadsRuleCaches(t *testing.T) {
+	s := setupTestStore(t)
+	a := newTestAggregator(s)
+	s.SetSetting(store.SettingBackupDir, t.TempDir())
+
+	storetest.SeedExcelRule(t, s.GetDB())
+	s.GetOrCreateDictApp("EXCEL.EXE")
+
+	snap, err := s.CreateSnapshot(store.SnapshotManual)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+
+	// Caches loaded after the snapshot reflect the later state
+	s.GetDB().Exec("DELETE FROM rule")
+	s.GetOrCreateDictApp("WINWORD.EXE")
+	a.ruleEngine.LoadRules()
+	a.ruleEngine.LoadDictionaries()
+
+	if err := a.RestoreSnapshot(snap.Name); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if len(a.ruleEngine.cache.rules) != 1 {
+		t.Errorf("Expected the snapshot's rule after restore, got %d rules", len(a.ruleEngine.cache.rules))
+	}
+	if _, ok := a.ruleEngine.cache.appNameMap["WINWORD.EXE"]; ok {
+		t.Error("Dictionary cache should not keep apps from the replaced database")
+	}
+}
@@ -0,0 +1,294 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"chroniclecore/internal/store"
+)
+
+// applyBatchSize is how many blocks ApplyRules matches per transaction
+const applyBatchSize = 500
+
+// ApplyOptions selects the blocks and rules for ApplyRules
+type ApplyOptions struct {
+	Start     time.Time
+	End       time.Time
+	RuleIDs   []int64 // Enabled rules to apply; empty for all
+	Overwrite bool    // Also reassign MEDIUM/HIGH blocks the user never assigned
+}
+
+// ApplyProgress is reported after each batch
+type ApplyProgress struct {
+	Processed int `json:"processed"`
+	Total     int `json:"total"`
+	Changed   int `json:"changed"`
+}
+
+// ApplyChange is one block a rule run reassigned
+type ApplyChange struct {
+	BlockID        int64  `json:"block_id"`
+	RuleID         int64  `json:"rule_id"`
+	FromProfileID  *int64 `json:"from_profile_id"`
+	FromConfidence string `json:"from_confidence"`
+	ProfileID      int64  `json:"profile_id"`
+	Confidence     string `json:"confidence"`
+}
+
+// ApplyReport is the outcome of ApplyRules
+type ApplyReport struct {
+	Start       string        `json:"start"`
+	End         string        `json:"end"`
+	RuleIDs     []int64       `json:"rule_ids"` // Rules actually applied
+	Overwrite   bool          `json:"overwrite"`
+	Blocks      int           `json:"blocks"`    // Candidate blocks examined
+	Unmatched   int           `json:"unmatched"` // Candidates no applied rule matched
+	Unchanged   int           `json:"unchanged"` // Matched, already assigned that way
+	Changes     []ApplyChange `json:"changes"`
+	OperationID string        `json:"operation_id"` // Shared by the run's audit entries; undoes them all
+	AuditIDs    []int64       `json:"audit_ids"`    // One per batch that changed blocks
+}
+
+// ErrRuleUnavailable is returned when a requested rule is not an enabled
+// rule that loaded
+var ErrRuleUnavailable = errors.New("not found, disabled or invalid")
+
+// applyCursor is the last block a batch examined, in (ts_start, block_id) order
+type applyCursor struct {
+	tsStart string
+	blockID int64
+}
+
+// ApplyRules runs rules over existing blocks starting in [start, end), not
+// just the recent unassigned ones a rollup picks up. Unlocked blocks that
+// are unassigned or LOW confidence are candidates; with Overwrite, so are
+// MEDIUM and HIGH blocks that aren't manual entries and whose profile the
+// user never chose. Blocks no rule matches are left alone. Candidates are
+// matched in batches of applyBatchSize, each committed with its own audit
+// entry, so the database isn't held for the whole run. The entries share an
+// operation ID, so store.UndoOperation reverts the whole run at once. Blocks
+// a failed run already assigned stay assigned, and running it again picks
+// up the rest.
+func (re *RuleEngine) ApplyRules(opts ApplyOptions, progress func(ApplyProgress)) (*ApplyReport, error) {
+	if !opts.Start.Before(opts.End) {
+		return nil, fmt.Errorf("invalid range: start must be before end")
+	}
+
+	if err := re.LoadRules(); err != nil {
+		return nil, err
+	}
+	if err := re.LoadDictionaries(); err != nil {
+		return nil, err
+	}
+
+	rules, err := selectRules(re.cache.rules, opts.RuleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	operationID, err := store.NewOperationID()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ApplyReport{
+		Start:       opts.Start.UTC().Format(time.RFC3339),
+		End:         opts.End.UTC().Format(time.RFC3339),
+		RuleIDs:     []int64{},
+		Overwrite:   opts.Overwrite,
+		Changes:     []ApplyChange{},
+		OperationID: operationID,
+		AuditIDs:    []int64{},
+	}
+	for _, rule := range rules {
+		report.RuleIDs = append(report.RuleIDs, rule.RuleID)
+	}
+
+	candidates := `b.deleted_at IS NULL
+		  AND b.ts_start >= ? AND b.ts_start < ?
+		  AND ` + reassignableSQL(opts.Overwrite)
+
+	var total int
+	err = re.store.GetDB().QueryRow(
+		"SELECT COUNT(*) FROM block b WHERE "+candidates, report.Start, report.End,
+	).Scan(&total)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count blocks: %w", err)
+	}
+
+	re.stats = make(map[int64]*ruleStat)
+
+	var cursor applyCursor
+	for {
+		n, err := re.applyBatch(opts, rules, candidates, report, &cursor)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+
+		// Blocks rolled up since the count are picked up too
+		report.Blocks += n
+		if report.Blocks > total {
+			total = report.Blocks
+		}
+		log.Printf("Applying rules: %d/%d blocks, %d changed", report.Blocks, total, len(report.Changes))
+		if progress != nil {
+			progress(ApplyProgress{Processed: report.Blocks, Total: total, Changed: len(report.Changes)})
+		}
+
+		if n < applyBatchSize {
+			break
+		}
+	}
+
+	return report, nil
+}
+
+// reassignableSQL is the condition on a block (aliased b) for a rule run to
+// reassign it: unlocked, and unassigned or LOW confidence. With overwrite,
+// MEDIUM and HIGH blocks qualify too unless they are manual entries or the
+// user chose their profile.
+func reassignableSQL(overwrite bool) string {
+	eligible := "b.profile_id IS NULL OR b.confidence = 'LOW'"
+	if overwrite {
+		eligible += " OR (COALESCE(b.is_manual, 0) = 0 AND NOT " + userAssignedSQL + ")"
+	}
+	return "b.locked = 0 AND (" + eligible + ")"
+}
+
+// applyBatch matches the next applyBatchSize candidates after cursor in one
+// transaction and advances cursor. Returns the number of blocks examined.
+func (re *RuleEngine) applyBatch(opts ApplyOptions, rules []*Rule, candidates string, report *ApplyReport, cursor *applyCursor) (int, error) {
+	tx, err := re.store.GetDB().Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT `+blockColumns+`
+		FROM block b
+		WHERE `+candidates+`
+		  AND (b.ts_start > ? OR (b.ts_start = ? AND b.block_id > ?))
+		ORDER BY b.ts_start ASC, b.block_id ASC
+		LIMIT ?
+	`, report.Start, report.End, cursor.tsStart, cursor.tsStart, cursor.blockID, applyBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query blocks: %w", err)
+	}
+	blocks, err := scanBlocks(rows)
+	rows.Close() // Free the transaction's connection before the updates
+	if err != nil {
+		return 0, err
+	}
+	if len(blocks) == 0 {
+		return 0, nil
+	}
+
+	audit := store.NewBlockAudit(store.AuditActorUser, store.AuditApplyRules)
+	changed := 0
+
+	for _, block := range blocks {
+		facts, err := re.factsFor(block)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load block %d: %w", block.BlockID, err)
+		}
+
+		// A subset would skew the statistics: its rules win blocks that
+		// rules left out of it would take
+		var rule *Rule
+		if len(opts.RuleIDs) == 0 {
+			rule = re.recordMatches(rules, facts)
+		} else {
+			rule = firstMatch(rules, facts)
+		}
+		if rule == nil {
+			report.Unmatched++
+			continue
+		}
+		profileID, confidence := rule.assignment()
+		if block.ProfileID != nil && *block.ProfileID == *profileID && block.Confidence == confidence {
+			report.Unchanged++
+			continue
+		}
+
+		if err := audit.Track(tx, block.BlockID); err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(
+			"UPDATE block SET profile_id = ?, confidence = ? WHERE block_id = ?",
+			*profileID, confidence, block.BlockID,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update block %d: %w", block.BlockID, err)
+		}
+
+		report.Changes = append(report.Changes, ApplyChange{
+			BlockID:        block.BlockID,
+			RuleID:         rule.RuleID,
+			FromProfileID:  block.ProfileID,
+			FromConfidence: block.Confidence,
+			ProfileID:      *profileID,
+			Confidence:     confidence,
+		})
+		changed++
+	}
+
+	auditID, err := audit.Commit(tx, map[string]interface{}{
+		"operation_id": report.OperationID,
+		"start":        report.Start,
+		"end":          report.End,
+		"rule_ids":     report.RuleIDs,
+		"overwrite":    opts.Overwrite,
+		"assigned":     changed,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := re.flushStats(tx); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit rule application: %w", err)
+	}
+
+	if auditID != 0 {
+		report.AuditIDs = append(report.AuditIDs, auditID)
+	}
+	last := blocks[len(blocks)-1]
+	*cursor = applyCursor{tsStart: last.TsStart.UTC().Format(time.RFC3339), blockID: last.BlockID}
+	return len(blocks), nil
+}
+
+// selectRules returns the loaded rules with the given IDs, in match order.
+// Every ID must be an enabled rule that loaded.
+func selectRules(rules []*Rule, ids []int64) ([]*Rule, error) {
+	if len(ids) == 0 {
+		return rules, nil
+	}
+
+	wanted := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var selected []*Rule
+	for _, rule := range rules {
+		if wanted[rule.RuleID] {
+			selected = append(selected, rule)
+			delete(wanted, rule.RuleID)
+		}
+	}
+	for _, id := range ids {
+		if wanted[id] {
+			return nil, fmt.Errorf("rule %d %w", id, ErrRuleUnavailable)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"chroniclecore/internal/store"
+)
+
+// BackupScheduler takes rotating daily/weekly snapshots of the database
+type BackupScheduler struct {
+	store  *store.Store
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// BackupSchedulerConfig holds backup scheduler configuration
+type BackupSchedulerConfig struct {
+	Store         *store.Store
+	CheckInterval time.Duration // How often to check whether a snapshot is due (default: 1 hour)
+}
+
+// NewBackupScheduler creates a backup scheduler and starts it
+func NewBackupScheduler(config BackupSchedulerConfig) *BackupScheduler {
+	if config.CheckInterval == 0 {
+		config.CheckInterval = time.Hour
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	bs := &BackupScheduler{
+		store:  config.Store,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	go bs.schedule(config.CheckInterval)
+
+	return bs
+}
+
+// Stop stops the scheduler
+func (bs *BackupScheduler) Stop() {
+	if bs.cancel != nil {
+		bs.cancel()
+	}
+}
+
+// schedule checks for due snapshots on a fixed interval
+func (bs *BackupScheduler) schedule(interval time.Duration) {
+	bs.run()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bs.ctx.Done():
+			return
+		case <-ticker.C:
+			bs.run()
+		}
+	}
+}
+
+func (bs *BackupScheduler) run() {
+	created, err := bs.store.RunScheduledBackup(time.Now())
+	if err != nil {
+		log.Printf("Scheduled backup failed: %v", err)
+		return
+	}
+	for _, snap := range created {
+		log.Printf("Scheduled %s snapshot: %s", snap.Kind, snap.Name)
+	}
+}
+
+// RestoreSnapshot swaps the database for a snapshot while no rollup is
+// running, then reloads the rules and dictionaries cached from the old one
+func (a *Aggregator) RestoreSnapshot(name string) error {
+	a.rollupMu.Lock()
+	defer a.rollupMu.Unlock()
+
+	if err := a.store.RestoreSnapshot(name); err != nil {
+		return err
+	}
+
+	if err := a.ruleEngine.LoadRules(); err != nil {
+		return fmt.Errorf("failed to reload rules: %w", err)
+	}
+	if err := a.ruleEngine.LoadDictionaries(); err != nil {
+		return fmt.Errorf("failed to reload dictionaries: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// condition is a compiled COMPOSITE rule match_value. The JSON form is a
+// tree of single-key objects:
+//
+//	{"all": [...]}, {"any": [...]}, {"not": {...}}
+//	{"app": "EXCEL.EXE"}, {"title_regex": "Invoice \\d+"}, {"keyword": "budget"}
+//	{"domain": "*.xero.com"}  (same patterns as DOMAIN rules)
+//	{"weekday": ["mon", "tue"]}
+//	{"time_of_day": {"from": "09:00", "to": "17:00"}}  (wraps midnight if from > to)
+//	{"min_duration": "15m"}, {"max_duration": "2h"}
+//	{"min_activity": 0.5}, {"max_activity": 0.9}
+//
+// Weekday and time of day are taken at the block's start, in local time.
+type condition struct {
+	op       string
+	children []*condition
+	text     string
+	regex    *regexp.Regexp
+	domain   *domainPattern
+	weekdays [7]bool
+	from, to int // Minutes since midnight
+	duration time.Duration
+	score    float64
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// conditionError is a validation error at a path in the condition tree
+type conditionError struct {
+	path string
+	msg  string
+}
+
+func (e *conditionError) Error() string {
+	return e.path + ": " + e.msg
+}
+
+// compileCondition parses and validates a COMPOSITE rule's match_value
+func compileCondition(value string) (*condition, error) {
+	return parseCondition(json.RawMessage(value), "match_value")
+}
+
+// ValidateCondition checks a COMPOSITE rule's match_value. Errors name the
+// offending node, e.g. match_value.all[1].time_of_day.from.
+func ValidateCondition(value string) error {
+	_, err := compileCondition(value)
+	return err
+}
+
+func parseCondition(raw json.RawMessage, path string) (*condition, error) {
+	var node map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &node); err != nil || node == nil {
+		return nil, &conditionError{path, "expected an object with one condition"}
+	}
+	if len(node) != 1 {
+		keys := make([]string, 0, len(node))
+		for k := range node {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return nil, &conditionError{path, fmt.Sprintf("expected exactly one condition, got %d (%s); combine them with all", len(keys), strings.Join(keys, ", "))}
+	}
+
+	var op string
+	var arg json.RawMessage
+	for k, v := range node {
+		op, arg = k, v
+	}
+	path += "." + op
+	c := &condition{op: op}
+
+	switch op {
+	case "all", "any":
+		var items []json.RawMessage
+		if err := json.Unmarshal(arg, &items); err != nil {
+			return nil, &conditionError{path, "expected a list of conditions"}
+		}
+		if len(items) == 0 {
+			return nil, &conditionError{path, "needs at least one condition"}
+		}
+		for i, item := range items {
+			child, err := parseCondition(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			c.children = append(c.children, child)
+		}
+
+	case "not":
+		child, err := parseCondition(arg, path)
+		if err != nil {
+			return nil, err
+		}
+		c.children = []*condition{child}
+
+	case "app", "keyword":
+		if err := parseString(arg, path, &c.text); err != nil {
+			return nil, err
+		}
+
+	case "title_regex":
+		var pattern string
+		if err := parseString(arg, path, &pattern); err != nil {
+			return nil, err
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, &conditionError{path, "invalid regex: " + err.Error()}
+		}
+		c.regex = compiled
+
+	case "domain":
+		var pattern string
+		if err := parseString(arg, path, &pattern); err != nil {
+			return nil, err
+		}
+		domain, err := parseDomainPattern(pattern)
+		if err != nil {
+			return nil, &conditionError{path, err.Error()}
+		}
+		c.domain = domain
+
+	case "weekday":
+		var days []string
+		if err := json.Unmarshal(arg, &days); err != nil || len(days) == 0 {
+			return nil, &conditionError{path, `expected a list of days, e.g. ["mon", "fri"]`}
+		}
+		for i, day := range days {
+			name := strings.ToLower(strings.TrimSpace(day))
+			wd, ok := weekdayNames[name]
+			if !ok && len(name) > 3 {
+				wd, ok = weekdayNames[name[:3]]
+				ok = ok && strings.EqualFold(wd.String(), name)
+			}
+			if !ok {
+				return nil, &conditionError{fmt.Sprintf("%s[%d]", path, i), fmt.Sprintf("unknown day %q", day)}
+			}
+			c.weekdays[wd] = true
+		}
+
+	case "time_of_day":
+		var window struct {
+			From *string `json:"from"`
+			To   *string `json:"to"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(arg))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&window); err != nil {
+			return nil, &conditionError{path, `expected {"from": "HH:MM", "to": "HH:MM"}`}
+		}
+		var err error
+		if c.from, err = parseClock(window.From, path+".from"); err != nil {
+			return nil, err
+		}
+		if c.to, err = parseClock(window.To, path+".to"); err != nil {
+			return nil, err
+		}
+		if c.from == c.to {
+			return nil, &conditionError{path, "from and to are the same time"}
+		}
+
+	case "min_duration", "max_duration":
+		var text string
+		if err := parseString(arg, path, &text); err != nil {
+			return nil, err
+		}
+		d, err := time.ParseDuration(text)
+		if err != nil || d < 0 {
+			return nil, &conditionError{path, fmt.Sprintf("invalid duration %q, e.g. \"15m\" or \"1h30m\"", text)}
+		}
+		c.duration = d
+
+	case "min_activity", "max_activity":
+		if err := json.Unmarshal(arg, &c.score); err != nil {
+			return nil, &conditionError{path, "expected a number between 0 and 1"}
+		}
+		if c.score < 0 || c.score > 1 {
+			return nil, &conditionError{path, fmt.Sprintf("%g is not between 0 and 1", c.score)}
+		}
+
+	default:
+		return nil, &conditionError{path, "unknown condition; expected one of all, any, not, app, title_regex, keyword, domain, weekday, time_of_day, min_duration, max_duration, min_activity, max_activity"}
+	}
+
+	return c, nil
+}
+
+// parseString reads a non-empty JSON string
+func parseString(arg json.RawMessage, path string, out *string) error {
+	if err := json.Unmarshal(arg, out); err != nil {
+		return &conditionError{path, "expected a string"}
+	}
+	if strings.TrimSpace(*out) == "" {
+		return &conditionError{path, "must not be empty"}
+	}
+	return nil
+}
+
+// parseClock reads an HH:MM time as minutes since midnight
+func parseClock(value *string, path string) (int, error) {
+	if value == nil {
+		return 0, &conditionError{path, "is required"}
+	}
+	t, err := time.Parse("15:04", *value)
+	if err != nil {
+		return 0, &conditionError{path, fmt.Sprintf("invalid time %q, expected HH:MM", *value)}
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// eval reports whether a block satisfies the condition
+func (c *condition) eval(f *blockFacts) bool {
+	switch c.op {
+	case "all":
+		for _, child := range c.children {
+			if !child.eval(f) {
+				return false
+			}
+		}
+		return true
+	case "any":
+		for _, child := range c.children {
+			if child.eval(f) {
+				return true
+			}
+		}
+		return false
+	case "not":
+		return !c.children[0].eval(f)
+	case "app":
+		return f.app == c.text
+	case "title_regex":
+		return f.title != "" && c.regex.MatchString(f.title)
+	case "keyword":
+		return f.title != "" && contains(f.title, c.text)
+	case "domain":
+		hosts, urls := f.domains()
+		return c.domain.matches(hosts, urls)
+	case "weekday":
+		return c.weekdays[f.start.Weekday()]
+	case "time_of_day":
+		minute := f.start.Hour()*60 + f.start.Minute()
+		if c.from < c.to {
+			return minute >= c.from && minute < c.to
+		}
+		return minute >= c.from || minute < c.to
+	case "min_duration":
+		return f.duration >= c.duration
+	case "max_duration":
+		return f.duration <= c.duration
+	case "min_activity":
+		return f.activity >= c.score
+	case "max_activity":
+		return f.activity <= c.score
+	}
+	return false
+}
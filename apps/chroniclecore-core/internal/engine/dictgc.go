@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"chroniclecore/internal/store"
+)
+
+// DictGC periodically deletes dictionary rows nothing refers to any more,
+// mostly window titles left behind by the raw event retention purge
+type DictGC struct {
+	store  *store.Store
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// DictGCConfig holds dictionary GC configuration
+type DictGCConfig struct {
+	Store    *store.Store
+	Interval time.Duration // How often to collect (default: 24 hours)
+}
+
+// NewDictGC creates a dictionary garbage collector and starts it
+func NewDictGC(config DictGCConfig) *DictGC {
+	if config.Interval == 0 {
+		config.Interval = 24 * time.Hour
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	gc := &DictGC{
+		store:  config.Store,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	go gc.schedule(config.Interval)
+
+	return gc
+}
+
+// Stop stops the collector
+func (gc *DictGC) Stop() {
+	if gc.cancel != nil {
+		gc.cancel()
+	}
+}
+
+// schedule collects at startup, then on a fixed interval
+func (gc *DictGC) schedule(interval time.Duration) {
+	gc.run()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-gc.ctx.Done():
+			return
+		case <-ticker.C:
+			gc.run()
+		}
+	}
+}
+
+func (gc *DictGC) run() {
+	titles, domains, err := gc.store.CollectDictGarbage()
+	if err != nil {
+		log.Printf("Dictionary GC failed: %v", err)
+		return
+	}
+	if titles > 0 || domains > 0 {
+		log.Printf("Dictionary GC removed %d titles and %d domains", titles, domains)
+	}
+}
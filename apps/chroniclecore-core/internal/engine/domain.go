@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"chroniclecore/internal/store"
+)
+
+// How a DOMAIN rule compares hosts
+const (
+	domainExact     = iota // go.xero.com
+	domainSubdomain        // *.xero.com: any subdomain, not xero.com itself
+	domainSite             // site:xero.com: any host under the same registrable domain
+)
+
+// domainPattern is a parsed DOMAIN rule match_value. An optional path
+// prefix (go.xero.com/app/invoices) also requires a page URL under it.
+type domainPattern struct {
+	host string
+	mode int
+	path string
+}
+
+// multiLabelSuffixes are public suffixes of more than one label. Hosts
+// under them keep one more label as their registrable domain; anywhere
+// else the last two labels are used. This is a short list of the common
+// ones, not the full public suffix list.
+var multiLabelSuffixes = map[string]bool{
+	"co.za": true, "org.za": true, "net.za": true, "gov.za": true, "ac.za": true, "web.za": true,
+	"co.uk": true, "org.uk": true, "ac.uk": true, "gov.uk": true, "me.uk": true,
+	"com.au": true, "net.au": true, "org.au": true, "gov.au": true, "edu.au": true,
+	"co.nz": true, "org.nz": true, "govt.nz": true,
+	"co.in": true, "co.jp": true, "co.ke": true, "co.na": true, "co.bw": true, "co.zw": true,
+	"com.br": true, "com.mx": true, "com.sg": true, "com.ng": true,
+}
+
+// parseDomainPattern parses a DOMAIN rule's match_value
+func parseDomainPattern(value string) (*domainPattern, error) {
+	v := strings.ToLower(strings.TrimSpace(value))
+	if i := strings.Index(v, "://"); i != -1 {
+		v = v[i+3:]
+	}
+
+	p := &domainPattern{mode: domainExact}
+	switch {
+	case strings.HasPrefix(v, "site:"):
+		p.mode = domainSite
+		v = strings.TrimPrefix(v, "site:")
+	case strings.HasPrefix(v, "*."):
+		p.mode = domainSubdomain
+		v = strings.TrimPrefix(v, "*.")
+	}
+
+	if i := strings.Index(v, "/"); i != -1 {
+		v, p.path = v[:i], strings.TrimRight(v[i:], "/")
+	}
+	p.host = normalizeHost(v)
+
+	if p.host == "" {
+		return nil, fmt.Errorf("domain is empty")
+	}
+	if strings.ContainsAny(p.host, "*?: ") {
+		return nil, fmt.Errorf("invalid domain %q: wildcards are only allowed as a leading *.", p.host)
+	}
+	if multiLabelSuffixes[p.host] || !strings.Contains(p.host, ".") && p.mode != domainExact {
+		return nil, fmt.Errorf("%q is a public suffix, not a domain", p.host)
+	}
+	if p.mode == domainSite && registrableDomain(p.host) != p.host {
+		return nil, fmt.Errorf("site: needs a registrable domain, e.g. site:%s", registrableDomain(p.host))
+	}
+	return p, nil
+}
+
+// ValidateDomainPattern checks a DOMAIN rule's match_value
+func ValidateDomainPattern(value string) error {
+	_, err := parseDomainPattern(value)
+	return err
+}
+
+// matches reports whether one of hosts matches, and for a path prefix
+// whether one of the page URLs on a matching host lies under it
+func (p *domainPattern) matches(hosts []string, urls []string) bool {
+	if p.path == "" {
+		for _, host := range hosts {
+			if p.matchesHost(host) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || !p.matchesHost(u.Hostname()) {
+			continue
+		}
+		path := strings.ToLower(u.Path)
+		if path == p.path || strings.HasPrefix(path, p.path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *domainPattern) matchesHost(host string) bool {
+	host = normalizeHost(host)
+	switch p.mode {
+	case domainSubdomain:
+		return strings.HasSuffix(host, "."+p.host)
+	case domainSite:
+		return registrableDomain(host) == p.host
+	default:
+		return host == p.host
+	}
+}
+
+// normalizeHost lowercases a host and drops www. and any trailing dot,
+// the way the tracker records domains
+func normalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	return strings.TrimPrefix(host, "www.")
+}
+
+// registrableDomain returns the eTLD+1 of a host, e.g. xero.com for
+// go.xero.com and sars.gov.za for efiling.sars.gov.za
+func registrableDomain(host string) string {
+	labels := strings.Split(normalizeHost(host), ".")
+	keep := 2
+	if len(labels) >= 3 && multiLabelSuffixes[strings.Join(labels[len(labels)-2:], ".")] {
+		keep = 3
+	}
+	if len(labels) <= keep {
+		return strings.Join(labels, ".")
+	}
+	return strings.Join(labels[len(labels)-keep:], ".")
+}
+
+// blockDomains returns the hosts a block visited, primary domain first,
+// and the page URLs recorded in its metadata
+func (re *RuleEngine) blockDomains(block *store.Block) (hosts []string, urls []string) {
+	ids := []int64{}
+	if block.PrimaryDomainID != nil {
+		ids = append(ids, *block.PrimaryDomainID)
+	}
+
+	if block.Metadata != nil {
+		var meta struct {
+			DomainIDs []int64  `json:"domain_ids"`
+			URLs      []string `json:"urls"`
+		}
+		if json.Unmarshal([]byte(*block.Metadata), &meta) == nil {
+			ids = append(ids, meta.DomainIDs...)
+			urls = meta.URLs
+		}
+	}
+
+	for _, id := range ids {
+		if host := re.domainText(id); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, urls
+}
+
+// domainText looks a domain up in the cache, falling back to the database
+// for domains recorded since LoadDictionaries
+func (re *RuleEngine) domainText(domainID int64) string {
+	if text, ok := re.cache.domainMap[domainID]; ok {
+		return text
+	}
+
+	var text string
+	if err := re.store.GetDB().QueryRow(
+		"SELECT domain_text FROM dict_domain WHERE domain_id = ?", domainID,
+	).Scan(&text); err != nil {
+		return ""
+	}
+	re.cache.domainMap[domainID] = text
+	return text
+}
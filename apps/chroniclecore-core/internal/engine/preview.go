@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Why a matched block would keep its profile
+const (
+	PreviewSkippedLocked    = "locked"
+	PreviewSkippedConfirmed = "confirmed" // Assigned, and a rule run leaves it alone (see reassignableSQL)
+	PreviewSkippedSame      = "same_profile"
+)
+
+// RulePreviewBlock is one block in a rule preview
+type RulePreviewBlock struct {
+	BlockID        int64   `json:"block_id"`
+	TsStart        string  `json:"ts_start"`
+	TsEnd          string  `json:"ts_end"`
+	Hours          float64 `json:"hours"`
+	ProfileID      *int64  `json:"profile_id"` // Current assignment
+	Confidence     string  `json:"confidence"`
+	NewProfileID   *int64  `json:"new_profile_id,omitempty"`
+	NewConfidence  string  `json:"new_confidence,omitempty"`
+	ShadowedByID   int64   `json:"shadowed_by_rule_id,omitempty"`
+	ShadowedByName string  `json:"shadowed_by_rule_name,omitempty"`
+	Skipped        string  `json:"skipped,omitempty"`
+}
+
+// RulePreview is what a rule would do to existing blocks. Nothing is written.
+type RulePreview struct {
+	Start       string             `json:"start"`
+	End         string             `json:"end"`
+	Blocks      int                `json:"blocks"` // Live blocks in the range
+	Matched     []RulePreviewBlock `json:"matched"`
+	Changes     []RulePreviewBlock `json:"changes"`
+	Shadowed    []RulePreviewBlock `json:"shadowed"`
+	HoursMoved  float64            `json:"hours_moved"`
+	AmountMoved float64            `json:"amount_moved"` // Billable, activity-weighted, at the target profile's rate
+	Currency    string             `json:"currency"`
+}
+
+// PreviewRule reports which blocks starting in [start, end) a rule matches,
+// which of those would change profile, and which a higher-priority rule
+// would take instead. A block counts as changed only if ApplyRules with the
+// same overwrite setting would reassign it. The rule may be unsaved (RuleID 0) or an
+// edited copy of a saved rule, which then stands in for the saved one.
+// Disabled rules are previewed as if enabled.
+func (re *RuleEngine) PreviewRule(candidate *Rule, start, end time.Time, overwrite bool) (*RulePreview, error) {
+	if !start.Before(end) {
+		return nil, fmt.Errorf("invalid range: start must be before end")
+	}
+	if err := candidate.compile(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", candidate.MatchType, err)
+	}
+
+	if err := re.LoadRules(); err != nil {
+		return nil, err
+	}
+	if err := re.LoadDictionaries(); err != nil {
+		return nil, err
+	}
+
+	rules := withCandidate(re.cache.rules, candidate)
+
+	startStr := start.UTC().Format(time.RFC3339)
+	endStr := end.UTC().Format(time.RFC3339)
+
+	rows, err := re.store.GetDB().Query(`
+		SELECT `+blockColumns+`
+		FROM block
+		WHERE deleted_at IS NULL AND ts_start >= ? AND ts_start < ?
+		ORDER BY ts_start ASC, block_id ASC
+	`, startStr, endStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blocks: %w", err)
+	}
+	defer rows.Close()
+
+	blocks, err := scanBlocks(rows)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Blocks a rule run with the same overwrite setting would reassign
+	rows, err = re.store.GetDB().Query(`
+		SELECT b.block_id
+		FROM block b
+		WHERE b.deleted_at IS NULL AND b.ts_start >= ? AND b.ts_start < ?
+		  AND `+reassignableSQL(overwrite), startStr, endStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reassignable blocks: %w", err)
+	}
+	reassignable := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan block: %w", err)
+		}
+		reassignable[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query reassignable blocks: %w", err)
+	}
+	rows.Close()
+
+	preview := &RulePreview{
+		Start:    startStr,
+		End:      endStr,
+		Blocks:   len(blocks),
+		Matched:  []RulePreviewBlock{},
+		Changes:  []RulePreviewBlock{},
+		Shadowed: []RulePreviewBlock{},
+	}
+
+	newProfile, newConfidence := candidate.assignment()
+	for _, block := range blocks {
+		facts, err := re.factsFor(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load block %d: %w", block.BlockID, err)
+		}
+		if !candidate.matches(facts) {
+			continue
+		}
+
+		hours := block.TsEnd.Sub(block.TsStart).Hours()
+		entry := RulePreviewBlock{
+			BlockID:    block.BlockID,
+			TsStart:    block.TsStart.UTC().Format(time.RFC3339),
+			TsEnd:      block.TsEnd.UTC().Format(time.RFC3339),
+			Hours:      math.Round(hours*100) / 100,
+			ProfileID:  block.ProfileID,
+			Confidence: block.Confidence,
+		}
+
+		winner := firstMatch(rules, facts)
+		switch {
+		case winner != candidate:
+			entry.ShadowedByID = winner.RuleID
+			entry.ShadowedByName = winner.Name
+			preview.Shadowed = append(preview.Shadowed, entry)
+		case block.Locked:
+			entry.Skipped = PreviewSkippedLocked
+		case !reassignable[block.BlockID]:
+			entry.Skipped = PreviewSkippedConfirmed
+		case block.ProfileID != nil && *block.ProfileID == *newProfile && block.Confidence == newConfidence:
+			entry.Skipped = PreviewSkippedSame
+		default:
+			entry.NewProfileID = newProfile
+			entry.NewConfidence = newConfidence
+			preview.Changes = append(preview.Changes, entry)
+
+			preview.HoursMoved += hours
+			if block.Billable {
+				preview.AmountMoved += hours * block.ActivityScore
+			}
+		}
+
+		preview.Matched = append(preview.Matched, entry)
+	}
+
+	// Amount at the target profile's hourly rate
+	var minorUnits int64
+	err = re.store.GetDB().QueryRow(`
+		SELECT r.hourly_minor_units, r.currency_code
+		FROM profile p JOIN rate r ON p.rate_id = r.rate_id
+		WHERE p.profile_id = ?
+	`, candidate.TargetProfileID).Scan(&minorUnits, &preview.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rate for profile %d: %w", candidate.TargetProfileID, err)
+	}
+	preview.AmountMoved = math.Round(preview.AmountMoved*float64(minorUnits)) / 100
+	preview.HoursMoved = math.Round(preview.HoursMoved*100) / 100
+
+	return preview, nil
+}
+
+// withCandidate returns rules in match order with candidate in place of its
+// saved version. An unsaved rule goes after saved rules of equal priority,
+// where it would land once saved.
+func withCandidate(rules []*Rule, candidate *Rule) []*Rule {
+	out := make([]*Rule, 0, len(rules)+1)
+	for _, r := range rules {
+		if candidate.RuleID == 0 || r.RuleID != candidate.RuleID {
+			out = append(out, r)
+		}
+	}
+	out = append(out, candidate)
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Priority != out[j].Priority {
+			return out[i].Priority > out[j].Priority
+		}
+		return out[i].RuleID != 0 && (out[j].RuleID == 0 || out[i].RuleID < out[j].RuleID)
+	})
+	return out
+}
@@ -0,0 +1,515 @@
+package engine
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"chroniclecore/internal/store"
+)
+
+// Why a block survived a rebuild untouched
+const (
+	RebuildKeptLocked  = "locked"
+	RebuildKeptManual  = "manual"
+	RebuildKeptTrashed = "trashed"
+	RebuildKeptOutside = "outside_range" // Reaches past the rebuilt window
+)
+
+// RebuildBlock is one block in a rebuild report
+type RebuildBlock struct {
+	BlockID    int64  `json:"block_id,omitempty"` // Omitted for new blocks in a dry run
+	TsStart    string `json:"ts_start"`
+	TsEnd      string `json:"ts_end"`
+	App        string `json:"app"`
+	ProfileID  *int64 `json:"profile_id"`
+	Confidence string `json:"confidence"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// RebuildReport is the diff produced by RebuildRange
+type RebuildReport struct {
+	Start          string         `json:"start"` // Window actually rebuilt, widened to whole blocks
+	End            string         `json:"end"`
+	DryRun         bool           `json:"dry_run"`
+	Events         int            `json:"events"`          // Live raw events read
+	ArchivedEvents int            `json:"archived_events"` // Events read back from archive files
+	Kept           []RebuildBlock `json:"kept"`
+	Removed        []RebuildBlock `json:"removed"`
+	Created        []RebuildBlock `json:"created"`
+	Unchanged      int            `json:"unchanged"` // Rebuilt blocks identical to an existing one
+	AuditID        int64          `json:"audit_id,omitempty"`
+}
+
+// existingBlock is a block found in the rebuild window
+type existingBlock struct {
+	RebuildBlock
+	tsStart      time.Time
+	tsEnd        time.Time
+	appID        int64
+	userAssigned bool
+}
+
+// RebuildRange regenerates the blocks in [start, end) from raw events, and
+// from the raw event archive where events have expired. Locked, manual and
+// trashed blocks are kept and new blocks are cut around them. Profiles the
+// user chose for replaced blocks carry onto the new blocks that overlap
+// them, and the one overlapping most takes over the old block's ID so its
+// labels and suggestions stay attached. Rules assign the rest. A dry run
+// does the same work and rolls it back, so the report is exactly what a
+// real run would do.
+func (a *Aggregator) RebuildRange(start, end time.Time, dryRun bool) (*RebuildReport, error) {
+	if !start.Before(end) {
+		return nil, fmt.Errorf("invalid range: start must be before end")
+	}
+
+	a.rollupMu.Lock()
+	defer a.rollupMu.Unlock()
+
+	// Events past the watermark belong to the next rollup
+	watermark, err := a.getWatermark()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rollup watermark: %w", err)
+	}
+
+	tx, err := a.store.GetDB().Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	lo, hi, err := rebuildWindow(tx, start.UTC(), end.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RebuildReport{
+		Start:   lo.Format(time.RFC3339),
+		End:     hi.Format(time.RFC3339),
+		DryRun:  dryRun,
+		Kept:    []RebuildBlock{},
+		Removed: []RebuildBlock{},
+		Created: []RebuildBlock{},
+	}
+
+	existing, err := loadExistingBlocks(tx, lo, hi)
+	if err != nil {
+		return nil, err
+	}
+
+	live, err := store.RawEventsInRangeTx(tx, lo, hi)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := a.store.ArchivedRawEventsTx(tx, lo, hi)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archived events: %w", err)
+	}
+
+	var events []*store.RawEvent
+	for _, e := range live {
+		if e.TsStart.Before(watermark.ts) || (e.TsStart.Equal(watermark.ts) && e.EventID <= watermark.eventID) {
+			events = append(events, e)
+		}
+	}
+	report.Events = len(events)
+	for _, e := range archived {
+		if !e.TsStart.After(watermark.ts) {
+			events = append(events, e)
+			report.ArchivedEvents++
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].TsStart.Before(events[j].TsStart) })
+
+	// Open events in a rolled-up range were orphaned by a crash. Any still
+	// open here have no later OS event in the window and are skipped.
+	closeOrphanedEvents(events)
+
+	// Every event in the range is here, so the sources reconcile in full
+	sources, err := a.sourceReconciler(events, false)
+	if err != nil {
+		return nil, err
+	}
+	events, _ = sources.reconcile(events)
+	blocks := groupEvents(events, a.strategy(), a.interruptionTolerance()).finish()
+
+	// Kept blocks own their time
+	var replaced []*existingBlock
+	for _, b := range existing {
+		if b.Reason != "" {
+			report.Kept = append(report.Kept, b.RebuildBlock)
+			blocks = cutAround(blocks, b.tsStart, b.tsEnd)
+		} else {
+			replaced = append(replaced, b)
+		}
+	}
+
+	// Leave blocks that come out the same alone
+	var created []*store.Block
+	for _, block := range blocks {
+		if i := findIdentical(replaced, block); i >= 0 {
+			replaced = append(replaced[:i], replaced[i+1:]...)
+			report.Unchanged++
+			continue
+		}
+		created = append(created, block)
+	}
+
+	// Carry user-chosen profiles by overlap
+	carriedFrom := make(map[*store.Block]*existingBlock)
+	for _, block := range created {
+		if src := mostOverlapping(replaced, block); src != nil {
+			block.ProfileID = src.ProfileID
+			block.Confidence = src.Confidence
+			block.Metadata = withMetadata(block.Metadata, "profile_source", "user")
+			carriedFrom[block] = src
+		}
+	}
+	heirs := heirsOf(created, carriedFrom)
+	reused := make(map[int64]bool, len(heirs))
+	for _, id := range heirs {
+		reused[id] = true
+	}
+
+	audit := store.NewBlockAudit(store.AuditActorUser, store.AuditRebuildBlocks)
+
+	trackIDs := make([]int64, len(replaced))
+	var removedIDs []int64
+	for i, b := range replaced {
+		trackIDs[i] = b.BlockID
+		if !reused[b.BlockID] {
+			removedIDs = append(removedIDs, b.BlockID)
+			report.Removed = append(report.Removed, b.RebuildBlock)
+		}
+	}
+	if err := audit.Track(tx, trackIDs...); err != nil {
+		return nil, err
+	}
+	if err := store.DeleteBlocksTx(tx, removedIDs); err != nil {
+		return nil, err
+	}
+
+	// Non-nil, so rules never reach past the new blocks
+	assignIDs := []int64{}
+	for _, block := range created {
+		if id, ok := heirs[block]; ok {
+			if err := store.ReplaceBlockTx(tx, id, block); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := store.InsertBlockTx(tx, block); err != nil {
+			return nil, err
+		}
+		audit.Created(block.BlockID)
+		if _, ok := carriedFrom[block]; !ok {
+			assignIDs = append(assignIDs, block.BlockID)
+		}
+	}
+
+	if len(created) > 0 {
+		if err := a.ruleEngine.AssignBlocksTx(tx, assignIDs); err != nil {
+			return nil, fmt.Errorf("failed to assign profiles: %w", err)
+		}
+		if err := a.templateEngine.GenerateDescriptionsTx(tx); err != nil {
+			return nil, fmt.Errorf("failed to generate descriptions: %w", err)
+		}
+	}
+
+	auditID, err := audit.Commit(tx, map[string]interface{}{
+		"start":   report.Start,
+		"end":     report.End,
+		"created": len(created),
+		"removed": len(removedIDs),
+		"kept":    len(report.Kept),
+		"carried": len(carriedFrom),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Report what the new blocks ended up with after rules ran
+	for _, block := range created {
+		rb, err := describeCreatedBlock(tx, block)
+		if err != nil {
+			return nil, err
+		}
+		if src, ok := carriedFrom[block]; ok {
+			rb.Reason = fmt.Sprintf("profile carried from block %d", src.BlockID)
+		}
+		if _, ok := heirs[block]; dryRun && !ok {
+			rb.BlockID = 0
+		}
+		report.Created = append(report.Created, rb)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit rebuild: %w", err)
+	}
+
+	report.AuditID = auditID
+	log.Printf("Rebuilt blocks %s..%s: %d created, %d removed, %d kept, %d unchanged",
+		report.Start, report.End, len(report.Created), len(report.Removed), len(report.Kept), report.Unchanged)
+	return report, nil
+}
+
+// rebuildWindow widens [start, end) so replaceable blocks crossing either
+// edge are rebuilt whole
+func rebuildWindow(tx *sql.Tx, start, end time.Time) (time.Time, time.Time, error) {
+	const replaceable = "locked = 0 AND COALESCE(is_manual, 0) = 0 AND deleted_at IS NULL"
+
+	startStr := start.Format(time.RFC3339)
+	endStr := end.Format(time.RFC3339)
+
+	var lo, hi sql.NullString
+	err := tx.QueryRow(
+		"SELECT MIN(ts_start) FROM block WHERE ts_start < ? AND ts_end > ? AND "+replaceable,
+		startStr, startStr,
+	).Scan(&lo)
+	if err != nil {
+		return start, end, fmt.Errorf("failed to widen rebuild window: %w", err)
+	}
+	err = tx.QueryRow(
+		"SELECT MAX(ts_end) FROM block WHERE ts_start < ? AND ts_end > ? AND "+replaceable,
+		endStr, endStr,
+	).Scan(&hi)
+	if err != nil {
+		return start, end, fmt.Errorf("failed to widen rebuild window: %w", err)
+	}
+
+	if lo.Valid {
+		if ts, err := time.Parse(time.RFC3339, lo.String); err == nil && ts.Before(start) {
+			start = ts
+		}
+	}
+	if hi.Valid {
+		if ts, err := time.Parse(time.RFC3339, hi.String); err == nil && ts.After(end) {
+			end = ts
+		}
+	}
+
+	return start, end, nil
+}
+
+// userAssignedSQL is true for a block (aliased b) whose profile the user
+// chose: they reassigned it or accepted a suggestion for it, or an earlier
+// rebuild carried such a choice onto it
+const userAssignedSQL = `(
+		       EXISTS (
+		         SELECT 1 FROM audit_log a
+		         WHERE a.actor = 'USER' AND a.action IN ('REASSIGN_BLOCK', 'ML_ACCEPT')
+		           AND json_valid(a.details_json) AND (
+		             EXISTS (SELECT 1 FROM json_each(a.details_json, '$.block_ids') WHERE value = b.block_id)
+		             OR json_extract(a.details_json, '$.block_id') = b.block_id
+		           )
+		       )
+		       OR EXISTS (
+		         SELECT 1 FROM ml_label_event m
+		         WHERE m.block_id = b.block_id AND m.actor = 'USER' AND m.new_profile_id IS NOT NULL
+		       )
+		       OR COALESCE(CASE WHEN json_valid(b.metadata) THEN json_extract(b.metadata, '$.profile_source') END, '') = 'user'
+		       )`
+
+// loadExistingBlocks returns every block overlapping [lo, hi). Blocks that
+// must be kept come back with Reason set.
+func loadExistingBlocks(tx *sql.Tx, lo, hi time.Time) ([]*existingBlock, error) {
+	loStr := lo.Format(time.RFC3339)
+	hiStr := hi.Format(time.RFC3339)
+
+	rows, err := tx.Query(`
+		SELECT b.block_id, b.ts_start, b.ts_end, b.primary_app_id, da.app_name,
+		       b.profile_id, b.confidence, b.locked, COALESCE(b.is_manual, 0),
+		       b.deleted_at IS NOT NULL, `+userAssignedSQL+`
+		FROM block b
+		JOIN dict_app da ON b.primary_app_id = da.app_id
+		WHERE b.ts_start < ? AND b.ts_end > ?
+		ORDER BY b.ts_start ASC, b.block_id ASC
+	`, hiStr, loStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blocks: %w", err)
+	}
+	defer rows.Close()
+
+	var blocks []*existingBlock
+	for rows.Next() {
+		var b existingBlock
+		var profileID sql.NullInt64
+		var locked, manual, trashed bool
+		err := rows.Scan(
+			&b.BlockID, &b.TsStart, &b.TsEnd, &b.appID, &b.App,
+			&profileID, &b.Confidence, &locked, &manual,
+			&trashed, &b.userAssigned,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan block: %w", err)
+		}
+
+		b.tsStart, _ = time.Parse(time.RFC3339, b.TsStart)
+		b.tsEnd, _ = time.Parse(time.RFC3339, b.TsEnd)
+
+		if profileID.Valid {
+			pid := profileID.Int64
+			b.ProfileID = &pid
+		}
+
+		switch {
+		case locked:
+			b.Reason = RebuildKeptLocked
+		case manual:
+			b.Reason = RebuildKeptManual
+		case trashed:
+			b.Reason = RebuildKeptTrashed
+		case b.TsStart < loStr || b.TsEnd > hiStr:
+			b.Reason = RebuildKeptOutside
+		}
+
+		blocks = append(blocks, &b)
+	}
+
+	return blocks, rows.Err()
+}
+
+// cutAround trims blocks so none overlap [start, end), splitting any block
+// the range sits inside. Pieces under MinBlockDuration are dropped.
+func cutAround(blocks []*store.Block, start, end time.Time) []*store.Block {
+	var result []*store.Block
+	for _, b := range blocks {
+		if !b.TsStart.Before(end) || !start.Before(b.TsEnd) {
+			result = append(result, b)
+			continue
+		}
+
+		if b.TsStart.Before(start) {
+			left := *b
+			left.TsEnd = start
+			if left.TsEnd.Sub(left.TsStart) >= MinBlockDuration {
+				result = append(result, &left)
+			}
+		}
+		if end.Before(b.TsEnd) {
+			right := *b
+			right.TsStart = end
+			if right.TsEnd.Sub(right.TsStart) >= MinBlockDuration {
+				result = append(result, &right)
+			}
+		}
+	}
+	return result
+}
+
+// findIdentical returns the index of the existing block with the same span
+// and app as block, or -1
+func findIdentical(existing []*existingBlock, block *store.Block) int {
+	for i, b := range existing {
+		if b.appID == block.PrimaryAppID && b.tsStart.Equal(block.TsStart) && b.tsEnd.Equal(block.TsEnd) {
+			return i
+		}
+	}
+	return -1
+}
+
+// mostOverlapping returns the user-assigned block that overlaps block the
+// most, or nil if none does
+func mostOverlapping(existing []*existingBlock, block *store.Block) *existingBlock {
+	var best *existingBlock
+	var bestOverlap time.Duration
+	for _, b := range existing {
+		if !b.userAssigned || b.ProfileID == nil {
+			continue
+		}
+
+		if o := overlap(b, block); o > bestOverlap {
+			best, bestOverlap = b, o
+		}
+	}
+	return best
+}
+
+// heirsOf picks, for each block a profile was carried from, the new block
+// overlapping it most, earliest first on a tie. Each heir takes over the ID
+// of the block it replaces.
+func heirsOf(created []*store.Block, carriedFrom map[*store.Block]*existingBlock) map[*store.Block]int64 {
+	best := make(map[*existingBlock]*store.Block)
+	for _, block := range created {
+		src, ok := carriedFrom[block]
+		if !ok {
+			continue
+		}
+		if cur, ok := best[src]; !ok || overlap(src, block) > overlap(src, cur) {
+			best[src] = block
+		}
+	}
+
+	heirs := make(map[*store.Block]int64, len(best))
+	for src, block := range best {
+		heirs[block] = src.BlockID
+	}
+	return heirs
+}
+
+func overlap(b *existingBlock, block *store.Block) time.Duration {
+	return minTime(b.tsEnd, block.TsEnd).Sub(maxTime(b.tsStart, block.TsStart))
+}
+
+// describeCreatedBlock reads back a block inserted by the rebuild
+func describeCreatedBlock(tx *sql.Tx, block *store.Block) (RebuildBlock, error) {
+	rb := RebuildBlock{
+		BlockID: block.BlockID,
+		TsStart: block.TsStart.UTC().Format(time.RFC3339),
+		TsEnd:   block.TsEnd.UTC().Format(time.RFC3339),
+	}
+
+	var profileID sql.NullInt64
+	err := tx.QueryRow(`
+		SELECT da.app_name, b.profile_id, b.confidence
+		FROM block b
+		JOIN dict_app da ON b.primary_app_id = da.app_id
+		WHERE b.block_id = ?
+	`, block.BlockID).Scan(&rb.App, &profileID, &rb.Confidence)
+	if err != nil {
+		return rb, fmt.Errorf("failed to read rebuilt block: %w", err)
+	}
+
+	if profileID.Valid {
+		pid := profileID.Int64
+		rb.ProfileID = &pid
+	}
+	return rb, nil
+}
+
+// withMetadata returns block metadata with key set
+func withMetadata(metadata *string, key string, value interface{}) *string {
+	meta := map[string]interface{}{}
+	if metadata != nil {
+		json.Unmarshal([]byte(*metadata), &meta)
+	}
+	meta[key] = value
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return metadata
+	}
+	s := string(data)
+	return &s
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,268 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"chroniclecore/internal/store"
+)
+
+// browserProcesses are the OS tracker's browser apps (see the tracker's
+// browserPatterns); the extension reports the same time in more detail
+var browserProcesses = map[string]bool{
+	"chrome.exe":  true,
+	"msedge.exe":  true,
+	"firefox.exe": true,
+	"opera.exe":   true,
+	"brave.exe":   true,
+}
+
+// timeSpan is the half-open interval [start, end)
+type timeSpan struct {
+	start time.Time
+	end   time.Time
+}
+
+// sourceReconciler attributes browser time once when both the OS tracker
+// and the browser extension report it. Extension events carry the URL and
+// page description, so they win over OS browser events. Where the OS
+// tracker shows another app in front, or the user idle, the extension's
+// time is dropped: it measures time per page, not time in the browser.
+type sourceReconciler struct {
+	browsers   map[int64]bool // App IDs of OS browser processes
+	attributed []timeSpan     // Time already held by blocks
+}
+
+// reconcile returns the events with overlaps between sources removed,
+// ordered by start, and for each the index of the input event it came from.
+// Events may be trimmed, split or dropped; untouched ones are returned as
+// is. Without extension events nothing changes.
+func (r sourceReconciler) reconcile(events []*store.RawEvent) ([]*store.RawEvent, []int) {
+	hasExtension := false
+	var blocking []timeSpan
+	for _, event := range events {
+		if event.TsEnd == nil {
+			continue
+		}
+		if event.Source == "EXTENSION" {
+			hasExtension = true
+		} else if !r.replaceable(event) {
+			blocking = append(blocking, timeSpan{event.TsStart, *event.TsEnd})
+		}
+	}
+
+	if !hasExtension {
+		origin := make([]int, len(events))
+		for i := range origin {
+			origin[i] = i
+		}
+		return events, origin
+	}
+
+	blocking = append(blocking, r.attributed...)
+
+	// Extension time the OS tracker doesn't contradict, counted once even
+	// where the extension overlaps itself
+	kept := make(map[int][]timeSpan)
+	var covered []timeSpan
+	for i, event := range events {
+		if event.Source != "EXTENSION" || event.TsEnd == nil {
+			continue
+		}
+		spans := subtractAll([]timeSpan{{event.TsStart, *event.TsEnd}}, blocking)
+		spans = subtractAll(spans, covered)
+		kept[i] = spans
+		covered = append(covered, spans...)
+	}
+
+	// OS browser time goes to the extension where it has something to say
+	covered = append(covered, r.attributed...)
+
+	var out []*store.RawEvent
+	var origin []int
+	for i, event := range events {
+		spans, trimmed := kept[i]
+		if !trimmed && event.TsEnd != nil && r.replaceable(event) {
+			spans, trimmed = subtractAll([]timeSpan{{event.TsStart, *event.TsEnd}}, covered), true
+		}
+		if !trimmed {
+			out = append(out, event)
+			origin = append(origin, i)
+			continue
+		}
+
+		for _, span := range spans {
+			if span.start.Equal(event.TsStart) && span.end.Equal(*event.TsEnd) {
+				out = append(out, event)
+			} else {
+				out = append(out, trimEvent(event, span))
+			}
+			origin = append(origin, i)
+		}
+	}
+
+	order := make([]int, len(out))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return out[order[a]].TsStart.Before(out[order[b]].TsStart)
+	})
+
+	sorted := make([]*store.RawEvent, len(out))
+	sortedOrigin := make([]int, len(out))
+	for i, j := range order {
+		sorted[i] = out[j]
+		sortedOrigin[i] = origin[j]
+	}
+	return sorted, sortedOrigin
+}
+
+// rewindForExtension moves a cut in events back until no extension event
+// before it overlaps the event at the cut. The two sources are reconciled
+// against each other, so they are rolled up in the same run.
+func rewindForExtension(events []*store.RawEvent, cut int) int {
+	for cut < len(events) {
+		moved := false
+		for i := 0; i < cut; i++ {
+			event := events[i]
+			if event.Source == "EXTENSION" && event.TsEnd != nil && event.TsEnd.After(events[cut].TsStart) {
+				cut = i
+				moved = true
+				break
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+	return cut
+}
+
+// replaceable reports whether extension time may stand in for an OS event
+func (r sourceReconciler) replaceable(event *store.RawEvent) bool {
+	return event.Source == "OS" && event.State == "ACTIVE" && r.browsers[event.AppID]
+}
+
+// trimEvent copies an event onto a part of its time
+func trimEvent(event *store.RawEvent, span timeSpan) *store.RawEvent {
+	trimmed := *event
+	end := span.end
+	trimmed.TsStart = span.start
+	trimmed.TsEnd = &end
+	return &trimmed
+}
+
+// subtractSpans returns the parts of span not covered by any of cut
+func subtractSpans(span timeSpan, cut []timeSpan) []timeSpan {
+	var overlapping []timeSpan
+	for _, c := range cut {
+		if c.start.Before(span.end) && c.end.After(span.start) {
+			overlapping = append(overlapping, c)
+		}
+	}
+	sort.Slice(overlapping, func(i, j int) bool { return overlapping[i].start.Before(overlapping[j].start) })
+
+	var out []timeSpan
+	cursor := span.start
+	for _, c := range overlapping {
+		if c.start.After(cursor) {
+			out = append(out, timeSpan{cursor, c.start})
+		}
+		if c.end.After(cursor) {
+			cursor = c.end
+		}
+	}
+	if span.end.After(cursor) {
+		out = append(out, timeSpan{cursor, span.end})
+	}
+	return out
+}
+
+// subtractAll returns the parts of spans not covered by any of cut
+func subtractAll(spans []timeSpan, cut []timeSpan) []timeSpan {
+	var out []timeSpan
+	for _, span := range spans {
+		out = append(out, subtractSpans(span, cut)...)
+	}
+	return out
+}
+
+// sourceReconciler loads the browser apps and, for a rollup, the time
+// already held by blocks around the events. An extension event can arrive
+// after the OS events it overlaps were rolled up; it then only fills time
+// no block holds. Trashed blocks count too, so it doesn't bring back time
+// the user threw away.
+func (a *Aggregator) sourceReconciler(events []*store.RawEvent, withBlocks bool) (sourceReconciler, error) {
+	r := sourceReconciler{browsers: make(map[int64]bool)}
+	db := a.store.GetDB()
+
+	rows, err := db.Query("SELECT app_id, app_name FROM dict_app")
+	if err != nil {
+		return r, fmt.Errorf("failed to load apps: %w", err)
+	}
+	for rows.Next() {
+		var appID int64
+		var appName string
+		if err := rows.Scan(&appID, &appName); err != nil {
+			rows.Close()
+			return r, fmt.Errorf("failed to scan app: %w", err)
+		}
+		if browserProcesses[strings.ToLower(appName)] {
+			r.browsers[appID] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return r, fmt.Errorf("failed to read apps: %w", err)
+	}
+
+	if !withBlocks {
+		return r, nil
+	}
+
+	// Blocks only matter when there is extension time to reconcile
+	hasExtension := false
+	var lo, hi time.Time
+	for _, event := range events {
+		if event.TsEnd == nil {
+			continue
+		}
+		hasExtension = hasExtension || event.Source == "EXTENSION"
+		if lo.IsZero() || event.TsStart.Before(lo) {
+			lo = event.TsStart
+		}
+		if event.TsEnd.After(hi) {
+			hi = *event.TsEnd
+		}
+	}
+	if !hasExtension {
+		return r, nil
+	}
+
+	rows, err = db.Query(
+		"SELECT ts_start, ts_end FROM block WHERE ts_start < ? AND ts_end > ?",
+		hi.UTC().Format(time.RFC3339), lo.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return r, fmt.Errorf("failed to load blocks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var startStr, endStr string
+		if err := rows.Scan(&startStr, &endStr); err != nil {
+			return r, fmt.Errorf("failed to scan block: %w", err)
+		}
+		start, err1 := time.Parse(time.RFC3339, startStr)
+		end, err2 := time.Parse(time.RFC3339, endStr)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		r.attributed = append(r.attributed, timeSpan{start, end})
+	}
+
+	return r, rows.Err()
+}
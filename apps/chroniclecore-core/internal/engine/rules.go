@@ -2,6 +2,7 @@ package engine
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"regexp"
@@ -14,13 +15,14 @@ import (
 type RuleEngine struct {
 	store *store.Store
 	cache *ruleCache
+	stats map[int64]*ruleStat // Matches since the last flushStats
 }
 
 type ruleCache struct {
-	rules       []*Rule
-	appNameMap  map[string]int64 // app_name -> app_id
-	titleCache  map[int64]string // title_id -> title_text
-	domainCache map[int64]string // domain_id -> domain_text
+	rules      []*Rule
+	invalid    []InvalidRule    // Enabled rules skipped because they don't compile
+	appNameMap map[string]int64 // app_name -> app_id
+	domainMap  map[int64]string // domain_id -> domain_text
 }
 
 // Rule represents an assignment rule
@@ -35,6 +37,8 @@ type Rule struct {
 	ConfidenceBoost  int
 	Enabled          bool
 	compiledRegex    *regexp.Regexp // For TITLE_REGEX match type
+	domain           *domainPattern // For DOMAIN match type
+	condition        *condition     // For COMPOSITE match type
 }
 
 // NewRuleEngine creates a new rule engine
@@ -42,10 +46,10 @@ func NewRuleEngine(store *store.Store) *RuleEngine {
 	return &RuleEngine{
 		store: store,
 		cache: &ruleCache{
-			appNameMap:  make(map[string]int64),
-			titleCache:  make(map[int64]string),
-			domainCache: make(map[int64]string),
+			appNameMap: make(map[string]int64),
+			domainMap:  make(map[int64]string),
 		},
+		stats: make(map[int64]*ruleStat),
 	}
 }
 
@@ -66,6 +70,7 @@ func (re *RuleEngine) LoadRules() error {
 	defer rows.Close()
 
 	var rules []*Rule
+	invalid := []InvalidRule{}
 	for rows.Next() {
 		var r Rule
 		var targetServiceID sql.NullInt64
@@ -90,27 +95,55 @@ func (re *RuleEngine) LoadRules() error {
 			r.TargetServiceID = &sid
 		}
 
-		// Compile regex for TITLE_REGEX match type
-		if r.MatchType == "TITLE_REGEX" {
-			compiled, err := regexp.Compile(r.MatchValue)
-			if err != nil {
-				log.Printf("Warning: Invalid regex in rule %d (%s): %v", r.RuleID, r.Name, err)
-				continue // Skip invalid regex rules
-			}
-			r.compiledRegex = compiled
+		// Skip rules whose pattern doesn't compile
+		if err := r.compile(); err != nil {
+			log.Printf("Warning: Invalid %s in rule %d (%s): %v", r.MatchType, r.RuleID, r.Name, err)
+			invalid = append(invalid, InvalidRule{RuleID: r.RuleID, Name: r.Name, MatchType: r.MatchType, Error: err.Error()})
+			continue
 		}
 
 		rules = append(rules, &r)
 	}
 
 	re.cache.rules = rules
+	re.cache.invalid = invalid
 	log.Printf("Loaded %d active rules", len(rules))
 
 	return nil
 }
 
-// LoadDictionaries loads app/title/domain dictionaries into cache
+// compile prepares a rule's regex, domain pattern or condition for matching
+func (r *Rule) compile() error {
+	switch r.MatchType {
+	case "TITLE_REGEX":
+		compiled, err := regexp.Compile(r.MatchValue)
+		if err != nil {
+			return err
+		}
+		r.compiledRegex = compiled
+	case "DOMAIN":
+		pattern, err := parseDomainPattern(r.MatchValue)
+		if err != nil {
+			return err
+		}
+		r.domain = pattern
+	case "COMPOSITE":
+		compiled, err := compileCondition(r.MatchValue)
+		if err != nil {
+			return err
+		}
+		r.condition = compiled
+	}
+	return nil
+}
+
+// LoadDictionaries replaces the cached app and domain dictionaries, so
+// entries from a restored-over database don't linger. Titles are looked up
+// per block instead; dict_title grows with every window title ever seen.
 func (re *RuleEngine) LoadDictionaries() error {
+	appNameMap := make(map[string]int64)
+	domainMap := make(map[int64]string)
+
 	// Load app names
 	rows, err := re.store.GetDB().Query("SELECT app_id, app_name FROM dict_app")
 	if err != nil {
@@ -124,108 +157,178 @@ func (re *RuleEngine) LoadDictionaries() error {
 		if err := rows.Scan(&appID, &appName); err != nil {
 			return err
 		}
-		re.cache.appNameMap[appName] = appID
+		appNameMap[appName] = appID
 	}
+	rows.Close()
 
-	// Load titles (for regex matching)
-	rows, err = re.store.GetDB().Query("SELECT title_id, title_text FROM dict_title")
+	// Load domains
+	rows, err = re.store.GetDB().Query("SELECT domain_id, domain_text FROM dict_domain")
 	if err != nil {
-		return fmt.Errorf("failed to load title dictionary: %w", err)
+		return fmt.Errorf("failed to load domain dictionary: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var titleID int64
-		var titleText string
-		if err := rows.Scan(&titleID, &titleText); err != nil {
+		var domainID int64
+		var domainText string
+		if err := rows.Scan(&domainID, &domainText); err != nil {
 			return err
 		}
-		re.cache.titleCache[titleID] = titleText
+		domainMap[domainID] = domainText
 	}
 
-	log.Printf("Loaded dictionaries: %d apps, %d titles", len(re.cache.appNameMap), len(re.cache.titleCache))
+	re.cache.appNameMap = appNameMap
+	re.cache.domainMap = domainMap
+	log.Printf("Loaded dictionaries: %d apps, %d domains", len(re.cache.appNameMap), len(re.cache.domainMap))
 
 	return nil
 }
 
 // AssignProfile matches a block against rules and returns profile ID + confidence
 func (re *RuleEngine) AssignProfile(block *store.Block) (profileID *int64, confidence string) {
+	facts, err := re.factsFor(block)
+	if err != nil {
+		log.Printf("Failed to get app name for block %d: %v", block.BlockID, err)
+		return nil, "LOW"
+	}
+
+	// Match against rules (ordered by priority DESC). Every rule is tried
+	// so the statistics show which ones lose on priority.
+	winner := re.recordMatches(re.cache.rules, facts)
+	if winner != nil {
+		return winner.assignment()
+	}
+
+	// No match - return unassigned with LOW confidence
+	return nil, "LOW"
+}
+
+// blockFacts is what rules match a block on. Domains are resolved on first
+// use, once for all rules.
+type blockFacts struct {
+	app      string
+	title    string
+	start    time.Time // Local time, for weekday and time of day
+	duration time.Duration
+	activity float64
+
+	block         *store.Block
+	engine        *RuleEngine
+	hosts, urls   []string
+	domainsLoaded bool
+}
+
+// factsFor looks up a block's app name and dominant title
+func (re *RuleEngine) factsFor(block *store.Block) (*blockFacts, error) {
 	// Get app name from dictionary
 	var appName string
 	err := re.store.GetDB().QueryRow(
 		"SELECT app_name FROM dict_app WHERE app_id = ?",
 		block.PrimaryAppID,
 	).Scan(&appName)
-
 	if err != nil {
-		log.Printf("Failed to get app name for block %d: %v", block.BlockID, err)
-		return nil, "LOW"
+		return nil, err
 	}
 
 	// Get title text if present
 	var titleText string
 	if block.TitleSummaryID != nil {
-		titleText = re.cache.titleCache[*block.TitleSummaryID]
-		if titleText == "" {
-			// Not in cache, fetch from DB
-			re.store.GetDB().QueryRow(
-				"SELECT title_text FROM dict_title WHERE title_id = ?",
-				*block.TitleSummaryID,
-			).Scan(&titleText)
-		}
+		re.store.GetDB().QueryRow(
+			"SELECT title_text FROM dict_title WHERE title_id = ?",
+			*block.TitleSummaryID,
+		).Scan(&titleText)
 	}
 
-	// Match against rules (ordered by priority DESC)
-	for _, rule := range re.cache.rules {
-		matched := false
+	return re.newBlockFacts(block, appName, titleText), nil
+}
 
-		switch rule.MatchType {
-		case "APP":
-			// Exact app name match
-			matched = (appName == rule.MatchValue)
+func (re *RuleEngine) newBlockFacts(block *store.Block, appName, titleText string) *blockFacts {
+	activity := block.ActivityScore
+	if activity == 0 {
+		activity = 1.0 // Blocks from before activity scores were recorded
+	}
+	return &blockFacts{
+		app:      appName,
+		title:    titleText,
+		start:    block.TsStart.Local(),
+		duration: block.TsEnd.Sub(block.TsStart),
+		activity: activity,
+		block:    block,
+		engine:   re,
+	}
+}
 
-		case "TITLE_REGEX":
-			// Regex match on title
-			if rule.compiledRegex != nil && titleText != "" {
-				matched = rule.compiledRegex.MatchString(titleText)
-			}
+// domains returns the hosts and page URLs the block visited
+func (f *blockFacts) domains() ([]string, []string) {
+	if !f.domainsLoaded {
+		f.hosts, f.urls = f.engine.blockDomains(f.block)
+		f.domainsLoaded = true
+	}
+	return f.hosts, f.urls
+}
 
-		case "KEYWORD":
-			// Simple substring match (case-insensitive) on title
-			if titleText != "" {
-				// Simple implementation for MVP
-				matched = contains(titleText, rule.MatchValue)
-			}
+// matches reports whether a rule matches a block
+func (r *Rule) matches(f *blockFacts) bool {
+	switch r.MatchType {
+	case "APP":
+		// Exact app name match
+		return f.app == r.MatchValue
+
+	case "TITLE_REGEX":
+		// Regex match on title
+		return r.compiledRegex != nil && f.title != "" && r.compiledRegex.MatchString(f.title)
+
+	case "KEYWORD":
+		// Simple substring match (case-insensitive) on title
+		return f.title != "" && contains(f.title, r.MatchValue)
+
+	case "DOMAIN":
+		// Host match on the primary domain and any others the block visited
+		if r.domain == nil {
+			return false
+		}
+		hosts, urls := f.domains()
+		return r.domain.matches(hosts, urls)
 
-		case "DOMAIN":
-			// Domain match (requires browser extension - Phase 2)
-			// For MVP, skip domain rules
-			continue
+	case "COMPOSITE":
+		// Condition tree over the above plus time, duration and activity
+		return r.condition != nil && r.condition.eval(f)
+	}
+	return false
+}
 
-		case "COMPOSITE":
-			// Complex rules (future enhancement)
-			continue
+// firstMatch returns the rule that would assign a block, or nil
+func firstMatch(rules []*Rule, facts *blockFacts) *Rule {
+	for _, rule := range rules {
+		if rule.matches(facts) {
+			return rule
 		}
+	}
+	return nil
+}
 
-		if matched {
-			pid := rule.TargetProfileID
-			conf := "HIGH" // Automatic assignment with rule match
-
-			// Apply confidence boost if specified
-			if rule.ConfidenceBoost < 0 {
-				conf = "MEDIUM" // Lower confidence
-			}
+// assignment is the profile and confidence a rule match gives a block
+func (r *Rule) assignment() (*int64, string) {
+	pid := r.TargetProfileID
+	conf := "HIGH" // Automatic assignment with rule match
 
-			return &pid, conf
-		}
+	// Apply confidence boost if specified
+	if r.ConfidenceBoost < 0 {
+		conf = "MEDIUM" // Lower confidence
 	}
 
-	// No match - return unassigned with LOW confidence
-	return nil, "LOW"
+	return &pid, conf
 }
 
-// AssignBlocksInRange applies rules to all unassigned blocks in a time range
-func (re *RuleEngine) AssignBlocksInRange() error {
+// AssignBlocksTx applies rules to unassigned blocks inside the caller's
+// transaction, so blocks inserted earlier in it are picked up. With
+// blockIDs only those blocks are considered; nil means the most recent
+// unassigned blocks.
+func (re *RuleEngine) AssignBlocksTx(tx *sql.Tx, blockIDs []int64) error {
+	if blockIDs != nil && len(blockIDs) == 0 {
+		return nil
+	}
+
 	// Reload rules to catch any changes
 	if err := re.LoadRules(); err != nil {
 		return err
@@ -236,29 +339,112 @@ func (re *RuleEngine) AssignBlocksInRange() error {
 		return err
 	}
 
-	// Get all unassigned or LOW confidence blocks
+	// Get all unassigned or LOW confidence blocks, leaving profiles a
+	// rebuild carried over from the user alone
 	query := `
-		SELECT block_id, ts_start, ts_end, primary_app_id, primary_domain_id,
-		       title_summary_id, profile_id, confidence, billable, locked
+		SELECT ` + blockColumns + `
 		FROM block
 		WHERE (profile_id IS NULL OR confidence = 'LOW')
 		  AND locked = 0
-		ORDER BY ts_start DESC
-		LIMIT 1000
+		  AND deleted_at IS NULL
+		  AND COALESCE(CASE WHEN json_valid(metadata) THEN json_extract(metadata, '$.profile_source') END, '') != 'user'
 	`
+	var args []interface{}
+	if blockIDs != nil {
+		ids, err := json.Marshal(blockIDs)
+		if err != nil {
+			return fmt.Errorf("failed to encode block IDs: %w", err)
+		}
+		query += " AND block_id IN (SELECT value FROM json_each(?)) ORDER BY ts_start DESC"
+		args = append(args, string(ids))
+	} else {
+		query += " ORDER BY ts_start DESC LIMIT 1000"
+	}
 
-	rows, err := re.store.GetDB().Query(query)
+	rows, err := tx.Query(query, args...)
 	if err != nil {
 		return fmt.Errorf("failed to query blocks: %w", err)
 	}
 	defer rows.Close()
 
+	blocks, err := scanBlocks(rows)
+	if err != nil {
+		return err
+	}
+	rows.Close() // Free the transaction's connection before the updates
+
+	if len(blocks) == 0 {
+		log.Println("No blocks to assign")
+		return nil
+	}
+
+	log.Printf("Assigning profiles to %d blocks...", len(blocks))
+
+	// One audit entry covers the whole run
+	audit := store.NewBlockAudit(store.AuditActorSystem, store.AuditRuleAssign)
+	re.stats = make(map[int64]*ruleStat)
+
+	// Process each block
+	assigned := 0
+	for _, block := range blocks {
+		profileID, confidence := re.AssignProfile(block)
+
+		// Update block if assignment changed
+		if profileID != nil || confidence != block.Confidence {
+			if err := audit.Track(tx, block.BlockID); err != nil {
+				return err
+			}
+
+			_, err := tx.Exec(
+				"UPDATE block SET profile_id = ?, confidence = ? WHERE block_id = ?",
+				profileID,
+				confidence,
+				block.BlockID,
+			)
+			if err != nil {
+				log.Printf("Failed to update block %d: %v", block.BlockID, err)
+				continue
+			}
+
+			if profileID != nil {
+				assigned++
+			}
+		}
+	}
+
+	if _, err := audit.Commit(tx, map[string]interface{}{"assigned": assigned}); err != nil {
+		return err
+	}
+
+	if err := re.flushStats(tx); err != nil {
+		return err
+	}
+
+	log.Printf("Assigned %d blocks to profiles", assigned)
+	return nil
+}
+
+// Helper function for case-insensitive substring matching
+func contains(text, substr string) bool {
+	// Simple case-insensitive contains for MVP
+	// Could be improved with proper Unicode normalization
+	return regexp.MustCompile(`(?i)` + regexp.QuoteMeta(substr)).MatchString(text)
+}
+
+// blockColumns are the block columns scanBlocks reads
+const blockColumns = `block_id, ts_start, ts_end, primary_app_id, primary_domain_id,
+		       title_summary_id, profile_id, confidence, billable, locked, metadata,
+		       COALESCE(activity_score, 1.0)`
+
+// scanBlocks reads blocks selected with blockColumns
+func scanBlocks(rows *sql.Rows) ([]*store.Block, error) {
 	var blocks []*store.Block
 	for rows.Next() {
 		var b store.Block
 		var profileID sql.NullInt64
 		var domainID, titleID sql.NullInt64
 		var tsStartStr, tsEndStr string
+		var metadata sql.NullString
 
 		err := rows.Scan(
 			&b.BlockID,
@@ -271,9 +457,11 @@ func (re *RuleEngine) AssignBlocksInRange() error {
 			&b.Confidence,
 			&b.Billable,
 			&b.Locked,
+			&metadata,
+			&b.ActivityScore,
 		)
 		if err != nil {
-			return fmt.Errorf("failed to scan block: %w", err)
+			return nil, fmt.Errorf("failed to scan block: %w", err)
 		}
 
 		// Parse timestamps from SQLite string format
@@ -292,48 +480,14 @@ func (re *RuleEngine) AssignBlocksInRange() error {
 			pid := profileID.Int64
 			b.ProfileID = &pid
 		}
+		if metadata.Valid {
+			b.Metadata = &metadata.String
+		}
 
 		blocks = append(blocks, &b)
 	}
-
-	if len(blocks) == 0 {
-		log.Println("No blocks to assign")
-		return nil
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read blocks: %w", err)
 	}
-
-	log.Printf("Assigning profiles to %d blocks...", len(blocks))
-
-	// Process each block
-	assigned := 0
-	for _, block := range blocks {
-		profileID, confidence := re.AssignProfile(block)
-
-		// Update block if assignment changed
-		if profileID != nil || confidence != block.Confidence {
-			_, err := re.store.GetDB().Exec(
-				"UPDATE block SET profile_id = ?, confidence = ? WHERE block_id = ?",
-				profileID,
-				confidence,
-				block.BlockID,
-			)
-			if err != nil {
-				log.Printf("Failed to update block %d: %v", block.BlockID, err)
-				continue
-			}
-
-			if profileID != nil {
-				assigned++
-			}
-		}
-	}
-
-	log.Printf("Assigned %d blocks to profiles", assigned)
-	return nil
-}
-
-// Helper function for case-insensitive substring matching
-func contains(text, substr string) bool {
-	// Simple case-insensitive contains for MVP
-	// Could be improved with proper Unicode normalization
-	return regexp.MustCompile(`(?i)` + regexp.QuoteMeta(substr)).MatchString(text)
+	return blocks, nil
 }
@@ -0,0 +1,273 @@
+package engine
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// maxConflictSamples caps the block IDs listed per conflicting rule pair
+const maxConflictSamples = 5
+
+// ruleStat counts one rule's matches until they are flushed to rule_stat
+type ruleStat struct {
+	matches     int
+	wins        int
+	lastMatched time.Time
+}
+
+// recordMatches tries every rule against a block, counts the matches, and
+// returns the highest-priority one, or nil
+func (re *RuleEngine) recordMatches(rules []*Rule, facts *blockFacts) *Rule {
+	var winner *Rule
+	now := time.Now().UTC()
+	for _, rule := range rules {
+		if !rule.matches(facts) {
+			continue
+		}
+
+		stat := re.stats[rule.RuleID]
+		if stat == nil {
+			stat = &ruleStat{}
+			re.stats[rule.RuleID] = stat
+		}
+		stat.matches++
+		stat.lastMatched = now
+
+		if winner == nil {
+			winner = rule
+			stat.wins++
+		}
+	}
+	return winner
+}
+
+// flushStats adds the counted matches to rule_stat in the caller's
+// transaction, so a rolled back run leaves no trace
+func (re *RuleEngine) flushStats(tx *sql.Tx) error {
+	for ruleID, stat := range re.stats {
+		_, err := tx.Exec(`
+			INSERT INTO rule_stat (rule_id, match_count, win_count, last_matched_at)
+			SELECT rule_id, ?, ?, ? FROM rule WHERE rule_id = ?
+			ON CONFLICT (rule_id) DO UPDATE SET
+				match_count = match_count + excluded.match_count,
+				win_count = win_count + excluded.win_count,
+				last_matched_at = excluded.last_matched_at
+		`, stat.matches, stat.wins, stat.lastMatched.Format(time.RFC3339), ruleID)
+		if err != nil {
+			return fmt.Errorf("failed to record rule statistics: %w", err)
+		}
+	}
+	re.stats = make(map[int64]*ruleStat)
+	return nil
+}
+
+// InvalidRule is an enabled rule LoadRules skips because its pattern
+// doesn't compile
+type InvalidRule struct {
+	RuleID    int64  `json:"rule_id"`
+	Name      string `json:"name"`
+	MatchType string `json:"match_type"`
+	Error     string `json:"error"`
+}
+
+// RuleStat is one rule's recorded and analysed matches
+type RuleStat struct {
+	RuleID          int64   `json:"rule_id"`
+	Name            string  `json:"name"`
+	Priority        int     `json:"priority"`
+	TargetProfileID int64   `json:"target_profile_id"`
+	Matches         int     `json:"matches"` // Recorded during assignment since Since
+	Wins            int     `json:"wins"`
+	LastMatchedAt   *string `json:"last_matched_at"`
+	Since           *string `json:"since"`
+	WindowMatches   int     `json:"window_matches"` // Blocks in the analysed range
+	WindowWins      int     `json:"window_wins"`
+	ShadowedBy      []int64 `json:"shadowed_by,omitempty"` // Rules that won blocks it matched in the range
+}
+
+// RuleConflict is a pair of rules matching the same blocks with different
+// target profiles. RuleID is the one that wins on priority.
+type RuleConflict struct {
+	RuleID         int64   `json:"rule_id"`
+	OtherRuleID    int64   `json:"other_rule_id"`
+	ProfileID      int64   `json:"profile_id"`
+	OtherProfileID int64   `json:"other_profile_id"`
+	Blocks         int     `json:"blocks"`
+	SampleBlockIDs []int64 `json:"sample_block_ids"`
+}
+
+// RuleAnalysis reports dead, shadowed, conflicting and invalid rules
+type RuleAnalysis struct {
+	Start     string         `json:"start"`
+	End       string         `json:"end"`
+	Blocks    int            `json:"blocks"` // Live blocks analysed
+	Rules     []RuleStat     `json:"rules"`
+	Unused    []RuleStat     `json:"unused"`          // Never matched, recorded or in the range
+	Shadowed  []RuleStat     `json:"always_shadowed"` // Matched, but always lost to a higher-priority rule
+	Conflicts []RuleConflict `json:"conflicts"`
+	Invalid   []InvalidRule  `json:"invalid"`
+}
+
+// AnalyzeRules combines the recorded statistics of the enabled rules with
+// a fresh match of every rule against the blocks starting in [start, end).
+// Nothing is written.
+func (re *RuleEngine) AnalyzeRules(start, end time.Time) (*RuleAnalysis, error) {
+	if !start.Before(end) {
+		return nil, fmt.Errorf("invalid range: start must be before end")
+	}
+
+	if err := re.LoadRules(); err != nil {
+		return nil, err
+	}
+	if err := re.LoadDictionaries(); err != nil {
+		return nil, err
+	}
+	rules := re.cache.rules
+
+	analysis := &RuleAnalysis{
+		Start:     start.UTC().Format(time.RFC3339),
+		End:       end.UTC().Format(time.RFC3339),
+		Rules:     []RuleStat{},
+		Unused:    []RuleStat{},
+		Shadowed:  []RuleStat{},
+		Conflicts: []RuleConflict{},
+		Invalid:   re.cache.invalid,
+	}
+
+	stats := make(map[int64]*RuleStat, len(rules))
+	for _, rule := range rules {
+		stats[rule.RuleID] = &RuleStat{
+			RuleID:          rule.RuleID,
+			Name:            rule.Name,
+			Priority:        rule.Priority,
+			TargetProfileID: rule.TargetProfileID,
+		}
+	}
+
+	// Recorded counters
+	rows, err := re.store.GetDB().Query("SELECT rule_id, match_count, win_count, last_matched_at, since FROM rule_stat")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rule statistics: %w", err)
+	}
+	for rows.Next() {
+		var ruleID int64
+		var matches, wins int
+		var lastMatched sql.NullString
+		var since string
+		if err := rows.Scan(&ruleID, &matches, &wins, &lastMatched, &since); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan rule statistics: %w", err)
+		}
+		if stat := stats[ruleID]; stat != nil {
+			stat.Matches, stat.Wins, stat.Since = matches, wins, &since
+			if lastMatched.Valid {
+				stat.LastMatchedAt = &lastMatched.String
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rule statistics: %w", err)
+	}
+
+	// Match every rule against the range
+	rows, err = re.store.GetDB().Query(`
+		SELECT `+blockColumns+`
+		FROM block
+		WHERE deleted_at IS NULL AND ts_start >= ? AND ts_start < ?
+		ORDER BY ts_start ASC, block_id ASC
+	`, analysis.Start, analysis.End)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blocks: %w", err)
+	}
+	blocks, err := scanBlocks(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	analysis.Blocks = len(blocks)
+
+	shadowedBy := make(map[int64]map[int64]bool)
+	conflicts := make(map[[2]int64]*RuleConflict)
+	var conflictOrder [][2]int64
+
+	for _, block := range blocks {
+		facts, err := re.factsFor(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load block %d: %w", block.BlockID, err)
+		}
+
+		var matched []*Rule
+		for _, rule := range rules {
+			if rule.matches(facts) {
+				matched = append(matched, rule)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		winner := matched[0]
+		stats[winner.RuleID].WindowWins++
+		for i, rule := range matched {
+			stats[rule.RuleID].WindowMatches++
+			if i > 0 {
+				if shadowedBy[rule.RuleID] == nil {
+					shadowedBy[rule.RuleID] = make(map[int64]bool)
+				}
+				shadowedBy[rule.RuleID][winner.RuleID] = true
+			}
+
+			// Pairs with different profiles, higher priority first
+			for _, other := range matched[i+1:] {
+				if other.TargetProfileID == rule.TargetProfileID {
+					continue
+				}
+				key := [2]int64{rule.RuleID, other.RuleID}
+				c := conflicts[key]
+				if c == nil {
+					c = &RuleConflict{
+						RuleID:         rule.RuleID,
+						OtherRuleID:    other.RuleID,
+						ProfileID:      rule.TargetProfileID,
+						OtherProfileID: other.TargetProfileID,
+						SampleBlockIDs: []int64{},
+					}
+					conflicts[key] = c
+					conflictOrder = append(conflictOrder, key)
+				}
+				c.Blocks++
+				if len(c.SampleBlockIDs) < maxConflictSamples {
+					c.SampleBlockIDs = append(c.SampleBlockIDs, block.BlockID)
+				}
+			}
+		}
+	}
+
+	for _, rule := range rules {
+		stat := stats[rule.RuleID]
+		for id := range shadowedBy[rule.RuleID] {
+			stat.ShadowedBy = append(stat.ShadowedBy, id)
+		}
+		sort.Slice(stat.ShadowedBy, func(i, j int) bool { return stat.ShadowedBy[i] < stat.ShadowedBy[j] })
+
+		analysis.Rules = append(analysis.Rules, *stat)
+		switch {
+		case stat.Matches == 0 && stat.WindowMatches == 0:
+			analysis.Unused = append(analysis.Unused, *stat)
+		case stat.Wins == 0 && stat.WindowWins == 0:
+			analysis.Shadowed = append(analysis.Shadowed, *stat)
+		}
+	}
+
+	for _, key := range conflictOrder {
+		analysis.Conflicts = append(analysis.Conflicts, *conflicts[key])
+	}
+	sort.SliceStable(analysis.Conflicts, func(i, j int) bool {
+		return analysis.Conflicts[i].Blocks > analysis.Conflicts[j].Blocks
+	})
+
+	return analysis, nil
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"chroniclecore/internal/store"
+)
+
+// SettingAggregationStrategy names the strategy used to group events
+const SettingAggregationStrategy = "aggregation_strategy"
+
+// Aggregation strategy names
+const (
+	StrategyApp      = "app"      // Same application (default)
+	StrategyDocument = "document" // Same document or file (deep tracking)
+	StrategyProject  = "project"  // Same IDE project (deep tracking)
+	StrategyDomain   = "domain"   // Same website domain
+)
+
+// AggregationStrategy decides which events belong to the same block.
+// Sequential events with equal keys are grouped; gaps, interruptions and
+// noise filtering work the same under every strategy.
+type AggregationStrategy interface {
+	Name() string
+	Key(event *store.RawEvent) string
+}
+
+// AppStrategy groups events by application
+type AppStrategy struct{}
+
+func (AppStrategy) Name() string { return StrategyApp }
+
+func (AppStrategy) Key(event *store.RawEvent) string {
+	return appKey(event)
+}
+
+// DocumentStrategy groups events by the document or file being edited.
+// Events without one are grouped by application.
+type DocumentStrategy struct{}
+
+func (DocumentStrategy) Name() string { return StrategyDocument }
+
+func (DocumentStrategy) Key(event *store.RawEvent) string {
+	if name := metadataString(event, "document_name", "file_name"); name != "" {
+		return "document:" + name
+	}
+	return appKey(event)
+}
+
+// ProjectStrategy groups events by IDE project, so switching between files
+// of one project stays in one block. Events without one are grouped by
+// application.
+type ProjectStrategy struct{}
+
+func (ProjectStrategy) Name() string { return StrategyProject }
+
+func (ProjectStrategy) Key(event *store.RawEvent) string {
+	if name := metadataString(event, "project_name"); name != "" {
+		return "project:" + name
+	}
+	return appKey(event)
+}
+
+// DomainStrategy groups events by website domain, across browsers. Events
+// without a domain are grouped by application.
+type DomainStrategy struct{}
+
+func (DomainStrategy) Name() string { return StrategyDomain }
+
+func (DomainStrategy) Key(event *store.RawEvent) string {
+	if event.DomainID != nil {
+		return "domain:" + strconv.FormatInt(*event.DomainID, 10)
+	}
+	return appKey(event)
+}
+
+var aggregationStrategies = map[string]AggregationStrategy{
+	StrategyApp:      AppStrategy{},
+	StrategyDocument: DocumentStrategy{},
+	StrategyProject:  ProjectStrategy{},
+	StrategyDomain:   DomainStrategy{},
+}
+
+// StrategyByName returns the named strategy, or false if there is none
+func StrategyByName(name string) (AggregationStrategy, bool) {
+	strategy, ok := aggregationStrategies[name]
+	return strategy, ok
+}
+
+// strategy returns the configured aggregation strategy, AppStrategy if unset
+func (a *Aggregator) strategy() AggregationStrategy {
+	name, _ := a.store.GetSetting(SettingAggregationStrategy)
+	if strategy, ok := StrategyByName(name); ok {
+		return strategy
+	}
+	return AppStrategy{}
+}
+
+func appKey(event *store.RawEvent) string {
+	return "app:" + strconv.FormatInt(event.AppID, 10)
+}
+
+// metadataString returns the first non-empty string among keys in the
+// event's deep-tracking metadata
+func metadataString(event *store.RawEvent, keys ...string) string {
+	if event.Metadata == nil {
+		return ""
+	}
+
+	var meta map[string]interface{}
+	if err := json.Unmarshal([]byte(*event.Metadata), &meta); err != nil {
+		return ""
+	}
+
+	for _, key := range keys {
+		if value, ok := meta[key].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -1,8 +1,10 @@
 package engine
 
 import (
+	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"chroniclecore/internal/store"
 )
@@ -76,31 +78,52 @@ func (te *TemplateEngine) GenerateDescription(block *store.Block) string {
 
 // GenerateDescriptionsForBlocks applies descriptions to blocks without them
 func (te *TemplateEngine) GenerateDescriptionsForBlocks() error {
+	tx, err := te.store.GetDB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := te.GenerateDescriptionsTx(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit descriptions: %w", err)
+	}
+	return nil
+}
+
+// GenerateDescriptionsTx applies descriptions inside the caller's
+// transaction, so blocks inserted earlier in it are picked up
+func (te *TemplateEngine) GenerateDescriptionsTx(tx *sql.Tx) error {
 	// Get blocks without descriptions
 	query := `
 		SELECT block_id, ts_start, ts_end, primary_app_id, primary_domain_id,
 		       title_summary_id, profile_id, confidence, billable, locked, description
 		FROM block
-		WHERE description IS NULL OR description = ''
+		WHERE (description IS NULL OR description = '')
+		  AND deleted_at IS NULL
 		ORDER BY ts_start DESC
 		LIMIT 1000
 	`
 
-	rows, err := te.store.GetDB().Query(query)
+	rows, err := tx.Query(query)
 	if err != nil {
 		return fmt.Errorf("failed to query blocks: %w", err)
 	}
 	defer rows.Close()
 
-	count := 0
+	var blocks []*store.Block
 	for rows.Next() {
 		var block store.Block
 		var titleID, domainID, profileID *int64
+		var tsStartStr, tsEndStr string
 
 		err := rows.Scan(
 			&block.BlockID,
-			&block.TsStart,
-			&block.TsEnd,
+			&tsStartStr,
+			&tsEndStr,
 			&block.PrimaryAppID,
 			&domainID,
 			&titleID,
@@ -114,6 +137,10 @@ func (te *TemplateEngine) GenerateDescriptionsForBlocks() error {
 			continue
 		}
 
+		// Timestamps are stored as RFC3339 text
+		block.TsStart, _ = time.Parse(time.RFC3339, tsStartStr)
+		block.TsEnd, _ = time.Parse(time.RFC3339, tsEndStr)
+
 		if titleID != nil {
 			block.TitleSummaryID = titleID
 		}
@@ -124,11 +151,20 @@ func (te *TemplateEngine) GenerateDescriptionsForBlocks() error {
 			block.ProfileID = profileID
 		}
 
+		blocks = append(blocks, &block)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read blocks: %w", err)
+	}
+	rows.Close() // Free the transaction's connection before the updates
+
+	count := 0
+	for _, block := range blocks {
 		// Generate description
-		desc := te.GenerateDescription(&block)
+		desc := te.GenerateDescription(block)
 
 		// Update block
-		_, err = te.store.GetDB().Exec(
+		_, err = tx.Exec(
 			"UPDATE block SET description = ? WHERE block_id = ?",
 			desc,
 			block.BlockID,
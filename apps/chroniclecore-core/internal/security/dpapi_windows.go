@@ -0,0 +1,55 @@
+//go:build windows
+// +build windows
+
+package security
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// DPAPIProvider encrypts with CryptProtectData under the current user
+type DPAPIProvider struct{}
+
+func NewDPAPIProvider() *DPAPIProvider {
+	return &DPAPIProvider{}
+}
+
+func (p *DPAPIProvider) Name() string {
+	return "dpapi"
+}
+
+func (p *DPAPIProvider) Encrypt(plaintext, context []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptProtectData(newDataBlob(plaintext), nil, newDataBlob(context), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, fmt.Errorf("CryptProtectData failed: %w", err)
+	}
+	return takeDataBlob(&out), nil
+}
+
+func (p *DPAPIProvider) Decrypt(ciphertext, context []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptUnprotectData(newDataBlob(ciphertext), nil, newDataBlob(context), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, fmt.Errorf("CryptUnprotectData failed: %w", err)
+	}
+	return takeDataBlob(&out), nil
+}
+
+func newDataBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// takeDataBlob copies a DPAPI output buffer into Go memory and frees it
+func takeDataBlob(blob *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+	out := make([]byte, blob.Size)
+	copy(out, unsafe.Slice(blob.Data, blob.Size))
+	return out
+}
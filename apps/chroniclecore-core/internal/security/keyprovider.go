@@ -0,0 +1,19 @@
+// Package security protects secrets stored in the local database
+package security
+
+// KeyProvider encrypts and decrypts small secrets. The context bytes are
+// bound to the ciphertext (AES-GCM additional data, DPAPI entropy), so a
+// value copied onto another setting key fails to decrypt.
+type KeyProvider interface {
+	Name() string
+	Encrypt(plaintext, context []byte) ([]byte, error)
+	Decrypt(ciphertext, context []byte) ([]byte, error)
+}
+
+// KeyRotator is implemented by providers that manage their own keys.
+// RotateKey makes a new key current while older keys can still decrypt;
+// RetireOldKeys drops them once everything has been re-encrypted.
+type KeyRotator interface {
+	RotateKey() error
+	RetireOldKeys() error
+}
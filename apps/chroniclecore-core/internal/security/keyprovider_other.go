@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package security
+
+import "path/filepath"
+
+// NewDefaultKeyProvider returns a file keyring in dataDir. Only meant for
+// development and tests; the key file is as sensitive as the secrets.
+func NewDefaultKeyProvider(dataDir string) (KeyProvider, error) {
+	return NewFileKeyring(filepath.Join(dataDir, "keyring.json"))
+}
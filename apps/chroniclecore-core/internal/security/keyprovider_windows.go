@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package security
+
+// NewDefaultKeyProvider returns DPAPI, which ties secrets to the current
+// Windows user. dataDir is unused.
+func NewDefaultKeyProvider(dataDir string) (KeyProvider, error) {
+	return NewDPAPIProvider(), nil
+}
@@ -0,0 +1,216 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileKeyring is an AES-256-GCM keyring kept in a JSON file. Ciphertext is
+// key ID (4 bytes, big endian) || nonce || sealed data, so values written
+// under an old key still decrypt after a rotation.
+type FileKeyring struct {
+	path    string
+	mu      sync.Mutex
+	current uint32
+	keys    map[uint32][]byte
+	created map[uint32]string
+}
+
+type keyringFile struct {
+	Current uint32       `json:"current"`
+	Keys    []keyringKey `json:"keys"`
+}
+
+type keyringKey struct {
+	ID        uint32 `json:"id"`
+	Key       []byte `json:"key"` // base64 in JSON
+	CreatedAt string `json:"created_at"`
+}
+
+// NewFileKeyring loads the keyring at path, creating it with a fresh key if
+// it does not exist
+func NewFileKeyring(path string) (*FileKeyring, error) {
+	k := &FileKeyring{
+		path:    path,
+		keys:    map[uint32][]byte{},
+		created: map[uint32]string{},
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := k.addKey(1); err != nil {
+			return nil, err
+		}
+		return k, k.save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring: %w", err)
+	}
+	for _, key := range f.Keys {
+		if len(key.Key) != 32 {
+			return nil, fmt.Errorf("keyring key %d has invalid length", key.ID)
+		}
+		k.keys[key.ID] = key.Key
+		k.created[key.ID] = key.CreatedAt
+	}
+	if _, ok := k.keys[f.Current]; !ok {
+		return nil, fmt.Errorf("keyring current key %d is missing", f.Current)
+	}
+	k.current = f.Current
+
+	return k, nil
+}
+
+func (k *FileKeyring) Name() string {
+	return "keyring"
+}
+
+func (k *FileKeyring) Encrypt(plaintext, context []byte) ([]byte, error) {
+	k.mu.Lock()
+	id, key := k.current, k.keys[k.current]
+	k.mu.Unlock()
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 4+gcm.NonceSize(), 4+gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	binary.BigEndian.PutUint32(out, id)
+	if _, err := rand.Read(out[4:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return gcm.Seal(out, out[4:], plaintext, context), nil
+}
+
+func (k *FileKeyring) Decrypt(ciphertext, context []byte) ([]byte, error) {
+	if len(ciphertext) < 4 {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	id := binary.BigEndian.Uint32(ciphertext)
+	k.mu.Lock()
+	key, ok := k.keys[id]
+	k.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key %d", id)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	body := ciphertext[4:]
+	if len(body) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	plaintext, err := gcm.Open(nil, body[:gcm.NonceSize()], body[gcm.NonceSize():], context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// RotateKey adds a new key and makes it current
+func (k *FileKeyring) RotateKey() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	next := k.current
+	for id := range k.keys {
+		if id > next {
+			next = id
+		}
+	}
+	prev := k.current
+	if err := k.addKey(next + 1); err != nil {
+		return err
+	}
+	if err := k.save(); err != nil {
+		// Never encrypt with a key that isn't on disk
+		delete(k.keys, k.current)
+		delete(k.created, k.current)
+		k.current = prev
+		return err
+	}
+	return nil
+}
+
+// RetireOldKeys drops every key except the current one
+func (k *FileKeyring) RetireOldKeys() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for id := range k.keys {
+		if id != k.current {
+			delete(k.keys, id)
+			delete(k.created, id)
+		}
+	}
+	return k.save()
+}
+
+// addKey generates a key and makes it current. Caller holds mu or owns k.
+func (k *FileKeyring) addKey(id uint32) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+	k.keys[id] = key
+	k.created[id] = time.Now().UTC().Format(time.RFC3339)
+	k.current = id
+	return nil
+}
+
+// save writes the keyring owner-readable only, via a temp file and rename
+func (k *FileKeyring) save() error {
+	f := keyringFile{Current: k.current}
+	for id, key := range k.keys {
+		f.Keys = append(f.Keys, keyringKey{ID: id, Key: key, CreatedAt: k.created[id]})
+	}
+	sort.Slice(f.Keys, func(i, j int) bool { return f.Keys[i].ID < f.Keys[j].ID })
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode keyring: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(k.path), 0700); err != nil {
+		return fmt.Errorf("failed to create keyring folder: %w", err)
+	}
+
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace keyring: %w", err)
+	}
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
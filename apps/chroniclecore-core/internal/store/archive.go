@@ -0,0 +1,556 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Archive setting keys
+const (
+	SettingArchiveDir               = "archive_dir"                 // Archive folder (default: <db dir>/archive)
+	SettingArchiveRehydrateHoldDays = "archive_rehydrate_hold_days" // Days rehydrated events stay live
+)
+
+// DefaultArchiveRehydrateHoldDays applies when the setting is absent
+const DefaultArchiveRehydrateHoldDays = 7
+
+// raw_events_2026-01.jsonl.gz
+var archiveNamePattern = regexp.MustCompile(`^raw_events_(\d{4}-\d{2})\.jsonl\.gz$`)
+
+// ArchivedRawEvent is one line of an archive file. Dictionary IDs are
+// resolved to text so the archive stands on its own.
+type ArchivedRawEvent struct {
+	EventID       int64   `json:"event_id"`
+	TsStart       string  `json:"ts_start"`
+	TsEnd         *string `json:"ts_end,omitempty"`
+	AppName       string  `json:"app_name"`
+	TitleText     *string `json:"title_text,omitempty"`
+	DomainText    *string `json:"domain_text,omitempty"`
+	State         string  `json:"state"`
+	Source        string  `json:"source"`
+	Metadata      *string `json:"metadata,omitempty"`
+	HashSignature *string `json:"hash_signature,omitempty"`
+	CreatedAt     string  `json:"created_at"`
+}
+
+// RawEventArchive describes one monthly archive file
+type RawEventArchive struct {
+	Name       string    `json:"name"`
+	Month      string    `json:"month"` // YYYY-MM
+	SizeBytes  int64     `json:"size_bytes"`
+	ModifiedAt time.Time `json:"modified_at"`
+	EventCount int       `json:"event_count"`
+	FirstEvent string    `json:"first_event,omitempty"`
+	LastEvent  string    `json:"last_event,omitempty"`
+}
+
+// RehydrateReport summarizes a rehydrate run
+type RehydrateReport struct {
+	Files      []string `json:"files"`
+	Read       int      `json:"read"`       // Archived events within the range
+	Inserted   int      `json:"inserted"`   // Copied back into raw_event
+	Duplicates int      `json:"duplicates"` // Already live, or repeated in the archive
+}
+
+// ArchiveDir returns the configured archive folder
+func (s *Store) ArchiveDir() string {
+	if dir, err := s.GetSetting(SettingArchiveDir); err == nil && dir != "" {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(s.dbPath), "archive")
+}
+
+// ArchiveRawEventsBefore moves raw events older than before into the monthly
+// archive files, then deletes them. Rehydrated events are already archived,
+// so they are only deleted once their hold period has passed. Returns the
+// number of rows removed from raw_event.
+func (s *Store) ArchiveRawEventsBefore(before time.Time) (int64, error) {
+	dir := s.ArchiveDir()
+	holdDays := s.getSettingInt(SettingArchiveRehydrateHoldDays, DefaultArchiveRehydrateHoldDays)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return 0, fmt.Errorf("store not initialized")
+	}
+
+	s.archiveMu.Lock()
+	defer s.archiveMu.Unlock()
+
+	cutoff := before.UTC().Format(time.RFC3339)
+	holdCutoff := time.Now().UTC().AddDate(0, 0, -holdDays).Format(time.RFC3339)
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT
+			re.event_id, re.ts_start, re.ts_end, da.app_name, dt.title_text, dd.domain_text,
+			re.state, re.source, re.metadata, re.hash_signature, re.created_at
+		FROM raw_event re
+		JOIN dict_app da ON re.app_id = da.app_id
+		LEFT JOIN dict_title dt ON re.title_id = dt.title_id
+		LEFT JOIN dict_domain dd ON re.domain_id = dd.domain_id
+		WHERE re.ts_start < ? AND re.rehydrated_at IS NULL
+		ORDER BY re.ts_start ASC, re.event_id ASC
+	`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query expiring raw events: %w", err)
+	}
+
+	// Compress each month in memory so a failure never leaves half a
+	// gzip member on disk
+	months := map[string]*archiveBuffer{}
+	for rows.Next() {
+		var ev ArchivedRawEvent
+		var tsEnd, title, domain, metadata, hashSig sql.NullString
+		if err := rows.Scan(
+			&ev.EventID, &ev.TsStart, &tsEnd, &ev.AppName, &title, &domain,
+			&ev.State, &ev.Source, &metadata, &hashSig, &ev.CreatedAt,
+		); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan raw_event: %w", err)
+		}
+		ev.TsEnd = nullStringPtr(tsEnd)
+		ev.TitleText = nullStringPtr(title)
+		ev.DomainText = nullStringPtr(domain)
+		ev.Metadata = nullStringPtr(metadata)
+		ev.HashSignature = nullStringPtr(hashSig)
+
+		month := archiveMonth(ev.TsStart)
+		buf, ok := months[month]
+		if !ok {
+			buf = newArchiveBuffer()
+			months[month] = buf
+		}
+		if err := buf.enc.Encode(&ev); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to encode raw_event %d: %w", ev.EventID, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, fmt.Errorf("failed to read raw events: %w", err)
+	}
+	rows.Close()
+
+	if len(months) > 0 {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return 0, fmt.Errorf("failed to create archive folder: %w", err)
+		}
+	}
+
+	// Files are written before the delete commits. A crash in between can
+	// only duplicate events in the archive, which rehydrate skips.
+	for month, buf := range months {
+		if err := buf.appendTo(filepath.Join(dir, archiveFileName(month))); err != nil {
+			return 0, fmt.Errorf("failed to write archive for %s: %w", month, err)
+		}
+	}
+
+	res, err := tx.Exec(`
+		DELETE FROM raw_event
+		WHERE ts_start < ? AND (rehydrated_at IS NULL OR rehydrated_at < ?)
+	`, cutoff, holdCutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete archived raw events: %w", err)
+	}
+	deleted, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit archive: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// ListRawEventArchives returns the archive files, oldest month first
+func (s *Store) ListRawEventArchives() ([]RawEventArchive, error) {
+	dir := s.ArchiveDir()
+
+	s.archiveMu.Lock()
+	defer s.archiveMu.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []RawEventArchive{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive folder: %w", err)
+	}
+
+	archives := []RawEventArchive{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		m := archiveNamePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+
+		archive := RawEventArchive{Name: entry.Name(), Month: m[1]}
+		if info, err := entry.Info(); err == nil {
+			archive.SizeBytes = info.Size()
+			archive.ModifiedAt = info.ModTime().UTC()
+		}
+
+		err := readArchiveFile(filepath.Join(dir, entry.Name()), func(ev *ArchivedRawEvent) error {
+			archive.EventCount++
+			if archive.FirstEvent == "" || ev.TsStart < archive.FirstEvent {
+				archive.FirstEvent = ev.TsStart
+			}
+			if ev.TsStart > archive.LastEvent {
+				archive.LastEvent = ev.TsStart
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		archives = append(archives, archive)
+	}
+
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].Month < archives[j].Month
+	})
+
+	return archives, nil
+}
+
+// RehydrateRawEvents copies archived events with ts_start in [start, end)
+// back into raw_event. Events already present are skipped, so repeated runs
+// are safe. Rehydrated rows are not picked up by the regular rollup; they
+// are there for investigation or an explicit rebuild.
+func (s *Store) RehydrateRawEvents(start, end time.Time) (*RehydrateReport, error) {
+	dir := s.ArchiveDir()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	if !start.Before(end) {
+		return nil, fmt.Errorf("invalid range: start must be before end")
+	}
+
+	s.archiveMu.Lock()
+	defer s.archiveMu.Unlock()
+
+	startStr := start.UTC().Format(time.RFC3339)
+	endStr := end.UTC().Format(time.RFC3339)
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	report := &RehydrateReport{Files: []string{}}
+	seen := map[string]bool{}
+
+	for _, month := range archiveMonthsBetween(start.UTC(), end.UTC()) {
+		name := archiveFileName(month)
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		report.Files = append(report.Files, name)
+
+		err := readArchiveFile(path, func(ev *ArchivedRawEvent) error {
+			if ev.TsStart < startStr || ev.TsStart >= endStr {
+				return nil
+			}
+			report.Read++
+
+			key := fmt.Sprintf("%d|%s|%s", ev.EventID, ev.TsStart, ev.AppName)
+			if seen[key] {
+				report.Duplicates++
+				return nil
+			}
+			seen[key] = true
+
+			inserted, err := rehydrateEvent(tx, ev, now)
+			if err != nil {
+				return err
+			}
+			if inserted {
+				report.Inserted++
+			} else {
+				report.Duplicates++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit rehydrate: %w", err)
+	}
+
+	return report, nil
+}
+
+// rehydrateEvent inserts one archived event unless an identical one is live
+func rehydrateEvent(tx *sql.Tx, ev *ArchivedRawEvent, now string) (bool, error) {
+	event, err := resolveArchivedEvent(tx, ev)
+	if err != nil {
+		return false, err
+	}
+
+	live, err := isRawEventLive(tx, event)
+	if err != nil || live {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO raw_event (
+			ts_start, ts_end, app_id, title_id, domain_id,
+			state, source, metadata, hash_signature, created_at, rehydrated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, ev.TsStart, ev.TsEnd, event.AppID, event.TitleID, event.DomainID,
+		ev.State, ev.Source, ev.Metadata, ev.HashSignature, ev.CreatedAt, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert raw_event: %w", err)
+	}
+
+	return true, nil
+}
+
+// ArchivedRawEventsTx reads the archived events starting in [start, end)
+// that are no longer live, resolving their dictionary text inside tx.
+// Nothing is written to raw_event; events come back in archive order
+// without an event ID.
+func (s *Store) ArchivedRawEventsTx(tx *sql.Tx, start, end time.Time) ([]*RawEvent, error) {
+	dir := s.ArchiveDir()
+
+	s.archiveMu.Lock()
+	defer s.archiveMu.Unlock()
+
+	startStr := start.UTC().Format(time.RFC3339)
+	endStr := end.UTC().Format(time.RFC3339)
+
+	var events []*RawEvent
+	seen := map[string]bool{}
+
+	for _, month := range archiveMonthsBetween(start.UTC(), end.UTC()) {
+		path := filepath.Join(dir, archiveFileName(month))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		err := readArchiveFile(path, func(ev *ArchivedRawEvent) error {
+			if ev.TsStart < startStr || ev.TsStart >= endStr {
+				return nil
+			}
+
+			key := fmt.Sprintf("%d|%s|%s", ev.EventID, ev.TsStart, ev.AppName)
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+
+			event, err := resolveArchivedEvent(tx, ev)
+			if err != nil {
+				return err
+			}
+			live, err := isRawEventLive(tx, event)
+			if err != nil || live {
+				return err
+			}
+
+			events = append(events, event)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return events, nil
+}
+
+// resolveArchivedEvent turns an archive line back into a raw event,
+// creating any dictionary entries that were collected since
+func resolveArchivedEvent(tx *sql.Tx, ev *ArchivedRawEvent) (*RawEvent, error) {
+	appID, err := getOrCreateDictTx(tx, "dict_app", "app_id", "app_name", ev.AppName)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &RawEvent{
+		AppID:         appID,
+		State:         ev.State,
+		Source:        ev.Source,
+		Metadata:      ev.Metadata,
+		HashSignature: ev.HashSignature,
+	}
+	event.TsStart, _ = time.Parse(time.RFC3339, ev.TsStart)
+	if ev.TsEnd != nil {
+		ts, _ := time.Parse(time.RFC3339, *ev.TsEnd)
+		event.TsEnd = &ts
+	}
+
+	if ev.TitleText != nil {
+		id, err := getOrCreateDictTx(tx, "dict_title", "title_id", "title_text", *ev.TitleText)
+		if err != nil {
+			return nil, err
+		}
+		event.TitleID = &id
+	}
+	if ev.DomainText != nil {
+		id, err := getOrCreateDictTx(tx, "dict_domain", "domain_id", "domain_text", *ev.DomainText)
+		if err != nil {
+			return nil, err
+		}
+		event.DomainID = &id
+	}
+
+	return event, nil
+}
+
+// isRawEventLive reports whether an identical event is already in raw_event
+func isRawEventLive(tx *sql.Tx, event *RawEvent) (bool, error) {
+	var exists int
+	err := tx.QueryRow(`
+		SELECT COUNT(*) FROM raw_event
+		WHERE ts_start = ? AND app_id = ? AND source = ? AND title_id IS ? AND domain_id IS ?
+	`, event.TsStart.UTC().Format(time.RFC3339), event.AppID, event.Source, event.TitleID, event.DomainID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for existing raw_event: %w", err)
+	}
+	return exists > 0, nil
+}
+
+// getOrCreateDictTx resolves dictionary text to its ID inside a transaction.
+// The shared cache is left alone; it fills on the next normal lookup.
+func getOrCreateDictTx(tx *sql.Tx, table, idCol, textCol, text string) (int64, error) {
+	var id int64
+	err := tx.QueryRow("SELECT "+idCol+" FROM "+table+" WHERE "+textCol+" = ?", text).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to query %s: %w", table, err)
+	}
+
+	res, err := tx.Exec("INSERT INTO "+table+" ("+textCol+") VALUES (?)", text)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert %s: %w", table, err)
+	}
+	return res.LastInsertId()
+}
+
+// readArchiveFile calls fn for every event in an archive file. A truncated
+// final gzip member (from a crash mid-append) is logged and ignored.
+func readArchiveFile(path string, fn func(*ArchivedRawEvent) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to read archive %s: %w", filepath.Base(path), err)
+	}
+	defer zr.Close()
+
+	dec := json.NewDecoder(zr)
+	for {
+		var ev ArchivedRawEvent
+		err := dec.Decode(&ev)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			log.Printf("Warning: archive %s ends with a truncated entry; ignoring it", filepath.Base(path))
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode archive %s: %w", filepath.Base(path), err)
+		}
+		if err := fn(&ev); err != nil {
+			return err
+		}
+	}
+}
+
+// archiveBuffer holds one gzip member destined for a monthly file
+type archiveBuffer struct {
+	buf bytes.Buffer
+	zw  *gzip.Writer
+	enc *json.Encoder
+}
+
+func newArchiveBuffer() *archiveBuffer {
+	b := &archiveBuffer{}
+	b.zw = gzip.NewWriter(&b.buf)
+	b.enc = json.NewEncoder(b.zw)
+	return b
+}
+
+// appendTo finishes the gzip member and appends it to path. Concatenated
+// members read back as a single stream.
+func (b *archiveBuffer) appendTo(path string) error {
+	if err := b.zw.Close(); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b.buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func archiveFileName(month string) string {
+	return "raw_events_" + month + ".jsonl.gz"
+}
+
+// archiveMonth returns the YYYY-MM of an RFC3339 timestamp
+func archiveMonth(ts string) string {
+	if len(ts) < 7 {
+		return "0000-00"
+	}
+	return ts[:7]
+}
+
+// archiveMonthsBetween lists the months touched by [start, end)
+func archiveMonthsBetween(start, end time.Time) []string {
+	var months []string
+	cur := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for cur.Before(end) {
+		months = append(months, cur.Format("2006-01"))
+		cur = cur.AddDate(0, 1, 0)
+	}
+	return months
+}
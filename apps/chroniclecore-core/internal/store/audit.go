@@ -0,0 +1,823 @@
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Audit actors (audit_log.actor CHECK constraint)
+const (
+	AuditActorUser   = "USER"
+	AuditActorSystem = "SYSTEM"
+)
+
+// Audit actions for block mutations
+const (
+	AuditCreateManualEntry = "CREATE_MANUAL_ENTRY"
+	AuditReassignBlock     = "REASSIGN_BLOCK"
+	AuditLockBlock         = "LOCK_BLOCK"
+	AuditUnlockBlock       = "UNLOCK_BLOCK"
+	AuditTrashBlock        = "TRASH_BLOCK"
+	AuditRestoreBlock      = "RESTORE_BLOCK"
+	AuditPurgeBlock        = "PURGE_BLOCK"
+	AuditRuleAssign        = "RULE_ASSIGN"
+	AuditMLAccept          = "ML_ACCEPT"
+	AuditUndo              = "UNDO"
+	AuditIntegrityRepair   = "INTEGRITY_REPAIR"
+	AuditRebuildBlocks     = "REBUILD_BLOCKS"
+	AuditSplitBlock        = "SPLIT_BLOCK"
+	AuditMergeBlocks       = "MERGE_BLOCKS"
+	AuditEditBlock         = "EDIT_BLOCK"
+	AuditBulkUpdate        = "BULK_UPDATE"
+	AuditApplyRules        = "APPLY_RULES"
+)
+
+// BlockRow is a snapshot of a block row keyed by column name
+type BlockRow map[string]interface{}
+
+// BlockChange is the before/after state of one block in an audit entry.
+// Before is nil for created blocks, After is nil for purged blocks.
+type BlockChange struct {
+	BlockID int64           `json:"block_id"`
+	Before  BlockRow        `json:"before"`
+	After   BlockRow        `json:"after"`
+	Related []RelatedChange `json:"related,omitempty"`
+}
+
+// RelatedChange is how a block's rows in one related table changed.
+// Before holds the rows removed or altered as they were, Added the keys of
+// rows inserted.
+type RelatedChange struct {
+	Table  string     `json:"table"`
+	Before []BlockRow `json:"before,omitempty"`
+	Added  []int64    `json:"added,omitempty"`
+}
+
+// blockRelatedTable is a table whose rows belong to a block
+type blockRelatedTable struct {
+	name   string
+	key    string // Primary key column
+	column string // Column holding the block ID
+	filter string // Extra condition, if the column isn't only used for blocks
+}
+
+// blockRelatedTables are snapshotted along with the blocks they belong to,
+// so undo can put them back too
+var blockRelatedTables = []blockRelatedTable{
+	{name: "block_title", key: "block_title_id", column: "block_id"},
+	{name: "ml_label_event", key: "label_event_id", column: "block_id"},
+	{name: "ml_suggestion", key: "suggestion_id", column: "entity_id", filter: "entity_type = 'BLOCK'"},
+}
+
+// relatedRows are a block's rows per related table, by primary key
+type relatedRows map[string]map[int64]BlockRow
+
+// AuditEntry is a decoded audit_log row
+type AuditEntry struct {
+	AuditID  int64                  `json:"audit_id"`
+	Ts       string                 `json:"ts"`
+	Actor    string                 `json:"actor"`
+	Action   string                 `json:"action"`
+	BlockIDs []int64                `json:"block_ids"`
+	Changes  []BlockChange          `json:"changes,omitempty"`
+	Details  map[string]interface{} `json:"details"`
+	UndoneBy *int64                 `json:"undone_by,omitempty"`
+	Undoable bool                   `json:"undoable"`
+}
+
+// AuditFilter narrows ListAudit
+type AuditFilter struct {
+	Action    string
+	Actor     string
+	BlockID   *int64
+	StartDate string // YYYY-MM-DD, inclusive
+	EndDate   string // YYYY-MM-DD, inclusive
+	Limit     int
+	Offset    int
+}
+
+// Columns never compared or written back by undo
+var blockAuditIgnored = map[string]bool{"block_id": true, "created_at": true, "updated_at": true}
+
+// BlockAudit records before/after snapshots of the blocks a transaction
+// touches. Call Track before changing existing blocks, Created after
+// inserting new ones, then Commit in the same transaction.
+type BlockAudit struct {
+	actor   string
+	action  string
+	order   []int64
+	before  map[int64]BlockRow
+	related map[int64]relatedRows
+}
+
+// NewBlockAudit starts an audit record for one logical mutation
+func NewBlockAudit(actor, action string) *BlockAudit {
+	return &BlockAudit{
+		actor:   actor,
+		action:  action,
+		before:  make(map[int64]BlockRow),
+		related: make(map[int64]relatedRows),
+	}
+}
+
+// Track snapshots blocks before they are modified. Blocks already tracked
+// keep their first snapshot.
+func (a *BlockAudit) Track(tx *sql.Tx, blockIDs ...int64) error {
+	var pending []int64
+	for _, id := range blockIDs {
+		if _, ok := a.before[id]; !ok {
+			pending = append(pending, id)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	rows, err := snapshotBlocks(tx, pending)
+	if err != nil {
+		return err
+	}
+	related, err := snapshotRelated(tx, pending)
+	if err != nil {
+		return err
+	}
+	for _, id := range pending {
+		a.before[id] = rows[id] // nil if the block doesn't exist yet
+		a.related[id] = related[id]
+		a.order = append(a.order, id)
+	}
+	return nil
+}
+
+// Created registers blocks inserted by this mutation
+func (a *BlockAudit) Created(blockIDs ...int64) {
+	for _, id := range blockIDs {
+		if _, ok := a.before[id]; !ok {
+			a.before[id] = nil
+			a.order = append(a.order, id)
+		}
+	}
+}
+
+// Commit snapshots the tracked blocks again and writes the audit entry.
+// Returns 0 without writing anything if no tracked block changed.
+func (a *BlockAudit) Commit(tx *sql.Tx, details map[string]interface{}) (int64, error) {
+	if len(a.order) == 0 {
+		return 0, nil
+	}
+
+	after, err := snapshotBlocks(tx, a.order)
+	if err != nil {
+		return 0, err
+	}
+	afterRelated, err := snapshotRelated(tx, a.order)
+	if err != nil {
+		return 0, err
+	}
+
+	changes := []BlockChange{}
+	blockIDs := []int64{}
+	for _, id := range a.order {
+		related := diffRelated(a.related[id], afterRelated[id])
+		if sameBlockRow(a.before[id], after[id]) && len(related) == 0 {
+			continue
+		}
+		changes = append(changes, BlockChange{BlockID: id, Before: a.before[id], After: after[id], Related: related})
+		blockIDs = append(blockIDs, id)
+	}
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	payload := make(map[string]interface{}, len(details)+2)
+	for k, v := range details {
+		payload[k] = v
+	}
+	payload["block_ids"] = blockIDs
+	payload["changes"] = changes
+
+	return insertAuditLog(tx, a.actor, a.action, payload)
+}
+
+// insertAuditLog writes an audit entry with JSON details
+func insertAuditLog(tx *sql.Tx, actor, action string, details map[string]interface{}) (int64, error) {
+	data, err := json.Marshal(details)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode audit details: %w", err)
+	}
+
+	res, err := tx.Exec(
+		"INSERT INTO audit_log (actor, action, details_json) VALUES (?, ?, ?)",
+		actor, action, string(data),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write audit log: %w", err)
+	}
+
+	return res.LastInsertId()
+}
+
+// ListAudit returns audit entries, newest first
+func (s *Store) ListAudit(filter AuditFilter) ([]AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.initialized {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = 100
+	}
+
+	query := `
+		SELECT a.audit_id, a.ts, a.actor, a.action, a.details_json,
+		       (SELECT u.audit_id FROM audit_log u
+		         WHERE u.action = 'UNDO' AND json_valid(u.details_json)
+		           AND json_extract(u.details_json, '$.undo_of') = a.audit_id)
+		FROM audit_log a
+		WHERE 1=1
+	`
+	var args []interface{}
+
+	if filter.Action != "" {
+		query += " AND a.action = ?"
+		args = append(args, filter.Action)
+	}
+	if filter.Actor != "" {
+		query += " AND a.actor = ?"
+		args = append(args, filter.Actor)
+	}
+	if filter.StartDate != "" {
+		query += " AND DATE(a.ts) >= ?"
+		args = append(args, filter.StartDate)
+	}
+	if filter.EndDate != "" {
+		query += " AND DATE(a.ts) <= ?"
+		args = append(args, filter.EndDate)
+	}
+	if filter.BlockID != nil {
+		// Older entries only carry a single block_id
+		query += ` AND json_valid(a.details_json) AND (
+			EXISTS (SELECT 1 FROM json_each(a.details_json, '$.block_ids') WHERE value = ?)
+			OR json_extract(a.details_json, '$.block_id') = ?
+		)`
+		args = append(args, *filter.BlockID, *filter.BlockID)
+	}
+
+	query += " ORDER BY a.audit_id DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var details sql.NullString
+		var undoneBy sql.NullInt64
+		if err := rows.Scan(&e.AuditID, &e.Ts, &e.Actor, &e.Action, &details, &undoneBy); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		if undoneBy.Valid {
+			id := undoneBy.Int64
+			e.UndoneBy = &id
+		}
+		decodeAuditDetails(&e, details.String)
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// UndoAudit reverts the block changes recorded by an audit entry in one
+// transaction and records the undo as its own entry. It refuses if any of
+// the blocks changed again since.
+func (s *Store) UndoAudit(auditID int64) (int64, error) {
+	s.mu.RLock()
+	db, initialized := s.DB, s.initialized
+	s.mu.RUnlock()
+
+	if !initialized {
+		return 0, fmt.Errorf("store not initialized")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	undoID, err := undoAuditTx(tx, auditID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit undo: %w", err)
+	}
+
+	return undoID, nil
+}
+
+// NewOperationID returns a random ID that ties together the audit entries
+// of one operation committed over several transactions. Callers store it
+// as operation_id in each entry's details.
+func NewOperationID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate operation ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// UndoOperation reverts every audit entry of an operation in one
+// transaction, newest first, each recorded as its own undo entry. Entries
+// already undone on their own are skipped. It refuses, undoing nothing, if
+// any block changed again since. Returns the undo entry IDs.
+func (s *Store) UndoOperation(operationID string) ([]int64, error) {
+	s.mu.RLock()
+	db, initialized := s.DB, s.initialized
+	s.mu.RUnlock()
+
+	if !initialized {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT a.audit_id,
+		       EXISTS (SELECT 1 FROM audit_log u
+		               WHERE u.action = 'UNDO' AND json_valid(u.details_json)
+		                 AND json_extract(u.details_json, '$.undo_of') = a.audit_id)
+		FROM audit_log a
+		WHERE json_valid(a.details_json) AND json_extract(a.details_json, '$.operation_id') = ?
+		ORDER BY a.audit_id DESC
+	`, operationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load operation: %w", err)
+	}
+	var pending []int64
+	found := false
+	for rows.Next() {
+		var id int64
+		var undone bool
+		if err := rows.Scan(&id, &undone); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		found = true
+		if !undone {
+			pending = append(pending, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load operation: %w", err)
+	}
+	if !found {
+		return nil, fmt.Errorf("operation not found")
+	}
+	if len(pending) == 0 {
+		return nil, fmt.Errorf("operation %s was already undone", operationID)
+	}
+
+	undoIDs := make([]int64, 0, len(pending))
+	for _, id := range pending {
+		undoID, err := undoAuditTx(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		undoIDs = append(undoIDs, undoID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit undo: %w", err)
+	}
+
+	return undoIDs, nil
+}
+
+// undoAuditTx reverts one audit entry inside the caller's transaction
+func undoAuditTx(tx *sql.Tx, auditID int64) (int64, error) {
+	var e AuditEntry
+	var details sql.NullString
+	var undone int
+	err := tx.QueryRow(`
+		SELECT audit_id, action, details_json,
+		       (SELECT COUNT(*) FROM audit_log u
+		         WHERE u.action = 'UNDO' AND json_valid(u.details_json)
+		           AND json_extract(u.details_json, '$.undo_of') = audit_log.audit_id)
+		FROM audit_log WHERE audit_id = ?
+	`, auditID).Scan(&e.AuditID, &e.Action, &details, &undone)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("audit entry not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load audit entry: %w", err)
+	}
+	if undone > 0 {
+		return 0, fmt.Errorf("audit entry %d was already undone", auditID)
+	}
+
+	decodeAuditDetails(&e, details.String)
+	if len(e.Changes) == 0 {
+		return 0, fmt.Errorf("audit entry %d has no block changes to undo", auditID)
+	}
+
+	ids := make([]int64, len(e.Changes))
+	for i, c := range e.Changes {
+		ids[i] = c.BlockID
+	}
+
+	audit := NewBlockAudit(AuditActorUser, AuditUndo)
+	if err := audit.Track(tx, ids...); err != nil {
+		return 0, err
+	}
+	for _, c := range e.Changes {
+		if !sameBlockRow(audit.before[c.BlockID], c.After) {
+			return 0, fmt.Errorf("conflict: block %d changed since audit entry %d", c.BlockID, auditID)
+		}
+	}
+
+	columns, err := tableColumns(tx, "block")
+	if err != nil {
+		return 0, err
+	}
+
+	// Related rows can move between blocks, so all added rows go before
+	// any removed ones come back
+	for _, c := range e.Changes {
+		if err := removeRelatedRows(tx, c.Related); err != nil {
+			return 0, err
+		}
+	}
+	for i := len(e.Changes) - 1; i >= 0; i-- {
+		if err := revertBlockChange(tx, columns, e.Changes[i]); err != nil {
+			return 0, err
+		}
+	}
+	for _, c := range e.Changes {
+		if err := restoreRelatedRows(tx, c.Related); err != nil {
+			return 0, err
+		}
+	}
+
+	return audit.Commit(tx, map[string]interface{}{
+		"undo_of":       auditID,
+		"undone_action": e.Action,
+	})
+}
+
+// revertBlockChange puts a block back into its Before state
+func revertBlockChange(tx *sql.Tx, columns map[string]bool, c BlockChange) error {
+	if c.Before == nil {
+		return DeleteBlocksTx(tx, []int64{c.BlockID})
+	}
+
+	var names []string
+	for name := range c.Before {
+		if columns[name] && name != "updated_at" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	args := make([]interface{}, 0, len(names)+1)
+	if c.After == nil {
+		// Purged block: re-insert with its original ID
+		placeholders := make([]string, len(names))
+		for i, name := range names {
+			placeholders[i] = "?"
+			args = append(args, blockRowValue(c.Before[name]))
+		}
+		_, err := tx.Exec(
+			"INSERT INTO block ("+strings.Join(names, ", ")+") VALUES ("+strings.Join(placeholders, ", ")+")",
+			args...,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to re-create block %d: %w", c.BlockID, err)
+		}
+		return nil
+	}
+
+	var sets []string
+	for _, name := range names {
+		if blockAuditIgnored[name] {
+			continue
+		}
+		sets = append(sets, name+" = ?")
+		args = append(args, blockRowValue(c.Before[name]))
+	}
+	args = append(args, c.BlockID)
+
+	if _, err := tx.Exec("UPDATE block SET "+strings.Join(sets, ", ")+" WHERE block_id = ?", args...); err != nil {
+		return fmt.Errorf("failed to revert block %d: %w", c.BlockID, err)
+	}
+	return nil
+}
+
+// removeRelatedRows deletes the related rows a change added
+func removeRelatedRows(tx *sql.Tx, related []RelatedChange) error {
+	for _, rc := range related {
+		table, ok := relatedTable(rc.Table)
+		if !ok {
+			return fmt.Errorf("unknown related table %q", rc.Table)
+		}
+		for _, key := range rc.Added {
+			if _, err := tx.Exec("DELETE FROM "+table.name+" WHERE "+table.key+" = ?", key); err != nil {
+				return fmt.Errorf("failed to remove %s row %d: %w", table.name, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// restoreRelatedRows writes back the related rows a change removed or
+// altered, with their original keys
+func restoreRelatedRows(tx *sql.Tx, related []RelatedChange) error {
+	for _, rc := range related {
+		if len(rc.Before) == 0 {
+			continue
+		}
+		table, ok := relatedTable(rc.Table)
+		if !ok {
+			return fmt.Errorf("unknown related table %q", rc.Table)
+		}
+		columns, err := tableColumns(tx, table.name)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rc.Before {
+			var names, placeholders []string
+			var args []interface{}
+			for name := range row {
+				if columns[name] {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				placeholders = append(placeholders, "?")
+				args = append(args, blockRowValue(row[name]))
+			}
+
+			_, err := tx.Exec(
+				"INSERT OR REPLACE INTO "+table.name+" ("+strings.Join(names, ", ")+") VALUES ("+strings.Join(placeholders, ", ")+")",
+				args...,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to restore %s row: %w", table.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func relatedTable(name string) (blockRelatedTable, bool) {
+	for _, t := range blockRelatedTables {
+		if t.name == name {
+			return t, true
+		}
+	}
+	return blockRelatedTable{}, false
+}
+
+// snapshotRelated reads the related rows of blocks, normalized like
+// snapshotBlocks. Every block gets an entry, empty if it has no rows.
+func snapshotRelated(tx *sql.Tx, blockIDs []int64) (map[int64]relatedRows, error) {
+	result := make(map[int64]relatedRows, len(blockIDs))
+	for _, id := range blockIDs {
+		result[id] = relatedRows{}
+	}
+	if len(blockIDs) == 0 {
+		return result, nil
+	}
+
+	in, args := inClause(blockIDs)
+	for _, table := range blockRelatedTables {
+		query := "SELECT * FROM " + table.name + " WHERE " + table.column + " IN " + in
+		if table.filter != "" {
+			query += " AND " + table.filter
+		}
+		rows, err := tx.Query(query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot %s: %w", table.name, err)
+		}
+		snapshot, err := scanSnapshotRows(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range snapshot {
+			blockID, _ := row[table.column].(json.Number).Int64()
+			key, _ := row[table.key].(json.Number).Int64()
+			byKey := result[blockID][table.name]
+			if byKey == nil {
+				byKey = make(map[int64]BlockRow)
+				result[blockID][table.name] = byKey
+			}
+			byKey[key] = row
+		}
+	}
+	return result, nil
+}
+
+// diffRelated lists how a block's related rows changed between snapshots
+func diffRelated(before, after relatedRows) []RelatedChange {
+	var changes []RelatedChange
+	for _, table := range blockRelatedTables {
+		rc := RelatedChange{Table: table.name}
+		for key, row := range before[table.name] {
+			if !reflect.DeepEqual(row, after[table.name][key]) {
+				rc.Before = append(rc.Before, row)
+			}
+		}
+		for key := range after[table.name] {
+			if _, ok := before[table.name][key]; !ok {
+				rc.Added = append(rc.Added, key)
+			}
+		}
+		if len(rc.Before) == 0 && len(rc.Added) == 0 {
+			continue
+		}
+
+		sort.Slice(rc.Before, func(i, j int) bool {
+			a, _ := rc.Before[i][table.key].(json.Number).Int64()
+			b, _ := rc.Before[j][table.key].(json.Number).Int64()
+			return a < b
+		})
+		sort.Slice(rc.Added, func(i, j int) bool { return rc.Added[i] < rc.Added[j] })
+		changes = append(changes, rc)
+	}
+	return changes
+}
+
+// snapshotBlocks reads full block rows, normalized to their JSON form so
+// they compare equal to snapshots decoded from audit_log
+func snapshotBlocks(tx *sql.Tx, blockIDs []int64) (map[int64]BlockRow, error) {
+	result := make(map[int64]BlockRow, len(blockIDs))
+	if len(blockIDs) == 0 {
+		return result, nil
+	}
+
+	placeholders := make([]string, len(blockIDs))
+	args := make([]interface{}, len(blockIDs))
+	for i, id := range blockIDs {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	rows, err := tx.Query("SELECT * FROM block WHERE block_id IN ("+strings.Join(placeholders, ",")+")", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot blocks: %w", err)
+	}
+	defer rows.Close()
+
+	snapshot, err := scanSnapshotRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range snapshot {
+		id, _ := row["block_id"].(json.Number).Int64()
+		result[id] = row
+	}
+	return result, nil
+}
+
+// scanSnapshotRows reads whole rows, normalized to their JSON form
+func scanSnapshotRows(rows *sql.Rows) ([]BlockRow, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []BlockRow
+
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot: %w", err)
+		}
+
+		row := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[col] = values[i]
+		}
+
+		normalized, err := normalizeBlockRow(row)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, normalized)
+	}
+
+	return result, rows.Err()
+}
+
+func normalizeBlockRow(row map[string]interface{}) (BlockRow, error) {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode block snapshot: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out BlockRow
+	if err := dec.Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode block snapshot: %w", err)
+	}
+	return out, nil
+}
+
+// sameBlockRow compares snapshots, ignoring bookkeeping columns
+func sameBlockRow(a, b BlockRow) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	strip := func(r BlockRow) BlockRow {
+		out := make(BlockRow, len(r))
+		for k, v := range r {
+			if !blockAuditIgnored[k] {
+				out[k] = v
+			}
+		}
+		return out
+	}
+	return reflect.DeepEqual(strip(a), strip(b))
+}
+
+// blockRowValue converts a decoded snapshot value back to a SQL argument
+func blockRowValue(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+func decodeAuditDetails(e *AuditEntry, details string) {
+	e.Details = map[string]interface{}{}
+	e.BlockIDs = []int64{}
+
+	if details == "" {
+		return
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(details), &raw); err != nil {
+		return
+	}
+
+	if data, ok := raw["changes"]; ok {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		dec.Decode(&e.Changes)
+		delete(raw, "changes")
+	}
+
+	if data, ok := raw["block_ids"]; ok {
+		json.Unmarshal(data, &e.BlockIDs)
+		delete(raw, "block_ids")
+	} else if data, ok := raw["block_id"]; ok {
+		var id int64
+		if json.Unmarshal(data, &id) == nil {
+			e.BlockIDs = []int64{id}
+		}
+	}
+
+	for k, v := range raw {
+		var value interface{}
+		json.Unmarshal(v, &value)
+		e.Details[k] = value
+	}
+
+	e.Undoable = len(e.Changes) > 0 && e.UndoneBy == nil
+}
@@ -0,0 +1,448 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backup setting keys
+const (
+	SettingBackupDir        = "backup_dir"         // Snapshot folder (default: <db dir>/snapshots)
+	SettingBackupDailyKeep  = "backup_daily_keep"  // Daily snapshots to retain
+	SettingBackupWeeklyKeep = "backup_weekly_keep" // Weekly snapshots to retain
+)
+
+// Snapshot kinds
+const (
+	SnapshotDaily      = "DAILY"
+	SnapshotWeekly     = "WEEKLY"
+	SnapshotManual     = "MANUAL"
+	SnapshotPreRestore = "PRE_RESTORE"
+)
+
+// Retention defaults, overridable via settings
+const (
+	DefaultDailyKeep      = 7
+	DefaultWeeklyKeep     = 4
+	DefaultManualKeep     = 10
+	DefaultPreRestoreKeep = 3
+)
+
+const snapshotTimeFormat = "20060102T150405Z"
+
+// chronicle_daily_20260110T020000Z.db
+var snapshotNamePattern = regexp.MustCompile(`^chronicle_(daily|weekly|manual|pre_restore)_(\d{8}T\d{6}Z)\.db$`)
+
+// Snapshot describes a backup file in the snapshot folder
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+	SizeBytes int64     `json:"size_bytes"`
+}
+
+// BackupDir returns the configured snapshot folder
+func (s *Store) BackupDir() string {
+	if dir, err := s.GetSetting(SettingBackupDir); err == nil && dir != "" {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(s.dbPath), "snapshots")
+}
+
+// CreateSnapshot writes a consistent copy of the live database using SQLite's
+// online backup API. Writers in WAL mode are not blocked while it runs.
+func (s *Store) CreateSnapshot(kind string) (*Snapshot, error) {
+	s.backupMu.Lock()
+	defer s.backupMu.Unlock()
+
+	return s.createSnapshot(kind, time.Now())
+}
+
+func (s *Store) createSnapshot(kind string, now time.Time) (*Snapshot, error) {
+	dir := s.BackupDir()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.createSnapshotLocked(dir, kind, now)
+}
+
+// createSnapshotLocked writes a snapshot into dir; the caller holds s.mu
+func (s *Store) createSnapshotLocked(dir, kind string, now time.Time) (*Snapshot, error) {
+	switch kind {
+	case SnapshotDaily, SnapshotWeekly, SnapshotManual, SnapshotPreRestore:
+	default:
+		return nil, fmt.Errorf("unknown snapshot kind: %s", kind)
+	}
+
+	if !s.initialized {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup folder: %w", err)
+	}
+
+	now = now.UTC()
+	name := fmt.Sprintf("chronicle_%s_%s.db", strings.ToLower(kind), now.Format(snapshotTimeFormat))
+	path := filepath.Join(dir, name)
+
+	if err := backupDatabase(s.DB, path); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat snapshot: %w", err)
+	}
+
+	log.Printf("Snapshot created: %s (%d bytes)", name, info.Size())
+
+	return &Snapshot{
+		Name:      name,
+		Kind:      kind,
+		CreatedAt: now.Truncate(time.Second),
+		SizeBytes: info.Size(),
+	}, nil
+}
+
+// ListSnapshots returns snapshots in the backup folder, newest first
+func (s *Store) ListSnapshots() ([]Snapshot, error) {
+	entries, err := os.ReadDir(s.BackupDir())
+	if os.IsNotExist(err) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup folder: %w", err)
+	}
+
+	snapshots := []Snapshot{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		snap, ok := parseSnapshotName(entry.Name())
+		if !ok {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			snap.SizeBytes = info.Size()
+		}
+		snapshots = append(snapshots, snap)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+
+	return snapshots, nil
+}
+
+// SnapshotPath resolves a snapshot name to its file, rejecting anything that
+// isn't a snapshot file name (including path separators)
+func (s *Store) SnapshotPath(name string) (string, error) {
+	if _, ok := parseSnapshotName(name); !ok {
+		return "", fmt.Errorf("invalid snapshot name: %s", name)
+	}
+	return filepath.Join(s.BackupDir(), name), nil
+}
+
+// RunScheduledBackup takes a daily snapshot if none exists for today and a
+// weekly one if none exists for this ISO week, then rotates old snapshots
+func (s *Store) RunScheduledBackup(now time.Time) ([]Snapshot, error) {
+	s.backupMu.Lock()
+	defer s.backupMu.Unlock()
+
+	existing, err := s.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	now = now.UTC()
+	needDaily, needWeekly := true, true
+	year, week := now.ISOWeek()
+	for _, snap := range existing {
+		switch snap.Kind {
+		case SnapshotDaily:
+			if snap.CreatedAt.Format("2006-01-02") == now.Format("2006-01-02") {
+				needDaily = false
+			}
+		case SnapshotWeekly:
+			if y, w := snap.CreatedAt.ISOWeek(); y == year && w == week {
+				needWeekly = false
+			}
+		}
+	}
+
+	created := []Snapshot{}
+	if needDaily {
+		snap, err := s.createSnapshot(SnapshotDaily, now)
+		if err != nil {
+			return created, err
+		}
+		created = append(created, *snap)
+	}
+	if needWeekly {
+		snap, err := s.createSnapshot(SnapshotWeekly, now)
+		if err != nil {
+			return created, err
+		}
+		created = append(created, *snap)
+	}
+
+	if _, err := s.rotateSnapshots(); err != nil {
+		return created, err
+	}
+
+	return created, nil
+}
+
+// rotateSnapshots deletes snapshots beyond each kind's retention count
+func (s *Store) rotateSnapshots() (int, error) {
+	snapshots, err := s.ListSnapshots()
+	if err != nil {
+		return 0, err
+	}
+
+	keep := map[string]int{
+		SnapshotDaily:      s.getSettingInt(SettingBackupDailyKeep, DefaultDailyKeep),
+		SnapshotWeekly:     s.getSettingInt(SettingBackupWeeklyKeep, DefaultWeeklyKeep),
+		SnapshotManual:     DefaultManualKeep,
+		SnapshotPreRestore: DefaultPreRestoreKeep,
+	}
+
+	seen := make(map[string]int)
+	removed := 0
+	for _, snap := range snapshots { // newest first
+		seen[snap.Kind]++
+		if seen[snap.Kind] <= keep[snap.Kind] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.BackupDir(), snap.Name)); err != nil {
+			return removed, fmt.Errorf("failed to remove snapshot %s: %w", snap.Name, err)
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		log.Printf("Rotated %d old snapshot(s)", removed)
+	}
+
+	return removed, nil
+}
+
+// ErrInvalidSnapshot is returned by RestoreSnapshot for a snapshot that
+// fails validation
+var ErrInvalidSnapshot = errors.New("snapshot failed validation")
+
+// ValidateSnapshot checks that a file is an intact ChronicleCore database that
+// this build can open
+func ValidateSnapshot(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("snapshot not found: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1) // query_only is per connection
+
+	if _, err := db.Exec("PRAGMA query_only = ON"); err != nil {
+		return fmt.Errorf("failed to open snapshot read-only: %w", err)
+	}
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("integrity check failed: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("snapshot is corrupt: %s", result)
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='install'").Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to inspect snapshot: %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("snapshot is not a ChronicleCore database")
+	}
+
+	// Snapshots predating the migration table are upgraded on Init
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='schema_migrations'").Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to inspect snapshot: %w", err)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	applied, err := appliedMigrations(context.Background(), conn)
+	if err != nil {
+		return err
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	if err := verifyApplied(migrations, applied); err != nil {
+		return fmt.Errorf("snapshot schema incompatible: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreSnapshot replaces the live database with a snapshot. The current
+// database is saved as a PRE_RESTORE snapshot first, and the store is closed
+// and re-initialized so no process restart is needed. The store lock is held
+// throughout, so other store calls wait for the restored database rather
+// than writing to the one being replaced.
+func (s *Store) RestoreSnapshot(name string) error {
+	s.backupMu.Lock()
+	defer s.backupMu.Unlock()
+
+	path, err := s.SnapshotPath(name)
+	if err != nil {
+		return err
+	}
+
+	if err := ValidateSnapshot(path); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+
+	dir := s.BackupDir()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	safety, err := s.createSnapshotLocked(dir, SnapshotPreRestore, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save current database before restore: %w", err)
+	}
+	safetyPath := filepath.Join(dir, safety.Name)
+
+	if err := s.closeLocked(); err != nil {
+		return fmt.Errorf("failed to close store: %w", err)
+	}
+
+	restoreErr := restoreDatabase(path, s.dbPath)
+	if restoreErr == nil {
+		s.dictCache.reset()
+		if restoreErr = s.initLocked(); restoreErr == nil {
+			log.Printf("Database restored from %s", name)
+			return nil
+		}
+	}
+
+	// Put the previous database back so the app keeps running
+	log.Printf("Restore from %s failed, rolling back: %v", name, restoreErr)
+	if err := restoreDatabase(safetyPath, s.dbPath); err != nil {
+		return fmt.Errorf("restore failed (%v) and rollback failed: %w", restoreErr, err)
+	}
+	s.dictCache.reset()
+	if err := s.initLocked(); err != nil {
+		return fmt.Errorf("restore failed (%v) and re-open failed: %w", restoreErr, err)
+	}
+
+	return fmt.Errorf("restore failed: %w", restoreErr)
+}
+
+func (s *Store) getSettingInt(key string, def int) int {
+	value, err := s.GetSetting(key)
+	if err != nil || value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return def
+	}
+	return n
+}
+
+func parseSnapshotName(name string) (Snapshot, bool) {
+	m := snapshotNamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return Snapshot{}, false
+	}
+	createdAt, err := time.Parse(snapshotTimeFormat, m[2])
+	if err != nil {
+		return Snapshot{}, false
+	}
+	return Snapshot{
+		Name:      name,
+		Kind:      strings.ToUpper(m[1]),
+		CreatedAt: createdAt,
+	}, true
+}
+
+// backupDatabase copies src into a new file at destPath. The copy is written
+// to a temp file and renamed so a partial snapshot never looks valid.
+func backupDatabase(src *sql.DB, destPath string) error {
+	tmpPath := destPath + ".tmp"
+	os.Remove(tmpPath)
+
+	dest, err := sql.Open("sqlite3", tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	err = copyDatabase(dest, src)
+	if err == nil {
+		// Snapshots are standalone files, not WAL databases
+		_, err = dest.Exec("PRAGMA journal_mode = DELETE")
+	}
+	dest.Close()
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("backup failed: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to finalize snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// restoreDatabase overwrites the database at destPath with the snapshot at
+// srcPath through SQLite, so any WAL/SHM files stay consistent
+func restoreDatabase(srcPath, destPath string) error {
+	src, err := sql.Open("sqlite3", srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer src.Close()
+
+	dest, err := sql.Open("sqlite3", destPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer dest.Close()
+
+	if err := copyDatabase(dest, src); err != nil {
+		return fmt.Errorf("failed to copy snapshot: %w", err)
+	}
+
+	// Snapshots are stored in rollback-journal mode; Init re-enables WAL
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+// +build !cgo
+
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// copyDatabase needs the cgo SQLite driver's backup API
+func copyDatabase(dest, src *sql.DB) error {
+	return fmt.Errorf("database backup requires a cgo build")
+}
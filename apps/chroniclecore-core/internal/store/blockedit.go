@@ -0,0 +1,1199 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Label event action types recorded for block edits
+const (
+	LabelActionAssign = "ASSIGN"
+	LabelActionSplit  = "SPLIT"
+	LabelActionMerge  = "MERGE"
+)
+
+// SplitSegment overrides fields of one segment of a split block. Unset
+// fields are inherited from the original block.
+type SplitSegment struct {
+	ProfileID   *int64  `json:"profile_id"`
+	Billable    *bool   `json:"billable"`
+	Description *string `json:"description"`
+}
+
+// SplitBlock cuts a block at the given points, in order, into
+// len(points)+1 blocks. The first keeps the block's ID. segments is empty
+// or holds one override per resulting block. Activity scores, title
+// timelines and title summaries are recomputed per segment from raw events
+// still retained. Every segment is recorded as a SPLIT label event from
+// the block's profile to its own, so segments that kept the profile show
+// the same old and new profile. Returns the IDs of all segments.
+func (s *Store) SplitBlock(blockID int64, points []time.Time, segments []SplitSegment) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	if len(points) == 0 {
+		return nil, fmt.Errorf("no split points given")
+	}
+	if len(segments) != 0 && len(segments) != len(points)+1 {
+		return nil, fmt.Errorf("expected %d segments for %d split points, got %d", len(points)+1, len(points), len(segments))
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	block, err := loadEditableBlock(tx, blockID)
+	if err != nil {
+		return nil, err
+	}
+	if block.locked {
+		return nil, fmt.Errorf("block %d is locked", blockID)
+	}
+
+	bounds := []time.Time{block.start}
+	for _, p := range points {
+		if !p.After(bounds[len(bounds)-1]) || !p.Before(block.end) {
+			return nil, fmt.Errorf("split points must be in order and inside the block")
+		}
+		bounds = append(bounds, p)
+	}
+	bounds = append(bounds, block.end)
+
+	for _, seg := range segments {
+		if seg.ProfileID == nil {
+			continue
+		}
+		var exists int
+		if err := tx.QueryRow("SELECT 1 FROM profile WHERE profile_id = ?", *seg.ProfileID).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("segment profile %d does not exist", *seg.ProfileID)
+		}
+	}
+
+	audit := NewBlockAudit(AuditActorUser, AuditSplitBlock)
+	if err := audit.Track(tx, blockID); err != nil {
+		return nil, err
+	}
+
+	events, err := RawEventsInRangeTx(tx, block.start, block.end)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int64{blockID}
+	for range points {
+		id, err := copyBlockTx(tx, blockID)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	audit.Created(ids[1:]...)
+
+	for i, id := range ids {
+		var seg SplitSegment
+		if len(segments) > 0 {
+			seg = segments[i]
+		}
+
+		part := block.segment(bounds[i], bounds[i+1], events)
+		if seg.ProfileID != nil {
+			part.profileID = seg.ProfileID
+			part.confidence = "HIGH" // Picked by hand
+		}
+		if seg.Billable != nil {
+			part.billable = *seg.Billable
+		}
+		if seg.Description != nil {
+			part.description = seg.Description
+		}
+
+		if err := part.save(tx, id); err != nil {
+			return nil, err
+		}
+		if err := updateTitleSummaryTx(tx, id); err != nil {
+			return nil, err
+		}
+		if err := insertLabelEventTx(tx, id, block.profileID, part.profileID, block.confidence, part.confidence, LabelActionSplit); err != nil {
+			return nil, err
+		}
+	}
+
+	splitAt := make([]string, len(points))
+	for i, p := range points {
+		splitAt[i] = p.UTC().Format(time.RFC3339)
+	}
+	if _, err := audit.Commit(tx, map[string]interface{}{"split_at": splitAt}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit split: %w", err)
+	}
+
+	return ids, nil
+}
+
+// MergeOptions controls MergeBlocks. Unset overrides are worked out from
+// the merged blocks.
+type MergeOptions struct {
+	Force       bool    // Merge locked blocks too
+	ProfileID   *int64  // Profile for the merged block
+	Billable    *bool   // Billable flag for the merged block
+	Description *string // Replaces the combined description
+}
+
+// MergeBlocks merges blocks from one local day into the earliest of them,
+// which then spans all of them. The rest are removed; undoing the audit
+// entry brings them back with their titles, labels and suggestions. No
+// other block may lie in between.
+//
+// Where the blocks have different profiles, the one holding the most time
+// wins and the merged block is marked LOW confidence for review. The
+// activity score is weighted by time and descriptions are combined in
+// order. Pending MERGE_BLOCKS suggestions for the blocks are resolved.
+// Returns the merged block's ID.
+func (s *Store) MergeBlocks(blockIDs []int64, opts MergeOptions) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return 0, fmt.Errorf("store not initialized")
+	}
+
+	seen := make(map[int64]bool)
+	var ids []int64
+	for _, id := range blockIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < 2 {
+		return 0, fmt.Errorf("merge needs at least 2 blocks")
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var blocks []*editableBlock
+	for _, id := range ids {
+		b, err := loadEditableBlock(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		if b.locked && !opts.Force {
+			return 0, fmt.Errorf("block %d is locked", id)
+		}
+		blocks = append(blocks, b)
+	}
+	sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].start.Before(blocks[j].start) })
+
+	first := blocks[0]
+	day := first.start.Local().Format("2006-01-02")
+	end := first.end
+	for _, b := range blocks[1:] {
+		if b.start.Local().Format("2006-01-02") != day {
+			return 0, fmt.Errorf("merge blocks must be from the same day")
+		}
+		end = maxTime(end, b.end)
+	}
+
+	// Anything else in the merged span would end up overlapping it
+	in, idArgs := inClause(ids)
+	var between int64
+	err = tx.QueryRow(`
+		SELECT block_id FROM block
+		WHERE deleted_at IS NULL AND ts_start < ? AND ts_end > ? AND block_id NOT IN `+in+`
+		LIMIT 1
+	`, append([]interface{}{end.UTC().Format(time.RFC3339), first.start.UTC().Format(time.RFC3339)}, idArgs...)...).Scan(&between)
+	if err == nil {
+		return 0, fmt.Errorf("merge blocks must be contiguous: block %d lies in between", between)
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to check for blocks in between: %w", err)
+	}
+
+	if opts.ProfileID != nil {
+		var exists int
+		if err := tx.QueryRow("SELECT 1 FROM profile WHERE profile_id = ?", *opts.ProfileID).Scan(&exists); err != nil {
+			return 0, fmt.Errorf("merge profile %d does not exist", *opts.ProfileID)
+		}
+	}
+
+	audit := NewBlockAudit(AuditActorUser, AuditMergeBlocks)
+	if err := audit.Track(tx, ids...); err != nil {
+		return 0, err
+	}
+
+	merged := *first
+	merged.end = end
+	merged.metadata = make(map[string]interface{}, len(first.metadata))
+	for k, v := range first.metadata {
+		merged.metadata[k] = v
+	}
+
+	// Time-weighted activity score, profile time and combined description
+	var weighted, total float64
+	profileTime := make(map[int64]float64)
+	var profiles []int64
+	var descriptions []string
+	var interruptions []interface{}
+	interrupted := 0.0
+	longest := first
+	for _, b := range blocks {
+		seconds := b.end.Sub(b.start).Seconds()
+		weighted += b.score * seconds
+		total += seconds
+		if seconds > longest.end.Sub(longest.start).Seconds() {
+			longest = b
+		}
+
+		if b.profileID != nil {
+			if _, ok := profileTime[*b.profileID]; !ok {
+				profiles = append(profiles, *b.profileID)
+			}
+			profileTime[*b.profileID] += seconds
+		}
+		if b.description != nil && *b.description != "" && !containsString(descriptions, *b.description) {
+			descriptions = append(descriptions, *b.description)
+		}
+		if list, ok := b.metadata["interruptions"].([]interface{}); ok {
+			interruptions = append(interruptions, list...)
+		}
+		if secs, ok := b.metadata["interrupted_seconds"].(float64); ok {
+			interrupted += secs
+		}
+		merged.locked = merged.locked || b.locked
+		merged.billable = merged.billable || b.billable
+	}
+	merged.appID = longest.appID
+
+	if total > 0 {
+		merged.score = weighted / total
+		merged.metadata["avg_activity_score"] = math.Round(merged.score*100) / 100
+	}
+	delete(merged.metadata, "interruptions")
+	delete(merged.metadata, "interrupted_seconds")
+	if len(interruptions) > 0 {
+		merged.metadata["interruptions"] = interruptions
+		merged.metadata["interrupted_seconds"] = int(interrupted)
+	}
+
+	resolution := "none"
+	merged.profileID = nil
+	switch {
+	case opts.ProfileID != nil:
+		resolution = "explicit"
+		merged.profileID = opts.ProfileID
+		merged.confidence = "HIGH"
+	case len(profiles) == 1:
+		resolution = "unanimous"
+		merged.profileID = &profiles[0]
+		merged.confidence = lowestConfidence(blocks, profiles[0])
+	case len(profiles) > 1:
+		resolution = "longest"
+		best := profiles[0]
+		for _, pid := range profiles[1:] {
+			if profileTime[pid] > profileTime[best] {
+				best = pid
+			}
+		}
+		merged.profileID = &best
+		merged.confidence = "LOW" // Needs review
+	default:
+		merged.confidence = "LOW"
+	}
+
+	if opts.Billable != nil {
+		merged.billable = *opts.Billable
+	}
+	if opts.Description != nil {
+		merged.description = opts.Description
+	} else if len(descriptions) > 0 {
+		combined := strings.Join(descriptions, "; ")
+		merged.description = &combined
+	} else {
+		merged.description = nil
+	}
+
+	merged.titles, err = mergedTitlesTx(tx, ids)
+	if err != nil {
+		return 0, err
+	}
+	if len(merged.titles) == 0 {
+		merged.titles = nil
+	}
+
+	suggestions, err := resolveMergeSuggestionsTx(tx, audit, first.id, seen)
+	if err != nil {
+		return 0, err
+	}
+
+	var removed []int64
+	for _, b := range blocks[1:] {
+		removed = append(removed, b.id)
+	}
+	if err := DeleteBlocksTx(tx, removed); err != nil {
+		return 0, err
+	}
+
+	if err := merged.save(tx, first.id); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(
+		"UPDATE block SET primary_app_id = ?, locked = ? WHERE block_id = ?",
+		merged.appID, merged.locked, first.id,
+	); err != nil {
+		return 0, fmt.Errorf("failed to update merged block: %w", err)
+	}
+	if err := updateTitleSummaryTx(tx, first.id); err != nil {
+		return 0, err
+	}
+
+	if err := insertLabelEventTx(tx, first.id, first.profileID, merged.profileID, first.confidence, merged.confidence, LabelActionMerge); err != nil {
+		return 0, err
+	}
+
+	if _, err := audit.Commit(tx, map[string]interface{}{
+		"merged_into":        first.id,
+		"profile_resolution": resolution,
+		"suggestion_ids":     suggestions,
+	}); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit merge: %w", err)
+	}
+
+	return first.id, nil
+}
+
+// mergedTitlesTx sums the title timelines of blocks, longest first
+func mergedTitlesTx(tx *sql.Tx, blockIDs []int64) ([]BlockTitle, error) {
+	in, idArgs := inClause(blockIDs)
+	rows, err := tx.Query(`
+		SELECT title_id, SUM(seconds) FROM block_title
+		WHERE block_id IN `+in+`
+		GROUP BY title_id
+		ORDER BY SUM(seconds) DESC, MIN(block_title_id) ASC
+	`, idArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load block titles: %w", err)
+	}
+	defer rows.Close()
+
+	var titles []BlockTitle
+	for rows.Next() {
+		var t BlockTitle
+		if err := rows.Scan(&t.TitleID, &t.Seconds); err != nil {
+			return nil, fmt.Errorf("failed to scan block title: %w", err)
+		}
+		titles = append(titles, t)
+	}
+	return titles, rows.Err()
+}
+
+// resolveMergeSuggestionsTx resolves pending MERGE_BLOCKS suggestions that
+// involve the merged blocks. Their payload lists the blocks to merge as
+// block_ids, next to the entity block. Suggestions the merge covered are
+// accepted, ones that only overlap it expire. Both move to the merged
+// block so they outlive the blocks removed. Their blocks are tracked so
+// undo puts them back as they were.
+func resolveMergeSuggestionsTx(tx *sql.Tx, audit *BlockAudit, mergedID int64, merged map[int64]bool) ([]int64, error) {
+	rows, err := tx.Query(`
+		SELECT suggestion_id, entity_id, payload_json FROM ml_suggestion
+		WHERE entity_type = 'BLOCK' AND suggestion_type = 'MERGE_BLOCKS' AND status = 'PENDING'
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load merge suggestions: %w", err)
+	}
+
+	type resolved struct {
+		id       int64
+		entityID int64
+		status   string
+	}
+	var updates []resolved
+	for rows.Next() {
+		var id, entityID int64
+		var payloadJSON string
+		if err := rows.Scan(&id, &entityID, &payloadJSON); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan merge suggestion: %w", err)
+		}
+
+		var payload struct {
+			BlockIDs []int64 `json:"block_ids"`
+		}
+		json.Unmarshal([]byte(payloadJSON), &payload)
+
+		involved, covered := false, true
+		for _, blockID := range append(payload.BlockIDs, entityID) {
+			if merged[blockID] {
+				involved = true
+			} else {
+				covered = false
+			}
+		}
+		if !involved {
+			continue
+		}
+		status := "EXPIRED"
+		if covered {
+			status = "ACCEPTED"
+		}
+		updates = append(updates, resolved{id, entityID, status})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read merge suggestions: %w", err)
+	}
+
+	ids := []int64{}
+	for _, u := range updates {
+		if err := audit.Track(tx, u.entityID); err != nil {
+			return nil, err
+		}
+		_, err := tx.Exec(`
+			UPDATE ml_suggestion
+			SET status = ?, entity_id = ?, resolved_at = datetime('now')
+			WHERE suggestion_id = ?
+		`, u.status, mergedID, u.id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve merge suggestion %d: %w", u.id, err)
+		}
+		ids = append(ids, u.id)
+	}
+	return ids, nil
+}
+
+// updateTitleSummaryTx points a block's title summary at the title it
+// spent the most time on, if it has a title timeline
+func updateTitleSummaryTx(tx *sql.Tx, blockID int64) error {
+	_, err := tx.Exec(`
+		UPDATE block
+		SET title_summary_id = COALESCE((
+			SELECT title_id FROM block_title WHERE block_id = ?
+			ORDER BY seconds DESC, block_title_id ASC LIMIT 1
+		), title_summary_id)
+		WHERE block_id = ?
+	`, blockID, blockID)
+	if err != nil {
+		return fmt.Errorf("failed to update title summary for block %d: %w", blockID, err)
+	}
+	return nil
+}
+
+// lowestConfidence returns the lowest confidence among blocks with a profile
+func lowestConfidence(blocks []*editableBlock, profileID int64) string {
+	rank := map[string]int{"LOW": 0, "MEDIUM": 1, "HIGH": 2}
+	lowest := "HIGH"
+	for _, b := range blocks {
+		if b.profileID != nil && *b.profileID == profileID && rank[b.confidence] < rank[lowest] {
+			lowest = b.confidence
+		}
+	}
+	return lowest
+}
+
+// inClause returns "(?,?,...)" for ids with the matching arguments
+func inClause(ids []int64) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	return "(" + strings.Join(placeholders, ",") + ")", args
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// BlockEdit holds the block fields an edit may change. Nil fields are left
+// as they are.
+type BlockEdit struct {
+	TsStart     *time.Time
+	TsEnd       *time.Time
+	Billable    *bool
+	Notes       *string
+	Description *string
+	Title       *string // Manual entries only
+}
+
+// EditBlock applies an edit to a block. New times may not overlap other
+// blocks; with trim, the neighbours overlapped are shortened to make room
+// instead, as long as that leaves something of them. Activity score, title
+// timeline and title summary follow the new times where raw events are
+// retained.
+// Returns the IDs of trimmed neighbours.
+func (s *Store) EditBlock(blockID int64, edit BlockEdit, trim bool) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	var titleID int64
+	if edit.Title != nil {
+		if strings.TrimSpace(*edit.Title) == "" {
+			return nil, fmt.Errorf("title cannot be empty")
+		}
+		// s.mu is already held, so skip the locking GetOrCreateDictTitle
+		var err error
+		titleID, err = s.dictCache.getOrCreate(s.DB, s.dictCache.titles, "dict_title", "title_id", "title_text", *edit.Title)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	block, err := loadEditableBlock(tx, blockID)
+	if err != nil {
+		return nil, err
+	}
+	if block.locked {
+		return nil, fmt.Errorf("block %d is locked", blockID)
+	}
+	if edit.Title != nil && !block.manual {
+		return nil, fmt.Errorf("title can only be changed on manual entries")
+	}
+
+	start, end := block.start, block.end
+	if edit.TsStart != nil {
+		start = edit.TsStart.UTC().Truncate(time.Second)
+	}
+	if edit.TsEnd != nil {
+		end = edit.TsEnd.UTC().Truncate(time.Second)
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("ts_end must be after ts_start")
+	}
+	moved := !start.Equal(block.start) || !end.Equal(block.end)
+
+	audit := NewBlockAudit(AuditActorUser, AuditEditBlock)
+	if err := audit.Track(tx, blockID); err != nil {
+		return nil, err
+	}
+
+	var trimmed []*editableBlock
+	if moved {
+		neighbours, err := overlappingBlocksTx(tx, blockID, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range neighbours {
+			switch {
+			case !trim:
+				return nil, fmt.Errorf("block %d overlaps block %d", blockID, n.id)
+			case n.locked:
+				return nil, fmt.Errorf("block %d overlaps locked block %d", blockID, n.id)
+			case !n.start.Before(start) && !n.end.After(end):
+				return nil, fmt.Errorf("block %d overlaps all of block %d", blockID, n.id)
+			case n.start.Before(start) && n.end.After(end):
+				return nil, fmt.Errorf("block %d overlaps the middle of block %d", blockID, n.id)
+			}
+			trimmed = append(trimmed, n)
+		}
+	}
+
+	updated := block
+	var events []*RawEvent
+	if moved {
+		lo, hi := start, end
+		for _, n := range trimmed {
+			lo, hi = minTime(lo, n.start), maxTime(hi, n.end)
+		}
+		if events, err = RawEventsInRangeTx(tx, lo, hi); err != nil {
+			return nil, err
+		}
+		updated = block.segment(start, end, events)
+	}
+
+	fields := []string{}
+	if moved {
+		fields = append(fields, "ts_start", "ts_end")
+	}
+	if edit.Billable != nil {
+		updated.billable = *edit.Billable
+		fields = append(fields, "billable")
+	}
+	if edit.Notes != nil {
+		updated.notes = edit.Notes
+		fields = append(fields, "notes")
+	}
+	if edit.Description != nil {
+		updated.description = edit.Description
+		fields = append(fields, "description")
+	}
+	if err := updated.save(tx, blockID); err != nil {
+		return nil, err
+	}
+	if moved {
+		if err := updateTitleSummaryTx(tx, blockID); err != nil {
+			return nil, err
+		}
+	}
+
+	if edit.Title != nil {
+		if _, err := tx.Exec(
+			"UPDATE block SET manual_title = ?, title_summary_id = ? WHERE block_id = ?",
+			*edit.Title, titleID, blockID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to update title: %w", err)
+		}
+		fields = append(fields, "title")
+	}
+
+	trimmedIDs := []int64{}
+	for _, n := range trimmed {
+		if err := audit.Track(tx, n.id); err != nil {
+			return nil, err
+		}
+		nStart, nEnd := n.start, n.end
+		if n.start.Before(start) {
+			nEnd = start
+		} else {
+			nStart = end
+		}
+		if err := n.segment(nStart, nEnd, events).save(tx, n.id); err != nil {
+			return nil, err
+		}
+		if err := updateTitleSummaryTx(tx, n.id); err != nil {
+			return nil, err
+		}
+		trimmedIDs = append(trimmedIDs, n.id)
+	}
+
+	if _, err := audit.Commit(tx, map[string]interface{}{
+		"fields":            fields,
+		"trimmed_block_ids": trimmedIDs,
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit edit: %w", err)
+	}
+
+	return trimmedIDs, nil
+}
+
+// overlappingBlocksTx loads the live blocks other than blockID that
+// overlap start to end
+func overlappingBlocksTx(tx *sql.Tx, blockID int64, start, end time.Time) ([]*editableBlock, error) {
+	ids, err := queryBlockIDsTx(tx, `
+		SELECT block_id FROM block
+		WHERE deleted_at IS NULL AND block_id != ? AND ts_start < ? AND ts_end > ?
+		ORDER BY ts_start
+	`, blockID, end.UTC().Format(time.RFC3339), start.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make([]*editableBlock, 0, len(ids))
+	for _, id := range ids {
+		b, err := loadEditableBlock(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// BlockFilter selects live blocks the way the block list does. A date
+// range needs both ends; otherwise Date is used.
+type BlockFilter struct {
+	Date        string `json:"date"`       // YYYY-MM-DD
+	StartDate   string `json:"start_date"` // YYYY-MM-DD, inclusive
+	EndDate     string `json:"end_date"`   // YYYY-MM-DD, inclusive
+	ProfileID   *int64 `json:"profile_id"`
+	Unassigned  bool   `json:"unassigned"`
+	NeedsReview bool   `json:"needs_review"`
+	App         string `json:"app"`    // Process name, e.g. EXCEL.EXE
+	Domain      string `json:"domain"` // e.g. go.xero.com
+}
+
+// Where returns SQL conditions on block alias b, each starting with AND
+func (f BlockFilter) Where() (string, []interface{}) {
+	var sb strings.Builder
+	var args []interface{}
+
+	if f.StartDate != "" && f.EndDate != "" {
+		sb.WriteString(" AND DATE(b.ts_start) >= ? AND DATE(b.ts_start) <= ?")
+		args = append(args, f.StartDate, f.EndDate)
+	} else if f.Date != "" {
+		sb.WriteString(" AND DATE(b.ts_start) = ?")
+		args = append(args, f.Date)
+	}
+	if f.ProfileID != nil {
+		sb.WriteString(" AND b.profile_id = ?")
+		args = append(args, *f.ProfileID)
+	}
+	if f.Unassigned {
+		sb.WriteString(" AND b.profile_id IS NULL")
+	}
+	if f.NeedsReview {
+		sb.WriteString(" AND (b.profile_id IS NULL OR b.confidence = 'LOW')")
+	}
+	if f.App != "" {
+		sb.WriteString(" AND b.primary_app_id IN (SELECT app_id FROM dict_app WHERE app_name = ? COLLATE NOCASE)")
+		args = append(args, f.App)
+	}
+	if f.Domain != "" {
+		sb.WriteString(" AND b.primary_domain_id IN (SELECT domain_id FROM dict_domain WHERE domain_text = ? COLLATE NOCASE)")
+		args = append(args, f.Domain)
+	}
+
+	return sb.String(), args
+}
+
+// Bulk actions
+const (
+	BulkReassign    = "reassign"
+	BulkLock        = "lock"
+	BulkUnlock      = "unlock"
+	BulkBillable    = "billable"
+	BulkDescription = "description"
+	BulkDelete      = "delete"
+)
+
+// BulkAction is one change applied to many blocks
+type BulkAction struct {
+	Action      string
+	ProfileID   *int64  // reassign; nil unassigns
+	Confidence  string  // reassign, HIGH if empty
+	Billable    bool    // billable
+	Description *string // description; nil clears it
+}
+
+// BulkResult is the outcome for one block. Status is updated, unchanged
+// or skipped.
+type BulkResult struct {
+	BlockID int64  `json:"block_id"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BulkUpdateBlocks applies an action to the listed blocks, or with no IDs
+// to the blocks matching filter, in one transaction under one audit entry.
+// Locked blocks are skipped except to lock or unlock them. Reassigning
+// records label events like a single reassign. Returns a result per block
+// and the audit entry ID, 0 if nothing changed.
+func (s *Store) BulkUpdateBlocks(blockIDs []int64, filter *BlockFilter, action BulkAction) ([]BulkResult, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return nil, 0, fmt.Errorf("store not initialized")
+	}
+
+	switch action.Action {
+	case BulkReassign:
+		if action.Confidence == "" {
+			action.Confidence = "HIGH"
+		}
+		if action.Confidence != "HIGH" && action.Confidence != "MEDIUM" && action.Confidence != "LOW" {
+			return nil, 0, fmt.Errorf("confidence must be HIGH, MEDIUM, or LOW")
+		}
+	case BulkLock, BulkUnlock, BulkBillable, BulkDescription, BulkDelete:
+	default:
+		return nil, 0, fmt.Errorf("unknown bulk action %q", action.Action)
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if action.ProfileID != nil {
+		var exists int
+		if err := tx.QueryRow("SELECT 1 FROM profile WHERE profile_id = ?", *action.ProfileID).Scan(&exists); err != nil {
+			return nil, 0, fmt.Errorf("profile %d does not exist", *action.ProfileID)
+		}
+	}
+
+	if len(blockIDs) == 0 {
+		if filter == nil {
+			return nil, 0, fmt.Errorf("block_ids or filter is required")
+		}
+		where, args := filter.Where()
+		if where == "" {
+			return nil, 0, fmt.Errorf("filter must narrow the blocks down")
+		}
+		if blockIDs, err = queryBlockIDsTx(tx, "SELECT b.block_id FROM block b WHERE b.deleted_at IS NULL"+where+" ORDER BY b.ts_start", args...); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	audit := NewBlockAudit(AuditActorUser, AuditBulkUpdate)
+	results := make([]BulkResult, 0, len(blockIDs))
+	seen := make(map[int64]bool)
+	for _, id := range blockIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		var profileID sql.NullInt64
+		var confidence string
+		var locked bool
+		err := tx.QueryRow(
+			"SELECT profile_id, confidence, locked FROM block WHERE block_id = ? AND deleted_at IS NULL", id,
+		).Scan(&profileID, &confidence, &locked)
+		if err == sql.ErrNoRows {
+			results = append(results, BulkResult{BlockID: id, Status: "skipped", Error: "block not found"})
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to load block %d: %w", id, err)
+		}
+		if locked && action.Action != BulkLock && action.Action != BulkUnlock {
+			results = append(results, BulkResult{BlockID: id, Status: "skipped", Error: "block is locked"})
+			continue
+		}
+
+		if err := audit.Track(tx, id); err != nil {
+			return nil, 0, err
+		}
+
+		var res sql.Result
+		switch action.Action {
+		case BulkReassign:
+			res, err = tx.Exec("UPDATE block SET profile_id = ?, confidence = ? WHERE block_id = ? AND (profile_id IS NOT ? OR confidence != ?)",
+				action.ProfileID, action.Confidence, id, action.ProfileID, action.Confidence)
+		case BulkLock, BulkUnlock:
+			res, err = tx.Exec("UPDATE block SET locked = ? WHERE block_id = ? AND locked != ?",
+				action.Action == BulkLock, id, action.Action == BulkLock)
+		case BulkBillable:
+			res, err = tx.Exec("UPDATE block SET billable = ? WHERE block_id = ? AND billable != ?",
+				action.Billable, id, action.Billable)
+		case BulkDescription:
+			res, err = tx.Exec("UPDATE block SET description = ? WHERE block_id = ? AND description IS NOT ?",
+				action.Description, id, action.Description)
+		case BulkDelete:
+			res, err = tx.Exec("UPDATE block SET deleted_at = ? WHERE block_id = ?",
+				time.Now().UTC().Format(time.RFC3339), id)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to update block %d: %w", id, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			results = append(results, BulkResult{BlockID: id, Status: "unchanged"})
+			continue
+		}
+
+		if action.Action == BulkReassign && action.ProfileID != nil {
+			var oldPID *int64
+			if profileID.Valid {
+				oldPID = &profileID.Int64
+			}
+			if err := insertLabelEventTx(tx, id, oldPID, action.ProfileID, confidence, action.Confidence, LabelActionAssign); err != nil {
+				return nil, 0, err
+			}
+		}
+		results = append(results, BulkResult{BlockID: id, Status: "updated"})
+	}
+
+	details := map[string]interface{}{"bulk_action": action.Action}
+	switch action.Action {
+	case BulkReassign:
+		details["new_profile_id"] = action.ProfileID
+		details["new_confidence"] = action.Confidence
+	case BulkBillable:
+		details["billable"] = action.Billable
+	case BulkDescription:
+		details["description"] = action.Description
+	}
+	if filter != nil {
+		details["filter"] = filter
+	}
+
+	auditID, err := audit.Commit(tx, details)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, fmt.Errorf("failed to commit bulk update: %w", err)
+	}
+
+	return results, auditID, nil
+}
+
+// queryBlockIDsTx runs a query returning block IDs
+func queryBlockIDsTx(tx *sql.Tx, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blocks: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan block: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// editableBlock holds the block fields that edits read and rewrite
+type editableBlock struct {
+	id          int64
+	start       time.Time
+	end         time.Time
+	appID       int64
+	profileID   *int64
+	confidence  string
+	billable    bool
+	locked      bool
+	notes       *string
+	description *string
+	metadata    map[string]interface{}
+	score       float64
+	manual      bool
+	titles      []BlockTitle // nil leaves block_title as it is
+}
+
+func loadEditableBlock(tx *sql.Tx, blockID int64) (*editableBlock, error) {
+	var b editableBlock
+	var startStr, endStr string
+	var profileID sql.NullInt64
+	var notes, description, metadata sql.NullString
+
+	err := tx.QueryRow(`
+		SELECT block_id, ts_start, ts_end, primary_app_id, profile_id, confidence,
+		       billable, locked, notes, description, metadata, COALESCE(activity_score, 1.0),
+		       COALESCE(is_manual, 0)
+		FROM block
+		WHERE block_id = ? AND deleted_at IS NULL
+	`, blockID).Scan(&b.id, &startStr, &endStr, &b.appID, &profileID, &b.confidence,
+		&b.billable, &b.locked, &notes, &description, &metadata, &b.score, &b.manual)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("block %d not found", blockID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load block %d: %w", blockID, err)
+	}
+
+	if b.start, err = time.Parse(time.RFC3339, startStr); err != nil {
+		return nil, fmt.Errorf("block %d has an invalid ts_start: %w", blockID, err)
+	}
+	if b.end, err = time.Parse(time.RFC3339, endStr); err != nil {
+		return nil, fmt.Errorf("block %d has an invalid ts_end: %w", blockID, err)
+	}
+	if profileID.Valid {
+		pid := profileID.Int64
+		b.profileID = &pid
+	}
+	if notes.Valid {
+		b.notes = &notes.String
+	}
+	if description.Valid {
+		b.description = &description.String
+	}
+	b.metadata = map[string]interface{}{}
+	if metadata.Valid {
+		json.Unmarshal([]byte(metadata.String), &b.metadata)
+	}
+
+	return &b, nil
+}
+
+// segment returns the part of the block between start and end. The
+// activity score and title timeline come from the block's own events
+// there, if any are retained; interruptions are kept if they began there.
+func (b *editableBlock) segment(start, end time.Time, events []*RawEvent) *editableBlock {
+	part := *b
+	part.start, part.end = start, end
+
+	part.metadata = make(map[string]interface{}, len(b.metadata))
+	for k, v := range b.metadata {
+		part.metadata[k] = v
+	}
+
+	if list, ok := b.metadata["interruptions"].([]interface{}); ok {
+		var kept []interface{}
+		seconds := 0.0
+		for _, item := range list {
+			entry, _ := item.(map[string]interface{})
+			at, err := time.Parse(time.RFC3339, fmt.Sprint(entry["start"]))
+			if err != nil || at.Before(start) || !at.Before(end) {
+				continue
+			}
+			kept = append(kept, entry)
+			if secs, ok := entry["seconds"].(float64); ok {
+				seconds += secs
+			}
+		}
+		delete(part.metadata, "interruptions")
+		delete(part.metadata, "interrupted_seconds")
+		if len(kept) > 0 {
+			part.metadata["interruptions"] = kept
+			part.metadata["interrupted_seconds"] = int(seconds)
+		}
+	}
+
+	var weighted, active float64
+	titleSeconds := make(map[int64]float64)
+	var titleOrder []int64
+	for _, event := range events {
+		if event.AppID != b.appID || event.TsEnd == nil {
+			continue
+		}
+		overlap := minTime(*event.TsEnd, end).Sub(maxTime(event.TsStart, start)).Seconds()
+		if overlap <= 0 {
+			continue
+		}
+
+		if event.TitleID != nil {
+			if _, seen := titleSeconds[*event.TitleID]; !seen {
+				titleOrder = append(titleOrder, *event.TitleID)
+			}
+			titleSeconds[*event.TitleID] += overlap
+		}
+
+		if event.State != "ACTIVE" || event.Metadata == nil {
+			continue
+		}
+		var meta map[string]interface{}
+		if json.Unmarshal([]byte(*event.Metadata), &meta) != nil {
+			continue
+		}
+		if score, ok := meta["activity_score"].(float64); ok {
+			weighted += score * overlap
+			active += overlap
+		}
+	}
+
+	if active > 0 {
+		part.score = weighted / active
+		part.metadata["avg_activity_score"] = math.Round(part.score*100) / 100
+	}
+
+	if len(titleOrder) > 0 {
+		part.titles = make([]BlockTitle, 0, len(titleOrder))
+		for _, id := range titleOrder {
+			part.titles = append(part.titles, BlockTitle{TitleID: id, Seconds: int(titleSeconds[id])})
+		}
+	} else {
+		part.titles = nil
+	}
+
+	return &part
+}
+
+// save writes the block's fields to row id
+func (b *editableBlock) save(tx *sql.Tx, id int64) error {
+	var metadata *string
+	if len(b.metadata) > 0 {
+		data, err := json.Marshal(b.metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata: %w", err)
+		}
+		str := string(data)
+		metadata = &str
+	}
+
+	_, err := tx.Exec(`
+		UPDATE block
+		SET ts_start = ?, ts_end = ?, profile_id = ?, confidence = ?, billable = ?,
+		    notes = ?, description = ?, metadata = ?, activity_score = ?,
+		    updated_at = strftime('%Y-%m-%dT%H:%M:%fZ','now')
+		WHERE block_id = ?
+	`, b.start.UTC().Format(time.RFC3339), b.end.UTC().Format(time.RFC3339), b.profileID, b.confidence,
+		b.billable, b.notes, b.description, metadata, b.score, id)
+	if err != nil {
+		return fmt.Errorf("failed to update block %d: %w", id, err)
+	}
+
+	if b.titles == nil {
+		return nil
+	}
+	if _, err := tx.Exec("DELETE FROM block_title WHERE block_id = ?", id); err != nil {
+		return fmt.Errorf("failed to clear titles for block %d: %w", id, err)
+	}
+	for _, t := range b.titles {
+		_, err := tx.Exec(
+			"INSERT INTO block_title (block_id, title_id, seconds) VALUES (?, ?, ?)",
+			id, t.TitleID, t.Seconds,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert block title: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyBlockTx inserts a copy of a block row and returns the new ID
+func copyBlockTx(tx *sql.Tx, blockID int64) (int64, error) {
+	names, err := columnNames(tx, "block")
+	if err != nil {
+		return 0, err
+	}
+
+	var cols []string
+	for _, name := range names {
+		if name != "block_id" && name != "created_at" && name != "updated_at" {
+			cols = append(cols, name)
+		}
+	}
+	list := strings.Join(cols, ", ")
+
+	res, err := tx.Exec("INSERT INTO block ("+list+") SELECT "+list+" FROM block WHERE block_id = ?", blockID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy block %d: %w", blockID, err)
+	}
+	return res.LastInsertId()
+}
+
+// insertLabelEventTx records a user edit as ML training feedback
+func insertLabelEventTx(tx *sql.Tx, blockID int64, oldProfileID, newProfileID *int64, oldConfidence, newConfidence, action string) error {
+	_, err := tx.Exec(`
+		INSERT INTO ml_label_event (block_id, old_profile_id, new_profile_id, actor, confidence_before, confidence_after, action_type)
+		VALUES (?, ?, ?, 'USER', ?, ?, ?)
+	`, blockID, oldProfileID, newProfileID, oldConfidence, newConfidence, action)
+	if err != nil {
+		return fmt.Errorf("failed to record label event for block %d: %w", blockID, err)
+	}
+	return nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
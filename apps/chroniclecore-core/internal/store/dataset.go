@@ -0,0 +1,524 @@
+package store
+
+import (
+	"archive/zip"
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Dataset archive format: a zip holding manifest.json plus one JSON-lines
+// file per table under tables/. Rows keep their original IDs; the importer
+// remaps them into the target database.
+const (
+	DatasetFormat        = "chroniclecore-dataset"
+	DatasetFormatVersion = 1
+)
+
+// Import modes
+const (
+	ImportModeMerge   = "merge"   // Keep existing data, add what's missing
+	ImportModeReplace = "replace" // Wipe user data first
+)
+
+// DatasetManifest describes an exported dataset
+type DatasetManifest struct {
+	Format        string           `json:"format"`
+	FormatVersion int              `json:"format_version"`
+	InstallID     string           `json:"install_id"`
+	AppVersion    string           `json:"app_version"`
+	SchemaVersion int              `json:"schema_version"`
+	ExportedAt    string           `json:"exported_at"`
+	Tables        map[string]int64 `json:"tables"` // table -> row count
+}
+
+// ImportTableResult counts what happened to one table's rows
+type ImportTableResult struct {
+	Inserted int `json:"inserted"`
+	Matched  int `json:"matched"` // Already present (merge mode)
+	Skipped  int `json:"skipped"` // Unresolvable references
+}
+
+// ImportReport summarizes a dataset import
+type ImportReport struct {
+	Mode     string                        `json:"mode"`
+	Manifest DatasetManifest               `json:"manifest"`
+	Tables   map[string]*ImportTableResult `json:"tables"`
+}
+
+// datasetTable describes how a table is exported and remapped on import
+type datasetTable struct {
+	name  string
+	idCol string            // Primary key; new IDs are recorded for referencing tables
+	refs  map[string]string // Column -> referenced table
+	match []string          // Columns identifying an existing row in merge mode
+
+	// remap rewrites IDs held outside refs columns, e.g. inside JSON
+	remap func(row map[string]interface{}, idMaps map[string]map[int64]int64) error
+}
+
+// datasetTables are listed in dependency order
+var datasetTables = []datasetTable{
+	{name: "dict_app", idCol: "app_id", match: []string{"app_name"}},
+	{name: "dict_title", idCol: "title_id", match: []string{"title_text"}},
+	{name: "dict_domain", idCol: "domain_id", match: []string{"domain_text"}},
+	{name: "raw_event", idCol: "event_id",
+		refs:  map[string]string{"app_id": "dict_app", "title_id": "dict_title", "domain_id": "dict_domain"},
+		match: []string{"ts_start", "app_id", "source"}},
+	{name: "client", idCol: "client_id", match: []string{"name"}},
+	{name: "project", idCol: "project_id",
+		refs:  map[string]string{"client_id": "client"},
+		match: []string{"client_id", "name"}},
+	{name: "service", idCol: "service_id", match: []string{"name"}},
+	{name: "rate", idCol: "rate_id",
+		match: []string{"name", "currency_code", "hourly_minor_units", "effective_from", "effective_to"}},
+	{name: "profile", idCol: "profile_id",
+		refs:  map[string]string{"client_id": "client", "project_id": "project", "service_id": "service", "rate_id": "rate"},
+		match: []string{"client_id", "project_id", "service_id", "rate_id", "name"}},
+	{name: "rule", idCol: "rule_id",
+		refs:  map[string]string{"target_profile_id": "profile", "target_service_id": "service"},
+		match: []string{"name", "match_type", "match_value", "target_profile_id"}},
+	{name: "app_blacklist", idCol: "blacklist_id",
+		refs:  map[string]string{"app_id": "dict_app"},
+		match: []string{"app_id"}},
+	{name: "keyword_blacklist", idCol: "keyword_id", match: []string{"keyword_text"}},
+	{name: "block", idCol: "block_id",
+		refs:  map[string]string{"primary_app_id": "dict_app", "primary_domain_id": "dict_domain", "title_summary_id": "dict_title", "profile_id": "profile"},
+		match: []string{"ts_start", "ts_end", "primary_app_id"},
+		remap: remapBlockMetadata},
+	{name: "block_title", idCol: "block_title_id",
+		refs:  map[string]string{"block_id": "block", "title_id": "dict_title"},
+		match: []string{"block_id", "title_id"}},
+	{name: "ml_label_event", idCol: "label_event_id",
+		refs:  map[string]string{"block_id": "block", "old_profile_id": "profile", "new_profile_id": "profile"},
+		match: []string{"block_id", "ts", "new_profile_id"}},
+	{name: "ml_deletion_event", idCol: "deletion_event_id",
+		match: []string{"app_name", "title_text", "ts_start", "ts_end"}},
+}
+
+// datasetWipeOrder lists tables cleared by a replace import, children first.
+// Suggestions reference the blocks being replaced and aren't exported.
+var datasetWipeOrder = []string{
+	"ml_suggestion", "ml_label_event", "ml_deletion_event", "block_title", "block", "raw_event",
+	"rule_stat", "rule", "app_blacklist", "keyword_blacklist", "profile", "rate", "service",
+	"project", "client", "dict_domain", "dict_title", "dict_app",
+}
+
+// ExportDataset writes every user-data table to w as a zip archive
+func (s *Store) ExportDataset(w io.Writer, appVersion string) (*DatasetManifest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.initialized {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	// Read everything from one transaction so the archive is consistent
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	manifest := &DatasetManifest{
+		Format:        DatasetFormat,
+		FormatVersion: DatasetFormatVersion,
+		AppVersion:    appVersion,
+		ExportedAt:    time.Now().UTC().Format(time.RFC3339),
+		Tables:        make(map[string]int64),
+	}
+
+	if err := tx.QueryRow("SELECT install_id FROM install LIMIT 1").Scan(&manifest.InstallID); err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to read install id: %w", err)
+	}
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&manifest.SchemaVersion); err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	zw := zip.NewWriter(w)
+
+	for _, table := range datasetTables {
+		entry, err := zw.Create("tables/" + table.name + ".jsonl")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create archive entry: %w", err)
+		}
+
+		count, err := exportTable(tx, table, entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", table.name, err)
+		}
+		manifest.Tables[table.name] = count
+	}
+
+	entry, err := zw.Create("manifest.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest: %w", err)
+	}
+	enc := json.NewEncoder(entry)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize archive: %w", err)
+	}
+
+	return manifest, nil
+}
+
+func exportTable(tx *sql.Tx, table datasetTable, w io.Writer) (int64, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT * FROM %s ORDER BY %s", table.name, table.idCol))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	enc := json.NewEncoder(w)
+	var count int64
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return count, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[col] = string(b)
+			} else {
+				row[col] = values[i]
+			}
+		}
+
+		if err := enc.Encode(row); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, rows.Err()
+}
+
+// ImportDataset loads a dataset archive. Dictionary, profile and block IDs
+// are remapped to the target database; in merge mode rows that already
+// exist are reused instead of duplicated. The whole import is one transaction.
+func (s *Store) ImportDataset(r io.ReaderAt, size int64, mode string) (*ImportReport, error) {
+	if mode != ImportModeMerge && mode != ImportModeReplace {
+		return nil, fmt.Errorf("invalid import mode: %s", mode)
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a dataset archive: %w", err)
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	manifest, err := readDatasetManifest(files["manifest.json"])
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.initialized {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if mode == ImportModeReplace {
+		for _, table := range datasetWipeOrder {
+			if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+				return nil, fmt.Errorf("failed to clear %s: %w", table, err)
+			}
+		}
+	}
+
+	report := &ImportReport{
+		Mode:     mode,
+		Manifest: *manifest,
+		Tables:   make(map[string]*ImportTableResult),
+	}
+
+	// Old ID -> new ID, per table
+	idMaps := make(map[string]map[int64]int64)
+
+	for _, table := range datasetTables {
+		result := &ImportTableResult{}
+		report.Tables[table.name] = result
+		idMaps[table.name] = make(map[int64]int64)
+
+		f, ok := files["tables/"+table.name+".jsonl"]
+		if !ok {
+			continue // Older archives may lack newer tables
+		}
+
+		if err := importTable(tx, table, f, idMaps, result); err != nil {
+			return nil, fmt.Errorf("failed to import %s: %w", table.name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit import: %w", err)
+	}
+
+	// Cached dictionary IDs may no longer exist
+	s.dictCache.reset()
+
+	log.Printf("Dataset imported (%s) from install %s", mode, manifest.InstallID)
+
+	return report, nil
+}
+
+func readDatasetManifest(f *zip.File) (*DatasetManifest, error) {
+	if f == nil {
+		return nil, fmt.Errorf("archive has no manifest.json")
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer rc.Close()
+
+	var manifest DatasetManifest
+	if err := json.NewDecoder(rc).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	if manifest.Format != DatasetFormat {
+		return nil, fmt.Errorf("unsupported archive format: %q", manifest.Format)
+	}
+	if manifest.FormatVersion > DatasetFormatVersion {
+		return nil, fmt.Errorf("archive format version %d is newer than supported (%d)", manifest.FormatVersion, DatasetFormatVersion)
+	}
+	if manifest.SchemaVersion > LatestMigrationVersion() {
+		return nil, fmt.Errorf("archive schema version %d is newer than this build (%d)", manifest.SchemaVersion, LatestMigrationVersion())
+	}
+
+	return &manifest, nil
+}
+
+func importTable(tx *sql.Tx, table datasetTable, f *zip.File, idMaps map[string]map[int64]int64, result *ImportTableResult) error {
+	columns, err := columnNames(tx, table.name)
+	if err != nil {
+		return err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	scanner := bufio.NewScanner(rc)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		row, err := decodeDatasetRow(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		oldID, ok := row[table.idCol].(int64)
+		if !ok {
+			return fmt.Errorf("line %d: missing %s", line, table.idCol)
+		}
+
+		// Remap foreign keys; rows pointing at data that wasn't imported are skipped
+		resolved := true
+		for col, refTable := range table.refs {
+			ref, ok := row[col].(int64)
+			if !ok {
+				continue // NULL reference
+			}
+			newRef, found := idMaps[refTable][ref]
+			if !found {
+				resolved = false
+				break
+			}
+			row[col] = newRef
+		}
+		if !resolved {
+			result.Skipped++
+			continue
+		}
+		if table.remap != nil {
+			if err := table.remap(row, idMaps); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+
+		existingID, err := findMatchingRow(tx, table, row)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if existingID != 0 {
+			idMaps[table.name][oldID] = existingID
+			result.Matched++
+			continue
+		}
+
+		var cols []string
+		var placeholders []string
+		var args []interface{}
+		for _, col := range columns {
+			if col == table.idCol {
+				continue
+			}
+			value, ok := row[col]
+			if !ok {
+				continue // Column added after the export; use its default
+			}
+			cols = append(cols, col)
+			placeholders = append(placeholders, "?")
+			args = append(args, value)
+		}
+
+		res, err := tx.Exec(
+			fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table.name, strings.Join(cols, ", "), strings.Join(placeholders, ", ")),
+			args...,
+		)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		newID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		idMaps[table.name][oldID] = newID
+		result.Inserted++
+	}
+
+	return scanner.Err()
+}
+
+// remapBlockMetadata rewrites the dictionary IDs a block's metadata holds:
+// the other domains visited and the apps of interruptions. IDs that weren't
+// imported are dropped.
+func remapBlockMetadata(row map[string]interface{}, idMaps map[string]map[int64]int64) error {
+	raw, ok := row["metadata"].(string)
+	if !ok || raw == "" {
+		return nil
+	}
+
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &meta); err != nil {
+		return nil // Not ours to fix; imported as is
+	}
+
+	if data, ok := meta["domain_ids"]; ok {
+		var ids []int64
+		if err := json.Unmarshal(data, &ids); err == nil {
+			remapped := []int64{}
+			for _, id := range ids {
+				if newID, found := idMaps["dict_domain"][id]; found {
+					remapped = append(remapped, newID)
+				}
+			}
+			meta["domain_ids"], _ = json.Marshal(remapped)
+		}
+	}
+
+	if data, ok := meta["interruptions"]; ok {
+		var list []map[string]interface{}
+		dec := json.NewDecoder(strings.NewReader(string(data)))
+		dec.UseNumber()
+		if err := dec.Decode(&list); err == nil {
+			for _, entry := range list {
+				n, ok := entry["app_id"].(json.Number)
+				if !ok {
+					continue
+				}
+				id, _ := n.Int64()
+				if newID, found := idMaps["dict_app"][id]; found {
+					entry["app_id"] = newID
+				} else {
+					delete(entry, "app_id")
+				}
+			}
+			meta["interruptions"], _ = json.Marshal(list)
+		}
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode block metadata: %w", err)
+	}
+	row["metadata"] = string(data)
+	return nil
+}
+
+// findMatchingRow returns the ID of an existing row equal on the table's
+// match columns, or 0. NULLs compare equal via IS.
+func findMatchingRow(tx *sql.Tx, table datasetTable, row map[string]interface{}) (int64, error) {
+	var conds []string
+	var args []interface{}
+	for _, col := range table.match {
+		conds = append(conds, col+" IS ?")
+		args = append(args, row[col])
+	}
+
+	var id int64
+	err := tx.QueryRow(
+		fmt.Sprintf("SELECT %s FROM %s WHERE %s LIMIT 1", table.idCol, table.name, strings.Join(conds, " AND ")),
+		args...,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+func decodeDatasetRow(data []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+
+	var row map[string]interface{}
+	if err := dec.Decode(&row); err != nil {
+		return nil, err
+	}
+
+	for col, value := range row {
+		if n, ok := value.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				row[col] = i
+			} else if f, err := n.Float64(); err == nil {
+				row[col] = f
+			}
+		}
+	}
+
+	return row, nil
+}
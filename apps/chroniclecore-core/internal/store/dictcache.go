@@ -0,0 +1,240 @@
+package store
+
+import (
+	"container/list"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Dictionary cache bounds. Apps are few; titles grow with every browser tab,
+// so only the working set is kept in memory.
+const (
+	DictCacheAppCapacity    = 1000
+	DictCacheTitleCapacity  = 5000
+	DictCacheDomainCapacity = 2000
+)
+
+// DictCache stores in-memory cache of dictionary tables to avoid lookups
+type DictCache struct {
+	mu      sync.Mutex
+	apps    *lruCache // app_name -> app_id
+	titles  *lruCache // title_text -> title_id
+	domains *lruCache // domain_text -> domain_id
+}
+
+// DictCacheStats reports size and hit rate for one dictionary cache
+type DictCacheStats struct {
+	Size     int    `json:"size"`
+	Capacity int    `json:"capacity"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+func newDictCache() *DictCache {
+	return &DictCache{
+		apps:    newLRUCache(DictCacheAppCapacity),
+		titles:  newLRUCache(DictCacheTitleCapacity),
+		domains: newLRUCache(DictCacheDomainCapacity),
+	}
+}
+
+func (c *DictCache) get(cache *lruCache, key string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cache.get(key)
+}
+
+// getOrCreate returns the ID of text in a dictionary table, inserting it if
+// needed. The cache lock is held from lookup until the ID is cached, so
+// CollectDictGarbage can't delete the row in between.
+func (c *DictCache) getOrCreate(db *sql.DB, cache *lruCache, table, idCol, textCol, text string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Check cache first
+	if id, exists := cache.get(text); exists {
+		return id, nil
+	}
+
+	// Try to get from DB
+	var id int64
+	err := db.QueryRow("SELECT "+idCol+" FROM "+table+" WHERE "+textCol+" = ?", text).Scan(&id)
+	if err == nil {
+		cache.put(text, id)
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to query %s: %w", table, err)
+	}
+
+	// Not found - insert new
+	result, err := db.Exec("INSERT INTO "+table+" ("+textCol+") VALUES (?)", text)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert %s: %w", table, err)
+	}
+	id, err = result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	cache.put(text, id)
+	return id, nil
+}
+
+// reset empties the caches, keeping the counters
+func (c *DictCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apps.clear()
+	c.titles.clear()
+	c.domains.clear()
+}
+
+// DictCacheStats returns cache statistics keyed by dictionary
+func (s *Store) DictCacheStats() map[string]DictCacheStats {
+	c := s.dictCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return map[string]DictCacheStats{
+		"apps":    c.apps.stats(),
+		"titles":  c.titles.stats(),
+		"domains": c.domains.stats(),
+	}
+}
+
+// CollectDictGarbage deletes dict_title and dict_domain rows that no
+// raw_event, block or rule refers to, counting the other domains a block
+// lists in its metadata. IDs held in the cache are kept, since they may
+// have just been handed to a writer that hasn't inserted yet.
+func (s *Store) CollectDictGarbage() (titles int64, domains int64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.initialized {
+		return 0, 0, fmt.Errorf("store not initialized")
+	}
+
+	// Hold the cache lock so no new IDs are handed out mid-collection
+	s.dictCache.mu.Lock()
+	defer s.dictCache.mu.Unlock()
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		DELETE FROM dict_title
+		WHERE NOT EXISTS (SELECT 1 FROM raw_event WHERE title_id = dict_title.title_id)
+		  AND NOT EXISTS (SELECT 1 FROM block WHERE title_summary_id = dict_title.title_id)
+		  AND NOT EXISTS (SELECT 1 FROM block_title WHERE title_id = dict_title.title_id)
+		  AND NOT EXISTS (SELECT 1 FROM rule WHERE match_value = dict_title.title_text)
+		  AND title_id NOT IN (SELECT value FROM json_each(?))
+	`, s.dictCache.titles.idsJSON())
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to collect titles: %w", err)
+	}
+	titles, _ = res.RowsAffected()
+
+	res, err = tx.Exec(`
+		DELETE FROM dict_domain
+		WHERE NOT EXISTS (SELECT 1 FROM raw_event WHERE domain_id = dict_domain.domain_id)
+		  AND NOT EXISTS (SELECT 1 FROM block WHERE primary_domain_id = dict_domain.domain_id)
+		  AND domain_id NOT IN (
+		      SELECT d.value
+		      FROM block b, json_each(CASE WHEN json_valid(b.metadata) THEN b.metadata END, '$.domain_ids') d
+		  )
+		  AND NOT EXISTS (SELECT 1 FROM rule WHERE match_value = dict_domain.domain_text)
+		  AND domain_id NOT IN (SELECT value FROM json_each(?))
+	`, s.dictCache.domains.idsJSON())
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to collect domains: %w", err)
+	}
+	domains, _ = res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit dictionary GC: %w", err)
+	}
+
+	return titles, domains, nil
+}
+
+// lruCache is a bounded string -> id map that evicts the least recently
+// used entry. Not safe for concurrent use; DictCache guards it.
+type lruCache struct {
+	capacity int
+	order    *list.List // front = most recently used
+	items    map[string]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+type lruEntry struct {
+	key string
+	id  int64
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string) (int64, bool) {
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		c.hits++
+		return el.Value.(*lruEntry).id, true
+	}
+	c.misses++
+	return 0, false
+}
+
+func (c *lruCache) put(key string, id int64) {
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruEntry).id = id
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, id: id})
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lruCache) clear() {
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+func (c *lruCache) stats() DictCacheStats {
+	return DictCacheStats{
+		Size:     c.order.Len(),
+		Capacity: c.capacity,
+		Hits:     c.hits,
+		Misses:   c.misses,
+	}
+}
+
+// idsJSON returns the cached IDs as a JSON array for json_each
+func (c *lruCache) idsJSON() string {
+	buf := make([]byte, 0, c.order.Len()*8+2)
+	buf = append(buf, '[')
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		if len(buf) > 1 {
+			buf = append(buf, ',')
+		}
+		buf = strconv.AppendInt(buf, el.Value.(*lruEntry).id, 10)
+	}
+	return string(append(buf, ']'))
+}
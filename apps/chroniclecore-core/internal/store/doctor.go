@@ -0,0 +1,579 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Integrity issue classes
+const (
+	IntegritySQLite             = "SQLITE_INTEGRITY"
+	IntegrityForeignKeys        = "FOREIGN_KEYS"
+	IntegrityBadTimestamps      = "BAD_BLOCK_TIMESTAMPS"
+	IntegrityOverlappingBlocks  = "OVERLAPPING_BLOCKS"
+	IntegrityOpenRawEvents      = "OPEN_RAW_EVENTS"
+	IntegrityInvalidSuggestions = "INVALID_SUGGESTIONS"
+	IntegrityOrphanSuggestions  = "ORPHAN_SUGGESTIONS"
+)
+
+// At most this many examples are listed per issue
+const integritySampleLimit = 20
+
+// IntegrityIssue is the result of one check
+type IntegrityIssue struct {
+	Class       string   `json:"class"`
+	Description string   `json:"description"`
+	Count       int      `json:"count"`
+	Samples     []string `json:"samples,omitempty"`
+	Repairable  bool     `json:"repairable"`
+	Repaired    int      `json:"repaired"`
+	AuditID     *int64   `json:"audit_id,omitempty"` // Audit entry recording the repair
+}
+
+// IntegrityReport is the outcome of a doctor run
+type IntegrityReport struct {
+	CheckedAt string            `json:"checked_at"`
+	Repair    bool              `json:"repair"`
+	Healthy   bool              `json:"healthy"` // Nothing left unrepaired
+	Issues    []*IntegrityIssue `json:"issues"`
+}
+
+func (i *IntegrityIssue) sample(format string, args ...interface{}) {
+	if len(i.Samples) < integritySampleLimit {
+		i.Samples = append(i.Samples, fmt.Sprintf(format, args...))
+	}
+}
+
+// domainCheck inspects (and with repair, fixes) one class of inconsistency
+// inside the doctor's transaction
+type domainCheck struct {
+	class       string
+	description string
+	run         func(tx *sql.Tx, repair bool, issue *IntegrityIssue) error
+}
+
+// Timestamps are repaired before overlaps, which need them parsed; invalid
+// suggestions are removed before orphans so no row counts twice
+var domainChecks = []domainCheck{
+	{IntegrityBadTimestamps, "Live blocks with unparsable timestamps or ts_end before ts_start", checkBlockTimestamps},
+	{IntegrityOverlappingBlocks, "Live blocks overlapping an earlier block", checkOverlappingBlocks},
+	{IntegrityOpenRawEvents, "OS raw events left without ts_end after a later event started", checkOpenRawEvents},
+	{IntegrityInvalidSuggestions, "ML suggestions violating the table's CHECK constraints (e.g. DELETE_SUGGEST)", checkInvalidSuggestions},
+	{IntegrityOrphanSuggestions, "ML suggestions pointing at blocks that no longer exist", checkOrphanSuggestions},
+}
+
+// CheckIntegrity runs the doctor against the live database. Repairs take
+// the write lock; a plain check only blocks other writers for its duration.
+func (s *Store) CheckIntegrity(repair bool) (*IntegrityReport, error) {
+	if repair {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	} else {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+
+	if !s.initialized {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	return CheckIntegrity(s.DB, repair)
+}
+
+// CheckIntegrity runs SQLite's own integrity and foreign key checks, then the
+// domain checks. With repair, each fixable class is corrected in a single
+// transaction and recorded as an INTEGRITY_REPAIR audit entry; block fixes
+// carry snapshots and can be undone.
+func CheckIntegrity(db *sql.DB, repair bool) (*IntegrityReport, error) {
+	report := &IntegrityReport{
+		CheckedAt: time.Now().UTC().Format(time.RFC3339),
+		Repair:    repair,
+		Issues:    []*IntegrityIssue{},
+	}
+
+	sqliteIssue, err := checkSQLiteIntegrity(db)
+	if err != nil {
+		return nil, err
+	}
+	fkIssue, err := checkForeignKeyViolations(db)
+	if err != nil {
+		return nil, err
+	}
+	report.Issues = append(report.Issues, sqliteIssue, fkIssue)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, check := range domainChecks {
+		issue := &IntegrityIssue{Class: check.class, Description: check.description, Repairable: true}
+		if err := check.run(tx, repair, issue); err != nil {
+			return nil, fmt.Errorf("%s check failed: %w", check.class, err)
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	if repair {
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit repairs: %w", err)
+		}
+	}
+
+	report.Healthy = true
+	for _, issue := range report.Issues {
+		if issue.Count > issue.Repaired {
+			report.Healthy = false
+		}
+	}
+
+	return report, nil
+}
+
+// checkSQLiteIntegrity runs PRAGMA integrity_check. CHECK failures in
+// ml_suggestion are left to the domain check, which can repair them.
+func checkSQLiteIntegrity(db *sql.DB) (*IntegrityIssue, error) {
+	issue := &IntegrityIssue{
+		Class:       IntegritySQLite,
+		Description: "PRAGMA integrity_check (restore a snapshot if this fails)",
+	}
+
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run integrity_check: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, fmt.Errorf("failed to scan integrity_check: %w", err)
+		}
+		if line == "ok" || line == "CHECK constraint failed in ml_suggestion" {
+			continue
+		}
+		issue.Count++
+		issue.sample("%s", line)
+	}
+
+	return issue, rows.Err()
+}
+
+func checkForeignKeyViolations(db *sql.DB) (*IntegrityIssue, error) {
+	issue := &IntegrityIssue{
+		Class:       IntegrityForeignKeys,
+		Description: "PRAGMA foreign_key_check",
+	}
+
+	rows, err := db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run foreign_key_check: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowID, &parent, &fkid); err != nil {
+			return nil, fmt.Errorf("failed to scan foreign_key_check: %w", err)
+		}
+		issue.Count++
+		issue.sample("%s row %d references missing %s", table, rowID.Int64, parent)
+	}
+
+	return issue, rows.Err()
+}
+
+// checkBlockTimestamps normalizes timestamps written in other layouts and
+// trashes blocks whose times can't be recovered. Locked blocks are only
+// normalized, never trashed.
+func checkBlockTimestamps(tx *sql.Tx, repair bool, issue *IntegrityIssue) error {
+	type badBlock struct {
+		id         int64
+		start, end string
+		locked     bool
+	}
+
+	rows, err := tx.Query("SELECT block_id, ts_start, ts_end, locked FROM block WHERE deleted_at IS NULL")
+	if err != nil {
+		return fmt.Errorf("failed to query blocks: %w", err)
+	}
+	var bad []badBlock
+	for rows.Next() {
+		var b badBlock
+		if err := rows.Scan(&b.id, &b.start, &b.end, &b.locked); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan block: %w", err)
+		}
+		start, err1 := time.Parse(time.RFC3339, b.start)
+		end, err2 := time.Parse(time.RFC3339, b.end)
+		if err1 != nil || err2 != nil || end.Before(start) {
+			bad = append(bad, b)
+		}
+	}
+	rows.Close()
+
+	issue.Count = len(bad)
+	for _, b := range bad {
+		issue.sample("block %d: %q - %q", b.id, b.start, b.end)
+	}
+	if !repair || len(bad) == 0 {
+		return nil
+	}
+
+	audit := NewBlockAudit(AuditActorSystem, AuditIntegrityRepair)
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	for _, b := range bad {
+		start, ok1 := parseLenientTimestamp(b.start)
+		end, ok2 := parseLenientTimestamp(b.end)
+		recoverable := ok1 && ok2 && !end.Before(start)
+		if !recoverable && b.locked {
+			continue
+		}
+
+		if err := audit.Track(tx, b.id); err != nil {
+			return err
+		}
+		if recoverable {
+			_, err = tx.Exec("UPDATE block SET ts_start = ?, ts_end = ? WHERE block_id = ?",
+				start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339), b.id)
+		} else {
+			_, err = tx.Exec("UPDATE block SET deleted_at = ? WHERE block_id = ?", now, b.id)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to repair block %d: %w", b.id, err)
+		}
+		issue.Repaired++
+	}
+
+	return commitRepairAudit(tx, audit, issue)
+}
+
+// checkOverlappingBlocks walks live blocks in start order. The later block
+// is trimmed to start where the earlier one ends (or trashed if nothing is
+// left); if it is locked or manual, the earlier block is trimmed instead,
+// and split in two when the fixed block sits inside it so the time after
+// it is kept. Overlaps between two such blocks are reported but left alone.
+func checkOverlappingBlocks(tx *sql.Tx, repair bool, issue *IntegrityIssue) error {
+	type span struct {
+		id         int64
+		start, end time.Time
+		fixed      bool // Locked or manual; never trimmed
+	}
+
+	rows, err := tx.Query(`
+		SELECT block_id, ts_start, ts_end, locked, COALESCE(is_manual, 0)
+		FROM block WHERE deleted_at IS NULL
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query blocks: %w", err)
+	}
+	var spans []*span
+	for rows.Next() {
+		var id int64
+		var startStr, endStr string
+		var locked, manual bool
+		if err := rows.Scan(&id, &startStr, &endStr, &locked, &manual); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan block: %w", err)
+		}
+		start, err1 := time.Parse(time.RFC3339, startStr)
+		end, err2 := time.Parse(time.RFC3339, endStr)
+		if err1 != nil || err2 != nil || end.Before(start) {
+			continue // Reported by the timestamp check
+		}
+		spans = append(spans, &span{id: id, start: start, end: end, fixed: locked || manual})
+	}
+	rows.Close()
+
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].start.Equal(spans[j].start) {
+			return spans[i].id < spans[j].id
+		}
+		return spans[i].start.Before(spans[j].start)
+	})
+
+	audit := NewBlockAudit(AuditActorSystem, AuditIntegrityRepair)
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	trash := func(id int64) error {
+		if err := audit.Track(tx, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec("UPDATE block SET deleted_at = ? WHERE block_id = ?", now, id)
+		return err
+	}
+	trim := func(id int64, column string, ts time.Time) error {
+		if err := audit.Track(tx, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec("UPDATE block SET "+column+" = ? WHERE block_id = ?", ts.UTC().Format(time.RFC3339), id)
+		return err
+	}
+	// split copies the part of a block from start on into a new block,
+	// queued in start order so it is checked like any other
+	split := func(from int, block *span, start time.Time) error {
+		id, err := copyBlockTx(tx, block.id)
+		if err != nil {
+			return err
+		}
+		audit.Created(id)
+		if _, err := tx.Exec("UPDATE block SET ts_start = ? WHERE block_id = ?", start.UTC().Format(time.RFC3339), id); err != nil {
+			return err
+		}
+		tail := &span{id: id, start: start, end: block.end}
+		at := from + sort.Search(len(spans)-from, func(k int) bool {
+			return spans[from+k].start.After(start)
+		})
+		spans = append(spans, nil)
+		copy(spans[at+1:], spans[at:])
+		spans[at] = tail
+		return nil
+	}
+
+	// frontier is the block reaching furthest so far
+	var frontier *span
+	for i := 0; i < len(spans); i++ {
+		cur := spans[i]
+		if frontier == nil || !cur.start.Before(frontier.end) {
+			frontier = cur
+			continue
+		}
+
+		issue.Count++
+		issue.sample("block %d overlaps block %d by %s", cur.id, frontier.id, minTime(cur.end, frontier.end).Sub(cur.start))
+
+		if !repair {
+			if cur.end.After(frontier.end) {
+				frontier = cur
+			}
+			continue
+		}
+
+		switch {
+		case !cur.fixed:
+			if !cur.end.After(frontier.end) {
+				if err := trash(cur.id); err != nil {
+					return fmt.Errorf("failed to trash block %d: %w", cur.id, err)
+				}
+			} else {
+				cur.start = frontier.end
+				if err := trim(cur.id, "ts_start", cur.start); err != nil {
+					return fmt.Errorf("failed to trim block %d: %w", cur.id, err)
+				}
+				frontier = cur
+			}
+			issue.Repaired++
+
+		case !frontier.fixed:
+			if frontier.end.After(cur.end) {
+				if err := split(i+1, frontier, cur.end); err != nil {
+					return fmt.Errorf("failed to split block %d: %w", frontier.id, err)
+				}
+			}
+			if !frontier.start.Before(cur.start) {
+				if err := trash(frontier.id); err != nil {
+					return fmt.Errorf("failed to trash block %d: %w", frontier.id, err)
+				}
+			} else {
+				frontier.end = cur.start
+				if err := trim(frontier.id, "ts_end", frontier.end); err != nil {
+					return fmt.Errorf("failed to trim block %d: %w", frontier.id, err)
+				}
+			}
+			frontier = cur
+			issue.Repaired++
+
+		default:
+			if cur.end.After(frontier.end) {
+				frontier = cur
+			}
+		}
+	}
+
+	if !repair {
+		return nil
+	}
+	return commitRepairAudit(tx, audit, issue)
+}
+
+// checkOpenRawEvents closes OS events the tracker never finished (e.g. after
+// a crash) at the start of the next OS event. The newest open event is the
+// one currently being tracked and is left alone. Extension events are
+// point-in-time and legitimately have no ts_end.
+func checkOpenRawEvents(tx *sql.Tx, repair bool, issue *IntegrityIssue) error {
+	rows, err := tx.Query(`
+		SELECT event_id, ts_start, next_start FROM (
+			SELECT re.event_id, re.ts_start,
+			       (SELECT MIN(n.ts_start) FROM raw_event n
+			         WHERE n.source = 'OS' AND n.ts_start > re.ts_start) AS next_start
+			FROM raw_event re
+			WHERE re.source = 'OS' AND re.ts_end IS NULL
+		)
+		WHERE next_start IS NOT NULL
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query open raw events: %w", err)
+	}
+	type openEvent struct {
+		id               int64
+		start, nextStart string
+	}
+	var open []openEvent
+	for rows.Next() {
+		var e openEvent
+		if err := rows.Scan(&e.id, &e.start, &e.nextStart); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan raw_event: %w", err)
+		}
+		open = append(open, e)
+	}
+	rows.Close()
+
+	issue.Count = len(open)
+	for _, e := range open {
+		issue.sample("event %d started %s, next event %s", e.id, e.start, e.nextStart)
+	}
+	if !repair || len(open) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(open))
+	for _, e := range open {
+		if _, err := tx.Exec("UPDATE raw_event SET ts_end = ? WHERE event_id = ?", e.nextStart, e.id); err != nil {
+			return fmt.Errorf("failed to close raw_event %d: %w", e.id, err)
+		}
+		ids = append(ids, e.id)
+		issue.Repaired++
+	}
+
+	auditID, err := insertAuditLog(tx, AuditActorSystem, AuditIntegrityRepair, map[string]interface{}{
+		"issue":     issue.Class,
+		"event_ids": ids,
+	})
+	if err != nil {
+		return err
+	}
+	issue.AuditID = &auditID
+	return nil
+}
+
+// Allowed values from the ml_suggestion CHECK constraints
+const validSuggestionFilter = `
+	entity_type IN ('BLOCK', 'SESSION', 'RULE')
+	AND suggestion_type IN ('PROFILE_ASSIGN', 'MERGE_BLOCKS', 'CREATE_RULE')
+	AND status IN ('PENDING', 'ACCEPTED', 'REJECTED', 'EXPIRED')
+	AND confidence >= 0.0 AND confidence <= 1.0`
+
+// checkInvalidSuggestions deletes suggestion rows that break the table's
+// CHECK constraints. They can't be updated in place, since any UPDATE
+// re-checks the row.
+func checkInvalidSuggestions(tx *sql.Tx, repair bool, issue *IntegrityIssue) error {
+	return checkSuggestions(tx, repair, issue, "NOT ("+validSuggestionFilter+")")
+}
+
+// checkOrphanSuggestions deletes suggestions whose block has been purged
+func checkOrphanSuggestions(tx *sql.Tx, repair bool, issue *IntegrityIssue) error {
+	return checkSuggestions(tx, repair, issue, validSuggestionFilter+`
+		AND entity_type = 'BLOCK'
+		AND NOT EXISTS (SELECT 1 FROM block b WHERE b.block_id = ml_suggestion.entity_id)`)
+}
+
+func checkSuggestions(tx *sql.Tx, repair bool, issue *IntegrityIssue, where string) error {
+	rows, err := tx.Query(`
+		SELECT suggestion_id, entity_type, entity_id, suggestion_type, status, payload_json
+		FROM ml_suggestion WHERE ` + where)
+	if err != nil {
+		return fmt.Errorf("failed to query suggestions: %w", err)
+	}
+
+	// Deleted rows are kept in the audit entry in case they're wanted back
+	var ids []int64
+	var deleted []map[string]interface{}
+	for rows.Next() {
+		var id, entityID int64
+		var entityType, suggestionType, status, payload string
+		if err := rows.Scan(&id, &entityType, &entityID, &suggestionType, &status, &payload); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan suggestion: %w", err)
+		}
+		ids = append(ids, id)
+		deleted = append(deleted, map[string]interface{}{
+			"suggestion_id":   id,
+			"entity_type":     entityType,
+			"entity_id":       entityID,
+			"suggestion_type": suggestionType,
+			"status":          status,
+			"payload_json":    payload,
+		})
+		issue.sample("suggestion %d (%s %s) for %s %d", id, suggestionType, status, strings.ToLower(entityType), entityID)
+	}
+	rows.Close()
+
+	issue.Count = len(ids)
+	if !repair || len(ids) == 0 {
+		return nil
+	}
+
+	for _, id := range ids {
+		if _, err := tx.Exec("DELETE FROM ml_suggestion WHERE suggestion_id = ?", id); err != nil {
+			return fmt.Errorf("failed to delete suggestion %d: %w", id, err)
+		}
+		issue.Repaired++
+	}
+
+	auditID, err := insertAuditLog(tx, AuditActorSystem, AuditIntegrityRepair, map[string]interface{}{
+		"issue":       issue.Class,
+		"suggestions": deleted,
+	})
+	if err != nil {
+		return err
+	}
+	issue.AuditID = &auditID
+	return nil
+}
+
+func commitRepairAudit(tx *sql.Tx, audit *BlockAudit, issue *IntegrityIssue) error {
+	auditID, err := audit.Commit(tx, map[string]interface{}{"issue": issue.Class})
+	if err != nil {
+		return err
+	}
+	if auditID != 0 {
+		issue.AuditID = &auditID
+	}
+	return nil
+}
+
+// Layouts seen in older rows: Go's time.String via the driver, SQLite's
+// datetime(), and ISO without a zone (taken as UTC)
+var lenientTimestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+}
+
+func parseLenientTimestamp(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range lenientTimestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
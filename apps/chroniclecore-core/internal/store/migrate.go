@@ -0,0 +1,478 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+
+	// DisableForeignKeys runs the migration with foreign_keys OFF, which SQLite
+	// requires when a referenced table is rebuilt. foreign_key_check still runs
+	// before commit.
+	DisableForeignKeys bool
+
+	// Skip reports whether the change is already present. Databases created
+	// from a newer spec/schema.sql already contain most columns, so the
+	// migration is recorded as applied without running it.
+	Skip func(tx *sql.Tx) (bool, error)
+}
+
+// Checksum returns the SHA-256 of the up script (line endings normalised so
+// Windows checkouts hash the same)
+func (m *Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(strings.ReplaceAll(m.Up, "\r\n", "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrationState describes a migration as seen by a particular database
+type MigrationState struct {
+	Version   int     `json:"version"`
+	Name      string  `json:"name"`
+	Status    string  `json:"status"` // APPLIED, PENDING, CHECKSUM_MISMATCH, UNKNOWN
+	AppliedAt *string `json:"applied_at,omitempty"`
+}
+
+// migrationRegistry lists every migration in order. SQL lives in
+// migrations/NNN_name.up.sql and migrations/NNN_name.down.sql.
+var migrationRegistry = []Migration{
+	{Version: 1, Name: "currency_code", DisableForeignKeys: true, Skip: columnMissing("rate", "currency")},
+	{Version: 2, Name: "event_metadata", Skip: allOf(columnsExist("block", "metadata"), columnsExist("raw_event", "metadata"))},
+	{Version: 3, Name: "ml_pipeline"},
+	{Version: 4, Name: "app_blacklist"},
+	{Version: 5, Name: "manual_entries", Skip: columnsExist("block", "is_manual", "manual_title", "action_type", "entity_context")},
+	{Version: 6, Name: "keyword_blacklist"},
+	{Version: 7, Name: "label_action_type", Skip: columnsExist("ml_label_event", "action_type")},
+	{Version: 8, Name: "ml_deletion_event"},
+	{Version: 9, Name: "profile_name", Skip: columnsExist("profile", "name")},
+	{Version: 10, Name: "block_activity_score", Skip: columnsExist("block", "activity_score")},
+	{Version: 11, Name: "block_trash", Skip: columnsExist("block", "deleted_at")},
+	{Version: 12, Name: "raw_event_rehydrated", Skip: columnsExist("raw_event", "rehydrated_at")},
+	{Version: 13, Name: "block_title"},
+	{Version: 14, Name: "rule_stats"},
+}
+
+// Migrations returns the registered migrations with their SQL loaded
+func Migrations() ([]Migration, error) {
+	migrations := make([]Migration, 0, len(migrationRegistry))
+	for i, m := range migrationRegistry {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %s has version %d, expected %d", m.Name, m.Version, i+1)
+		}
+
+		base := fmt.Sprintf("migrations/%03d_%s", m.Version, m.Name)
+		up, err := migrationFS.ReadFile(base + ".up.sql")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %03d: %w", m.Version, err)
+		}
+		m.Up = string(up)
+
+		// Down scripts are optional; a missing one makes the migration irreversible
+		if down, err := migrationFS.ReadFile(base + ".down.sql"); err == nil {
+			m.Down = string(down)
+		}
+
+		migrations = append(migrations, m)
+	}
+	return migrations, nil
+}
+
+// LatestMigrationVersion returns the schema version this build expects
+func LatestMigrationVersion() int {
+	return len(migrationRegistry)
+}
+
+// ApplyMigrations runs all pending migrations, each in its own transaction.
+// It stops at the first failure and returns the number applied before it.
+func ApplyMigrations(db *sql.DB) (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if err := ensureMigrationTable(ctx, conn); err != nil {
+		return 0, err
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if err := verifyApplied(migrations, applied); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := range migrations {
+		m := &migrations[i]
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		skipped, err := runMigration(ctx, conn, m, true)
+		if err != nil {
+			return count, fmt.Errorf("migration %03d_%s failed: %w", m.Version, m.Name, err)
+		}
+
+		if skipped {
+			log.Printf("Migration %03d_%s already present, recorded", m.Version, m.Name)
+		} else {
+			log.Printf("Applied migration %03d_%s", m.Version, m.Name)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// RollbackMigrations reverts the most recent steps migrations, newest first
+func RollbackMigrations(db *sql.DB, steps int) (int, error) {
+	if steps < 1 {
+		return 0, fmt.Errorf("steps must be at least 1")
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if err := ensureMigrationTable(ctx, conn); err != nil {
+		return 0, err
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if err := verifyApplied(migrations, applied); err != nil {
+		return 0, err
+	}
+
+	// Search triggers reference block columns that down scripts may drop
+	if err := invalidateSearchIndex(ctx, conn); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := &migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return count, fmt.Errorf("migration %03d_%s is irreversible", m.Version, m.Name)
+		}
+
+		if _, err := runMigration(ctx, conn, m, false); err != nil {
+			return count, fmt.Errorf("rollback of %03d_%s failed: %w", m.Version, m.Name, err)
+		}
+
+		log.Printf("Rolled back migration %03d_%s", m.Version, m.Name)
+		count++
+	}
+
+	return count, nil
+}
+
+// GetMigrationStatus reports every known migration plus any applied version
+// this build doesn't know about
+func GetMigrationStatus(db *sql.DB) ([]MigrationState, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if err := ensureMigrationTable(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name, Status: "PENDING"}
+		if rec, ok := applied[m.Version]; ok {
+			appliedAt := rec.appliedAt
+			state.AppliedAt = &appliedAt
+			state.Status = "APPLIED"
+			if rec.checksum != m.Checksum() {
+				state.Status = "CHECKSUM_MISMATCH"
+			}
+			delete(applied, m.Version)
+		}
+		states = append(states, state)
+	}
+
+	for version, rec := range applied {
+		appliedAt := rec.appliedAt
+		states = append(states, MigrationState{
+			Version:   version,
+			Name:      rec.name,
+			Status:    "UNKNOWN",
+			AppliedAt: &appliedAt,
+		})
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt string
+}
+
+func ensureMigrationTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+		  version     INTEGER PRIMARY KEY,
+		  name        TEXT NOT NULL,
+		  checksum    TEXT NOT NULL,
+		  applied_at  TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var rec appliedMigration
+		if err := rows.Scan(&version, &rec.name, &rec.checksum, &rec.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[version] = rec
+	}
+	return applied, rows.Err()
+}
+
+// verifyApplied refuses to touch a database whose history doesn't match this
+// build: an edited migration or a schema from a newer version
+func verifyApplied(migrations []Migration, applied map[int]appliedMigration) error {
+	known := make(map[int]*Migration, len(migrations))
+	for i := range migrations {
+		known[migrations[i].Version] = &migrations[i]
+	}
+
+	for version, rec := range applied {
+		m, ok := known[version]
+		if !ok {
+			return fmt.Errorf("database has migration %03d_%s which this build doesn't know (schema is newer than the application)", version, rec.name)
+		}
+		if rec.checksum != m.Checksum() {
+			return fmt.Errorf("checksum mismatch for migration %03d_%s: it was modified after being applied", version, m.Name)
+		}
+	}
+	return nil
+}
+
+// runMigration executes one direction of a migration inside a transaction on
+// a dedicated connection. Returns true if an up migration was skipped.
+func runMigration(ctx context.Context, conn *sql.Conn, m *Migration, up bool) (bool, error) {
+	if m.DisableForeignKeys {
+		// PRAGMA foreign_keys is a no-op inside a transaction
+		var fkEnabled int
+		if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&fkEnabled); err != nil {
+			return false, fmt.Errorf("failed to read foreign_keys: %w", err)
+		}
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return false, fmt.Errorf("failed to disable foreign keys: %w", err)
+		}
+		defer conn.ExecContext(ctx, fmt.Sprintf("PRAGMA foreign_keys = %d", fkEnabled))
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	skipped := false
+	if up {
+		if m.Skip != nil {
+			if skipped, err = m.Skip(tx); err != nil {
+				return false, fmt.Errorf("failed to inspect schema: %w", err)
+			}
+		}
+
+		if !skipped {
+			if _, err := tx.Exec(m.Up); err != nil {
+				return false, err
+			}
+		}
+
+		_, err = tx.Exec(
+			"INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+			m.Version, m.Name, m.Checksum(), time.Now().UTC().Format(time.RFC3339),
+		)
+		if err != nil {
+			return false, fmt.Errorf("failed to record migration: %w", err)
+		}
+	} else {
+		if _, err := tx.Exec(m.Down); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+			return false, fmt.Errorf("failed to remove migration record: %w", err)
+		}
+	}
+
+	if m.DisableForeignKeys {
+		if err := checkForeignKeys(tx); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit: %w", err)
+	}
+
+	return skipped, nil
+}
+
+func checkForeignKeys(tx *sql.Tx) error {
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("failed to run foreign_key_check: %w", err)
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var table string
+		var rowID sql.NullInt64
+		var parent string
+		var fkid int
+		if err := rows.Scan(&table, &rowID, &parent, &fkid); err != nil {
+			return fmt.Errorf("failed to scan foreign_key_check: %w", err)
+		}
+		return fmt.Errorf("foreign key violation: %s row %d references missing %s", table, rowID.Int64, parent)
+	}
+	return rows.Err()
+}
+
+// columnNames returns a table's columns in declaration order (empty if it
+// doesn't exist)
+func columnNames(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var cid int
+		var name, dataType string
+		var notNull, dfltValue, pk interface{}
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &dfltValue, &pk); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// tableColumns returns the column names of a table as a set
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	names, err := columnNames(tx, table)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]bool, len(names))
+	for _, name := range names {
+		columns[name] = true
+	}
+	return columns, nil
+}
+
+// columnsExist skips a migration when every listed column is already present
+func columnsExist(table string, names ...string) func(tx *sql.Tx) (bool, error) {
+	return func(tx *sql.Tx) (bool, error) {
+		columns, err := tableColumns(tx, table)
+		if err != nil {
+			return false, err
+		}
+		for _, name := range names {
+			if !columns[name] {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
+
+// columnMissing skips a migration when the column it rewrites is absent
+func columnMissing(table, name string) func(tx *sql.Tx) (bool, error) {
+	return func(tx *sql.Tx) (bool, error) {
+		columns, err := tableColumns(tx, table)
+		if err != nil {
+			return false, err
+		}
+		return !columns[name], nil
+	}
+}
+
+// allOf skips a migration only when every check does
+func allOf(checks ...func(tx *sql.Tx) (bool, error)) func(tx *sql.Tx) (bool, error) {
+	return func(tx *sql.Tx) (bool, error) {
+		for _, check := range checks {
+			ok, err := check(tx)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+}
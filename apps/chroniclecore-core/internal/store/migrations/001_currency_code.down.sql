@@ -0,0 +1,32 @@
+-- Migration: Revert currency_code to currency in rate table
+
+CREATE TABLE IF NOT EXISTS rate_old (
+  rate_id            INTEGER PRIMARY KEY,
+  name               TEXT NOT NULL,
+  currency           TEXT NOT NULL DEFAULT 'USD',
+  hourly_minor_units INTEGER NOT NULL,
+  effective_from     TEXT,
+  effective_to       TEXT,
+  is_active          INTEGER NOT NULL DEFAULT 1 CHECK (is_active IN (0,1)),
+  created_at         TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+  updated_at         TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))
+);
+
+INSERT INTO rate_old (rate_id, name, currency, hourly_minor_units, effective_from, effective_to, is_active, created_at, updated_at)
+SELECT rate_id, name, currency_code, hourly_minor_units, effective_from, effective_to, is_active, created_at, updated_at
+FROM rate;
+
+DROP TABLE rate;
+
+ALTER TABLE rate_old RENAME TO rate;
+
+CREATE TRIGGER IF NOT EXISTS trg_rate_updated_at
+AFTER UPDATE ON rate
+FOR EACH ROW
+BEGIN
+  UPDATE rate
+     SET updated_at = (strftime('%Y-%m-%dT%H:%M:%fZ','now'))
+   WHERE rate_id = OLD.rate_id;
+END;
+
+CREATE INDEX IF NOT EXISTS idx_rate_active ON rate(is_active);
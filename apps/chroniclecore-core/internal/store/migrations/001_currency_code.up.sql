@@ -4,9 +4,8 @@
 -- Date: 2026-01-09
 
 -- SQLite doesn't support direct column rename in all versions,
--- so we need to recreate the table
-
-BEGIN TRANSACTION;
+-- so we need to recreate the table. The runner wraps this file in a
+-- transaction with foreign keys disabled.
 
 -- Create new rate table with currency_code
 CREATE TABLE IF NOT EXISTS rate_new (
@@ -53,5 +52,3 @@ END;
 
 -- Create indexes if needed
 CREATE INDEX IF NOT EXISTS idx_rate_active ON rate(is_active);
-
-COMMIT;
@@ -0,0 +1,2 @@
+ALTER TABLE raw_event DROP COLUMN metadata;
+ALTER TABLE block DROP COLUMN metadata;
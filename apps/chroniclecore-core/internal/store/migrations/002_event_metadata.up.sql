@@ -0,0 +1,4 @@
+-- Migration: Add JSON metadata to block and raw_event (1.5.0)
+
+ALTER TABLE block ADD COLUMN metadata TEXT;
+ALTER TABLE raw_event ADD COLUMN metadata TEXT;
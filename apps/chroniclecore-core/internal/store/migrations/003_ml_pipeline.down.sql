@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS ml_run_log;
+DROP TABLE IF EXISTS ml_suggestion;
+DROP TABLE IF EXISTS ml_label_event;
+DROP TABLE IF EXISTS ml_model_registry;
@@ -0,0 +1,63 @@
+-- Migration: ML pipeline tables (1.5.0)
+
+CREATE TABLE IF NOT EXISTS ml_model_registry (
+  model_id          INTEGER PRIMARY KEY,
+  model_type        TEXT NOT NULL CHECK (model_type IN ('PROFILE_CLASSIFIER', 'SESSION_CLUSTERER')),
+  version           TEXT NOT NULL,
+  algorithm         TEXT NOT NULL,
+  metrics_json      TEXT,
+  status            TEXT NOT NULL DEFAULT 'ACTIVE' CHECK (status IN ('ACTIVE', 'ARCHIVED', 'FAILED')),
+  trained_at        TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+  trained_samples   INTEGER NOT NULL DEFAULT 0,
+  created_at        TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))
+);
+
+CREATE TABLE IF NOT EXISTS ml_label_event (
+  label_event_id    INTEGER PRIMARY KEY,
+  block_id          INTEGER NOT NULL,
+  old_profile_id    INTEGER,
+  new_profile_id    INTEGER,
+  actor             TEXT NOT NULL DEFAULT 'USER',
+  confidence_before TEXT,
+  confidence_after  TEXT,
+  ts                TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+  FOREIGN KEY (block_id) REFERENCES block(block_id) ON DELETE CASCADE,
+  FOREIGN KEY (old_profile_id) REFERENCES profile(profile_id) ON DELETE SET NULL,
+  FOREIGN KEY (new_profile_id) REFERENCES profile(profile_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ml_label_block ON ml_label_event (block_id);
+CREATE INDEX IF NOT EXISTS idx_ml_label_ts ON ml_label_event (ts);
+
+CREATE TABLE IF NOT EXISTS ml_suggestion (
+  suggestion_id     INTEGER PRIMARY KEY,
+  entity_type       TEXT NOT NULL CHECK (entity_type IN ('BLOCK', 'SESSION', 'RULE')),
+  entity_id         INTEGER NOT NULL,
+  suggestion_type   TEXT NOT NULL CHECK (suggestion_type IN ('PROFILE_ASSIGN', 'MERGE_BLOCKS', 'CREATE_RULE')),
+  payload_json      TEXT NOT NULL,
+  confidence        REAL NOT NULL CHECK (confidence >= 0.0 AND confidence <= 1.0),
+  model_id          INTEGER,
+  status            TEXT NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'ACCEPTED', 'REJECTED', 'EXPIRED')),
+  created_at        TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+  resolved_at       TEXT,
+  FOREIGN KEY (model_id) REFERENCES ml_model_registry(model_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ml_suggestion_entity ON ml_suggestion (entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS idx_ml_suggestion_status ON ml_suggestion (status, confidence DESC);
+
+CREATE TABLE IF NOT EXISTS ml_run_log (
+  run_id            INTEGER PRIMARY KEY,
+  run_type          TEXT NOT NULL CHECK (run_type IN ('TRAIN', 'PREDICT', 'CLUSTER', 'RETRAIN')),
+  model_id          INTEGER,
+  success           INTEGER NOT NULL DEFAULT 0 CHECK (success IN (0,1)),
+  error_summary     TEXT,
+  duration_ms       INTEGER,
+  input_samples     INTEGER,
+  output_count      INTEGER,
+  triggered_by      TEXT NOT NULL DEFAULT 'SYSTEM',
+  ts                TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+  FOREIGN KEY (model_id) REFERENCES ml_model_registry(model_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ml_run_type_ts ON ml_run_log (run_type, ts);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_app_blacklist_app;
+DROP TABLE IF EXISTS app_blacklist;
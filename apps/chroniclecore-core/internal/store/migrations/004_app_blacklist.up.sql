@@ -0,0 +1,11 @@
+-- Migration: App blacklist (1.6.0)
+
+CREATE TABLE IF NOT EXISTS app_blacklist (
+  blacklist_id    INTEGER PRIMARY KEY,
+  app_id          INTEGER NOT NULL UNIQUE,
+  reason          TEXT,
+  created_at      TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+  FOREIGN KEY (app_id) REFERENCES dict_app(app_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_app_blacklist_app ON app_blacklist (app_id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_block_is_manual;
+
+ALTER TABLE block DROP COLUMN entity_context;
+ALTER TABLE block DROP COLUMN action_type;
+ALTER TABLE block DROP COLUMN manual_title;
+ALTER TABLE block DROP COLUMN is_manual;
@@ -0,0 +1,8 @@
+-- Migration: Manual entries and enhanced activity tracking (1.7.0)
+
+ALTER TABLE block ADD COLUMN is_manual INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE block ADD COLUMN manual_title TEXT;
+ALTER TABLE block ADD COLUMN action_type TEXT;
+ALTER TABLE block ADD COLUMN entity_context TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_block_is_manual ON block (is_manual);
@@ -0,0 +1,8 @@
+-- Migration: Keyword blacklist (1.8.0)
+
+CREATE TABLE IF NOT EXISTS keyword_blacklist (
+  keyword_id      INTEGER PRIMARY KEY,
+  keyword_text    TEXT NOT NULL UNIQUE,
+  reason          TEXT,
+  created_at      TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))
+);
@@ -0,0 +1 @@
+ALTER TABLE ml_label_event DROP COLUMN action_type;
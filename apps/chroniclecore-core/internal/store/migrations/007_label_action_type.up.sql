@@ -0,0 +1,3 @@
+-- Migration: ML deletion learning - label event action type (1.8.1)
+
+ALTER TABLE ml_label_event ADD COLUMN action_type TEXT DEFAULT 'ASSIGN';
@@ -0,0 +1,14 @@
+-- Migration: ML deletion learning (1.8.1)
+-- SQLite can't alter the ml_suggestion CHECK constraint, so deletions used
+-- for training are tracked in their own table.
+
+CREATE TABLE IF NOT EXISTS ml_deletion_event (
+  deletion_event_id INTEGER PRIMARY KEY,
+  app_name          TEXT NOT NULL,
+  title_text        TEXT,
+  domain_text       TEXT,
+  ts_start          TEXT,
+  ts_end            TEXT,
+  actor             TEXT NOT NULL DEFAULT 'USER',
+  created_at        TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))
+);
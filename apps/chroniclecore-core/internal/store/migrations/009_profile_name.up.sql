@@ -0,0 +1,3 @@
+-- Migration: Profile name field (1.8.9)
+
+ALTER TABLE profile ADD COLUMN name TEXT;
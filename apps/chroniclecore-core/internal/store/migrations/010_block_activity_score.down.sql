@@ -0,0 +1 @@
+ALTER TABLE block DROP COLUMN activity_score;
@@ -0,0 +1,4 @@
+-- Migration: Activity-weighted billing (1.8.11)
+-- Score is 0.0-1.0 representing percentage of time user was actively working
+
+ALTER TABLE block ADD COLUMN activity_score REAL DEFAULT 1.0;
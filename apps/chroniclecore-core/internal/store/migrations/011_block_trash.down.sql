@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_block_deleted_at;
+
+ALTER TABLE block DROP COLUMN deleted_at;
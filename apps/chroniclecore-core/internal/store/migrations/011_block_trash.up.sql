@@ -0,0 +1,7 @@
+-- Migration: Block trash bin
+-- Deleted blocks keep their row with deleted_at set until purged, so a
+-- mis-click can be restored. NULL means the block is live.
+
+ALTER TABLE block ADD COLUMN deleted_at TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_block_deleted_at ON block(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE raw_event DROP COLUMN rehydrated_at;
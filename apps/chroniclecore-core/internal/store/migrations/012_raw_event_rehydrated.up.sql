@@ -0,0 +1,6 @@
+-- Migration: Raw event archive
+-- Expired raw events are written to monthly archive files before deletion.
+-- Rows rehydrated from an archive are marked so they are not archived twice
+-- and are kept for a grace period before being dropped again.
+
+ALTER TABLE raw_event ADD COLUMN rehydrated_at TEXT;
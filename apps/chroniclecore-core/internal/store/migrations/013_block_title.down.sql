@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_block_title_title;
+DROP TABLE IF EXISTS block_title;
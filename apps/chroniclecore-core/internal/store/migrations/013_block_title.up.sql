@@ -0,0 +1,15 @@
+-- Migration: Block title timeline
+-- Time spent on each window title within a block. The block's
+-- title_summary_id is the title with the most time.
+
+CREATE TABLE IF NOT EXISTS block_title (
+  block_title_id  INTEGER PRIMARY KEY,
+  block_id        INTEGER NOT NULL,
+  title_id        INTEGER NOT NULL,
+  seconds         INTEGER NOT NULL DEFAULT 0,
+  UNIQUE (block_id, title_id),
+  FOREIGN KEY (block_id) REFERENCES block(block_id) ON DELETE CASCADE,
+  FOREIGN KEY (title_id) REFERENCES dict_title(title_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_block_title_title ON block_title (title_id);
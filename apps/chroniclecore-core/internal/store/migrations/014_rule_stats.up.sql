@@ -0,0 +1,13 @@
+-- Migration: Rule hit statistics
+-- How often each rule matched a block during assignment, and how often it
+-- was the highest-priority match that assigned it. Counters restart from
+-- since when the rule's pattern changes.
+
+CREATE TABLE IF NOT EXISTS rule_stat (
+  rule_id          INTEGER PRIMARY KEY,
+  match_count      INTEGER NOT NULL DEFAULT 0,
+  win_count        INTEGER NOT NULL DEFAULT 0,
+  last_matched_at  TEXT,
+  since            TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+  FOREIGN KEY (rule_id) REFERENCES rule(rule_id) ON DELETE CASCADE
+);
@@ -0,0 +1,332 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The full-text index is derived data, so it lives outside the versioned
+// migrations: it needs SQLite built with FTS5 (go build -tags sqlite_fts5)
+// and is rebuilt from block rows whenever searchIndexVersion changes.
+const searchIndexVersion = 1
+
+const settingSearchIndexVersion = "search_index_version"
+
+// searchIndexedText is the per-block text the index holds, in column order:
+// title, description, notes, manual_title, content_summary
+const searchIndexedText = `
+	(SELECT title_text FROM dict_title WHERE title_id = %[1]s.title_summary_id),
+	%[1]s.description,
+	%[1]s.notes,
+	%[1]s.manual_title,
+	CASE WHEN json_valid(%[1]s.metadata) THEN json_extract(%[1]s.metadata, '$.content_summary') END`
+
+var searchTriggers = []string{
+	`CREATE TRIGGER trg_block_fts_insert AFTER INSERT ON block
+	BEGIN
+		INSERT INTO block_fts (rowid, title, description, notes, manual_title, content_summary)
+		VALUES (NEW.block_id, ` + fmt.Sprintf(searchIndexedText, "NEW") + `);
+	END`,
+
+	`CREATE TRIGGER trg_block_fts_update
+	AFTER UPDATE OF title_summary_id, description, notes, manual_title, metadata ON block
+	BEGIN
+		DELETE FROM block_fts WHERE rowid = OLD.block_id;
+		INSERT INTO block_fts (rowid, title, description, notes, manual_title, content_summary)
+		VALUES (NEW.block_id, ` + fmt.Sprintf(searchIndexedText, "NEW") + `);
+	END`,
+
+	`CREATE TRIGGER trg_block_fts_delete AFTER DELETE ON block
+	BEGIN
+		DELETE FROM block_fts WHERE rowid = OLD.block_id;
+	END`,
+
+	`CREATE TRIGGER trg_dict_title_fts_update AFTER UPDATE OF title_text ON dict_title
+	BEGIN
+		UPDATE block_fts SET title = NEW.title_text
+		 WHERE rowid IN (SELECT block_id FROM block WHERE title_summary_id = NEW.title_id);
+	END`,
+}
+
+var searchTriggerNames = []string{
+	"trg_block_fts_insert", "trg_block_fts_update", "trg_block_fts_delete", "trg_dict_title_fts_update",
+}
+
+// SearchParams filters a full-text search
+type SearchParams struct {
+	Query     string
+	StartDate string // YYYY-MM-DD, inclusive
+	EndDate   string // YYYY-MM-DD, inclusive
+	ProfileID *int64
+	Limit     int
+	Offset    int
+}
+
+// SearchResult is a matching block with a highlighted snippet
+type SearchResult struct {
+	BlockID      int64   `json:"block_id"`
+	TsStart      string  `json:"ts_start"`
+	TsEnd        string  `json:"ts_end"`
+	AppName      string  `json:"app_name"`
+	TitleSummary *string `json:"title_summary"`
+	Description  *string `json:"description"`
+	ManualTitle  *string `json:"manual_title"`
+	ProfileID    *int64  `json:"profile_id"`
+	ClientName   *string `json:"client_name"`
+	ServiceName  *string `json:"service_name"`
+	Snippet      string  `json:"snippet"` // HTML-escaped, matches wrapped in <mark>
+	Rank         float64 `json:"rank"`    // bm25, lower is better
+}
+
+// SearchAvailable reports whether the full-text index is usable in this build
+func (s *Store) SearchAvailable() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.searchEnabled
+}
+
+// ensureSearchIndex creates or rebuilds the FTS5 index and its sync triggers
+func (s *Store) ensureSearchIndex(db *sql.DB) error {
+	var hasFTS5 bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&hasFTS5); err != nil {
+		return fmt.Errorf("failed to check FTS5 support: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if !hasFTS5 {
+		// Triggers left by an FTS5 build would break every block write here
+		if err := invalidateSearchIndex(context.Background(), tx); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		s.searchEnabled = false
+		log.Printf("Full-text search unavailable: SQLite built without FTS5 (build with -tags sqlite_fts5)")
+		return nil
+	}
+
+	var version string
+	err = tx.QueryRow("SELECT value FROM settings WHERE key = ?", settingSearchIndexVersion).Scan(&version)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read search index version: %w", err)
+	}
+
+	var tableCount int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'block_fts'").Scan(&tableCount); err != nil {
+		return fmt.Errorf("failed to inspect search index: %w", err)
+	}
+
+	if tableCount > 0 && version == strconv.Itoa(searchIndexVersion) {
+		s.searchEnabled = true
+		return nil
+	}
+
+	log.Printf("Building full-text search index...")
+
+	for _, name := range searchTriggerNames {
+		if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+			return fmt.Errorf("failed to drop %s: %w", name, err)
+		}
+	}
+
+	stmts := []string{
+		"DROP TABLE IF EXISTS block_fts",
+		`CREATE VIRTUAL TABLE block_fts USING fts5(
+			title, description, notes, manual_title, content_summary,
+			tokenize = 'unicode61 remove_diacritics 2'
+		)`,
+		`INSERT INTO block_fts (rowid, title, description, notes, manual_title, content_summary)
+		 SELECT b.block_id, ` + fmt.Sprintf(searchIndexedText, "b") + ` FROM block b`,
+	}
+	stmts = append(stmts, searchTriggers...)
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to build search index: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO settings (key, value, is_encrypted) VALUES (?, ?, 0)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, settingSearchIndexVersion, strconv.Itoa(searchIndexVersion)); err != nil {
+		return fmt.Errorf("failed to record search index version: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit search index: %w", err)
+	}
+
+	s.searchEnabled = true
+	log.Printf("Full-text search index ready")
+	return nil
+}
+
+// invalidateSearchIndex drops the sync triggers and marks the index stale so
+// the next Init with FTS5 rebuilds it. Used when the triggers would get in
+// the way: builds without FTS5, and schema rollbacks that drop block columns.
+func invalidateSearchIndex(ctx context.Context, ex interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+}) error {
+	for _, name := range searchTriggerNames {
+		if _, err := ex.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+name); err != nil {
+			return fmt.Errorf("failed to drop %s: %w", name, err)
+		}
+	}
+	if _, err := ex.ExecContext(ctx, `
+		INSERT INTO settings (key, value, is_encrypted) VALUES (?, '0', 0)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, settingSearchIndexVersion); err != nil {
+		return fmt.Errorf("failed to mark search index stale: %w", err)
+	}
+	return nil
+}
+
+// SearchBlocks runs a ranked full-text search. Blocks hidden by the app or
+// keyword blacklist are excluded, as in the block lists.
+func (s *Store) SearchBlocks(params SearchParams) ([]SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.initialized {
+		return nil, fmt.Errorf("store not initialized")
+	}
+	if !s.searchEnabled {
+		return nil, fmt.Errorf("full-text search is not available in this build")
+	}
+
+	match := buildMatchQuery(params.Query)
+	if match == "" {
+		return []SearchResult{}, nil
+	}
+
+	if params.Limit <= 0 {
+		params.Limit = 50
+	}
+
+	// \x02/\x03 mark hits so the snippet can be HTML-escaped before adding <mark>
+	query := `
+		SELECT
+			b.block_id,
+			b.ts_start,
+			b.ts_end,
+			da.app_name,
+			dt.title_text,
+			b.description,
+			b.manual_title,
+			b.profile_id,
+			c.name,
+			sv.name,
+			snippet(block_fts, -1, char(2), char(3), '…', 16),
+			bm25(block_fts, 4.0, 3.0, 2.0, 3.0, 1.0) AS rank
+		FROM block_fts
+		JOIN block b ON b.block_id = block_fts.rowid
+		JOIN dict_app da ON b.primary_app_id = da.app_id
+		LEFT JOIN dict_title dt ON b.title_summary_id = dt.title_id
+		LEFT JOIN profile p ON b.profile_id = p.profile_id
+		LEFT JOIN client c ON p.client_id = c.client_id
+		LEFT JOIN service sv ON p.service_id = sv.service_id
+		LEFT JOIN app_blacklist abl ON b.primary_app_id = abl.app_id
+		WHERE block_fts MATCH ?
+		  AND abl.app_id IS NULL
+		  AND b.deleted_at IS NULL
+		  AND NOT EXISTS (
+			  SELECT 1 FROM keyword_blacklist kbl
+			  WHERE dt.title_text LIKE '%' || kbl.keyword_text || '%'
+		  )
+	`
+	args := []interface{}{match}
+
+	if params.StartDate != "" {
+		query += " AND DATE(b.ts_start) >= ?"
+		args = append(args, params.StartDate)
+	}
+	if params.EndDate != "" {
+		query += " AND DATE(b.ts_start) <= ?"
+		args = append(args, params.EndDate)
+	}
+	if params.ProfileID != nil {
+		query += " AND b.profile_id = ?"
+		args = append(args, *params.ProfileID)
+	}
+
+	query += " ORDER BY rank, b.ts_start DESC LIMIT ? OFFSET ?"
+	args = append(args, params.Limit, params.Offset)
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search blocks: %w", err)
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var r SearchResult
+		var title, description, manualTitle, clientName, serviceName, snippet sql.NullString
+		var profileID sql.NullInt64
+
+		if err := rows.Scan(
+			&r.BlockID, &r.TsStart, &r.TsEnd, &r.AppName, &title, &description,
+			&manualTitle, &profileID, &clientName, &serviceName, &snippet, &r.Rank,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+
+		r.TitleSummary = nullStringPtr(title)
+		r.Description = nullStringPtr(description)
+		r.ManualTitle = nullStringPtr(manualTitle)
+		r.ClientName = nullStringPtr(clientName)
+		r.ServiceName = nullStringPtr(serviceName)
+		if profileID.Valid {
+			id := profileID.Int64
+			r.ProfileID = &id
+		}
+
+		r.Snippet = strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>").Replace(html.EscapeString(snippet.String))
+
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// buildMatchQuery turns free text into an FTS5 query: every word must match,
+// each word is quoted so user input can't inject FTS syntax, and the last
+// word is a prefix match so results appear while typing.
+func buildMatchQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+
+	terms := make([]string, len(words))
+	for i, w := range words {
+		terms[i] = `"` + w + `"`
+	}
+	terms[len(terms)-1] += "*"
+
+	return strings.Join(terms, " ")
+}
+
+func nullStringPtr(ns sql.NullString) *string {
+	if !ns.Valid {
+		return nil
+	}
+	s := ns.String
+	return &s
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	"chroniclecore/internal/security"
+)
+
+// SetKeyProvider sets the provider used to encrypt secret settings
+func (s *Store) SetKeyProvider(kp security.KeyProvider) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = kp
+}
+
+// KeyProviderName returns the configured provider, or "" if there is none
+func (s *Store) KeyProviderName() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.keys == nil {
+		return ""
+	}
+	return s.keys.Name()
+}
+
+// SetSecret encrypts value and stores it under key. Secret rows are hidden
+// from GetSetting and GetAllSettings.
+func (s *Store) SetSecret(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return fmt.Errorf("store not initialized")
+	}
+	if s.keys == nil {
+		return fmt.Errorf("no key provider configured")
+	}
+
+	ciphertext, err := s.keys.Encrypt([]byte(value), secretContext(key))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret %s: %w", key, err)
+	}
+
+	_, err = s.DB.Exec(`
+		INSERT INTO settings (key, value, is_encrypted)
+		VALUES (?, ?, 1)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, is_encrypted = 1
+	`, key, ciphertext)
+	if err != nil {
+		return fmt.Errorf("failed to set secret %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// GetSecret decrypts the secret stored under key. Missing keys return "".
+func (s *Store) GetSecret(key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.initialized {
+		return "", fmt.Errorf("store not initialized")
+	}
+	if s.keys == nil {
+		return "", fmt.Errorf("no key provider configured")
+	}
+
+	var ciphertext []byte
+	var encrypted bool
+	err := s.DB.QueryRow(
+		"SELECT value, is_encrypted FROM settings WHERE key = ?",
+		key,
+	).Scan(&ciphertext, &encrypted)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret %s: %w", key, err)
+	}
+	if !encrypted {
+		return "", fmt.Errorf("setting %s is not a secret", key)
+	}
+
+	plaintext, err := s.keys.Decrypt(ciphertext, secretContext(key))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret %s: %w", key, err)
+	}
+
+	return string(plaintext), nil
+}
+
+// DeleteSecret removes a secret
+func (s *Store) DeleteSecret(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return fmt.Errorf("store not initialized")
+	}
+
+	if _, err := s.DB.Exec("DELETE FROM settings WHERE key = ? AND is_encrypted = 1", key); err != nil {
+		return fmt.Errorf("failed to delete secret %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// RotateSecrets re-encrypts every secret under a fresh key. Providers that
+// manage their own keys get a new key first and drop the old ones once all
+// rows are rewritten; for DPAPI this refreshes each value under the current
+// Windows master key. Secrets in snapshots taken before a rotation can't be
+// read once the old keys are retired.
+func (s *Store) RotateSecrets() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return 0, fmt.Errorf("store not initialized")
+	}
+	if s.keys == nil {
+		return 0, fmt.Errorf("no key provider configured")
+	}
+
+	rotator, canRotate := s.keys.(security.KeyRotator)
+	if canRotate {
+		if err := rotator.RotateKey(); err != nil {
+			return 0, fmt.Errorf("failed to rotate key: %w", err)
+		}
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT key, value FROM settings WHERE is_encrypted = 1")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query secrets: %w", err)
+	}
+
+	type secret struct {
+		key   string
+		value []byte
+	}
+	var secrets []secret
+	for rows.Next() {
+		var sec secret
+		if err := rows.Scan(&sec.key, &sec.value); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan secret: %w", err)
+		}
+		secrets = append(secrets, sec)
+	}
+	rows.Close()
+
+	for _, sec := range secrets {
+		plaintext, err := s.keys.Decrypt(sec.value, secretContext(sec.key))
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt secret %s: %w", sec.key, err)
+		}
+		ciphertext, err := s.keys.Encrypt(plaintext, secretContext(sec.key))
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt secret %s: %w", sec.key, err)
+		}
+		if _, err := tx.Exec("UPDATE settings SET value = ? WHERE key = ?", ciphertext, sec.key); err != nil {
+			return 0, fmt.Errorf("failed to update secret %s: %w", sec.key, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit rotation: %w", err)
+	}
+
+	if canRotate {
+		if err := rotator.RetireOldKeys(); err != nil {
+			return len(secrets), fmt.Errorf("secrets rotated but old keys not retired: %w", err)
+		}
+	}
+
+	return len(secrets), nil
+}
+
+// secretContext binds a ciphertext to its setting key
+func secretContext(key string) []byte {
+	return []byte("settings:" + key)
+}
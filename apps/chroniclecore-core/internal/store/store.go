@@ -7,24 +7,22 @@ import (
 	"sync"
 	"time"
 
+	"chroniclecore/internal/security"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // Store manages database connections and operations
 type Store struct {
-	DB          *sql.DB // Exported for ML handler access
-	dbPath      string
-	mu          sync.RWMutex
-	dictCache   *DictCache
-	initialized bool
-}
-
-// DictCache stores in-memory cache of dictionary tables to avoid lookups
-type DictCache struct {
-	mu      sync.RWMutex
-	apps    map[string]int64 // app_name -> app_id
-	titles  map[string]int64 // title_text -> title_id
-	domains map[string]int64 // domain_text -> domain_id
+	DB            *sql.DB // Exported for ML handler access
+	dbPath        string
+	mu            sync.RWMutex
+	backupMu      sync.Mutex // Serializes snapshot and restore operations
+	archiveMu     sync.Mutex // Guards raw event archive files
+	dictCache     *DictCache
+	initialized   bool
+	searchEnabled bool                 // FTS5 index is present (requires -tags sqlite_fts5)
+	keys          security.KeyProvider // Encrypts secret settings; nil disables secrets
 }
 
 // RawEvent represents an activity event
@@ -56,18 +54,22 @@ type Block struct {
 	Notes           *string
 	Description     *string
 	Metadata        *string
-	ActivityScore   float64 // 0.0-1.0 representing active work percentage for billing
+	ActivityScore   float64      // 0.0-1.0 representing active work percentage for billing
+	Titles          []BlockTitle // Time per window title, stored in block_title
+}
+
+// BlockTitle is the time a block spent on one window title
+type BlockTitle struct {
+	TitleID   int64  `json:"title_id"`
+	TitleText string `json:"title_text,omitempty"`
+	Seconds   int    `json:"seconds"`
 }
 
 // NewStore creates a new store instance (not yet initialized)
 func NewStore(dbPath string) *Store {
 	return &Store{
-		dbPath: dbPath,
-		dictCache: &DictCache{
-			apps:    make(map[string]int64),
-			titles:  make(map[string]int64),
-			domains: make(map[string]int64),
-		},
+		dbPath:    dbPath,
+		dictCache: newDictCache(),
 	}
 }
 
@@ -76,6 +78,11 @@ func (s *Store) Init() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.initLocked()
+}
+
+// initLocked opens the database; the caller holds s.mu exclusively
+func (s *Store) initLocked() error {
 	if s.initialized {
 		return fmt.Errorf("store already initialized")
 	}
@@ -117,10 +124,19 @@ func (s *Store) Init() error {
 		return fmt.Errorf("database schema not initialized - run schema.sql first")
 	}
 
-	// Ensure schema is up to date (auto-migrate v1.4 -> v1.5)
-	if err := s.ensureSchema(db); err != nil {
-		log.Printf("Warning: Schema migration failed: %v", err)
-		// Proceed anyway, critical tables likely exist
+	// Bring schema up to date; a failed migration leaves the store unusable
+	applied, err := ApplyMigrations(db)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("schema migration failed: %w", err)
+	}
+	if applied > 0 {
+		log.Printf("Applied %d schema migration(s)", applied)
+	}
+
+	if err := s.ensureSearchIndex(db); err != nil {
+		db.Close()
+		return fmt.Errorf("search index setup failed: %w", err)
 	}
 
 	s.initialized = true
@@ -134,6 +150,11 @@ func (s *Store) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.closeLocked()
+}
+
+// closeLocked closes the database; the caller holds s.mu exclusively
+func (s *Store) closeLocked() error {
 	if !s.initialized || s.DB == nil {
 		return nil
 	}
@@ -145,155 +166,30 @@ func (s *Store) Close() error {
 
 // GetOrCreateDictApp gets or creates an app dictionary entry
 func (s *Store) GetOrCreateDictApp(appName string) (int64, error) {
-	// Check cache first
-	s.dictCache.mu.RLock()
-	if id, exists := s.dictCache.apps[appName]; exists {
-		s.dictCache.mu.RUnlock()
-		return id, nil
-	}
-	s.dictCache.mu.RUnlock()
-
-	// Try to get from DB
-	var appID int64
-	err := s.DB.QueryRow(
-		"SELECT app_id FROM dict_app WHERE app_name = ?",
-		appName,
-	).Scan(&appID)
-
-	if err == nil {
-		// Found - update cache
-		s.dictCache.mu.Lock()
-		s.dictCache.apps[appName] = appID
-		s.dictCache.mu.Unlock()
-		return appID, nil
-	}
-
-	if err != sql.ErrNoRows {
-		return 0, fmt.Errorf("failed to query dict_app: %w", err)
-	}
-
-	// Not found - insert new
-	result, err := s.DB.Exec(
-		"INSERT INTO dict_app (app_name) VALUES (?)",
-		appName,
-	)
-	if err != nil {
-		return 0, fmt.Errorf("failed to insert dict_app: %w", err)
-	}
-
-	appID, err = result.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("failed to get last insert id: %w", err)
-	}
-
-	// Update cache
-	s.dictCache.mu.Lock()
-	s.dictCache.apps[appName] = appID
-	s.dictCache.mu.Unlock()
-
-	return appID, nil
+	return s.getOrCreateDict(s.dictCache.apps, "dict_app", "app_id", "app_name", appName)
 }
 
 // GetOrCreateDictTitle gets or creates a title dictionary entry
 func (s *Store) GetOrCreateDictTitle(titleText string) (int64, error) {
-	// Check cache first
-	s.dictCache.mu.RLock()
-	if id, exists := s.dictCache.titles[titleText]; exists {
-		s.dictCache.mu.RUnlock()
-		return id, nil
-	}
-	s.dictCache.mu.RUnlock()
-
-	// Try to get from DB
-	var titleID int64
-	err := s.DB.QueryRow(
-		"SELECT title_id FROM dict_title WHERE title_text = ?",
-		titleText,
-	).Scan(&titleID)
-
-	if err == nil {
-		// Found - update cache
-		s.dictCache.mu.Lock()
-		s.dictCache.titles[titleText] = titleID
-		s.dictCache.mu.Unlock()
-		return titleID, nil
-	}
-
-	if err != sql.ErrNoRows {
-		return 0, fmt.Errorf("failed to query dict_title: %w", err)
-	}
-
-	// Not found - insert new
-	result, err := s.DB.Exec(
-		"INSERT INTO dict_title (title_text) VALUES (?)",
-		titleText,
-	)
-	if err != nil {
-		return 0, fmt.Errorf("failed to insert dict_title: %w", err)
-	}
-
-	titleID, err = result.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("failed to get last insert id: %w", err)
-	}
-
-	// Update cache
-	s.dictCache.mu.Lock()
-	s.dictCache.titles[titleText] = titleID
-	s.dictCache.mu.Unlock()
-
-	return titleID, nil
+	return s.getOrCreateDict(s.dictCache.titles, "dict_title", "title_id", "title_text", titleText)
 }
 
 // GetOrCreateDictDomain gets or creates a domain dictionary entry
 func (s *Store) GetOrCreateDictDomain(domainText string) (int64, error) {
-	// Check cache first
-	s.dictCache.mu.RLock()
-	if id, exists := s.dictCache.domains[domainText]; exists {
-		s.dictCache.mu.RUnlock()
-		return id, nil
-	}
-	s.dictCache.mu.RUnlock()
-
-	// Try to get from DB
-	var domainID int64
-	err := s.DB.QueryRow(
-		"SELECT domain_id FROM dict_domain WHERE domain_text = ?",
-		domainText,
-	).Scan(&domainID)
-
-	if err == nil {
-		// Found - update cache
-		s.dictCache.mu.Lock()
-		s.dictCache.domains[domainText] = domainID
-		s.dictCache.mu.Unlock()
-		return domainID, nil
-	}
-
-	if err != sql.ErrNoRows {
-		return 0, fmt.Errorf("failed to query dict_domain: %w", err)
-	}
+	return s.getOrCreateDict(s.dictCache.domains, "dict_domain", "domain_id", "domain_text", domainText)
+}
 
-	// Not found - insert new
-	result, err := s.DB.Exec(
-		"INSERT INTO dict_domain (domain_text) VALUES (?)",
-		domainText,
-	)
-	if err != nil {
-		return 0, fmt.Errorf("failed to insert dict_domain: %w", err)
-	}
+// getOrCreateDict looks up a dictionary entry under the store lock, so a
+// restore can't swap the database out from under it
+func (s *Store) getOrCreateDict(cache *lruCache, table, idCol, textCol, text string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	domainID, err = result.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("failed to get last insert id: %w", err)
+	if !s.initialized {
+		return 0, fmt.Errorf("store not initialized")
 	}
 
-	// Update cache
-	s.dictCache.mu.Lock()
-	s.dictCache.domains[domainText] = domainID
-	s.dictCache.mu.Unlock()
-
-	return domainID, nil
+	return s.dictCache.getOrCreate(s.DB, cache, table, idCol, textCol, text)
 }
 
 // InsertRawEvent inserts a raw activity event
@@ -367,6 +263,60 @@ func (s *Store) GetRawEventsSince(since time.Time) ([]*RawEvent, error) {
 	}
 	defer rows.Close()
 
+	return scanRawEvents(rows)
+}
+
+// GetRawEventsAfter retrieves raw events strictly after the (ts_start,
+// event_id) position, in that order. Rehydrated events are left out since
+// they were rolled up before they were archived.
+func (s *Store) GetRawEventsAfter(after time.Time, afterEventID int64) ([]*RawEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.initialized {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	query := `
+		SELECT
+			event_id, ts_start, ts_end, app_id, title_id,
+			domain_id, state, source, metadata, hash_signature
+		FROM raw_event
+		WHERE (ts_start > ? OR (ts_start = ? AND event_id > ?))
+		  AND rehydrated_at IS NULL
+		ORDER BY ts_start ASC, event_id ASC
+	`
+
+	ts := after.UTC().Format(time.RFC3339)
+	rows, err := s.DB.Query(query, ts, ts, afterEventID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query raw_event: %w", err)
+	}
+	defer rows.Close()
+
+	return scanRawEvents(rows)
+}
+
+// RawEventsInRangeTx retrieves raw events starting in [start, end) inside
+// the caller's transaction, ordered by (ts_start, event_id)
+func RawEventsInRangeTx(tx *sql.Tx, start, end time.Time) ([]*RawEvent, error) {
+	rows, err := tx.Query(`
+		SELECT
+			event_id, ts_start, ts_end, app_id, title_id,
+			domain_id, state, source, metadata, hash_signature
+		FROM raw_event
+		WHERE ts_start >= ? AND ts_start < ?
+		ORDER BY ts_start ASC, event_id ASC
+	`, start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query raw_event: %w", err)
+	}
+	defer rows.Close()
+
+	return scanRawEvents(rows)
+}
+
+func scanRawEvents(rows *sql.Rows) ([]*RawEvent, error) {
 	var events []*RawEvent
 
 	for rows.Next() {
@@ -460,6 +410,17 @@ func (s *Store) InsertBlock(block *Block) error {
 		return fmt.Errorf("store not initialized")
 	}
 
+	return insertBlock(s.DB, block)
+}
+
+// InsertBlockTx inserts a block inside the caller's transaction
+func InsertBlockTx(tx *sql.Tx, block *Block) error {
+	return insertBlock(tx, block)
+}
+
+func insertBlock(ex interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, block *Block) error {
 	query := `
 		INSERT INTO block (
 			ts_start, ts_end, primary_app_id, primary_domain_id,
@@ -468,7 +429,7 @@ func (s *Store) InsertBlock(block *Block) error {
 		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := s.DB.Exec(
+	result, err := ex.Exec(
 		query,
 		block.TsStart.UTC().Format(time.RFC3339),
 		block.TsEnd.UTC().Format(time.RFC3339),
@@ -494,39 +455,119 @@ func (s *Store) InsertBlock(block *Block) error {
 		block.BlockID = id
 	}
 
+	for _, t := range block.Titles {
+		_, err := ex.Exec(
+			"INSERT INTO block_title (block_id, title_id, seconds) VALUES (?, ?, ?)",
+			block.BlockID, t.TitleID, t.Seconds,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert block title: %w", err)
+		}
+	}
+
 	return nil
 }
 
-// DeleteBlock deletes a block by ID
-func (s *Store) DeleteBlock(blockID int64) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// ReplaceBlockTx rewrites an existing block with the span, app, title and
+// metadata of block, inside the caller's transaction. The row keeps its
+// profile, notes and description, and its label events and suggestions
+// stay attached. block.BlockID is set to blockID.
+func ReplaceBlockTx(tx *sql.Tx, blockID int64, block *Block) error {
+	_, err := tx.Exec(`
+		UPDATE block
+		SET ts_start = ?, ts_end = ?, primary_app_id = ?, primary_domain_id = ?,
+		    title_summary_id = ?, metadata = ?, activity_score = ?,
+		    updated_at = strftime('%Y-%m-%dT%H:%M:%fZ','now')
+		WHERE block_id = ?
+	`,
+		block.TsStart.UTC().Format(time.RFC3339),
+		block.TsEnd.UTC().Format(time.RFC3339),
+		block.PrimaryAppID,
+		block.PrimaryDomainID,
+		block.TitleSummaryID,
+		block.Metadata,
+		block.ActivityScore,
+		blockID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to replace block %d: %w", blockID, err)
+	}
+	block.BlockID = blockID
 
-	if !s.initialized {
-		return fmt.Errorf("store not initialized")
+	if _, err := tx.Exec("DELETE FROM block_title WHERE block_id = ?", blockID); err != nil {
+		return fmt.Errorf("failed to clear titles for block %d: %w", blockID, err)
 	}
+	for _, t := range block.Titles {
+		_, err := tx.Exec(
+			"INSERT INTO block_title (block_id, title_id, seconds) VALUES (?, ?, ?)",
+			blockID, t.TitleID, t.Seconds,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert block title: %w", err)
+		}
+	}
+
+	return nil
+}
 
-	// Manually delete related ML events first (safer than relying on CASCADE)
-	if _, err := s.DB.Exec("DELETE FROM ml_label_event WHERE block_id = ?", blockID); err != nil {
-		log.Printf("Warning: Failed to delete ml_label_event for block %d: %v", blockID, err)
+// GetBlockTitles returns the titles a block covered, longest first
+func (s *Store) GetBlockTitles(blockID int64) ([]BlockTitle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.initialized {
+		return nil, fmt.Errorf("store not initialized")
 	}
 
-	res, err := s.DB.Exec("DELETE FROM block WHERE block_id = ?", blockID)
+	rows, err := s.DB.Query(`
+		SELECT bt.title_id, dt.title_text, bt.seconds
+		FROM block_title bt
+		JOIN dict_title dt ON bt.title_id = dt.title_id
+		WHERE bt.block_id = ?
+		ORDER BY bt.seconds DESC, bt.block_title_id ASC
+	`, blockID)
 	if err != nil {
-		log.Printf("Error deleting block %d: %v", blockID, err)
-		return fmt.Errorf("failed to delete block: %w", err)
+		return nil, fmt.Errorf("failed to query block titles: %w", err)
 	}
+	defer rows.Close()
 
-	rows, _ := res.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("block not found")
+	var titles []BlockTitle
+	for rows.Next() {
+		var t BlockTitle
+		if err := rows.Scan(&t.TitleID, &t.TitleText, &t.Seconds); err != nil {
+			return nil, fmt.Errorf("failed to scan block title: %w", err)
+		}
+		titles = append(titles, t)
 	}
 
+	return titles, rows.Err()
+}
+
+// DeleteBlocksTx removes blocks inside the caller's transaction, with their
+// label events, ML suggestions and title timelines. Callers audit the blocks first.
+func DeleteBlocksTx(tx *sql.Tx, blockIDs []int64) error {
+	for _, id := range blockIDs {
+		if _, err := tx.Exec("DELETE FROM ml_label_event WHERE block_id = ?", id); err != nil {
+			return fmt.Errorf("failed to delete label events for block %d: %w", id, err)
+		}
+		if _, err := tx.Exec("DELETE FROM ml_suggestion WHERE entity_type = 'BLOCK' AND entity_id = ?", id); err != nil {
+			return fmt.Errorf("failed to delete suggestions for block %d: %w", id, err)
+		}
+		if _, err := tx.Exec("DELETE FROM block_title WHERE block_id = ?", id); err != nil {
+			return fmt.Errorf("failed to delete titles for block %d: %w", id, err)
+		}
+		if _, err := tx.Exec("DELETE FROM block WHERE block_id = ?", id); err != nil {
+			return fmt.Errorf("failed to delete block %d: %w", id, err)
+		}
+	}
 	return nil
 }
 
-// GetDB returns the underlying database connection (for advanced queries)
+// GetDB returns the underlying database connection (for advanced queries).
+// A restore replaces it, so fetch it per request rather than keeping it.
 func (s *Store) GetDB() *sql.DB {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.DB
 }
 
@@ -545,12 +586,12 @@ func (s *Store) GetSetting(key string) (string, error) {
 
 	var value string
 	err := s.DB.QueryRow(
-		"SELECT value FROM settings WHERE key = ?",
+		"SELECT value FROM settings WHERE key = ? AND is_encrypted = 0",
 		key,
 	).Scan(&value)
 
 	if err == sql.ErrNoRows {
-		return "", nil // Return empty string for missing keys
+		return "", nil // Return empty string for missing keys and secrets
 	}
 
 	if err != nil {
@@ -569,16 +610,22 @@ func (s *Store) SetSetting(key, value string) error {
 		return fmt.Errorf("store not initialized")
 	}
 
-	_, err := s.DB.Exec(`
+	// Secrets can only be overwritten through SetSecret
+	result, err := s.DB.Exec(`
 		INSERT INTO settings (key, value, is_encrypted)
 		VALUES (?, ?, 0)
 		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+		WHERE settings.is_encrypted = 0
 	`, key, value)
 
 	if err != nil {
 		return fmt.Errorf("failed to set setting %s: %w", key, err)
 	}
 
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("setting %s is a secret", key)
+	}
+
 	return nil
 }
 
@@ -646,6 +693,7 @@ func (s *Store) GetDailyTotalTime(day time.Time) (int64, error) {
 		SELECT SUM(strftime('%s', ts_end) - strftime('%s', ts_start))
 		FROM block
 		WHERE ts_start >= ? AND ts_end <= ?
+		  AND deleted_at IS NULL
 	`
 
 	var totalSeconds sql.NullInt64
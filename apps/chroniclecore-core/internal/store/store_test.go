@@ -1,11 +1,20 @@
 package store
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"chroniclecore/internal/security"
+	"chroniclecore/internal/storetest"
 )
 
 // setupTestDB creates a test database with schema
@@ -50,7 +59,7 @@ func TestStoreInit(t *testing.T) {
 		t.Error("Store should be initialized")
 	}
 
-	if store.db == nil {
+	if store.DB == nil {
 		t.Error("Database connection should not be nil")
 	}
 }
@@ -82,9 +91,7 @@ func TestGetOrCreateDictApp(t *testing.T) {
 	}
 
 	// Verify cache is working
-	store.dictCache.mu.RLock()
-	cachedID, exists := store.dictCache.apps[appName]
-	store.dictCache.mu.RUnlock()
+	cachedID, exists := store.dictCache.get(store.dictCache.apps, appName)
 
 	if !exists {
 		t.Error("App should be in cache")
@@ -163,7 +170,7 @@ func TestGetRawEventsSince(t *testing.T) {
 	// Insert test events
 	baseTime := time.Now().UTC().Add(-1 * time.Hour)
 
-	for i := 0; i < 5; i++ {
+	for i := 0; i < 6; i++ {
 		start := baseTime.Add(time.Duration(i*10) * time.Minute)
 		end := start.Add(5 * time.Minute)
 
@@ -200,6 +207,51 @@ func TestGetRawEventsSince(t *testing.T) {
 	}
 }
 
+func TestGetRawEventsAfter(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	appID, _ := store.GetOrCreateDictApp("CODE.EXE")
+	base := time.Now().UTC().Add(-1 * time.Hour).Truncate(time.Second)
+
+	// Three events share a start second; one is open
+	var ids []int64
+	for i := 0; i < 4; i++ {
+		start := base
+		if i == 3 {
+			start = base.Add(time.Minute)
+		}
+		end := start.Add(30 * time.Second)
+		event := &RawEvent{TsStart: start, TsEnd: &end, AppID: appID, State: "ACTIVE", Source: "OS"}
+		if i == 3 {
+			event.TsEnd = nil
+		}
+		if err := store.InsertRawEvent(event); err != nil {
+			t.Fatalf("Failed to insert event: %v", err)
+		}
+		ids = append(ids, event.EventID)
+	}
+
+	// Resume in the middle of the shared second
+	events, err := store.GetRawEventsAfter(base, ids[0])
+	if err != nil {
+		t.Fatalf("Failed to get events: %v", err)
+	}
+	if len(events) != 3 || events[0].EventID != ids[1] || events[2].EventID != ids[3] {
+		t.Fatalf("Expected events %v in order, got %d events", ids[1:], len(events))
+	}
+	if events[2].TsEnd != nil {
+		t.Error("Open event should be returned without an end")
+	}
+
+	// Rehydrated events were rolled up before they were archived
+	store.DB.Exec("UPDATE raw_event SET rehydrated_at = ? WHERE event_id = ?", base.Format(time.RFC3339), ids[1])
+	events, _ = store.GetRawEventsAfter(base, ids[0])
+	if len(events) != 2 {
+		t.Errorf("Expected rehydrated event to be skipped, got %d events", len(events))
+	}
+}
+
 func TestDeleteRawEventsBefore(t *testing.T) {
 	store, _ := setupTestDB(t)
 	defer store.Close()
@@ -278,7 +330,7 @@ func TestInsertBlock(t *testing.T) {
 
 	// Verify block was inserted
 	var count int
-	err = store.db.QueryRow("SELECT COUNT(*) FROM block").Scan(&count)
+	err = store.DB.QueryRow("SELECT COUNT(*) FROM block").Scan(&count)
 	if err != nil {
 		t.Fatalf("Failed to query blocks: %v", err)
 	}
@@ -288,6 +340,57 @@ func TestInsertBlock(t *testing.T) {
 	}
 }
 
+func TestBlockTitles(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	appID, _ := store.GetOrCreateDictApp("msedge.exe")
+	inbox, _ := store.GetOrCreateDictTitle("Gmail - Inbox")
+	invoice, _ := store.GetOrCreateDictTitle("Invoice #42")
+
+	now := time.Now().UTC()
+	block := &Block{
+		TsStart:        now,
+		TsEnd:          now.Add(30 * time.Minute),
+		PrimaryAppID:   appID,
+		TitleSummaryID: &invoice,
+		Confidence:     "LOW",
+		Titles: []BlockTitle{
+			{TitleID: inbox, Seconds: 300},
+			{TitleID: invoice, Seconds: 1500},
+		},
+	}
+	if err := store.InsertBlock(block); err != nil {
+		t.Fatalf("Failed to insert block: %v", err)
+	}
+
+	titles, err := store.GetBlockTitles(block.BlockID)
+	if err != nil {
+		t.Fatalf("Failed to get block titles: %v", err)
+	}
+	if len(titles) != 2 || titles[0].TitleText != "Invoice #42" || titles[0].Seconds != 1500 || titles[1].TitleID != inbox {
+		t.Errorf("Expected titles longest first, got %+v", titles)
+	}
+
+	// Titles only referenced by the timeline survive GC
+	store.dictCache.reset()
+	if collected, _, err := store.CollectDictGarbage(); err != nil || collected != 0 {
+		t.Errorf("Expected no titles collected, got %d (%v)", collected, err)
+	}
+
+	tx, _ := store.DB.Begin()
+	if err := DeleteBlocksTx(tx, []int64{block.BlockID}); err != nil {
+		t.Fatalf("Failed to delete block: %v", err)
+	}
+	tx.Commit()
+
+	var count int
+	store.DB.QueryRow("SELECT COUNT(*) FROM block_title").Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected block titles deleted with the block, got %d", count)
+	}
+}
+
 func TestConcurrentDictAccess(t *testing.T) {
 	store, _ := setupTestDB(t)
 	defer store.Close()
@@ -315,9 +418,7 @@ func TestConcurrentDictAccess(t *testing.T) {
 	}
 
 	// All goroutines should have gotten the same ID
-	store.dictCache.mu.RLock()
-	id, exists := store.dictCache.apps["TEST.EXE"]
-	store.dictCache.mu.RUnlock()
+	id, exists := store.dictCache.get(store.dictCache.apps, "TEST.EXE")
 
 	if !exists {
 		t.Error("App should be in cache after concurrent access")
@@ -325,8 +426,1621 @@ func TestConcurrentDictAccess(t *testing.T) {
 
 	// Verify only one entry in database
 	var count int
-	store.db.QueryRow("SELECT COUNT(*) FROM dict_app WHERE app_name = 'TEST.EXE'").Scan(&count)
+	store.DB.QueryRow("SELECT COUNT(*) FROM dict_app WHERE app_name = 'TEST.EXE'").Scan(&count)
 	if count != 1 {
 		t.Errorf("Expected 1 app entry, got %d (cache: %d)", count, id)
 	}
 }
+
+func TestMigrationsFreshSchema(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	states, err := GetMigrationStatus(store.DB)
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+
+	if len(states) != LatestMigrationVersion() {
+		t.Fatalf("Expected %d migrations, got %d", LatestMigrationVersion(), len(states))
+	}
+
+	for _, state := range states {
+		if state.Status != "APPLIED" {
+			t.Errorf("Migration %d should be applied, got %s", state.Version, state.Status)
+		}
+	}
+
+	// Re-running is a no-op
+	applied, err := ApplyMigrations(store.DB)
+	if err != nil {
+		t.Fatalf("Re-apply failed: %v", err)
+	}
+	if applied != 0 {
+		t.Errorf("Expected 0 migrations on re-run, got %d", applied)
+	}
+}
+
+func TestMigrationsRollbackAndReapply(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	_, err := store.DB.Exec("INSERT INTO rate (rate_id, name, currency_code, hourly_minor_units) VALUES (1, 'Standard', 'ZAR', 15000)")
+	if err != nil {
+		t.Fatalf("Failed to insert rate: %v", err)
+	}
+
+	rolledBack, err := RollbackMigrations(store.DB, LatestMigrationVersion())
+	if err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if rolledBack != LatestMigrationVersion() {
+		t.Errorf("Expected %d rollbacks, got %d", LatestMigrationVersion(), rolledBack)
+	}
+
+	// 001 down restores the legacy currency column
+	var currency string
+	if err := store.DB.QueryRow("SELECT currency FROM rate WHERE rate_id = 1").Scan(&currency); err != nil {
+		t.Fatalf("Legacy currency column missing after rollback: %v", err)
+	}
+
+	applied, err := ApplyMigrations(store.DB)
+	if err != nil {
+		t.Fatalf("Re-apply failed: %v", err)
+	}
+	if applied != LatestMigrationVersion() {
+		t.Errorf("Expected %d migrations applied, got %d", LatestMigrationVersion(), applied)
+	}
+
+	var currencyCode string
+	if err := store.DB.QueryRow("SELECT currency_code FROM rate WHERE rate_id = 1").Scan(&currencyCode); err != nil {
+		t.Fatalf("Failed to read migrated rate: %v", err)
+	}
+	if currencyCode != "ZAR" {
+		t.Errorf("Expected ZAR after migration, got %s", currencyCode)
+	}
+
+	var activityScore float64
+	if err := store.DB.QueryRow("SELECT COUNT(*), COALESCE(MAX(activity_score), 1.0) FROM block").Scan(new(int), &activityScore); err != nil {
+		t.Errorf("activity_score column missing after re-apply: %v", err)
+	}
+}
+
+func TestMigrationsFailLoudly(t *testing.T) {
+	store, dbPath := setupTestDB(t)
+
+	// Tampered checksum
+	_, err := store.DB.Exec("UPDATE schema_migrations SET checksum = 'tampered' WHERE version = 3")
+	if err != nil {
+		t.Fatalf("Failed to tamper checksum: %v", err)
+	}
+	store.Close()
+
+	reopened := NewStore(dbPath)
+	if err := reopened.Init(); err == nil {
+		reopened.Close()
+		t.Fatal("Init should fail on checksum mismatch")
+	}
+
+	// Database from a newer build
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("DELETE FROM schema_migrations WHERE version = 3"); err != nil {
+		t.Fatalf("Failed to reset migration: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (999, 'future', 'x', '2030-01-01T00:00:00Z')"); err != nil {
+		t.Fatalf("Failed to insert future migration: %v", err)
+	}
+
+	if _, err := ApplyMigrations(db); err == nil {
+		t.Error("ApplyMigrations should refuse a schema newer than the build")
+	}
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	backupDir := t.TempDir()
+	if err := store.SetSetting(SettingBackupDir, backupDir); err != nil {
+		t.Fatalf("Failed to set backup dir: %v", err)
+	}
+
+	if _, err := store.GetOrCreateDictApp("BEFORE.EXE"); err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	snap, err := store.CreateSnapshot(SnapshotManual)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+
+	if err := ValidateSnapshot(filepath.Join(backupDir, snap.Name)); err != nil {
+		t.Fatalf("Fresh snapshot should validate: %v", err)
+	}
+
+	// Changes after the snapshot should disappear on restore
+	if _, err := store.GetOrCreateDictApp("AFTER.EXE"); err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := store.RestoreSnapshot(snap.Name); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	var count int
+	store.GetDB().QueryRow("SELECT COUNT(*) FROM dict_app WHERE app_name = 'AFTER.EXE'").Scan(&count)
+	if count != 0 {
+		t.Error("Restored database should not contain rows written after the snapshot")
+	}
+	store.GetDB().QueryRow("SELECT COUNT(*) FROM dict_app WHERE app_name = 'BEFORE.EXE'").Scan(&count)
+	if count != 1 {
+		t.Error("Restored database should contain rows from the snapshot")
+	}
+
+	// Store is usable after restore and the safety snapshot exists
+	if _, err := store.GetOrCreateDictApp("AFTER.EXE"); err != nil {
+		t.Errorf("Store should be usable after restore: %v", err)
+	}
+
+	snapshots, err := store.ListSnapshots()
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	hasPreRestore := false
+	for _, s := range snapshots {
+		if s.Kind == SnapshotPreRestore {
+			hasPreRestore = true
+		}
+	}
+	if !hasPreRestore {
+		t.Error("Restore should keep a PRE_RESTORE snapshot of the replaced database")
+	}
+}
+
+func TestRestoreRejectsInvalidSnapshot(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	backupDir := t.TempDir()
+	store.SetSetting(SettingBackupDir, backupDir)
+
+	name := "chronicle_manual_20260101T000000Z.db"
+	if err := os.WriteFile(filepath.Join(backupDir, name), []byte("not a database"), 0644); err != nil {
+		t.Fatalf("Failed to write junk snapshot: %v", err)
+	}
+
+	if err := store.RestoreSnapshot(name); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("Restore should reject an invalid snapshot, got %v", err)
+	}
+
+	if err := store.RestoreSnapshot("../test.db"); err == nil {
+		t.Error("Restore should reject names outside the backup folder")
+	}
+
+	// Live database untouched
+	if _, err := store.GetOrCreateDictApp("STILL.EXE"); err != nil {
+		t.Errorf("Store should still be usable: %v", err)
+	}
+}
+
+func TestRestoreWaitsForWriters(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	store.SetSetting(SettingBackupDir, t.TempDir())
+	if _, err := store.GetOrCreateDictApp("BEFORE.EXE"); err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	snap, err := store.CreateSnapshot(SnapshotManual)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+
+	// Writes racing a restore wait for the restored database instead of
+	// finding the store closed
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			appID, err := store.GetOrCreateDictApp("BEFORE.EXE")
+			if err == nil {
+				end := time.Now()
+				err = store.InsertRawEvent(&RawEvent{TsStart: end.Add(-time.Second), TsEnd: &end, AppID: appID, State: "ACTIVE", Source: "OS"})
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		if err := store.RestoreSnapshot(snap.Name); err != nil {
+			t.Errorf("Restore failed: %v", err)
+		}
+	}
+	close(done)
+	if err := <-errs; err != nil {
+		t.Errorf("Writes during a restore should wait for it, got %v", err)
+	}
+}
+
+func TestScheduledBackupRotation(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	store.SetSetting(SettingBackupDir, t.TempDir())
+	store.SetSetting(SettingBackupDailyKeep, "3")
+	store.SetSetting(SettingBackupWeeklyKeep, "2")
+
+	day := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC) // Monday
+	for i := 0; i < 21; i++ {
+		if _, err := store.RunScheduledBackup(day.AddDate(0, 0, i)); err != nil {
+			t.Fatalf("Scheduled backup failed on day %d: %v", i, err)
+		}
+	}
+
+	// Second run on the same day is a no-op
+	created, err := store.RunScheduledBackup(day.AddDate(0, 0, 20))
+	if err != nil {
+		t.Fatalf("Scheduled backup failed: %v", err)
+	}
+	if len(created) != 0 {
+		t.Errorf("Expected no new snapshots on a repeat run, got %d", len(created))
+	}
+
+	snapshots, _ := store.ListSnapshots()
+	counts := make(map[string]int)
+	for _, s := range snapshots {
+		counts[s.Kind]++
+	}
+
+	if counts[SnapshotDaily] != 3 {
+		t.Errorf("Expected 3 daily snapshots, got %d", counts[SnapshotDaily])
+	}
+	if counts[SnapshotWeekly] != 2 {
+		t.Errorf("Expected 2 weekly snapshots, got %d", counts[SnapshotWeekly])
+	}
+}
+
+// seedDataset inserts a profile, a rule and an assigned block with label history
+func seedDataset(t *testing.T, store *Store, appName string) {
+	t.Helper()
+
+	profileID := storetest.SeedExcelRule(t, store.DB)
+	if _, err := store.DB.Exec("INSERT INTO keyword_blacklist (keyword_text) VALUES ('Netflix')"); err != nil {
+		t.Fatalf("Seed failed (blacklist): %v", err)
+	}
+
+	appID, _ := store.GetOrCreateDictApp(appName)
+	titleID, _ := store.GetOrCreateDictTitle("Budget.xlsx - Excel")
+
+	block := &Block{
+		TsStart:        time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC),
+		TsEnd:          time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC),
+		PrimaryAppID:   appID,
+		TitleSummaryID: &titleID,
+		ProfileID:      &profileID,
+		Confidence:     "HIGH",
+		Billable:       true,
+	}
+	if err := store.InsertBlock(block); err != nil {
+		t.Fatalf("Failed to insert block: %v", err)
+	}
+
+	_, err := store.DB.Exec("INSERT INTO ml_label_event (block_id, new_profile_id) VALUES (?, ?)", block.BlockID, profileID)
+	if err != nil {
+		t.Fatalf("Failed to insert label event: %v", err)
+	}
+}
+
+func TestDatasetExportImport(t *testing.T) {
+	source, _ := setupTestDB(t)
+	defer source.Close()
+	seedDataset(t, source, "EXCEL.EXE")
+
+	// Dictionary IDs held in block metadata and a raw event
+	outlookID, _ := source.GetOrCreateDictApp("OUTLOOK.EXE")
+	domainID, _ := source.GetOrCreateDictDomain("go.xero.com")
+	source.DB.Exec("UPDATE block SET metadata = ?",
+		fmt.Sprintf(`{"domain_ids":[%d],"interruptions":[{"app_id":%d,"start":"2026-01-05T09:10:00Z","seconds":60}]}`, domainID, outlookID))
+	eventEnd := time.Date(2026, 1, 5, 9, 30, 0, 0, time.UTC)
+	source.InsertRawEvent(&RawEvent{TsStart: eventEnd.Add(-30 * time.Minute), TsEnd: &eventEnd, AppID: outlookID, DomainID: &domainID, State: "ACTIVE", Source: "OS"})
+
+	var buf bytes.Buffer
+	manifest, err := source.ExportDataset(&buf, "test")
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if manifest.Tables["block"] != 1 || manifest.SchemaVersion != LatestMigrationVersion() {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+
+	target, _ := setupTestDB(t)
+	defer target.Close()
+
+	// Shift target dictionary IDs so remapping is exercised
+	target.GetOrCreateDictApp("OTHER.EXE")
+	target.GetOrCreateDictTitle("Other title")
+	target.GetOrCreateDictDomain("other.example")
+
+	data := buf.Bytes()
+	report, err := target.ImportDataset(bytes.NewReader(data), int64(len(data)), ImportModeMerge)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if report.Tables["block"].Inserted != 1 || report.Tables["ml_label_event"].Inserted != 1 {
+		t.Errorf("Unexpected import report: block=%+v label=%+v", report.Tables["block"], report.Tables["ml_label_event"])
+	}
+
+	// Block must point at the remapped app, title and profile
+	var appName, titleText, profileName string
+	err = target.DB.QueryRow(`
+		SELECT da.app_name, dt.title_text, p.name
+		FROM block b
+		JOIN dict_app da ON b.primary_app_id = da.app_id
+		JOIN dict_title dt ON b.title_summary_id = dt.title_id
+		JOIN profile p ON b.profile_id = p.profile_id
+	`).Scan(&appName, &titleText, &profileName)
+	if err != nil {
+		t.Fatalf("Imported block has broken references: %v", err)
+	}
+	if appName != "EXCEL.EXE" || titleText != "Budget.xlsx - Excel" || profileName != "ABC Books" {
+		t.Errorf("Block remapped incorrectly: %s / %s / %s", appName, titleText, profileName)
+	}
+
+	var domainText, interruptedApp, eventApp string
+	target.DB.QueryRow(`
+		SELECT dd.domain_text, da.app_name FROM block b
+		JOIN dict_domain dd ON dd.domain_id = json_extract(b.metadata, '$.domain_ids[0]')
+		JOIN dict_app da ON da.app_id = json_extract(b.metadata, '$.interruptions[0].app_id')
+	`).Scan(&domainText, &interruptedApp)
+	if domainText != "go.xero.com" || interruptedApp != "OUTLOOK.EXE" {
+		t.Errorf("Block metadata remapped incorrectly: domain %q, interruption app %q", domainText, interruptedApp)
+	}
+	target.DB.QueryRow("SELECT da.app_name FROM raw_event e JOIN dict_app da ON e.app_id = da.app_id").Scan(&eventApp)
+	if eventApp != "OUTLOOK.EXE" {
+		t.Errorf("Expected the raw event imported with its app, got %q", eventApp)
+	}
+
+	// A second merge finds everything already present
+	report, err = target.ImportDataset(bytes.NewReader(data), int64(len(data)), ImportModeMerge)
+	if err != nil {
+		t.Fatalf("Second import failed: %v", err)
+	}
+	for table, result := range report.Tables {
+		if result.Inserted != 0 {
+			t.Errorf("Second merge inserted %d rows into %s", result.Inserted, table)
+		}
+	}
+
+	// Replace wipes the unrelated target data
+	if _, err := target.ImportDataset(bytes.NewReader(data), int64(len(data)), ImportModeReplace); err != nil {
+		t.Fatalf("Replace import failed: %v", err)
+	}
+	var count int
+	target.DB.QueryRow("SELECT COUNT(*) FROM dict_app WHERE app_name = 'OTHER.EXE'").Scan(&count)
+	if count != 0 {
+		t.Error("Replace import should remove existing data")
+	}
+	target.DB.QueryRow("SELECT COUNT(*) FROM block").Scan(&count)
+	if count != 1 {
+		t.Errorf("Expected 1 block after replace, got %d", count)
+	}
+	target.DB.QueryRow("SELECT COUNT(*) FROM raw_event").Scan(&count)
+	if count != 1 {
+		t.Errorf("Expected the raw event back after replace, got %d", count)
+	}
+}
+
+func TestDatasetImportRejectsBadArchive(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	junk := []byte("not a zip")
+	if _, err := store.ImportDataset(bytes.NewReader(junk), int64(len(junk)), ImportModeMerge); err == nil {
+		t.Error("Import should reject a non-zip payload")
+	}
+
+	var buf bytes.Buffer
+	store.ExportDataset(&buf, "test")
+	data := buf.Bytes()
+	if _, err := store.ImportDataset(bytes.NewReader(data), int64(len(data)), "overwrite"); err == nil {
+		t.Error("Import should reject an unknown mode")
+	}
+}
+
+func TestSearchBlocks(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	if !store.SearchAvailable() {
+		t.Skip("SQLite built without FTS5 (run with -tags sqlite_fts5)")
+	}
+
+	seedDataset(t, store, "EXCEL.EXE")
+
+	// Index picks up blocks written after Init, including metadata summaries
+	appID, _ := store.GetOrCreateDictApp("chrome.exe")
+	titleID, _ := store.GetOrCreateDictTitle("Netflix - Watch Café Shows")
+	meta := `{"avg_activity_score": 0.9, "content_summary": "Quarterly budget review"}`
+	notes := "Call with <Client> about budgets"
+	blocks := []*Block{
+		{
+			TsStart: time.Date(2026, 1, 6, 9, 0, 0, 0, time.UTC), TsEnd: time.Date(2026, 1, 6, 10, 0, 0, 0, time.UTC),
+			PrimaryAppID: appID, Confidence: "LOW", Billable: true, Metadata: &meta, Notes: &notes,
+		},
+		{
+			TsStart: time.Date(2026, 1, 7, 9, 0, 0, 0, time.UTC), TsEnd: time.Date(2026, 1, 7, 10, 0, 0, 0, time.UTC),
+			PrimaryAppID: appID, TitleSummaryID: &titleID, Confidence: "LOW", Billable: true,
+		},
+	}
+	for _, b := range blocks {
+		if err := store.InsertBlock(b); err != nil {
+			t.Fatalf("Failed to insert block: %v", err)
+		}
+	}
+
+	results, err := store.SearchBlocks(SearchParams{Query: "budg"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results for prefix query, got %d", len(results))
+	}
+
+	results, _ = store.SearchBlocks(SearchParams{Query: "client budgets"})
+	if len(results) != 1 || results[0].BlockID != blocks[0].BlockID {
+		t.Fatalf("Expected notes match on block %d, got %+v", blocks[0].BlockID, results)
+	}
+	if want := "&lt;<mark>Client</mark>&gt;"; !bytes.Contains([]byte(results[0].Snippet), []byte(want)) {
+		t.Errorf("Snippet should be escaped and highlighted, got %q", results[0].Snippet)
+	}
+
+	// Date and profile filters
+	results, _ = store.SearchBlocks(SearchParams{Query: "budget", StartDate: "2026-01-06", EndDate: "2026-01-06"})
+	if len(results) != 1 {
+		t.Errorf("Expected 1 result in date range, got %d", len(results))
+	}
+	var profileID int64
+	store.DB.QueryRow("SELECT profile_id FROM profile").Scan(&profileID)
+	results, _ = store.SearchBlocks(SearchParams{Query: "budget", ProfileID: &profileID})
+	if len(results) != 1 || results[0].ClientName == nil || *results[0].ClientName != "Client ABC" {
+		t.Errorf("Expected 1 result for profile with client, got %+v", results)
+	}
+
+	// Keyword-blacklisted titles are hidden, FTS syntax in input is inert
+	results, _ = store.SearchBlocks(SearchParams{Query: "cafe"})
+	if len(results) != 0 {
+		t.Errorf("Blacklisted block should not be returned, got %d", len(results))
+	}
+	if _, err := store.SearchBlocks(SearchParams{Query: `budget" OR NEAR(`}); err != nil {
+		t.Errorf("Query syntax should be sanitized: %v", err)
+	}
+
+	// Edits and deletes keep the index in sync
+	store.DB.Exec("UPDATE block SET description = 'Payroll run' WHERE block_id = ?", blocks[1].BlockID)
+	store.DB.Exec("DELETE FROM keyword_blacklist")
+	results, _ = store.SearchBlocks(SearchParams{Query: "payroll"})
+	if len(results) != 1 {
+		t.Errorf("Expected updated description to be searchable, got %d", len(results))
+	}
+	store.DB.Exec("DELETE FROM block WHERE block_id = ?", blocks[1].BlockID)
+	results, _ = store.SearchBlocks(SearchParams{Query: "payroll"})
+	if len(results) != 0 {
+		t.Errorf("Deleted block should leave the index, got %d", len(results))
+	}
+}
+
+func TestSearchIndexRebuildsOnReopen(t *testing.T) {
+	store, dbPath := setupTestDB(t)
+
+	if !store.SearchAvailable() {
+		store.Close()
+		t.Skip("SQLite built without FTS5 (run with -tags sqlite_fts5)")
+	}
+
+	seedDataset(t, store, "EXCEL.EXE")
+
+	// Simulate an index left stale by a build without FTS5
+	store.DB.Exec("DROP TABLE block_fts")
+	store.Close()
+
+	reopened := NewStore(dbPath)
+	if err := reopened.Init(); err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer reopened.Close()
+
+	results, err := reopened.SearchBlocks(SearchParams{Query: "budget"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("Expected rebuilt index to find seeded block, got %d", len(results))
+	}
+}
+
+func TestTrashRestoreAndPurge(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	seedDataset(t, store, "EXCEL.EXE")
+
+	var blockID int64
+	store.DB.QueryRow("SELECT block_id FROM block").Scan(&blockID)
+
+	if err := store.TrashBlock(blockID); err != nil {
+		t.Fatalf("Trash failed: %v", err)
+	}
+	if err := store.TrashBlock(blockID); err != nil {
+		t.Errorf("Trashing twice should be a no-op: %v", err)
+	}
+	if err := store.TrashBlock(9999); err == nil {
+		t.Error("Trashing a missing block should fail")
+	}
+
+	// Trashed blocks drop out of totals but keep their labels
+	total, _ := store.GetDailyTotalTime(time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC))
+	if total != 0 {
+		t.Errorf("Trashed block should not count toward daily total, got %d", total)
+	}
+
+	trash, err := store.ListTrashedBlocks(0, 0)
+	if err != nil {
+		t.Fatalf("List trash failed: %v", err)
+	}
+	if len(trash) != 1 || trash[0].BlockID != blockID || trash[0].PurgeAfter == "" {
+		t.Fatalf("Expected trashed block %d with purge date, got %+v", blockID, trash)
+	}
+
+	var deletions int
+	store.DB.QueryRow("SELECT COUNT(*) FROM ml_deletion_event").Scan(&deletions)
+	if deletions != 0 {
+		t.Errorf("Trashing should not feed ML deletion learning, got %d events", deletions)
+	}
+
+	if err := store.RestoreTrashedBlock(blockID); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if err := store.RestoreTrashedBlock(blockID); err == nil {
+		t.Error("Restoring a live block should fail")
+	}
+	total, _ = store.GetDailyTotalTime(time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC))
+	if total != 3600 {
+		t.Errorf("Restored block should count again, got %d", total)
+	}
+
+	// Purge only blocks trashed longer than the retention period
+	store.DB.Exec(`INSERT INTO ml_suggestion (entity_type, entity_id, suggestion_type, payload_json, confidence)
+		VALUES ('BLOCK', ?, 'PROFILE_ASSIGN', '{}', 0.7)`, blockID)
+	store.TrashBlock(blockID)
+	store.SetSetting(SettingTrashRetentionDays, "7")
+	if purged, _ := store.PurgeExpiredTrash(time.Now()); purged != 0 {
+		t.Errorf("Fresh trash should not be purged, got %d", purged)
+	}
+	purged, err := store.PurgeExpiredTrash(time.Now().AddDate(0, 0, 8))
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected 1 purged block, got %d (%v)", purged, err)
+	}
+
+	var labels, suggestions int
+	store.DB.QueryRow("SELECT COUNT(*) FROM ml_deletion_event WHERE app_name = 'EXCEL.EXE' AND actor = 'SYSTEM'").Scan(&deletions)
+	store.DB.QueryRow("SELECT COUNT(*) FROM ml_label_event").Scan(&labels)
+	store.DB.QueryRow("SELECT COUNT(*) FROM ml_suggestion").Scan(&suggestions)
+	if deletions != 1 || labels != 0 || suggestions != 0 {
+		t.Errorf("Expiry purge should record 1 system deletion and drop labels and suggestions, got %d deletions, %d labels, %d suggestions", deletions, labels, suggestions)
+	}
+}
+
+func TestAuditHistoryAndUndo(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	seedDataset(t, store, "EXCEL.EXE")
+
+	var blockID int64
+	store.DB.QueryRow("SELECT block_id FROM block").Scan(&blockID)
+
+	// Reassign through BlockAudit, the way handlers do
+	tx, _ := store.DB.Begin()
+	audit := NewBlockAudit(AuditActorUser, AuditReassignBlock)
+	if err := audit.Track(tx, blockID); err != nil {
+		t.Fatalf("Track failed: %v", err)
+	}
+	tx.Exec("UPDATE block SET profile_id = NULL, confidence = 'LOW' WHERE block_id = ?", blockID)
+	reassignID, err := audit.Commit(tx, map[string]interface{}{"new_profile_id": nil})
+	if err != nil || reassignID == 0 {
+		t.Fatalf("Commit failed: id=%d err=%v", reassignID, err)
+	}
+	tx.Commit()
+
+	// A no-op mutation writes nothing
+	tx, _ = store.DB.Begin()
+	audit = NewBlockAudit(AuditActorUser, AuditLockBlock)
+	audit.Track(tx, blockID)
+	if id, _ := audit.Commit(tx, nil); id != 0 {
+		t.Errorf("Unchanged block should not be audited, got entry %d", id)
+	}
+	tx.Commit()
+
+	if err := store.TrashBlock(blockID); err != nil {
+		t.Fatalf("Trash failed: %v", err)
+	}
+
+	history, err := store.ListAudit(AuditFilter{BlockID: &blockID})
+	if err != nil {
+		t.Fatalf("ListAudit failed: %v", err)
+	}
+	if len(history) != 2 || history[0].Action != AuditTrashBlock || history[1].Action != AuditReassignBlock {
+		t.Fatalf("Expected trash then reassign in history, got %+v", history)
+	}
+	if !history[1].Undoable || history[1].Changes[0].Before["confidence"] != "HIGH" {
+		t.Errorf("Reassign entry should be undoable with before snapshot, got %+v", history[1])
+	}
+
+	// Undoing the reassign conflicts while the block sits in the trash
+	if _, err := store.UndoAudit(reassignID); err == nil {
+		t.Error("Undo should refuse when the block changed since")
+	}
+
+	if _, err := store.UndoAudit(history[0].AuditID); err != nil {
+		t.Fatalf("Undo trash failed: %v", err)
+	}
+	if _, err := store.UndoAudit(history[0].AuditID); err == nil {
+		t.Error("Second undo of the same entry should fail")
+	}
+	if _, err := store.UndoAudit(reassignID); err != nil {
+		t.Fatalf("Undo reassign failed: %v", err)
+	}
+
+	var profileID sql.NullInt64
+	var confidence string
+	var deletedAt sql.NullString
+	store.DB.QueryRow("SELECT profile_id, confidence, deleted_at FROM block WHERE block_id = ?", blockID).
+		Scan(&profileID, &confidence, &deletedAt)
+	if !profileID.Valid || confidence != "HIGH" || deletedAt.Valid {
+		t.Errorf("Block should be back to its original state, got profile=%v confidence=%s deleted=%v", profileID, confidence, deletedAt)
+	}
+
+	// Purge is undone by re-creating the block with its original ID, along
+	// with its title timeline and label history
+	store.DB.Exec("INSERT INTO block_title (block_id, title_id, seconds) SELECT block_id, title_summary_id, 3600 FROM block")
+	store.PurgeBlock(blockID)
+	purges, _ := store.ListAudit(AuditFilter{Action: AuditPurgeBlock})
+	if len(purges) != 1 {
+		t.Fatalf("Expected 1 purge entry, got %d", len(purges))
+	}
+	if _, err := store.UndoAudit(purges[0].AuditID); err != nil {
+		t.Fatalf("Undo purge failed: %v", err)
+	}
+	var count, titles, labels int
+	store.DB.QueryRow("SELECT COUNT(*) FROM block WHERE block_id = ?", blockID).Scan(&count)
+	store.DB.QueryRow("SELECT COUNT(*) FROM block_title WHERE block_id = ? AND seconds = 3600", blockID).Scan(&titles)
+	store.DB.QueryRow("SELECT COUNT(*) FROM ml_label_event WHERE block_id = ?", blockID).Scan(&labels)
+	if count != 1 || titles != 1 || labels != 1 {
+		t.Errorf("Purged block should be re-created by undo with its titles and labels, got %d blocks, %d titles, %d labels", count, titles, labels)
+	}
+
+	undos, _ := store.ListAudit(AuditFilter{Action: AuditUndo})
+	if len(undos) != 3 {
+		t.Errorf("Expected 3 undo entries, got %d", len(undos))
+	}
+}
+
+func TestUndoOperation(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	seedDataset(t, store, "EXCEL.EXE")
+	store.DB.Exec(`
+		INSERT INTO block (ts_start, ts_end, primary_app_id, profile_id, confidence)
+		SELECT '2026-01-05T11:00:00Z', '2026-01-05T12:00:00Z', primary_app_id, profile_id, confidence FROM block
+	`)
+	var ids []int64
+	rows, _ := store.DB.Query("SELECT block_id FROM block ORDER BY block_id")
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	// One operation committed as two entries
+	operationID, err := NewOperationID()
+	if err != nil {
+		t.Fatalf("Failed to create operation ID: %v", err)
+	}
+	for _, id := range ids {
+		tx, _ := store.DB.Begin()
+		audit := NewBlockAudit(AuditActorUser, AuditApplyRules)
+		audit.Track(tx, id)
+		tx.Exec("UPDATE block SET profile_id = NULL, confidence = 'LOW' WHERE block_id = ?", id)
+		if _, err := audit.Commit(tx, map[string]interface{}{"operation_id": operationID}); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+		tx.Commit()
+	}
+
+	// A conflict in one entry leaves the whole operation applied
+	store.DB.Exec("UPDATE block SET notes = 'edited' WHERE block_id = ?", ids[0])
+	if _, err := store.UndoOperation(operationID); err == nil || !strings.Contains(err.Error(), "conflict") {
+		t.Fatalf("Expected a conflict, got %v", err)
+	}
+	var reverted int
+	store.DB.QueryRow("SELECT COUNT(*) FROM block WHERE confidence = 'HIGH'").Scan(&reverted)
+	if reverted != 0 {
+		t.Errorf("A failed operation undo should revert nothing, got %d blocks back", reverted)
+	}
+
+	store.DB.Exec("UPDATE block SET notes = NULL WHERE block_id = ?", ids[0])
+	undoIDs, err := store.UndoOperation(operationID)
+	if err != nil || len(undoIDs) != 2 {
+		t.Fatalf("Expected both entries undone, got %v (%v)", undoIDs, err)
+	}
+	store.DB.QueryRow("SELECT COUNT(*) FROM block WHERE confidence = 'HIGH' AND profile_id IS NOT NULL").Scan(&reverted)
+	if reverted != 2 {
+		t.Errorf("Expected both blocks reassigned back, got %d", reverted)
+	}
+}
+
+func TestSplitBlock(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	seedDataset(t, store, "EXCEL.EXE")
+
+	var blockID, appID, titleID int64
+	store.DB.QueryRow("SELECT block_id, primary_app_id, title_summary_id FROM block").Scan(&blockID, &appID, &titleID)
+	store.DB.Exec("INSERT INTO block_title (block_id, title_id, seconds) VALUES (?, ?, 3600)", blockID, titleID)
+
+	res, _ := store.DB.Exec("INSERT INTO profile (client_id, service_id, rate_id, name) SELECT client_id, service_id, rate_id, 'Other' FROM profile")
+	otherProfile, _ := res.LastInsertId()
+
+	// Raw events behind the block: busy first half hour on the budget,
+	// quieter second on a forecast
+	forecastID, _ := store.GetOrCreateDictTitle("Forecast.xlsx - Excel")
+	start := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	for i, score := range []string{`{"activity_score":0.8}`, `{"activity_score":0.4}`} {
+		tsStart := start.Add(time.Duration(i) * 30 * time.Minute)
+		tsEnd := tsStart.Add(30 * time.Minute)
+		meta := score
+		title := []int64{titleID, forecastID}[i]
+		store.InsertRawEvent(&RawEvent{TsStart: tsStart, TsEnd: &tsEnd, AppID: appID, TitleID: &title, State: "ACTIVE", Source: "OS", Metadata: &meta})
+	}
+
+	points := []time.Time{start.Add(20 * time.Minute), start.Add(40 * time.Minute)}
+	notBillable := false
+	if _, err := store.SplitBlock(blockID, points, []SplitSegment{{}}); err == nil {
+		t.Error("Split should reject a segment count that doesn't match the points")
+	}
+	if _, err := store.SplitBlock(blockID, []time.Time{start}, nil); err == nil {
+		t.Error("Split should reject a point on the block's edge")
+	}
+
+	ids, err := store.SplitBlock(blockID, points, []SplitSegment{{}, {}, {Billable: &notBillable, ProfileID: &otherProfile}})
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	if len(ids) != 3 || ids[0] != blockID {
+		t.Fatalf("Expected the original block and two new ones, got %v", ids)
+	}
+
+	wantScores := []float64{0.8, 0.6, 0.4}
+	for i, id := range ids {
+		var tsStart string
+		var score float64
+		var billable bool
+		store.DB.QueryRow("SELECT ts_start, activity_score, billable FROM block WHERE block_id = ?", id).
+			Scan(&tsStart, &score, &billable)
+		if tsStart != start.Add(time.Duration(i)*20*time.Minute).Format(time.RFC3339) {
+			t.Errorf("Segment %d starts at %s", i, tsStart)
+		}
+		if math.Abs(score-wantScores[i]) > 0.001 {
+			t.Errorf("Segment %d: expected activity score %.1f, got %.3f", i, wantScores[i], score)
+		}
+		if billable != (i < 2) {
+			t.Errorf("Segment %d: unexpected billable %v", i, billable)
+		}
+	}
+
+	var lastTitle int64
+	store.DB.QueryRow("SELECT title_summary_id FROM block WHERE block_id = ?", ids[2]).Scan(&lastTitle)
+	if lastTitle != forecastID {
+		t.Errorf("Expected the last segment titled by its own events, got title %d", lastTitle)
+	}
+
+	var labels, reassigned int
+	store.DB.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(new_profile_id IS NOT old_profile_id), 0)
+		FROM ml_label_event WHERE action_type = 'SPLIT'
+	`).Scan(&labels, &reassigned)
+	if labels != 3 || reassigned != 1 {
+		t.Errorf("Expected a label event per segment with 1 profile change, got %d events, %d changes", labels, reassigned)
+	}
+	var newProfile int64
+	store.DB.QueryRow("SELECT new_profile_id FROM ml_label_event WHERE action_type = 'SPLIT' AND block_id = ?", ids[2]).Scan(&newProfile)
+	if newProfile != otherProfile {
+		t.Errorf("Expected the last segment labelled with profile %d, got %d", otherProfile, newProfile)
+	}
+
+	// Undo puts the block back together and drops what was attached to the new segments
+	store.DB.Exec(`INSERT INTO ml_suggestion (entity_type, entity_id, suggestion_type, payload_json, confidence)
+		VALUES ('BLOCK', ?, 'PROFILE_ASSIGN', '{}', 0.7)`, ids[1])
+	splits, _ := store.ListAudit(AuditFilter{Action: AuditSplitBlock})
+	if len(splits) != 1 {
+		t.Fatalf("Expected 1 split entry, got %d", len(splits))
+	}
+	if _, err := store.UndoAudit(splits[0].AuditID); err != nil {
+		t.Fatalf("Undo split failed: %v", err)
+	}
+	var count int
+	var tsEnd string
+	store.DB.QueryRow("SELECT COUNT(*), MAX(ts_end) FROM block").Scan(&count, &tsEnd)
+	if count != 1 || tsEnd != "2026-01-05T10:00:00Z" {
+		t.Errorf("Expected the whole block back, got %d blocks ending %s", count, tsEnd)
+	}
+	var titles, seconds int
+	store.DB.QueryRow("SELECT COUNT(*), SUM(seconds) FROM block_title").Scan(&titles, &seconds)
+	if titles != 1 || seconds != 3600 {
+		t.Errorf("Expected the original title timeline back, got %d rows, %d seconds", titles, seconds)
+	}
+	var suggestions int
+	store.DB.QueryRow("SELECT COUNT(*) FROM ml_suggestion").Scan(&suggestions)
+	if suggestions != 0 {
+		t.Errorf("Expected suggestions on removed segments to go with them, got %d", suggestions)
+	}
+
+	store.DB.Exec("UPDATE block SET locked = 1 WHERE block_id = ?", blockID)
+	if _, err := store.SplitBlock(blockID, points[:1], nil); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("Split should refuse a locked block, got %v", err)
+	}
+}
+
+func TestMergeBlocks(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	seedDataset(t, store, "EXCEL.EXE")
+
+	var first, appID, profileA int64
+	store.DB.QueryRow("SELECT block_id, primary_app_id, profile_id FROM block").Scan(&first, &appID, &profileA)
+	res, _ := store.DB.Exec("INSERT INTO profile (client_id, service_id, rate_id, name) SELECT client_id, service_id, rate_id, 'Other' FROM profile")
+	profileB, _ := res.LastInsertId()
+
+	// 09:00-10:00 profile A, 10:00-10:30 unassigned, 10:30-11:00 profile B
+	budget, review, filing := "Budget", "Review", "Filing"
+	store.DB.Exec("UPDATE block SET activity_score = 1.0, description = ? WHERE block_id = ?", budget, first)
+	day := time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)
+	second := &Block{TsStart: day.Add(10 * time.Hour), TsEnd: day.Add(10*time.Hour + 30*time.Minute), PrimaryAppID: appID,
+		Confidence: "LOW", Description: &review, ActivityScore: 0.5}
+	third := &Block{TsStart: day.Add(10*time.Hour + 30*time.Minute), TsEnd: day.Add(11 * time.Hour), PrimaryAppID: appID,
+		ProfileID: &profileB, Confidence: "HIGH", Description: &filing, ActivityScore: 0.8}
+	nextDay := &Block{TsStart: day.Add(33 * time.Hour), TsEnd: day.Add(34 * time.Hour), PrimaryAppID: appID, Confidence: "LOW"}
+	for _, b := range []*Block{second, third, nextDay} {
+		if err := store.InsertBlock(b); err != nil {
+			t.Fatalf("Failed to insert block: %v", err)
+		}
+	}
+
+	store.DB.Exec(`INSERT INTO ml_suggestion (entity_type, entity_id, suggestion_type, payload_json, confidence)
+		VALUES ('BLOCK', ?, 'MERGE_BLOCKS', ?, 0.9)`, second.BlockID, fmt.Sprintf(`{"block_ids":[%d]}`, first))
+	store.DB.Exec(`INSERT INTO ml_suggestion (entity_type, entity_id, suggestion_type, payload_json, confidence)
+		VALUES ('BLOCK', ?, 'PROFILE_ASSIGN', '{}', 0.7)`, third.BlockID)
+
+	// Title timelines and a label on a block that goes away
+	var titleID int64
+	store.DB.QueryRow("SELECT title_summary_id FROM block WHERE block_id = ?", first).Scan(&titleID)
+	store.DB.Exec("INSERT INTO block_title (block_id, title_id, seconds) VALUES (?, ?, 3600), (?, ?, 1800)", first, titleID, second.BlockID, titleID)
+	store.DB.Exec("INSERT INTO ml_label_event (block_id, new_profile_id) VALUES (?, ?)", third.BlockID, profileB)
+
+	if _, err := store.MergeBlocks([]int64{third.BlockID, nextDay.BlockID}, MergeOptions{}); err == nil {
+		t.Error("Merge should reject blocks from different days")
+	}
+	if _, err := store.MergeBlocks([]int64{first, third.BlockID}, MergeOptions{}); err == nil {
+		t.Error("Merge should reject a block lying in between")
+	}
+
+	store.DB.Exec("UPDATE block SET locked = 1 WHERE block_id = ?", second.BlockID)
+	if _, err := store.MergeBlocks([]int64{first, second.BlockID, third.BlockID}, MergeOptions{}); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Fatalf("Merge should refuse a locked block, got %v", err)
+	}
+
+	mergedID, err := store.MergeBlocks([]int64{third.BlockID, second.BlockID, first}, MergeOptions{Force: true})
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if mergedID != first {
+		t.Errorf("Expected the earliest block to survive, got %d", mergedID)
+	}
+
+	var tsEnd, confidence string
+	var profileID int64
+	var score float64
+	var description string
+	var locked bool
+	store.DB.QueryRow("SELECT ts_end, profile_id, confidence, activity_score, description, locked FROM block WHERE block_id = ?", first).
+		Scan(&tsEnd, &profileID, &confidence, &score, &description, &locked)
+	if tsEnd != "2026-01-05T11:00:00Z" {
+		t.Errorf("Merged block should end at 11:00, got %s", tsEnd)
+	}
+	if profileID != profileA || confidence != "LOW" {
+		t.Errorf("Expected the longest profile %d flagged LOW, got %d %s", profileA, profileID, confidence)
+	}
+	if math.Abs(score-0.825) > 0.001 {
+		t.Errorf("Expected time-weighted activity score 0.825, got %.3f", score)
+	}
+	if description != "Budget; Review; Filing" || !locked {
+		t.Errorf("Unexpected description %q or locked %v", description, locked)
+	}
+
+	var count int
+	store.DB.QueryRow("SELECT COUNT(*) FROM block").Scan(&count)
+	if count != 2 {
+		t.Errorf("Expected merged blocks removed, got %d blocks", count)
+	}
+	var status string
+	var entityID int64
+	store.DB.QueryRow("SELECT status, entity_id FROM ml_suggestion WHERE suggestion_type = 'MERGE_BLOCKS'").Scan(&status, &entityID)
+	if status != "ACCEPTED" || entityID != first {
+		t.Errorf("Expected the merge suggestion accepted on the merged block, got %s on %d", status, entityID)
+	}
+
+	merges, _ := store.ListAudit(AuditFilter{Action: AuditMergeBlocks})
+	if len(merges) != 1 {
+		t.Fatalf("Expected 1 merge entry, got %d", len(merges))
+	}
+	if _, err := store.UndoAudit(merges[0].AuditID); err != nil {
+		t.Fatalf("Undo merge failed: %v", err)
+	}
+	store.DB.QueryRow("SELECT COUNT(*) FROM block").Scan(&count)
+	store.DB.QueryRow("SELECT ts_end FROM block WHERE block_id = ?", first).Scan(&tsEnd)
+	if count != 4 || tsEnd != "2026-01-05T10:00:00Z" {
+		t.Errorf("Expected the blocks back apart, got %d blocks, first ending %s", count, tsEnd)
+	}
+
+	// Along with everything hanging off them
+	var firstTitle, secondTitle, labels, mergeLabels, assigns int
+	store.DB.QueryRow("SELECT COALESCE(SUM(seconds), 0) FROM block_title WHERE block_id = ?", first).Scan(&firstTitle)
+	store.DB.QueryRow("SELECT COALESCE(SUM(seconds), 0) FROM block_title WHERE block_id = ?", second.BlockID).Scan(&secondTitle)
+	store.DB.QueryRow("SELECT COUNT(*) FROM ml_label_event WHERE block_id = ?", third.BlockID).Scan(&labels)
+	store.DB.QueryRow("SELECT COUNT(*) FROM ml_label_event WHERE action_type = 'MERGE'").Scan(&mergeLabels)
+	store.DB.QueryRow("SELECT COUNT(*) FROM ml_suggestion WHERE suggestion_type = 'PROFILE_ASSIGN' AND entity_id = ?", third.BlockID).Scan(&assigns)
+	if firstTitle != 3600 || secondTitle != 1800 {
+		t.Errorf("Expected the title timelines back, got %d and %d seconds", firstTitle, secondTitle)
+	}
+	if labels != 1 || mergeLabels != 0 || assigns != 1 {
+		t.Errorf("Expected the removed block's label and suggestion back and the merge label gone, got %d, %d, %d", labels, mergeLabels, assigns)
+	}
+	var resolvedAt sql.NullString
+	store.DB.QueryRow("SELECT status, entity_id, resolved_at FROM ml_suggestion WHERE suggestion_type = 'MERGE_BLOCKS'").Scan(&status, &entityID, &resolvedAt)
+	if status != "PENDING" || entityID != second.BlockID || resolvedAt.Valid {
+		t.Errorf("Expected the merge suggestion pending on block %d again, got %s on %d", second.BlockID, status, entityID)
+	}
+}
+
+func TestEditBlock(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	seedDataset(t, store, "EXCEL.EXE")
+
+	var blockID, appID int64
+	store.DB.QueryRow("SELECT block_id, primary_app_id FROM block").Scan(&blockID, &appID)
+
+	day := time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)
+	next := &Block{TsStart: day.Add(10 * time.Hour), TsEnd: day.Add(11 * time.Hour), PrimaryAppID: appID, Confidence: "LOW"}
+	if err := store.InsertBlock(next); err != nil {
+		t.Fatalf("Failed to insert block: %v", err)
+	}
+
+	// The next block's first 20 minutes were on a different title
+	forecastID, _ := store.GetOrCreateDictTitle("Forecast.xlsx - Excel")
+	ledgerID, _ := store.GetOrCreateDictTitle("Ledger.xlsx - Excel")
+	for _, e := range []struct {
+		from, to time.Duration
+		title    int64
+	}{{10 * time.Hour, 10*time.Hour + 20*time.Minute, forecastID}, {10*time.Hour + 20*time.Minute, 11 * time.Hour, ledgerID}} {
+		tsEnd := day.Add(e.to)
+		title := e.title
+		store.InsertRawEvent(&RawEvent{TsStart: day.Add(e.from), TsEnd: &tsEnd, AppID: appID, TitleID: &title, State: "ACTIVE", Source: "OS"})
+	}
+	store.DB.Exec("UPDATE block SET title_summary_id = ? WHERE block_id = ?", forecastID, next.BlockID)
+
+	notes := "Checked with client"
+	if _, err := store.EditBlock(blockID, BlockEdit{Notes: &notes}, false); err != nil {
+		t.Fatalf("Edit notes failed: %v", err)
+	}
+
+	title := "Call"
+	if _, err := store.EditBlock(blockID, BlockEdit{Title: &title}, false); err == nil {
+		t.Error("Title should only be editable on manual entries")
+	}
+
+	later := day.Add(10*time.Hour + 20*time.Minute)
+	if _, err := store.EditBlock(blockID, BlockEdit{TsEnd: &later}, false); err == nil || !strings.Contains(err.Error(), "overlaps") {
+		t.Fatalf("Overlapping edit should be rejected, got %v", err)
+	}
+	whole := day.Add(11 * time.Hour)
+	if _, err := store.EditBlock(blockID, BlockEdit{TsEnd: &whole}, true); err == nil {
+		t.Error("Trim should not swallow a whole neighbour")
+	}
+
+	trimmed, err := store.EditBlock(blockID, BlockEdit{TsEnd: &later}, true)
+	if err != nil {
+		t.Fatalf("Edit with trim failed: %v", err)
+	}
+	if len(trimmed) != 1 || trimmed[0] != next.BlockID {
+		t.Fatalf("Expected the next block trimmed, got %v", trimmed)
+	}
+
+	var tsEnd, nextStart, savedNotes string
+	store.DB.QueryRow("SELECT ts_end, notes FROM block WHERE block_id = ?", blockID).Scan(&tsEnd, &savedNotes)
+	store.DB.QueryRow("SELECT ts_start FROM block WHERE block_id = ?", next.BlockID).Scan(&nextStart)
+	if tsEnd != "2026-01-05T10:20:00Z" || nextStart != tsEnd || savedNotes != notes {
+		t.Errorf("Unexpected result: end %s, next start %s, notes %q", tsEnd, nextStart, savedNotes)
+	}
+	var nextTitle int64
+	store.DB.QueryRow("SELECT title_summary_id FROM block WHERE block_id = ?", next.BlockID).Scan(&nextTitle)
+	if nextTitle != ledgerID {
+		t.Errorf("Expected the trimmed block retitled to what is left of it, got title %d", nextTitle)
+	}
+
+	edits, _ := store.ListAudit(AuditFilter{Action: AuditEditBlock})
+	if len(edits) != 2 || len(edits[0].BlockIDs) != 2 {
+		t.Fatalf("Expected 2 edit entries, the last covering both blocks, got %+v", edits)
+	}
+	if _, err := store.UndoAudit(edits[0].AuditID); err != nil {
+		t.Fatalf("Undo edit failed: %v", err)
+	}
+	store.DB.QueryRow("SELECT ts_start FROM block WHERE block_id = ?", next.BlockID).Scan(&nextStart)
+	if nextStart != "2026-01-05T10:00:00Z" {
+		t.Errorf("Undo should restore the trimmed neighbour, got start %s", nextStart)
+	}
+
+	store.DB.Exec("UPDATE block SET locked = 1 WHERE block_id = ?", blockID)
+	if _, err := store.EditBlock(blockID, BlockEdit{Notes: &notes}, false); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("Edit should refuse a locked block, got %v", err)
+	}
+}
+
+func TestBulkUpdateBlocks(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	seedDataset(t, store, "EXCEL.EXE")
+
+	var seeded, profileID int64
+	store.DB.QueryRow("SELECT block_id, profile_id FROM block").Scan(&seeded, &profileID)
+
+	// Three unassigned Chrome blocks the next day, one of them locked
+	chrome, _ := store.GetOrCreateDictApp("chrome.exe")
+	day := time.Date(2026, 1, 6, 9, 0, 0, 0, time.UTC)
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		b := &Block{TsStart: day.Add(time.Duration(i) * time.Hour), TsEnd: day.Add(time.Duration(i)*time.Hour + 30*time.Minute),
+			PrimaryAppID: chrome, Confidence: "LOW", Locked: i == 2}
+		if err := store.InsertBlock(b); err != nil {
+			t.Fatalf("Failed to insert block: %v", err)
+		}
+		ids = append(ids, b.BlockID)
+	}
+
+	if _, _, err := store.BulkUpdateBlocks(nil, &BlockFilter{}, BulkAction{Action: BulkDelete}); err == nil {
+		t.Error("An empty filter should not select every block")
+	}
+
+	results, auditID, err := store.BulkUpdateBlocks(nil, &BlockFilter{Date: "2026-01-06", App: "CHROME.EXE", Unassigned: true},
+		BulkAction{Action: BulkReassign, ProfileID: &profileID})
+	if err != nil {
+		t.Fatalf("Bulk reassign failed: %v", err)
+	}
+	if len(results) != 3 || results[0].Status != "updated" || results[1].Status != "updated" || results[2].Status != "skipped" {
+		t.Fatalf("Expected two updated and the locked block skipped, got %+v", results)
+	}
+
+	var labels int
+	store.DB.QueryRow("SELECT COUNT(*) FROM ml_label_event WHERE block_id IN (?, ?)", ids[0], ids[1]).Scan(&labels)
+	if labels != 2 {
+		t.Errorf("Expected a label event per reassigned block, got %d", labels)
+	}
+
+	entries, _ := store.ListAudit(AuditFilter{Action: AuditBulkUpdate})
+	if len(entries) != 1 || entries[0].AuditID != auditID || len(entries[0].BlockIDs) != 2 {
+		t.Fatalf("Expected one grouped audit entry for both blocks, got %+v", entries)
+	}
+
+	results, _, err = store.BulkUpdateBlocks([]int64{seeded, ids[0], 9999}, nil, BulkAction{Action: BulkBillable, Billable: true})
+	if err != nil {
+		t.Fatalf("Bulk billable failed: %v", err)
+	}
+	if results[0].Status != "unchanged" || results[1].Status != "updated" || results[2].Error != "block not found" {
+		t.Errorf("Unexpected billable results %+v", results)
+	}
+
+	if _, _, err := store.BulkUpdateBlocks(ids, nil, BulkAction{Action: BulkDelete}); err != nil {
+		t.Fatalf("Bulk delete failed: %v", err)
+	}
+	var live int
+	store.DB.QueryRow("SELECT COUNT(*) FROM block WHERE deleted_at IS NULL").Scan(&live)
+	if live != 2 {
+		t.Errorf("Expected the seeded and locked blocks left, got %d", live)
+	}
+
+	// Undo brings the whole batch back
+	entries, _ = store.ListAudit(AuditFilter{Action: AuditBulkUpdate, Limit: 1})
+	if _, err := store.UndoAudit(entries[0].AuditID); err != nil {
+		t.Fatalf("Undo bulk delete failed: %v", err)
+	}
+	store.DB.QueryRow("SELECT COUNT(*) FROM block WHERE deleted_at IS NULL").Scan(&live)
+	if live != 4 {
+		t.Errorf("Expected all blocks back after undo, got %d", live)
+	}
+}
+
+func TestDictCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newLRUCache(2)
+	cache.put("a", 1)
+	cache.put("b", 2)
+	cache.get("a") // b is now least recently used
+	cache.put("c", 3)
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if id, ok := cache.get("a"); !ok || id != 1 {
+		t.Error("a should still be cached")
+	}
+
+	stats := cache.stats()
+	if stats.Size != 2 || stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestCollectDictGarbage(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	appID, _ := store.GetOrCreateDictApp("chrome.exe")
+	usedTitle, _ := store.GetOrCreateDictTitle("Inbox - Gmail")
+	store.GetOrCreateDictTitle("Old tab")
+	store.GetOrCreateDictDomain("example.com")
+
+	now := time.Now().UTC()
+	end := now.Add(time.Minute)
+	if err := store.InsertRawEvent(&RawEvent{
+		TsStart: now, TsEnd: &end, AppID: appID, TitleID: &usedTitle, State: "ACTIVE", Source: "OS",
+	}); err != nil {
+		t.Fatalf("Failed to insert event: %v", err)
+	}
+
+	// A domain only a block's metadata still lists, its raw events archived
+	visited, _ := store.GetOrCreateDictDomain("go.xero.com")
+	meta := fmt.Sprintf(`{"domain_ids":[%d]}`, visited)
+	if err := store.InsertBlock(&Block{TsStart: now, TsEnd: end, PrimaryAppID: appID, Confidence: "LOW", Metadata: &meta}); err != nil {
+		t.Fatalf("Failed to insert block: %v", err)
+	}
+	if err := store.InsertBlock(&Block{TsStart: end, TsEnd: end.Add(time.Minute), PrimaryAppID: appID, Confidence: "LOW"}); err != nil {
+		t.Fatalf("Failed to insert block: %v", err)
+	}
+	store.DB.Exec("UPDATE block SET metadata = 'not json' WHERE metadata IS NULL") // Must not break GC
+
+	// Cached IDs are protected, so nothing goes while the cache is warm
+	if titles, domains, err := store.CollectDictGarbage(); err != nil || titles != 0 || domains != 0 {
+		t.Fatalf("Expected cached rows to survive, got %d titles, %d domains (%v)", titles, domains, err)
+	}
+
+	store.dictCache.reset()
+	titles, domains, err := store.CollectDictGarbage()
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if titles != 1 || domains != 1 {
+		t.Errorf("Expected 1 title and 1 domain collected, got %d and %d", titles, domains)
+	}
+
+	var remaining, domain string
+	store.DB.QueryRow("SELECT GROUP_CONCAT(title_text) FROM dict_title").Scan(&remaining)
+	if remaining != "Inbox - Gmail" {
+		t.Errorf("Referenced title should survive, got %q", remaining)
+	}
+	store.DB.QueryRow("SELECT GROUP_CONCAT(domain_text) FROM dict_domain").Scan(&domain)
+	if domain != "go.xero.com" {
+		t.Errorf("Domain listed in block metadata should survive, got %q", domain)
+	}
+
+	// Orphans looked up while GC runs must not be collected under the caller
+	for i := 0; i < 200; i++ {
+		store.GetOrCreateDictTitle(fmt.Sprintf("Tab %d", i))
+	}
+	store.dictCache.reset()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			store.CollectDictGarbage()
+		}
+	}()
+	var ids []int64
+	for i := 0; i < 200; i++ {
+		id, err := store.GetOrCreateDictTitle(fmt.Sprintf("Tab %d", i))
+		if err != nil {
+			t.Fatalf("GetOrCreateDictTitle failed: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	<-done
+
+	for _, id := range ids {
+		var exists int
+		if err := store.DB.QueryRow("SELECT 1 FROM dict_title WHERE title_id = ?", id).Scan(&exists); err != nil {
+			t.Fatalf("Title %d was handed out but collected", id)
+		}
+	}
+}
+
+func TestArchiveAndRehydrateRawEvents(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	appID, _ := store.GetOrCreateDictApp("chrome.exe")
+	titleID, _ := store.GetOrCreateDictTitle("Invoice #42")
+	domainID, _ := store.GetOrCreateDictDomain("billing.example.com")
+
+	// Two events in January, one in February, one recent
+	for _, ts := range []string{"2026-01-10T09:00:00Z", "2026-01-20T09:00:00Z", "2026-02-03T09:00:00Z"} {
+		start, _ := time.Parse(time.RFC3339, ts)
+		end := start.Add(5 * time.Minute)
+		store.InsertRawEvent(&RawEvent{
+			TsStart: start, TsEnd: &end, AppID: appID, TitleID: &titleID, DomainID: &domainID,
+			State: "ACTIVE", Source: "EXTENSION",
+		})
+	}
+	store.InsertRawEvent(&RawEvent{TsStart: time.Now().UTC(), AppID: appID, State: "ACTIVE", Source: "OS"})
+
+	cutoff := time.Now().Add(-14 * 24 * time.Hour)
+	archived, err := store.ArchiveRawEventsBefore(cutoff)
+	if err != nil {
+		t.Fatalf("Archive failed: %v", err)
+	}
+	if archived != 3 {
+		t.Errorf("Expected 3 archived events, got %d", archived)
+	}
+
+	archives, err := store.ListRawEventArchives()
+	if err != nil {
+		t.Fatalf("List archives failed: %v", err)
+	}
+	if len(archives) != 2 || archives[0].Month != "2026-01" || archives[0].EventCount != 2 || archives[1].EventCount != 1 {
+		t.Fatalf("Unexpected archives: %+v", archives)
+	}
+
+	// Reading January back inside a transaction leaves raw_event alone
+	start, _ := time.Parse("2006-01-02", "2026-01-01")
+	end, _ := time.Parse("2006-01-02", "2026-02-01")
+	tx, _ := store.DB.Begin()
+	events, err := store.ArchivedRawEventsTx(tx, start, end)
+	tx.Rollback()
+	if err != nil {
+		t.Fatalf("Reading archived events failed: %v", err)
+	}
+	if len(events) != 2 || events[0].AppID != appID || events[0].TitleID == nil || *events[0].TitleID != titleID {
+		t.Fatalf("Unexpected archived events: %+v", events)
+	}
+
+	// Rehydrate January twice; the second run finds everything already live
+	report, err := store.RehydrateRawEvents(start, end)
+	if err != nil {
+		t.Fatalf("Rehydrate failed: %v", err)
+	}
+	if report.Inserted != 2 || report.Duplicates != 0 {
+		t.Errorf("Unexpected rehydrate report: %+v", report)
+	}
+	report, _ = store.RehydrateRawEvents(start, end)
+	if report.Inserted != 0 || report.Duplicates != 2 {
+		t.Errorf("Expected repeat rehydrate to skip everything, got %+v", report)
+	}
+
+	tx, _ = store.DB.Begin()
+	events, _ = store.ArchivedRawEventsTx(tx, start, end)
+	tx.Rollback()
+	if len(events) != 0 {
+		t.Errorf("Archived events that are live again should be skipped, got %d", len(events))
+	}
+
+	var title, domain string
+	store.DB.QueryRow(`
+		SELECT dt.title_text, dd.domain_text FROM raw_event re
+		JOIN dict_title dt ON re.title_id = dt.title_id
+		JOIN dict_domain dd ON re.domain_id = dd.domain_id
+		WHERE re.ts_start = '2026-01-10T09:00:00Z'
+	`).Scan(&title, &domain)
+	if title != "Invoice #42" || domain != "billing.example.com" {
+		t.Errorf("Rehydrated event lost its strings: %q, %q", title, domain)
+	}
+
+	// Rehydrated events are held, and not written to the archive again
+	archived, _ = store.ArchiveRawEventsBefore(cutoff)
+	if archived != 0 {
+		t.Errorf("Rehydrated events should be held, but %d were removed", archived)
+	}
+	archives, _ = store.ListRawEventArchives()
+	if archives[0].EventCount != 2 {
+		t.Errorf("Rehydrated events were archived twice: %+v", archives[0])
+	}
+}
+
+func TestSecretsEncryptAndRotate(t *testing.T) {
+	store, dbPath := setupTestDB(t)
+	defer store.Close()
+
+	if err := store.SetSecret("extension_token", "s3cret"); err == nil {
+		t.Fatal("SetSecret should fail without a key provider")
+	}
+
+	keyringPath := filepath.Join(filepath.Dir(dbPath), "keyring.json")
+	keyring, err := security.NewFileKeyring(keyringPath)
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+	store.SetKeyProvider(keyring)
+
+	if err := store.SetSecret("extension_token", "s3cret"); err != nil {
+		t.Fatalf("SetSecret failed: %v", err)
+	}
+
+	// The stored value is ciphertext and stays hidden from plain settings
+	var raw []byte
+	store.DB.QueryRow("SELECT value FROM settings WHERE key = 'extension_token'").Scan(&raw)
+	if bytes.Contains(raw, []byte("s3cret")) {
+		t.Error("Secret stored in plaintext")
+	}
+	all, _ := store.GetAllSettings()
+	if _, ok := all["extension_token"]; ok {
+		t.Error("GetAllSettings should hide secrets")
+	}
+	if v, _ := store.GetSetting("extension_token"); v != "" {
+		t.Errorf("GetSetting leaked secret: %q", v)
+	}
+	if err := store.SetSetting("extension_token", "plain"); err == nil {
+		t.Error("SetSetting should not overwrite a secret")
+	}
+
+	// Ciphertext is bound to its key
+	store.DB.Exec("INSERT INTO settings (key, value, is_encrypted) VALUES ('other_token', ?, 1)", raw)
+	if _, err := store.GetSecret("other_token"); err == nil {
+		t.Error("Secret copied to another key should not decrypt")
+	}
+	store.DeleteSecret("other_token")
+
+	rotated, err := store.RotateSecrets()
+	if err != nil {
+		t.Fatalf("RotateSecrets failed: %v", err)
+	}
+	if rotated != 1 {
+		t.Errorf("Expected 1 rotated secret, got %d", rotated)
+	}
+
+	// A fresh keyring from disk only has the new key and still decrypts
+	reloaded, err := security.NewFileKeyring(keyringPath)
+	if err != nil {
+		t.Fatalf("Failed to reload keyring: %v", err)
+	}
+	if _, err := reloaded.Decrypt(raw, secretContext("extension_token")); err == nil {
+		t.Error("Old key should have been retired")
+	}
+	store.SetKeyProvider(reloaded)
+
+	value, err := store.GetSecret("extension_token")
+	if err != nil || value != "s3cret" {
+		t.Errorf("Expected s3cret after rotation, got %q (%v)", value, err)
+	}
+}
+
+func TestIntegrityCheckAndRepair(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	appID, _ := store.GetOrCreateDictApp("code.exe")
+
+	insertBlock := func(start, end string, locked int) int64 {
+		res, err := store.DB.Exec(`
+			INSERT INTO block (ts_start, ts_end, primary_app_id, locked) VALUES (?, ?, ?, ?)
+		`, start, end, appID, locked)
+		if err != nil {
+			t.Fatalf("Failed to insert block: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+
+	first := insertBlock("2026-03-02T09:00:00Z", "2026-03-02T10:00:00Z", 0)
+	overlapping := insertBlock("2026-03-02T09:30:00Z", "2026-03-02T11:00:00Z", 0)
+	lockedLater := insertBlock("2026-03-02T10:45:00Z", "2026-03-02T12:00:00Z", 1)
+	legacyFormat := insertBlock("2026-03-03 09:00:00+00:00", "2026-03-03 10:00:00+00:00", 0)
+	garbage := insertBlock("yesterday-ish", "2026-03-04T10:00:00Z", 0)
+
+	// The first OS event was left open by a crash; the last one is current
+	for _, ts := range []string{"2026-03-02T09:00:00Z", "2026-03-02T09:05:00Z"} {
+		store.DB.Exec("INSERT INTO raw_event (ts_start, app_id, state, source) VALUES (?, ?, 'ACTIVE', 'OS')", ts, appID)
+	}
+	store.DB.Exec("INSERT INTO raw_event (ts_start, app_id, state, source) VALUES ('2026-03-02T09:01:00Z', ?, 'ACTIVE', 'EXTENSION')", appID)
+
+	store.DB.Exec(`
+		INSERT INTO ml_suggestion (entity_type, entity_id, suggestion_type, payload_json, confidence)
+		VALUES ('BLOCK', 9999, 'PROFILE_ASSIGN', '{}', 0.8)
+	`)
+	conn, _ := store.DB.Conn(context.Background())
+	conn.ExecContext(context.Background(), "PRAGMA ignore_check_constraints = ON")
+	conn.ExecContext(context.Background(), `
+		INSERT INTO ml_suggestion (entity_type, entity_id, suggestion_type, payload_json, confidence)
+		VALUES ('BLOCK', ?, 'DELETE_SUGGEST', '{}', 0.6)
+	`, first)
+	conn.ExecContext(context.Background(), "PRAGMA ignore_check_constraints = OFF")
+	conn.Close()
+
+	report, err := store.CheckIntegrity(false)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	counts := map[string]int{}
+	for _, issue := range report.Issues {
+		counts[issue.Class] = issue.Count
+		if issue.Repaired != 0 {
+			t.Errorf("Check-only run repaired %s", issue.Class)
+		}
+	}
+	expected := map[string]int{
+		IntegritySQLite:             0,
+		IntegrityForeignKeys:        0,
+		IntegrityBadTimestamps:      2,
+		IntegrityOverlappingBlocks:  2,
+		IntegrityOpenRawEvents:      1,
+		IntegrityInvalidSuggestions: 1,
+		IntegrityOrphanSuggestions:  1,
+	}
+	for class, want := range expected {
+		if counts[class] != want {
+			t.Errorf("%s: expected %d, got %d", class, want, counts[class])
+		}
+	}
+	if report.Healthy {
+		t.Error("Report should not be healthy")
+	}
+
+	report, err = store.CheckIntegrity(true)
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	var overlapAudit int64
+	for _, issue := range report.Issues {
+		if issue.Repaired != issue.Count {
+			t.Errorf("%s: repaired %d of %d", issue.Class, issue.Repaired, issue.Count)
+		}
+		if issue.Class == IntegrityOverlappingBlocks && issue.AuditID != nil {
+			overlapAudit = *issue.AuditID
+		}
+	}
+
+	var start, end string
+	store.DB.QueryRow("SELECT ts_start, ts_end FROM block WHERE block_id = ?", overlapping).Scan(&start, &end)
+	if start != "2026-03-02T10:00:00Z" || end != "2026-03-02T10:45:00Z" {
+		t.Errorf("Overlapping block should be trimmed to 10:00-10:45, got %s-%s", start, end)
+	}
+	store.DB.QueryRow("SELECT ts_start FROM block WHERE block_id = ?", lockedLater).Scan(&start)
+	if start != "2026-03-02T10:45:00Z" {
+		t.Errorf("Locked block must not move, got %s", start)
+	}
+	store.DB.QueryRow("SELECT ts_start FROM block WHERE block_id = ?", legacyFormat).Scan(&start)
+	if start != "2026-03-03T09:00:00Z" {
+		t.Errorf("Legacy timestamp should be normalized, got %s", start)
+	}
+	var trashed sql.NullString
+	store.DB.QueryRow("SELECT deleted_at FROM block WHERE block_id = ?", garbage).Scan(&trashed)
+	if !trashed.Valid {
+		t.Error("Block with unrecoverable timestamp should be trashed")
+	}
+
+	report, _ = store.CheckIntegrity(false)
+	if !report.Healthy {
+		t.Errorf("Expected healthy database after repair, got %+v", report.Issues)
+	}
+
+	// Block repairs are audited with snapshots and can be undone
+	if overlapAudit == 0 {
+		t.Fatal("Overlap repair should be audited")
+	}
+	if _, err := store.UndoAudit(overlapAudit); err != nil {
+		t.Fatalf("Undo of repair failed: %v", err)
+	}
+	store.DB.QueryRow("SELECT ts_start FROM block WHERE block_id = ?", overlapping).Scan(&start)
+	if start != "2026-03-02T09:30:00Z" {
+		t.Errorf("Undo should restore the original start, got %s", start)
+	}
+}
+
+func TestIntegrityRepairSplitsAroundNestedBlock(t *testing.T) {
+	store, _ := setupTestDB(t)
+	defer store.Close()
+
+	appID, _ := store.GetOrCreateDictApp("code.exe")
+	insertBlock := func(start, end string, locked int) int64 {
+		res, err := store.DB.Exec(`
+			INSERT INTO block (ts_start, ts_end, primary_app_id, locked) VALUES (?, ?, ?, ?)
+		`, start, end, appID, locked)
+		if err != nil {
+			t.Fatalf("Failed to insert block: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+
+	// Two locked blocks sit inside one long automatic block
+	outer := insertBlock("2026-03-02T09:00:00Z", "2026-03-02T12:00:00Z", 0)
+	insertBlock("2026-03-02T10:00:00Z", "2026-03-02T10:30:00Z", 1)
+	insertBlock("2026-03-02T11:00:00Z", "2026-03-02T11:15:00Z", 1)
+
+	report, err := store.CheckIntegrity(true)
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	var overlapAudit int64
+	for _, issue := range report.Issues {
+		if issue.Repaired != issue.Count {
+			t.Errorf("%s: repaired %d of %d", issue.Class, issue.Repaired, issue.Count)
+		}
+		if issue.Class == IntegrityOverlappingBlocks && issue.AuditID != nil {
+			overlapAudit = *issue.AuditID
+		}
+	}
+
+	// The automatic block keeps its time on both sides of each locked block
+	rows, _ := store.DB.Query(`
+		SELECT ts_start, ts_end FROM block
+		WHERE deleted_at IS NULL AND locked = 0 ORDER BY ts_start
+	`)
+	var got []string
+	for rows.Next() {
+		var start, end string
+		rows.Scan(&start, &end)
+		got = append(got, start[11:16]+"-"+end[11:16])
+	}
+	rows.Close()
+	want := []string{"09:00-10:00", "10:30-11:00", "11:15-12:00"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected unlocked spans %v, got %v", want, got)
+	}
+
+	report, _ = store.CheckIntegrity(false)
+	if !report.Healthy {
+		t.Errorf("Expected healthy database after repair, got %+v", report.Issues)
+	}
+
+	// Undo removes the split-off parts and restores the original block
+	if overlapAudit == 0 {
+		t.Fatal("Overlap repair should be audited")
+	}
+	if _, err := store.UndoAudit(overlapAudit); err != nil {
+		t.Fatalf("Undo of repair failed: %v", err)
+	}
+	var count int
+	store.DB.QueryRow("SELECT COUNT(*) FROM block WHERE locked = 0").Scan(&count)
+	var end string
+	store.DB.QueryRow("SELECT ts_end FROM block WHERE block_id = ?", outer).Scan(&end)
+	if count != 1 || end != "2026-03-02T12:00:00Z" {
+		t.Errorf("Expected the original block back (09:00-12:00), got %d blocks ending %s", count, end)
+	}
+}
@@ -0,0 +1,316 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SettingTrashRetentionDays is how long trashed blocks are kept before purge
+const SettingTrashRetentionDays = "trash_retention_days"
+
+// DefaultTrashRetentionDays applies when the setting is absent
+const DefaultTrashRetentionDays = 30
+
+// TrashedBlock is a soft-deleted block awaiting restore or purge
+type TrashedBlock struct {
+	BlockID      int64   `json:"block_id"`
+	TsStart      string  `json:"ts_start"`
+	TsEnd        string  `json:"ts_end"`
+	AppName      string  `json:"app_name"`
+	TitleSummary *string `json:"title_summary"`
+	Description  *string `json:"description"`
+	ManualTitle  *string `json:"manual_title"`
+	ProfileID    *int64  `json:"profile_id"`
+	ClientName   *string `json:"client_name"`
+	DeletedAt    string  `json:"deleted_at"`
+	PurgeAfter   string  `json:"purge_after"`
+}
+
+// TrashRetentionDays returns the configured trash retention
+func (s *Store) TrashRetentionDays() int {
+	return s.getSettingInt(SettingTrashRetentionDays, DefaultTrashRetentionDays)
+}
+
+// TrashBlock moves a block to the trash. Trashing an already trashed block
+// is a no-op.
+func (s *Store) TrashBlock(blockID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return fmt.Errorf("store not initialized")
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	audit := NewBlockAudit(AuditActorUser, AuditTrashBlock)
+	if err := audit.Track(tx, blockID); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`
+		UPDATE block SET deleted_at = ?
+		WHERE block_id = ? AND deleted_at IS NULL
+	`, time.Now().UTC().Format(time.RFC3339), blockID)
+	if err != nil {
+		return fmt.Errorf("failed to trash block: %w", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		var exists int
+		tx.QueryRow("SELECT COUNT(*) FROM block WHERE block_id = ?", blockID).Scan(&exists)
+		if exists == 0 {
+			return fmt.Errorf("block not found")
+		}
+		return nil
+	}
+
+	if _, err := audit.Commit(tx, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RestoreTrashedBlock takes a block back out of the trash
+func (s *Store) RestoreTrashedBlock(blockID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return fmt.Errorf("store not initialized")
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	audit := NewBlockAudit(AuditActorUser, AuditRestoreBlock)
+	if err := audit.Track(tx, blockID); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`
+		UPDATE block SET deleted_at = NULL
+		WHERE block_id = ? AND deleted_at IS NOT NULL
+	`, blockID)
+	if err != nil {
+		return fmt.Errorf("failed to restore block: %w", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return fmt.Errorf("block not found in trash")
+	}
+
+	if _, err := audit.Commit(tx, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListTrashedBlocks returns trashed blocks, most recently deleted first
+func (s *Store) ListTrashedBlocks(limit, offset int) ([]TrashedBlock, error) {
+	retention := s.TrashRetentionDays()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.initialized {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT
+			b.block_id, b.ts_start, b.ts_end, da.app_name, dt.title_text,
+			b.description, b.manual_title, b.profile_id, c.name, b.deleted_at
+		FROM block b
+		JOIN dict_app da ON b.primary_app_id = da.app_id
+		LEFT JOIN dict_title dt ON b.title_summary_id = dt.title_id
+		LEFT JOIN profile p ON b.profile_id = p.profile_id
+		LEFT JOIN client c ON p.client_id = c.client_id
+		WHERE b.deleted_at IS NOT NULL
+		ORDER BY b.deleted_at DESC, b.block_id DESC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+	defer rows.Close()
+
+	blocks := []TrashedBlock{}
+	for rows.Next() {
+		var b TrashedBlock
+		var title, description, manualTitle, clientName sql.NullString
+		var profileID sql.NullInt64
+
+		if err := rows.Scan(
+			&b.BlockID, &b.TsStart, &b.TsEnd, &b.AppName, &title,
+			&description, &manualTitle, &profileID, &clientName, &b.DeletedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan trashed block: %w", err)
+		}
+
+		b.TitleSummary = nullStringPtr(title)
+		b.Description = nullStringPtr(description)
+		b.ManualTitle = nullStringPtr(manualTitle)
+		b.ClientName = nullStringPtr(clientName)
+		if profileID.Valid {
+			id := profileID.Int64
+			b.ProfileID = &id
+		}
+		if deletedAt, err := time.Parse(time.RFC3339, b.DeletedAt); err == nil {
+			b.PurgeAfter = deletedAt.AddDate(0, 0, retention).Format(time.RFC3339)
+		}
+
+		blocks = append(blocks, b)
+	}
+
+	return blocks, rows.Err()
+}
+
+// PurgeBlock permanently deletes a block, trashed or not, and records it
+// for ML deletion learning
+func (s *Store) PurgeBlock(blockID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return fmt.Errorf("store not initialized")
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	audit := NewBlockAudit(AuditActorUser, AuditPurgeBlock)
+	purged, err := purgeBlocks(tx, audit, AuditActorUser, "b.block_id = ?", blockID)
+	if err != nil {
+		return err
+	}
+	if purged == 0 {
+		return fmt.Errorf("block not found")
+	}
+
+	if _, err := audit.Commit(tx, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgeExpiredTrash permanently deletes blocks trashed longer than the
+// retention period
+func (s *Store) PurgeExpiredTrash(now time.Time) (int, error) {
+	retention := s.TrashRetentionDays()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		return 0, fmt.Errorf("store not initialized")
+	}
+
+	cutoff := now.UTC().AddDate(0, 0, -retention).Format(time.RFC3339)
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	audit := NewBlockAudit(AuditActorSystem, AuditPurgeBlock)
+	purged, err := purgeBlocks(tx, audit, AuditActorSystem, "b.deleted_at IS NOT NULL AND b.deleted_at < ?", cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := audit.Commit(tx, map[string]interface{}{"retention_days": retention}); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit purge: %w", err)
+	}
+
+	return purged, nil
+}
+
+// purgeBlocks records matching blocks in ml_deletion_event under actor,
+// then deletes them along with their label history
+func purgeBlocks(tx *sql.Tx, audit *BlockAudit, actor string, where string, args ...interface{}) (int, error) {
+	rows, err := tx.Query("SELECT b.block_id FROM block b WHERE "+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find blocks to purge: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if err := audit.Track(tx, ids...); err != nil {
+		return 0, err
+	}
+
+	placeholders := make([]string, len(ids))
+	idArgs := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		idArgs[i] = id
+	}
+	in := "(" + strings.Join(placeholders, ",") + ")"
+
+	_, err = tx.Exec(`
+		INSERT INTO ml_deletion_event (app_name, title_text, domain_text, ts_start, ts_end, actor)
+		SELECT da.app_name, dt.title_text, dd.domain_text, b.ts_start, b.ts_end, ?
+		FROM block b
+		JOIN dict_app da ON b.primary_app_id = da.app_id
+		LEFT JOIN dict_title dt ON b.title_summary_id = dt.title_id
+		LEFT JOIN dict_domain dd ON b.primary_domain_id = dd.domain_id
+		WHERE b.block_id IN `+in, append([]interface{}{actor}, idArgs...)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record deletions for ML: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM ml_label_event WHERE block_id IN "+in, idArgs...); err != nil {
+		return 0, fmt.Errorf("failed to delete label events: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM ml_suggestion WHERE entity_type = 'BLOCK' AND entity_id IN "+in, idArgs...); err != nil {
+		return 0, fmt.Errorf("failed to delete suggestions: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM block_title WHERE block_id IN "+in, idArgs...); err != nil {
+		return 0, fmt.Errorf("failed to delete block titles: %w", err)
+	}
+
+	res, err := tx.Exec("DELETE FROM block WHERE block_id IN "+in, idArgs...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge blocks: %w", err)
+	}
+
+	purged, _ := res.RowsAffected()
+	return int(purged), nil
+}
@@ -0,0 +1,41 @@
+// Package storetest holds database fixtures shared by the store and engine
+// tests. Only tests import it.
+package storetest
+
+import (
+	"database/sql"
+	"testing"
+)
+
+// SeedExcelRule creates the 'ABC Books' profile with its client, service
+// and rate, and an APP rule sending EXCEL.EXE to it. Returns the profile ID.
+func SeedExcelRule(t testing.TB, db *sql.DB) int64 {
+	t.Helper()
+
+	for _, stmt := range []string{
+		"INSERT INTO client (name) VALUES ('Client ABC')",
+		"INSERT INTO service (name) VALUES ('Bookkeeping')",
+		"INSERT INTO rate (name, currency_code, hourly_minor_units) VALUES ('Standard', 'ZAR', 15000)",
+		`INSERT INTO profile (client_id, service_id, rate_id, name)
+		 SELECT c.client_id, s.service_id, r.rate_id, 'ABC Books'
+		 FROM client c, service s, rate r`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Seed failed (%s): %v", stmt, err)
+		}
+	}
+
+	var profileID int64
+	if err := db.QueryRow("SELECT profile_id FROM profile WHERE name = 'ABC Books'").Scan(&profileID); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+	_, err := db.Exec(
+		"INSERT INTO rule (name, match_type, match_value, target_profile_id) VALUES ('Excel', 'APP', 'EXCEL.EXE', ?)",
+		profileID,
+	)
+	if err != nil {
+		t.Fatalf("Seed failed (rule): %v", err)
+	}
+
+	return profileID
+}
@@ -1,7 +1,13 @@
 @echo off
 REM Migration script for ChronicleCore backend
 
-echo Running migration...
+if "%~1"=="" (
+    set MIGRATE_ARGS=up
+) else (
+    set MIGRATE_ARGS=%*
+)
+
+echo Running migrate %MIGRATE_ARGS%...
 
 REM Set GCC path (installed via winget)
 set GCC_PATH=C:\Users\josh\AppData\Local\Microsoft\WinGet\Packages\BrechtSanders.WinLibs.POSIX.UCRT_Microsoft.Winget.Source_8wekyb3d8bbwe\mingw64\bin\gcc.exe
@@ -15,7 +21,7 @@ if not exist "%GCC_PATH%" (
 set CGO_ENABLED=1
 set CC=%GCC_PATH%
 
-"%GO_PATH%" run cmd/migrate_temp/main.go
+"%GO_PATH%" run -tags sqlite_fts5 ./cmd/migrate %MIGRATE_ARGS%
 
 if errorlevel 1 (
     echo.
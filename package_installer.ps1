@@ -131,7 +131,7 @@ try {
     }
 
     # Execute Build
-    Invoke-Expression "$GoCmd build -o $GoExe ./cmd/server"
+    Invoke-Expression "$GoCmd build -tags sqlite_fts5 -o $GoExe ./cmd/server"
     
     if (-not (Test-Path $GoExe)) {
         Throw "Backend build failed: $GoExe not created."